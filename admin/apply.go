@@ -0,0 +1,168 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/ss"
+	tunnel "github.com/nknorg/nkn-tunnel"
+)
+
+var (
+	// Config fields that take effect without restart when changed by
+	// applyConfig. Other fields are saved and take effect after restart.
+	hotConfigFields = map[string]bool{
+		"tunaServiceName":     true,
+		"tunaCountry":         true,
+		"tunaAllowNknAddr":    true,
+		"tunaDisallowNknAddr": true,
+		"tunaAllowIp":         true,
+		"tunaDisallowIp":      true,
+		"disableAdminHttpApi": true,
+		"tags":                true,
+		"configBackups":       true,
+		"maxUploadMbps":       true,
+		"maxDownloadMbps":     true,
+		"monthlyDataCapGB":    true,
+		"dataCapResetDay":     true,
+		"dataCapWarnPercent":  true,
+	}
+
+	// Config fields that the running client or server applies to its proxy
+	// by the config applier without restart. It can still defer a change it
+	// cannot apply, e.g. enabling UDP that was not started.
+	liveConfigFields = map[string]bool{
+		"cipher":          true,
+		"password":        true,
+		"udp":             true,
+		"verbose":         true,
+		"socksAllowedIPs": true,
+	}
+
+	configApplier func(name string) error
+
+	// Config fields that have dedicated RPCs with extra checks and side
+	// effects, so they cannot be changed by applyConfig.
+	protectedConfigFields = map[string]string{
+		"seed":        "setSeed",
+		"acceptAddrs": "setAddrs",
+		"adminAddrs":  "setAddrs",
+		"clients":     "importClients",
+		"webUsers":    "setWebUser",
+	}
+)
+
+// ApplyConfigResultJSON is the result of applying a partial config.
+type ApplyConfigResultJSON struct {
+	Applied  []string `json:"applied"`  // fields that have taken effect
+	Deferred []string `json:"deferred"` // fields that are saved but take effect after restart
+}
+
+// SetConfigApplier sets the function that applies a changed field of
+// liveConfigFields to the running client or server. It is called after merged
+// config is updated, and returns an error if the field takes effect only after
+// restart.
+func SetConfigApplier(f func(name string) error) {
+	configApplier = f
+}
+
+// applyLiveField applies a changed field of liveConfigFields and returns
+// whether it has taken effect.
+func applyLiveField(name string) bool {
+	if !liveConfigFields[name] || configApplier == nil {
+		return false
+	}
+	err := configApplier(name)
+	if err != nil {
+		log.Printf("Config field %s takes effect after restart: %v", name, err)
+		return false
+	}
+	return true
+}
+
+// applyConfig validates a partial config and applies the fields that differ
+// from the running config. Changed fields are saved, and applied at once if
+// possible.
+func applyConfig(persistConf, mergedConf *config.Config, tun *tunnel.Tunnel, partial map[string]interface{}) (*ApplyConfigResultJSON, error) {
+	for name := range partial {
+		if rpc, ok := protectedConfigFields[name]; ok {
+			return nil, fmt.Errorf("config field %s should be changed by %s", name, rpc)
+		}
+	}
+
+	updated, changed, err := mergedConf.WithPartial(partial)
+	if err != nil {
+		return nil, err
+	}
+	err = updated.VerifyServer()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ApplyConfigResultJSON{Applied: make([]string, 0), Deferred: make([]string, 0)}
+	if len(changed) == 0 {
+		return result, nil
+	}
+
+	err = persistConf.SetFields(updated, changed)
+	if err != nil {
+		return nil, err
+	}
+	err = mergedConf.SetFields(updated, changed)
+	if err != nil {
+		return nil, err
+	}
+
+	tunaChanged := false
+	for _, name := range changed {
+		if !hotConfigFields[name] {
+			if applyLiveField(name) {
+				result.Applied = append(result.Applied, name)
+			} else {
+				result.Deferred = append(result.Deferred, name)
+			}
+			continue
+		}
+		result.Applied = append(result.Applied, name)
+		switch name {
+		case "configBackups":
+			persistConf.SetMaxBackups(updated.ConfigBackups)
+		case "maxUploadMbps", "maxDownloadMbps":
+			ss.SetRateLimit(updated.MaxUploadMbps, updated.MaxDownloadMbps)
+		case "monthlyDataCapGB", "dataCapResetDay", "dataCapWarnPercent":
+			setDataCapLimits(updated)
+		case "tunaServiceName", "tunaCountry", "tunaAllowNknAddr", "tunaDisallowNknAddr", "tunaAllowIp", "tunaDisallowIp":
+			tunaChanged = true
+		}
+	}
+
+	AddPendingRestart(result.Deferred...)
+
+	if tunaChanged {
+		err = setTunaConfig(tun, persistConf, mergedConf, &tunaConfigJSON{
+			ServiceName:     updated.TunaServiceName,
+			Country:         updated.TunaCountry,
+			AllowNknAddr:    updated.TunaAllowNknAddr,
+			DisallowNknAddr: updated.TunaDisallowNknAddr,
+			AllowIp:         updated.TunaAllowIp,
+			DisallowIp:      updated.TunaDisallowIp,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// ApplyConfig applies a partial config, e.g. tuna filters, to the server at
+// addr. The client should be in its admin addresses.
+func (c *Client) ApplyConfig(addr string, partial map[string]interface{}) (*ApplyConfigResultJSON, error) {
+	res := &ApplyConfigResultJSON{}
+	err := c.RPCCall(addr, "applyConfig", partial, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
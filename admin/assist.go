@@ -0,0 +1,318 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/storage"
+	"github.com/nknorg/nconnect/util"
+	tunnel "github.com/nknorg/nkn-tunnel"
+)
+
+const (
+	assistAuditKey = "assist-audit.json"
+	assistLabel    = "assist"
+
+	DefaultAssistDuration = time.Hour
+	MaxAssistDuration     = 24 * time.Hour
+
+	// Oldest audit entries are dropped beyond this many.
+	maxAssistAuditEntries   = 1000
+	defaultAssistAuditLimit = 100
+
+	AssistActionGrant  = "grant"
+	AssistActionRevoke = "revoke"
+	AssistActionCall   = "call"
+)
+
+var (
+	errAssistAddrEmpty   = errors.New("assist address should not be empty")
+	errAssistNotGranted  = errors.New("assist is not granted to this address")
+	errAssistCannotGrant = errors.New("assist address cannot grant or revoke assist")
+
+	// assistLock serializes changes to assist audit in state storage.
+	assistLock sync.Mutex
+)
+
+type grantAssistJSON struct {
+	Addr     string `json:"addr"`           // NKN address of the support person
+	Duration int64  `json:"duration"`       // in seconds, DefaultAssistDuration if not positive
+	Role     string `json:"role,omitempty"` // admin role, operator if empty
+}
+
+type revokeAssistJSON struct {
+	Addr string `json:"addr"`
+}
+
+type getAssistAuditJSON struct {
+	Limit int `json:"limit"` // max number of entries returned, newest first
+}
+
+// AssistAuditJSON is an entry of assist audit, which records grants and
+// revokes of assist, and every rpc called by an assist address.
+type AssistAuditJSON struct {
+	Time    time.Time  `json:"time"`
+	Addr    string     `json:"addr"` // assist address
+	Action  string     `json:"action"`
+	By      string     `json:"by,omitempty"`      // NKN address that granted or revoked, or web if called over web API
+	Role    string     `json:"role,omitempty"`    // granted role
+	Expires *time.Time `json:"expires,omitempty"` // when granted assist expires
+	Method  string     `json:"method,omitempty"`  // rpc called by assist address
+	Error   string     `json:"error,omitempty"`   // error of rpc called by assist address
+}
+
+// assistAddrPattern returns the admin address pattern that matches exactly
+// assist address addr.
+func assistAddrPattern(addr string) string {
+	return "^" + regexp.QuoteMeta(addr) + "$"
+}
+
+// isAssistAddr returns whether addr has been granted assist that has not
+// expired or been revoked.
+func isAssistAddr(conf *config.Config, addr string) bool {
+	pattern, now := assistAddrPattern(addr), time.Now()
+	for _, e := range conf.GetAdminAddrEntries() {
+		if e.Addr == pattern && e.Label == assistLabel && !e.Expired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// grantAssist gives NKN address in params admin rights that expire after
+// duration, by adding an admin address entry labeled assist. Assist that is
+// granted again is extended or shortened.
+func grantAssist(conf *config.Config, tun *tunnel.Tunnel, src string, params *grantAssistJSON) (*AssistAuditJSON, error) {
+	addr := strings.TrimSpace(params.Addr)
+	if len(addr) == 0 {
+		return nil, errAssistAddrEmpty
+	}
+	if len(src) > 0 && isAssistAddr(conf, src) {
+		return nil, errAssistCannotGrant
+	}
+	duration := time.Duration(params.Duration) * time.Second
+	if duration <= 0 {
+		duration = DefaultAssistDuration
+	}
+	if duration > MaxAssistDuration {
+		return nil, fmt.Errorf("assist duration should be at most %v", MaxAssistDuration)
+	}
+	role := params.Role
+	if len(role) == 0 {
+		role = config.AdminRoleOperator
+	}
+
+	now := time.Now()
+	entry := config.AddrEntry{
+		Addr:    assistAddrPattern(addr),
+		Label:   assistLabel,
+		Created: now,
+		Expires: now.Add(duration),
+		Role:    role,
+	}
+	err := conf.AddAdminAddrEntries([]config.AddrEntry{entry})
+	if err != nil {
+		return nil, err
+	}
+
+	audit := &AssistAuditJSON{
+		Time:    now,
+		Addr:    addr,
+		Action:  AssistActionGrant,
+		By:      assistCaller(src),
+		Role:    role,
+		Expires: &entry.Expires,
+	}
+	log.Printf("Assist granted to %s as %s by %s until %v", addr, role, audit.By, entry.Expires.Format(time.RFC3339))
+	recordAssistAudit(conf, audit)
+
+	if tun == nil {
+		return audit, nil
+	}
+	return audit, applyAcceptAddrs(conf, tun)
+}
+
+// revokeAssist removes assist of NKN address in params before it expires.
+func revokeAssist(conf *config.Config, tun *tunnel.Tunnel, src string, params *revokeAssistJSON) error {
+	addr := strings.TrimSpace(params.Addr)
+	if len(src) > 0 && isAssistAddr(conf, src) && src != addr {
+		return errAssistCannotGrant
+	}
+	if !isAssistAddr(conf, addr) {
+		return errAssistNotGranted
+	}
+	err := conf.RemoveAdminAddrs([]string{assistAddrPattern(addr)})
+	if err != nil {
+		return err
+	}
+
+	by := assistCaller(src)
+	log.Printf("Assist of %s revoked by %s", addr, by)
+	recordAssistAudit(conf, &AssistAuditJSON{Time: time.Now(), Addr: addr, Action: AssistActionRevoke, By: by})
+
+	if tun == nil {
+		return nil
+	}
+	return applyAcceptAddrs(conf, tun)
+}
+
+// auditAssistCall records rpc method called by assist address addr and its
+// error, if any.
+func auditAssistCall(conf *config.Config, addr, method, rpcErr string) {
+	if !strings.HasPrefix(method, "get") {
+		log.Printf("Assist %s called %s", addr, method)
+	}
+	recordAssistAudit(conf, &AssistAuditJSON{Time: time.Now(), Addr: addr, Action: AssistActionCall, Method: method, Error: rpcErr})
+}
+
+func assistCaller(src string) string {
+	if len(src) == 0 {
+		return "web"
+	}
+	return src
+}
+
+// loadAssistAudit should be called with assistLock held.
+func loadAssistAudit(store storage.Storage) ([]*AssistAuditJSON, error) {
+	b, err := store.Get(assistAuditKey)
+	if err == storage.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []*AssistAuditJSON
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// recordAssistAudit appends entry to assist audit in state storage. Errors are
+// only logged, as the entry is also in log.
+func recordAssistAudit(conf *config.Config, entry *AssistAuditJSON) {
+	err := appendAssistAudit(conf, entry)
+	if err != nil {
+		log.Printf("Record assist audit error: %v", err)
+	}
+}
+
+func appendAssistAudit(conf *config.Config, entry *AssistAuditJSON) error {
+	store, err := conf.GetStorage()
+	if err != nil {
+		return err
+	}
+
+	assistLock.Lock()
+	defer assistLock.Unlock()
+	entries, err := loadAssistAudit(store)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, entry)
+	if len(entries) > maxAssistAuditEntries {
+		entries = entries[len(entries)-maxAssistAuditEntries:]
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return store.Put(assistAuditKey, b)
+}
+
+// getAssistAudit returns the latest assist audit entries, newest first.
+func getAssistAudit(conf *config.Config, params *getAssistAuditJSON) ([]*AssistAuditJSON, error) {
+	store, err := conf.GetStorage()
+	if err != nil {
+		return nil, err
+	}
+
+	assistLock.Lock()
+	entries, err := loadAssistAudit(store)
+	assistLock.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultAssistAuditLimit
+	}
+	if limit > len(entries) {
+		limit = len(entries)
+	}
+	res := make([]*AssistAuditJSON, 0, limit)
+	for i := len(entries) - 1; i >= 0 && len(res) < limit; i-- {
+		res = append(res, entries[i])
+	}
+	return res, nil
+}
+
+// GrantAssist gives NKN address assistAddr admin rights with role on the node
+// at addr for duration.
+func (c *Client) GrantAssist(addr, assistAddr string, duration time.Duration, role string) (*AssistAuditJSON, error) {
+	res := &AssistAuditJSON{}
+	err := c.RPCCall(addr, "grantAssist", &grantAssistJSON{Addr: assistAddr, Duration: int64(duration.Seconds()), Role: role}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RevokeAssist removes assist of NKN address assistAddr on the node at addr.
+func (c *Client) RevokeAssist(addr, assistAddr string) error {
+	return c.RPCCall(addr, "revokeAssist", &revokeAssistJSON{Addr: assistAddr}, nil)
+}
+
+// GetAssistAudit returns the latest limit assist audit entries of the node at
+// addr, newest first.
+func (c *Client) GetAssistAudit(addr string, limit int) ([]*AssistAuditJSON, error) {
+	var res []*AssistAuditJSON
+	err := c.RPCCall(addr, "getAssistAudit", &getAssistAuditJSON{Limit: limit}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// assistDeniedMethods are methods an assist address can't call whatever its
+// role, as they could make its access outlive the assist: reading or changing
+// seed and admin token, issuing tokens, changing web users, and replacing or
+// importing config that may have admin addresses.
+var assistDeniedMethods = map[string]bool{
+	"getAdminToken": true,
+	"getSeed":       true,
+	"setSeed":       true,
+	"issueToken":    true,
+	"setWebUser":    true,
+	"removeWebUser": true,
+	"resetWebTOTP":  true,
+	"applyConfig":   true,
+	"restoreConfig": true,
+	"importClients": true,
+}
+
+// checkAssistRequest returns errPermissionDenied if req of an assist address
+// could make its access permanent, e.g. by changing admin addresses.
+func checkAssistRequest(req *rpcReq) error {
+	if assistDeniedMethods[req.Method] {
+		return errPermissionDenied
+	}
+	switch req.Method {
+	case "setAddrs", "addAddrs", "removeAddrs":
+		addrs := &addrsJSON{}
+		err := util.JSONConvert(req.Params, addrs)
+		if err != nil || addrs.AdminAddrs != nil || addrs.AdminAddrEntries != nil {
+			return errPermissionDenied
+		}
+	}
+	return nil
+}
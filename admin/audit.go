@@ -0,0 +1,227 @@
+package admin
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/util"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+const (
+	AuditTransportNKN  = "nkn"
+	AuditTransportHTTP = "http"
+
+	AuditResultOK     = "ok"
+	AuditResultError  = "error"
+	AuditResultDenied = "denied"
+
+	defaultAuditLogLimit = 100
+	maxAuditLogLimit     = 1000
+
+	auditRedacted = "[REDACTED]"
+)
+
+var (
+	errAuditLogDisabled = errors.New("audit log is not enabled, set auditLog in config")
+
+	adminAudit = &auditLogger{}
+
+	// Params with these names are replaced in audit log, besides known
+	// secrets that are redacted from all logs.
+	auditSecretParams = map[string]bool{
+		"seed":        true,
+		"password":    true,
+		"oldPassword": true,
+		"newPassword": true,
+		"code":        true,
+		"token":       true,
+		"secret":      true,
+	}
+)
+
+// AuditEntryJSON is an admin rpc recorded in audit log.
+type AuditEntryJSON struct {
+	Time      time.Time              `json:"time"`
+	Transport string                 `json:"transport"`        // nkn or http
+	Source    string                 `json:"source,omitempty"` // NKN address, or web user (cert:<name> for client certificates) over http
+	Remote    string                 `json:"remote,omitempty"` // client IP over http
+	Method    string                 `json:"method"`
+	Params    map[string]interface{} `json:"params,omitempty"`
+	Result    string                 `json:"result"` // ok, error or denied
+	Error     string                 `json:"error,omitempty"`
+}
+
+type getAuditLogJSON struct {
+	Offset int    `json:"offset"` // entries to skip, newest first
+	Limit  int    `json:"limit"`  // max number of entries returned
+	Method string `json:"method,omitempty"`
+	Source string `json:"source,omitempty"`
+}
+
+// AuditLogJSON is a page of audit log entries, newest first.
+type AuditLogJSON struct {
+	Entries []*AuditEntryJSON `json:"entries"`
+	Total   int               `json:"total"` // number of matching entries
+}
+
+// auditLogger appends audit entries to its own log file, rotated by its own
+// size and backup settings. The file is opened on first entry.
+type auditLogger struct {
+	lock sync.Mutex
+	file *lumberjack.Logger
+}
+
+func (a *auditLogger) write(conf *config.Config, entry *AuditEntryJSON) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	b = append(util.DefaultRedactor.Redact(b), '\n')
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.file == nil || a.file.Filename != conf.AuditLogFileName {
+		if a.file != nil {
+			a.file.Close()
+		}
+		a.file = &lumberjack.Logger{
+			Filename:   conf.AuditLogFileName,
+			MaxSize:    conf.AuditLogMaxSize,
+			MaxBackups: conf.AuditLogMaxBackups,
+		}
+	}
+	_, err = a.file.Write(b)
+	return err
+}
+
+// recordAudit appends an admin rpc and its result to audit log if enabled.
+// Requests that only have accept client permission, e.g. clients polling
+// getInfo, are not admin actions and are not recorded. Errors are only
+// logged.
+func recordAudit(conf *config.Config, transport, source, remote string, req *rpcReq, resp *rpcResp, perm permission) {
+	if len(conf.AuditLogFileName) == 0 || perm&^rpcPermissionAcceptClient == 0 {
+		return
+	}
+
+	entry := &AuditEntryJSON{
+		Time:      time.Now(),
+		Transport: transport,
+		Source:    source,
+		Remote:    remote,
+		Method:    req.Method,
+		Params:    redactAuditParams(req.Params),
+		Result:    AuditResultOK,
+	}
+	if len(resp.Error) > 0 {
+		entry.Result, entry.Error = AuditResultError, resp.Error
+		if resp.Error == errPermissionDenied.Error() {
+			entry.Result = AuditResultDenied
+		}
+	}
+
+	err := adminAudit.write(conf, entry)
+	if err != nil {
+		log.Printf("Write audit log error: %v", err)
+	}
+}
+
+// redactAuditParams returns a copy of params with secret params replaced.
+func redactAuditParams(params map[string]interface{}) map[string]interface{} {
+	if len(params) == 0 {
+		return nil
+	}
+	res := make(map[string]interface{}, len(params))
+	for k, v := range params {
+		if auditSecretParams[k] {
+			v = auditRedacted
+		}
+		res[k] = v
+	}
+	return res
+}
+
+// getAuditLog returns a page of audit log entries matching method and source
+// if given, newest first, from audit log file and its rotated backups.
+func getAuditLog(conf *config.Config, params *getAuditLogJSON) (*AuditLogJSON, error) {
+	if len(conf.AuditLogFileName) == 0 {
+		return nil, errAuditLogDisabled
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultAuditLogLimit
+	}
+	if limit > maxAuditLogLimit {
+		limit = maxAuditLogLimit
+	}
+
+	files, err := logFiles(conf.AuditLogFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*AuditEntryJSON
+	for _, file := range files {
+		entries, err = readAuditFile(file, params, entries)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	offset := params.Offset
+	if offset < 0 {
+		offset = 0
+	}
+	res := &AuditLogJSON{Entries: make([]*AuditEntryJSON, 0, limit), Total: len(entries)}
+	for i := len(entries) - 1 - offset; i >= 0 && len(res.Entries) < limit; i-- {
+		res.Entries = append(res.Entries, entries[i])
+	}
+	return res, nil
+}
+
+// readAuditFile appends entries of audit log file at path matching params to
+// entries.
+func readAuditFile(path string, params *getAuditLogJSON, entries []*AuditEntryJSON) ([]*AuditEntryJSON, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) { // not written yet, or rotated meanwhile
+			return entries, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		entry := &AuditEntryJSON{}
+		if json.Unmarshal(scanner.Bytes(), entry) != nil {
+			continue
+		}
+		if len(params.Method) > 0 && entry.Method != params.Method {
+			continue
+		}
+		if len(params.Source) > 0 && entry.Source != params.Source {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, scanner.Err()
+}
+
+// GetAuditLog returns a page of audit log entries of the node at addr, newest
+// first.
+func (c *Client) GetAuditLog(addr string, offset, limit int) (*AuditLogJSON, error) {
+	res := &AuditLogJSON{}
+	err := c.RPCCall(addr, "getAuditLog", &getAuditLogJSON{Offset: offset, Limit: limit}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
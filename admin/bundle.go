@@ -0,0 +1,163 @@
+package admin
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/nknorg/nconnect/arch"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/monitor"
+	"github.com/nknorg/nconnect/util"
+)
+
+const (
+	supportBundleLogSize = 1 << 20
+)
+
+type diagnosticsJSON struct {
+	Time         time.Time          `json:"time"`
+	LocalIP      *localIPJSON       `json:"localIP,omitempty"`
+	Host         *monitor.HostStats `json:"host,omitempty"`
+	NumGoroutine int                `json:"numGoroutine"`
+	AcceptPaused bool               `json:"acceptPaused"`
+	Errors       []string           `json:"errors,omitempty"`
+}
+
+// WriteSupportBundle writes a gzipped tar archive with redacted config,
+// recent logs, version info, network state and diagnostics to w.
+func WriteSupportBundle(w io.Writer, persistConf, mergedConf *config.Config) error {
+	redactor := util.NewRedactor()
+	redactor.AddSecrets(persistConf.Secrets()...)
+	redactor.AddSecrets(mergedConf.Secrets()...)
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+
+	addFile := func(name string, b []byte) error {
+		err := tw.WriteHeader(&tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    int64(len(b)),
+			ModTime: time.Now(),
+		})
+		if err != nil {
+			return err
+		}
+		_, err = tw.Write(b)
+		return err
+	}
+
+	b, err := json.MarshalIndent(persistConf, "", " ")
+	if err != nil {
+		return err
+	}
+	err = addFile("config.json", redactor.Redact(b))
+	if err != nil {
+		return err
+	}
+
+	version := fmt.Sprintf("nConnect %s\n%s %s/%s\n", config.Version, runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	err = addFile("version.txt", []byte(version))
+	if err != nil {
+		return err
+	}
+
+	if len(mergedConf.LogFileName) > 0 {
+		b, err = readFileTail(mergedConf.LogFileName, supportBundleLogSize)
+		if err != nil {
+			b = []byte(err.Error())
+		}
+		err = addFile("log.txt", redactor.Redact(b))
+		if err != nil {
+			return err
+		}
+	}
+
+	var network bytes.Buffer
+	for _, cmd := range arch.NetworkStateCmds() {
+		fmt.Fprintf(&network, "$ %s\n", strings.Join(cmd, " "))
+		out, err := exec.Command(cmd[0], cmd[1:]...).CombinedOutput()
+		network.Write(out)
+		if err != nil {
+			fmt.Fprintln(&network, err)
+		}
+		network.WriteString("\n")
+	}
+	err = addFile("network.txt", network.Bytes())
+	if err != nil {
+		return err
+	}
+
+	diag := &diagnosticsJSON{
+		Time:         time.Now(),
+		NumGoroutine: runtime.NumGoroutine(),
+		AcceptPaused: IsAcceptPaused(),
+	}
+	diag.LocalIP, err = getLocalIP()
+	if err != nil {
+		diag.Errors = append(diag.Errors, err.Error())
+	}
+	diskPath := filepath.Dir(persistConf.Path())
+	if len(mergedConf.LogFileName) > 0 {
+		diskPath = filepath.Dir(mergedConf.LogFileName)
+	}
+	diag.Host, err = monitor.GetHostStats(diskPath)
+	if err != nil {
+		diag.Errors = append(diag.Errors, err.Error())
+	}
+	b, err = json.MarshalIndent(diag, "", " ")
+	if err != nil {
+		return err
+	}
+	err = addFile("diagnostics.json", b)
+	if err != nil {
+		return err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+func getSupportBundle(persistConf, mergedConf *config.Config) ([]byte, error) {
+	var buf bytes.Buffer
+	err := WriteSupportBundle(&buf, persistConf, mergedConf)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func readFileTail(path string, size int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := fi.Size() - size
+	if offset < 0 {
+		offset = 0
+	}
+	_, err = f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(f)
+}
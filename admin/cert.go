@@ -0,0 +1,170 @@
+package admin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/storage"
+)
+
+const (
+	adminCertKey      = "admin-http-cert.pem"
+	adminKeyKey       = "admin-http-key.pem"
+	adminCertValidity = 10 * 365 * 24 * time.Hour
+)
+
+// loadAdminCert loads the admin web server certificate from cert and key
+// files if given. Otherwise a self-signed certificate is generated on first
+// use and kept in runtime state storage, so browsers only need to trust it
+// once.
+func loadAdminCert(persistConf, mergedConf *config.Config) (tls.Certificate, error) {
+	if len(mergedConf.AdminHTTPCertFile) > 0 || len(mergedConf.AdminHTTPKeyFile) > 0 {
+		if len(mergedConf.AdminHTTPCertFile) == 0 || len(mergedConf.AdminHTTPKeyFile) == 0 {
+			return tls.Certificate{}, errors.New("adminHttpCertFile and adminHttpKeyFile should be given together")
+		}
+		return tls.LoadX509KeyPair(mergedConf.AdminHTTPCertFile, mergedConf.AdminHTTPKeyFile)
+	}
+
+	store, err := persistConf.GetStorage()
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	certPEM, err := store.Get(adminCertKey)
+	if err == nil {
+		var keyPEM []byte
+		keyPEM, err = store.Get(adminKeyKey)
+		if err == nil {
+			return tls.X509KeyPair(certPEM, keyPEM)
+		}
+	}
+	if err != storage.ErrNotFound {
+		return tls.Certificate{}, err
+	}
+
+	certPEM, keyPEM, err := newSelfSignedCert(mergedConf.AdminHTTPAddr)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	err = store.Put(adminKeyKey, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	err = store.Put(adminCertKey, certPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	log.Println("Generated self-signed certificate for admin web GUI")
+
+	return tls.X509KeyPair(certPEM, keyPEM)
+}
+
+// newSelfSignedCert returns PEM encoded certificate and key valid for
+// localhost and the host of listenAddr.
+func newSelfSignedCert(listenAddr string) ([]byte, []byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"nConnect"}, CommonName: "nConnect admin"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(adminCertValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if host, _, err := net.SplitHostPort(listenAddr); err == nil && len(host) > 0 {
+		if ip := net.ParseIP(host); ip != nil {
+			if !ip.IsUnspecified() && !ip.IsLoopback() {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			}
+		} else if host != "localhost" {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+// adminTLSConfig returns the TLS config of admin web server. Client
+// certificates are verified against CAs in adminHttpClientCAFile if given, and
+// required if adminHttpRequireClientCert is set.
+func adminTLSConfig(persistConf, mergedConf *config.Config) (*tls.Config, error) {
+	cert, err := loadAdminCert(persistConf, mergedConf)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if len(mergedConf.AdminHTTPClientCAFile) == 0 {
+		return tlsConfig, nil
+	}
+
+	b, err := os.ReadFile(mergedConf.AdminHTTPClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ClientCAs = x509.NewCertPool()
+	if !tlsConfig.ClientCAs.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificate found in %s", mergedConf.AdminHTTPClientCAFile)
+	}
+	if mergedConf.AdminHTTPRequireClientCert {
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	} else {
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
+
+// clientCertUser returns the common name of the verified client certificate
+// of r, and false if r has none. Client certificates are only verified when
+// adminHttpClientCAFile is given.
+func clientCertUser(r *http.Request) (string, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName, true
+}
+
+// httpPermission returns the permission of a web request. Requests with a
+// verified client certificate, e.g. from automation, have full web permission
+// without login. Others have the permission of their token.
+func httpPermission(r *http.Request, persistConf *config.Config, req *rpcReq) (permission, string) {
+	if name, ok := clientCertUser(r); ok {
+		return rpcPermissionWeb | rpcPermissionWebLogin, "cert:" + name
+	}
+	return webPermission(persistConf, req)
+}
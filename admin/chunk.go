@@ -0,0 +1,148 @@
+package admin
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	rpcChunkExpiration = time.Minute
+	rpcChunkIDSize     = 8
+
+	// maxRPCChunks is the max number of chunks of a response a client
+	// fetches, which is 32MB with the smallest chunks.
+	maxRPCChunks = 1024
+)
+
+var (
+	errChunkNotFound = errors.New("rpc chunk not found or expired")
+	errChunkChecksum = errors.New("rpc chunk checksum mismatch")
+	errChunkInvalid  = errors.New("invalid rpc chunk")
+	errReplyTooLarge = errors.New("rpc reply is too large for rpc version 1, please upgrade client")
+)
+
+var (
+	chunkStore = newRPCChunkStore(rpcChunkExpiration)
+)
+
+// rpcChunk is one part of a response that is too large to fit in a single
+// NKN message. The first chunk is sent as the reply; the receiver fetches the
+// rest with getRPCChunk and verifies both per-chunk and whole-payload digests.
+type rpcChunk struct {
+	ID       string `json:"id"`
+	Seq      int    `json:"seq"`
+	Total    int    `json:"total"`
+	Data     []byte `json:"data"`
+	Checksum string `json:"checksum"`
+	Digest   string `json:"digest"`
+}
+
+type getRPCChunkJSON struct {
+	ID  string `json:"id"`
+	Seq int    `json:"seq"`
+}
+
+type chunkedResp struct {
+	dest      string
+	chunks    []*rpcChunk
+	expiresAt time.Time
+}
+
+type rpcChunkStore struct {
+	expiration time.Duration
+
+	lock  sync.Mutex
+	resps map[string]*chunkedResp
+}
+
+func newRPCChunkStore(expiration time.Duration) *rpcChunkStore {
+	return &rpcChunkStore{
+		expiration: expiration,
+		resps:      make(map[string]*chunkedResp),
+	}
+}
+
+func checksum(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+// split stores b as chunks of chunkSize bytes readable by dest and returns
+// the first one. Callers use half of the max reply size as chunk size, which
+// leaves room for base64 encoding so a chunk reply never exceeds the max
+// reply size itself.
+func (s *rpcChunkStore) split(dest string, b []byte, chunkSize int) *rpcChunk {
+	idBytes := make([]byte, rpcChunkIDSize)
+	rand.Read(idBytes)
+	id := hex.EncodeToString(idBytes)
+	digest := checksum(b)
+
+	total := (len(b) + chunkSize - 1) / chunkSize
+	chunks := make([]*rpcChunk, total)
+	for i := range chunks {
+		end := (i + 1) * chunkSize
+		if end > len(b) {
+			end = len(b)
+		}
+		data := b[i*chunkSize : end]
+		chunks[i] = &rpcChunk{
+			ID:       id,
+			Seq:      i,
+			Total:    total,
+			Data:     data,
+			Checksum: checksum(data),
+			Digest:   digest,
+		}
+	}
+
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for k, v := range s.resps {
+		if now.After(v.expiresAt) {
+			delete(s.resps, k)
+		}
+	}
+	s.resps[id] = &chunkedResp{
+		dest:      dest,
+		chunks:    chunks,
+		expiresAt: now.Add(s.expiration),
+	}
+
+	return chunks[0]
+}
+
+func (s *rpcChunkStore) get(dest string, params *getRPCChunkJSON) (*rpcChunk, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	r, ok := s.resps[params.ID]
+	if !ok || r.dest != dest || time.Now().After(r.expiresAt) {
+		return nil, errChunkNotFound
+	}
+	if params.Seq < 0 || params.Seq >= len(r.chunks) {
+		return nil, fmt.Errorf("invalid chunk seq %d", params.Seq)
+	}
+	// Kept until expired instead of deleted after the last chunk, so a chunk
+	// whose reply is lost can be fetched again.
+	return r.chunks[params.Seq], nil
+}
+
+// joinChunks verifies and reassembles chunks fetched by the client.
+func joinChunks(chunks []*rpcChunk) ([]byte, error) {
+	var b []byte
+	for i, chunk := range chunks {
+		if chunk.Seq != i || chunk.Total != len(chunks) || checksum(chunk.Data) != chunk.Checksum {
+			return nil, errChunkChecksum
+		}
+		b = append(b, chunk.Data...)
+	}
+	if len(chunks) == 0 || checksum(b) != chunks[0].Digest {
+		return nil, errChunkChecksum
+	}
+	return b, nil
+}
@@ -3,9 +3,13 @@ package admin
 import (
 	"encoding/json"
 	"errors"
+	"log"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/util"
 	"github.com/nknorg/nkn-sdk-go"
 )
 
@@ -15,19 +19,36 @@ const (
 
 var (
 	errReplyTimeout = errors.New("wait for reply timeout")
+	errNotPaired    = errors.New("public key is not in accept or admin addresses")
 )
 
 var (
-	serverAdminAddr string
+	serverAdminAddr   string
+	serverMultiClient *nkn.MultiClient
 )
 
 type Client struct {
 	*nkn.MultiClient
 	replyTimeout time.Duration
+	name         string
+	tunAddr      string
+
+	msgLoopOnce    sync.Once
+	lock           sync.RWMutex
+	logHandlers    map[string]*logHandler // map follow id to log lines handler
+	pendingFollows int                    // followLog and startDebugSession calls waiting for reply
+	earlyLines     map[string][]string    // lines pushed while calls are pending, by follow id
+}
+
+// logHandler calls onLines with pushed log lines of a follow in order.
+type logHandler struct {
+	lock    sync.Mutex
+	onLines func(string)
 }
 
-func NewClient(account *nkn.Account, clientConfig *nkn.ClientConfig) (*Client, error) {
-	m, err := nkn.NewMultiClient(account, config.RandomIdentifier(), 4, false, clientConfig)
+// NewClient creates an admin client with numSubClients NKN sub-clients.
+func NewClient(account *nkn.Account, clientConfig *nkn.ClientConfig, numSubClients int) (*Client, error) {
+	m, err := nkn.NewMultiClient(account, config.RandomIdentifier(), numSubClients, false, clientConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -35,6 +56,7 @@ func NewClient(account *nkn.Account, clientConfig *nkn.ClientConfig) (*Client, e
 	c := &Client{
 		MultiClient:  m,
 		replyTimeout: replyTimeout,
+		logHandlers:  make(map[string]*logHandler),
 	}
 
 	<-m.OnConnect.C
@@ -44,9 +66,10 @@ func NewClient(account *nkn.Account, clientConfig *nkn.ClientConfig) (*Client, e
 
 func (c *Client) RPCCall(addr, method string, params interface{}, result interface{}) error {
 	req, err := json.Marshal(map[string]interface{}{
-		"id":     "nConnect",
-		"method": method,
-		"params": params,
+		"id":      "nConnect",
+		"version": RPCVersion,
+		"method":  method,
+		"params":  params,
 	})
 	if err != nil {
 		return err
@@ -72,14 +95,29 @@ Loop:
 		return err
 	}
 
+	data := reply.Data
 	resp := &rpcResp{
 		Result: result,
 	}
-	err = json.Unmarshal(reply.Data, resp)
+	err = json.Unmarshal(data, resp)
 	if err != nil {
 		return err
 	}
 
+	if resp.Chunk != nil {
+		data, err = c.getChunks(addr, resp.Chunk)
+		if err != nil {
+			return err
+		}
+		resp = &rpcResp{
+			Result: result,
+		}
+		err = json.Unmarshal(data, resp)
+		if err != nil {
+			return err
+		}
+	}
+
 	if len(resp.Error) > 0 {
 		return errors.New(resp.Error)
 	}
@@ -87,11 +125,215 @@ Loop:
 	return nil
 }
 
+// getChunks fetches the remaining chunks of a chunked response and returns
+// the reassembled payload. A failed fetch is retried once.
+func (c *Client) getChunks(addr string, first *rpcChunk) ([]byte, error) {
+	if first.Seq != 0 || first.Total < 1 || first.Total > maxRPCChunks {
+		return nil, errChunkInvalid
+	}
+	chunks := make([]*rpcChunk, first.Total)
+	chunks[0] = first
+	for i := 1; i < first.Total; i++ {
+		var err error
+		for attempt := 0; attempt < 2; attempt++ {
+			chunk := &rpcChunk{}
+			err = c.RPCCall(addr, "getRPCChunk", &getRPCChunkJSON{ID: first.ID, Seq: i}, chunk)
+			if err == nil {
+				chunks[i] = chunk
+				break
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return joinChunks(chunks)
+}
+
+// SetName sets the friendly name sent to servers in GetInfo.
+func (c *Client) SetName(name string) {
+	c.name = name
+}
+
+// SetTunAddr sets the TUN IP address sent to servers in GetInfo.
+func (c *Client) SetTunAddr(tunAddr string) {
+	c.tunAddr = tunAddr
+}
+
 func (c *Client) GetInfo(addr string) (*GetInfoJSON, error) {
 	res := &GetInfoJSON{}
-	err := c.RPCCall(addr, "getInfo", nil, res)
+	err := c.RPCCall(addr, "getInfo", &getInfoJSON{Name: c.name, Version: config.Version, TunAddr: c.tunAddr}, res)
 	if err != nil {
 		return nil, err
 	}
 	return res, nil
 }
+
+// FollowLog asks the server at addr to stream new log lines of at least level
+// (LogLevelInfo if empty) that contain contains, and calls onLines with each
+// chunk in order. The returned function stops following.
+func (c *Client) FollowLog(addr string, backlog int64, level, contains string, onLines func(string)) (func() error, error) {
+	c.msgLoopOnce.Do(func() {
+		go c.handleMessages()
+	})
+
+	c.beginFollow()
+	res := &followLogResultJSON{}
+	err := c.RPCCall(addr, "followLog", &followLogJSON{Backlog: backlog, Level: level, Contains: contains}, res)
+	if err != nil {
+		c.endFollow("", nil)
+		return nil, err
+	}
+	c.endFollow(res.FollowID, onLines)
+
+	stop := func() error {
+		c.lock.Lock()
+		delete(c.logHandlers, res.FollowID)
+		c.lock.Unlock()
+		return c.RPCCall(addr, "stopFollowLog", &stopFollowLogJSON{FollowID: res.FollowID}, nil)
+	}
+
+	return stop, nil
+}
+
+// beginFollow marks a follow call as pending. Lines the server pushes before
+// the call returns the follow id are kept until endFollow, instead of being
+// answered with stop.
+func (c *Client) beginFollow() {
+	c.lock.Lock()
+	c.pendingFollows++
+	c.lock.Unlock()
+}
+
+// endFollow ends a pending follow call, and if id is not empty registers
+// onLines for it and calls it with lines pushed before. Lines of ids no call
+// has returned are dropped when no call is pending.
+func (c *Client) endFollow(id string, onLines func(string)) {
+	h := &logHandler{onLines: onLines}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	c.lock.Lock()
+	c.pendingFollows--
+	early := c.earlyLines[id]
+	delete(c.earlyLines, id)
+	if c.pendingFollows == 0 {
+		c.earlyLines = nil
+	}
+	if len(id) > 0 {
+		c.logHandlers[id] = h
+	}
+	c.lock.Unlock()
+
+	for _, lines := range early {
+		onLines(lines)
+	}
+}
+
+func (c *Client) handleMessages() {
+	for {
+		msg, ok := <-c.OnMessage.C
+		if !ok {
+			return
+		}
+
+		req := &rpcReq{}
+		err := json.Unmarshal(msg.Data, req)
+		if err != nil || req.Method != logLinesMethod {
+			continue
+		}
+
+		lines := &logLinesJSON{}
+		err = util.JSONConvert(req.Params, lines)
+		if err != nil {
+			log.Println("Parse log lines error:", err)
+			continue
+		}
+
+		c.lock.Lock()
+		h, ok := c.logHandlers[lines.FollowID]
+		if !ok && c.pendingFollows > 0 {
+			if c.earlyLines == nil {
+				c.earlyLines = make(map[string][]string)
+			}
+			c.earlyLines[lines.FollowID] = append(c.earlyLines[lines.FollowID], lines.Lines)
+			ok = true
+		}
+		c.lock.Unlock()
+
+		if h != nil {
+			h.lock.Lock()
+			h.onLines(lines.Lines)
+			h.lock.Unlock()
+		}
+
+		b, err := json.Marshal(&logLinesAckJSON{Seq: lines.Seq, Stop: !ok})
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		err = msg.Reply(b)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func (c *Client) GetSupportBundle(addr string) ([]byte, error) {
+	var res []byte
+	err := c.RPCCall(addr, "getSupportBundle", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ImportClients imports clients in format (json or csv) to the server at addr.
+func (c *Client) ImportClients(addr, format string, data []byte) error {
+	return c.RPCCall(addr, "importClients", &importClientsJSON{Format: format, Data: string(data)}, nil)
+}
+
+// ExportClients exports clients of the server at addr in format.
+func (c *Client) ExportClients(addr, format string) ([]byte, error) {
+	var res string
+	err := c.RPCCall(addr, "exportClients", &exportClientsJSON{Format: format}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(res), nil
+}
+
+// ReplacePubKey replaces accept and admin addresses containing oldPubKey with
+// newPubKey on the server at addr, e.g. after regenerating seed. Admin
+// permission on the server is required.
+func (c *Client) ReplacePubKey(addr, oldPubKey, newPubKey string) error {
+	addrs := &addrsJSON{}
+	err := c.RPCCall(addr, "getAddrs", nil, addrs)
+	if err != nil {
+		return err
+	}
+
+	added, removed := &addrsJSON{}, &addrsJSON{}
+	for _, a := range addrs.AcceptAddrs {
+		if strings.Contains(a, oldPubKey) {
+			added.AcceptAddrs = append(added.AcceptAddrs, strings.ReplaceAll(a, oldPubKey, newPubKey))
+			removed.AcceptAddrs = append(removed.AcceptAddrs, a)
+		}
+	}
+	for _, a := range addrs.AdminAddrs {
+		if strings.Contains(a, oldPubKey) {
+			added.AdminAddrs = append(added.AdminAddrs, strings.ReplaceAll(a, oldPubKey, newPubKey))
+			removed.AdminAddrs = append(removed.AdminAddrs, a)
+		}
+	}
+	if len(removed.AcceptAddrs) == 0 && len(removed.AdminAddrs) == 0 {
+		return errNotPaired
+	}
+
+	err = c.RPCCall(addr, "addAddrs", added, nil)
+	if err != nil {
+		return err
+	}
+	return c.RPCCall(addr, "removeAddrs", removed, nil)
+}
@@ -0,0 +1,199 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nknorg/nconnect/config"
+	tunnel "github.com/nknorg/nkn-tunnel"
+)
+
+const (
+	ClientsFormatJSON = "json"
+	ClientsFormatCSV  = "csv"
+)
+
+var (
+	errUnknownClientsFormat = errors.New("unknown clients format, should be json or csv")
+
+	clientsCSVHeader = []string{"addr", "name", "group", "quotaMB", "admin"}
+)
+
+// PairedClient is one client in bulk import and export.
+type PairedClient struct {
+	Addr  string `json:"addr"`
+	Admin bool   `json:"admin,omitempty"` // add to admin addresses instead of accept addresses
+	config.ClientInfo
+}
+
+type importClientsJSON struct {
+	Format string `json:"format"`
+	Data   string `json:"data"`
+}
+
+type exportClientsJSON struct {
+	Format string `json:"format"`
+}
+
+// ParseClients parses clients exported by FormatClients. CSV data should
+// start with a header row, columns other than addr are optional.
+func ParseClients(format string, data []byte) ([]*PairedClient, error) {
+	var clients []*PairedClient
+	switch strings.ToLower(format) {
+	case ClientsFormatJSON:
+		err := json.Unmarshal(data, &clients)
+		if err != nil {
+			return nil, err
+		}
+	case ClientsFormatCSV:
+		r := csv.NewReader(bytes.NewReader(data))
+		r.FieldsPerRecord = -1
+		r.TrimLeadingSpace = true
+		header, err := r.Read()
+		if err != nil {
+			return nil, err
+		}
+		columns := make(map[string]int, len(header))
+		for i, h := range header {
+			columns[strings.TrimSpace(h)] = i
+		}
+		if _, ok := columns["addr"]; !ok {
+			return nil, errors.New("csv header should contain addr column")
+		}
+		field := func(record []string, name string) string {
+			if i, ok := columns[name]; ok && i < len(record) {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+		for line := 2; ; line++ {
+			record, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			c := &PairedClient{Addr: field(record, "addr")}
+			c.Name = field(record, "name")
+			c.Group = field(record, "group")
+			if s := field(record, "quotaMB"); len(s) > 0 {
+				c.QuotaMB, err = strconv.ParseInt(s, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid quotaMB %q", line, s)
+				}
+			}
+			if s := field(record, "admin"); len(s) > 0 {
+				c.Admin, err = strconv.ParseBool(s)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: invalid admin %q", line, s)
+				}
+			}
+			clients = append(clients, c)
+		}
+	default:
+		return nil, errUnknownClientsFormat
+	}
+
+	for i, c := range clients {
+		if len(c.Addr) == 0 {
+			return nil, fmt.Errorf("client %d: addr should not be empty", i)
+		}
+		if c.QuotaMB < 0 {
+			return nil, fmt.Errorf("client %d: quotaMB should not be negative", i)
+		}
+		c.Name = sanitizeClientName(c.Name)
+	}
+
+	return clients, nil
+}
+
+// FormatClients formats clients so they can be loaded by ParseClients.
+func FormatClients(format string, clients []*PairedClient) ([]byte, error) {
+	switch strings.ToLower(format) {
+	case ClientsFormatJSON:
+		return json.MarshalIndent(clients, "", " ")
+	case ClientsFormatCSV:
+		var buf bytes.Buffer
+		w := csv.NewWriter(&buf)
+		err := w.Write(clientsCSVHeader)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range clients {
+			err = w.Write([]string{c.Addr, c.Name, c.Group, strconv.FormatInt(c.QuotaMB, 10), strconv.FormatBool(c.Admin)})
+			if err != nil {
+				return nil, err
+			}
+		}
+		w.Flush()
+		return buf.Bytes(), w.Error()
+	default:
+		return nil, errUnknownClientsFormat
+	}
+}
+
+// ImportClients adds clients to accept or admin addresses with their info in
+// one config save, then applies accept addresses to tun if not nil.
+func ImportClients(conf *config.Config, tun *tunnel.Tunnel, clients []*PairedClient) error {
+	var acceptAddrs, adminAddrs []string
+	infos := make(map[string]config.ClientInfo, len(clients))
+	for _, c := range clients {
+		if c.Admin {
+			adminAddrs = append(adminAddrs, c.Addr)
+		} else {
+			acceptAddrs = append(acceptAddrs, c.Addr)
+		}
+		infos[addrPubKey(c.Addr)] = c.ClientInfo
+	}
+
+	err := conf.AddClients(acceptAddrs, adminAddrs, infos)
+	if err != nil {
+		return err
+	}
+
+	if tun == nil {
+		return nil
+	}
+	return applyAcceptAddrs(conf, tun)
+}
+
+// ExportClients returns accept and admin addresses with their client info.
+func ExportClients(conf *config.Config) []*PairedClient {
+	infos := conf.GetClients()
+	adminAddrs := conf.GetAdminAddrs()
+	clients := make([]*PairedClient, 0, len(conf.GetAcceptAddrs())+len(adminAddrs))
+	for _, addr := range conf.GetAcceptAddrs() {
+		clients = append(clients, &PairedClient{Addr: addr, ClientInfo: infos[addrPubKey(addr)]})
+	}
+	for _, addr := range adminAddrs {
+		clients = append(clients, &PairedClient{Addr: addr, Admin: true, ClientInfo: infos[addrPubKey(addr)]})
+	}
+	sort.SliceStable(clients, func(i, j int) bool {
+		return clients[i].Addr < clients[j].Addr
+	})
+	return clients
+}
+
+func importClients(conf *config.Config, tun *tunnel.Tunnel, params *importClientsJSON) (int, error) {
+	clients, err := ParseClients(params.Format, []byte(params.Data))
+	if err != nil {
+		return 0, err
+	}
+	return len(clients), ImportClients(conf, tun, clients)
+}
+
+func exportClients(conf *config.Config, params *exportClientsJSON) (string, error) {
+	b, err := FormatClients(params.Format, ExportClients(conf))
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
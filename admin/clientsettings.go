@@ -0,0 +1,171 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/ss"
+	"github.com/nknorg/nconnect/storage"
+)
+
+const (
+	clientSettingsKey = "client-settings.json"
+)
+
+var (
+	// Client config fields server can recommend to its clients. Clients save
+	// them to config, and they take effect after client restart.
+	clientSettingsFields = map[string]bool{
+		"cipher":         true,
+		"vpnRoute":       true,
+		"tunDNS":         true,
+		"direct":         true,
+		"disableRoaming": true,
+	}
+
+	clientSettingsLock   sync.Mutex
+	clientSettingsLoaded bool
+	clientSettings       *ClientSettingsJSON
+)
+
+// ClientSettingsJSON is the client settings server recommends to its
+// clients. Revision changes each time settings are changed, so clients only
+// apply them once.
+type ClientSettingsJSON struct {
+	Settings  map[string]interface{} `json:"settings"` // partial client config with the same field names as config.json
+	Revision  int64                  `json:"revision,omitempty"`
+	UpdatedAt time.Time              `json:"updatedAt,omitempty"`
+	QuotaMB   int64                  `json:"quotaMB,omitempty"` // monthly data quota of the client calling getClientSettings
+}
+
+type setClientSettingsJSON struct {
+	Settings map[string]interface{} `json:"settings"`
+}
+
+// loadClientSettings should be called with clientSettingsLock held.
+func loadClientSettings(persistConf *config.Config) error {
+	if clientSettingsLoaded {
+		return nil
+	}
+	store, err := persistConf.GetStorage()
+	if err != nil {
+		return err
+	}
+	clientSettings = &ClientSettingsJSON{Settings: make(map[string]interface{})}
+	b, err := store.Get(clientSettingsKey)
+	if err == nil {
+		err = json.Unmarshal(b, clientSettings)
+		if err != nil {
+			return err
+		}
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+	clientSettingsLoaded = true
+	return nil
+}
+
+// VerifyClientSettings checks that settings only have fields clients accept
+// from server, with values of the right type.
+func VerifyClientSettings(settings map[string]interface{}) error {
+	for name := range settings {
+		if !clientSettingsFields[name] {
+			fields := make([]string, 0, len(clientSettingsFields))
+			for f := range clientSettingsFields {
+				fields = append(fields, f)
+			}
+			sort.Strings(fields)
+			return fmt.Errorf("config field %s can't be recommended to clients, available fields are %v", name, fields)
+		}
+	}
+	updated, _, err := config.NewConfig().WithPartial(settings)
+	if err != nil {
+		return err
+	}
+	if _, ok := settings["cipher"]; ok {
+		ciphers := append([]string{config.CipherAuto, config.CipherDummy}, ss.AEADCiphers...)
+		for _, c := range ciphers {
+			if updated.Cipher == c {
+				return nil
+			}
+		}
+		return fmt.Errorf("unknown cipher %s, available ciphers are %v", updated.Cipher, ciphers)
+	}
+	return nil
+}
+
+// setClientSettings replaces client settings recommended to clients. Empty
+// settings stop recommending anything, but don't revert clients that have
+// applied them.
+func setClientSettings(persistConf *config.Config, params *setClientSettingsJSON) error {
+	err := VerifyClientSettings(params.Settings)
+	if err != nil {
+		return err
+	}
+
+	clientSettingsLock.Lock()
+	defer clientSettingsLock.Unlock()
+	err = loadClientSettings(persistConf)
+	if err != nil {
+		return err
+	}
+
+	settings := &ClientSettingsJSON{
+		Settings:  params.Settings,
+		Revision:  time.Now().UnixNano(),
+		UpdatedAt: time.Now(),
+	}
+	if settings.Settings == nil {
+		settings.Settings = make(map[string]interface{})
+	}
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return err
+	}
+	store, err := persistConf.GetStorage()
+	if err != nil {
+		return err
+	}
+	err = store.Put(clientSettingsKey, b)
+	if err != nil {
+		return err
+	}
+	clientSettings = settings
+	return nil
+}
+
+// SetClientSettings replaces client settings recommended to clients of the
+// server with persisted config persistConf.
+func SetClientSettings(persistConf *config.Config, settings map[string]interface{}) error {
+	return setClientSettings(persistConf, &setClientSettingsJSON{Settings: settings})
+}
+
+// getClientSettings returns client settings, with quota of the client at src
+// if src is not empty.
+func getClientSettings(persistConf *config.Config, src string) (*ClientSettingsJSON, error) {
+	clientSettingsLock.Lock()
+	defer clientSettingsLock.Unlock()
+	err := loadClientSettings(persistConf)
+	if err != nil {
+		return nil, err
+	}
+	settings := *clientSettings
+	if len(src) > 0 {
+		settings.QuotaMB = persistConf.GetClients()[addrPubKey(src)].QuotaMB
+	}
+	return &settings, nil
+}
+
+// GetClientSettings gets client settings recommended by the server at addr.
+func (c *Client) GetClientSettings(addr string) (*ClientSettingsJSON, error) {
+	res := &ClientSettingsJSON{}
+	err := c.RPCCall(addr, "getClientSettings", nil, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
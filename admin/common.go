@@ -3,9 +3,21 @@ package admin
 import (
 	"errors"
 	"io/ioutil"
+	"log"
 	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/events"
+	"github.com/nknorg/nconnect/monitor"
+	"github.com/nknorg/nconnect/nat"
+	"github.com/nknorg/nconnect/ss"
 	"github.com/nknorg/nconnect/util"
 	"github.com/nknorg/nkn-sdk-go"
 	ts "github.com/nknorg/nkn-tuna-session"
@@ -20,48 +32,140 @@ const (
 	rpcPermissionAcceptClient permission = 1 << iota
 	rpcPermissionAdminClient
 	rpcPermissionWeb
+	rpcPermissionWebViewer     // web user or admin address with viewer role
+	rpcPermissionWebLogin      // web request before login when web users are configured
+	rpcPermissionPairing       // NKN request from a client not paired yet
+	rpcPermissionAdminOperator // admin address with operator role
+	rpcPermissionACL           // issued token with acl scope
 )
 
+// rpcPermissionOperational is permissions that can see host resources and
+// operational state of the server, which accept clients cannot.
+const rpcPermissionOperational = rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer
+
 var (
 	errUnknownMethod    = errors.New("unknown method")
 	errPermissionDenied = errors.New("permission denied")
 	resultSuccess       = "success"
 )
 
+const (
+	maxClientNameLength = 64
+)
+
+var (
+	acceptLock   sync.Mutex
+	acceptPaused bool
+	directPort   int
+	loadMeter    *monitor.LoadMeter
+	dataCap      *monitor.DataCap
+
+	identityLock     sync.Mutex
+	identityConflict string
+
+	clockLock    sync.Mutex
+	clockSkew    time.Duration
+	clockWarning string
+)
+
 var (
 	rpcPermissions = map[string]permission{
-		"getAdminToken":   rpcPermissionAdminClient | rpcPermissionWeb,
-		"getAddrs":        rpcPermissionAdminClient | rpcPermissionWeb,
-		"setAddrs":        rpcPermissionAdminClient | rpcPermissionWeb,
-		"addAddrs":        rpcPermissionAdminClient | rpcPermissionWeb,
-		"removeAddrs":     rpcPermissionAdminClient | rpcPermissionWeb,
-		"getLocalIP":      rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionWeb,
-		"getInfo":         rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionWeb,
-		"getBalance":      rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionWeb,
-		"setAdminHttpApi": rpcPermissionAdminClient | rpcPermissionWeb,
-		"getSeed":         rpcPermissionAdminClient | rpcPermissionWeb,
-		"setSeed":         rpcPermissionAdminClient | rpcPermissionWeb,
-		"setTunaConfig":   rpcPermissionAdminClient | rpcPermissionWeb,
-		"getLog":          rpcPermissionAdminClient | rpcPermissionWeb,
+		"getAdminToken":     rpcPermissionAdminClient | rpcPermissionWeb,
+		"getNKNToken":       rpcPermissionWeb | rpcPermissionWebViewer,
+		"getAddrs":          rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer | rpcPermissionACL,
+		"setAddrs":          rpcPermissionAdminClient | rpcPermissionWeb | rpcPermissionACL,
+		"addAddrs":          rpcPermissionAdminClient | rpcPermissionWeb | rpcPermissionACL,
+		"removeAddrs":       rpcPermissionAdminClient | rpcPermissionWeb | rpcPermissionACL,
+		"getLocalIP":        rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"getInfo":           rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"getBalance":        rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"getTransactions":   rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"setAdminHttpApi":   rpcPermissionAdminClient | rpcPermissionWeb,
+		"getSeed":           rpcPermissionAdminClient | rpcPermissionWeb,
+		"setSeed":           rpcPermissionAdminClient | rpcPermissionWeb,
+		"setTunaConfig":     rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb,
+		"getLog":            rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"followLog":         rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWebViewer,
+		"stopFollowLog":     rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWebViewer,
+		"startDebugSession": rpcPermissionAdminClient | rpcPermissionAdminOperator,
+		"stopDebugSession":  rpcPermissionAdminClient | rpcPermissionAdminOperator,
+		"getRPCChunk":       rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWebViewer,
+		"getSupportBundle":  rpcPermissionAdminClient | rpcPermissionWeb,
+		"getClients":        rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"getSeenClients":    rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"forgetSeenClient":  rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb,
+		"importClients":     rpcPermissionAdminClient | rpcPermissionWeb,
+		"exportClients":     rpcPermissionAdminClient | rpcPermissionWeb,
+		"backupConfig":      rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb,
+		"getConfigBackups":  rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"restoreConfig":     rpcPermissionAdminClient | rpcPermissionWeb,
+		"applyConfig":       rpcPermissionAdminClient | rpcPermissionWeb,
+		"reloadConfig":      rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb,
+		"restart":           rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb,
+		"speedTest":         rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionAdminOperator,
+		"runPathProbe":      rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb,
+		"getSubsystems":     rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"setSubsystem":      rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb,
+		"setRollout":        rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb,
+		"pauseRollout":      rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb,
+		"getRollout":        rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"getUpdate":         rpcPermissionAcceptClient | rpcPermissionAdminClient,
+		"setClientSettings": rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb,
+		"getClientSettings": rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"reportUpdate":      rpcPermissionAcceptClient | rpcPermissionAdminClient,
+		"webLogin":          rpcPermissionWebLogin,
+		"webLogout":         rpcPermissionWebLogin,
+		"changeWebPassword": rpcPermissionWebLogin,
+		"getWebUsers":       rpcPermissionAdminClient | rpcPermissionWeb,
+		"setWebUser":        rpcPermissionAdminClient | rpcPermissionWeb,
+		"removeWebUser":     rpcPermissionAdminClient | rpcPermissionWeb,
+		"enrollWebTOTP":     rpcPermissionWebLogin,
+		"confirmWebTOTP":    rpcPermissionWebLogin,
+		"disableWebTOTP":    rpcPermissionWebLogin,
+		"resetWebTOTP":      rpcPermissionAdminClient | rpcPermissionWeb,
+		"getRevision":       rpcPermissionAcceptClient | rpcPermissionAdminClient | rpcPermissionAdminOperator | rpcPermissionWeb | rpcPermissionWebViewer,
+		"pair":              rpcPermissionPairing,
+		"grantAssist":       rpcPermissionAdminClient | rpcPermissionWeb,
+		"revokeAssist":      rpcPermissionAdminClient | rpcPermissionWeb,
+		"getAssistAudit":    rpcPermissionAdminClient | rpcPermissionWeb,
+		"issueToken":        rpcPermissionAdminClient | rpcPermissionWeb,
+		"listTokens":        rpcPermissionAdminClient | rpcPermissionWeb,
+		"revokeToken":       rpcPermissionAdminClient | rpcPermissionWeb,
+		"getAuditLog":       rpcPermissionAdminClient | rpcPermissionWeb,
 	}
 )
 
 type rpcReq struct {
 	ID      string                 `json:"id"`
 	JSONRPC string                 `json:"jsonrpc"`
+	Version int                    `json:"version,omitempty"` // rpc protocol version, 0 is for version 1
 	Method  string                 `json:"method"`
 	Params  map[string]interface{} `json:"params"`
 	Token   string                 `json:"token"`
+
+	// If set, a mutating method fails unless it is the current revision, so
+	// changes based on stale state are not applied.
+	IfRevision uint64 `json:"ifRevision,omitempty"`
+
+	src string // sender NKN address, empty for web requests
 }
 
 type rpcResp struct {
-	Result interface{} `json:"result,omitempty"`
-	Error  string      `json:"error,omitempty"`
+	Version    int         `json:"version,omitempty"` // rpc protocol version of the response
+	Deprecated string      `json:"deprecated,omitempty"`
+	Revision   uint64      `json:"revision,omitempty"` // config and state revision after the request
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Chunk      *rpcChunk   `json:"chunk,omitempty"`
 }
 
 type addrsJSON struct {
-	AcceptAddrs []string `json:"acceptAddrs"`
-	AdminAddrs  []string `json:"adminAddrs"`
+	AcceptAddrs       []string                     `json:"acceptAddrs"`
+	AdminAddrs        []string                     `json:"adminAddrs"`
+	AcceptAddrEntries []config.AddrEntry           `json:"acceptAddrEntries,omitempty"` // entries with metadata, used instead of acceptAddrs if given
+	AdminAddrEntries  []config.AddrEntry           `json:"adminAddrEntries,omitempty"`  // entries with metadata, used instead of adminAddrs if given
+	Clients           map[string]config.ClientInfo `json:"clients,omitempty"`           // map client public key to client info, only in results
+	Revision          uint64                       `json:"revision,omitempty"`          // revision of the addresses, only in results
 }
 
 type adminTokenJSON struct {
@@ -74,16 +178,45 @@ type localIPJSON struct {
 }
 
 type GetInfoJSON struct {
-	Addr                 string       `json:"addr"`
-	LocalIP              *localIPJSON `json:"localIP"`
-	AdminHTTPAPIDisabled bool         `json:"adminHttpApiDisabled"`
-	Version              string       `json:"version"`
-	Tuna                 bool         `json:"tuna"`
-	TunaServiceName      string       `json:"tunaServiceName,omitempty"`
-	TunaCountry          []string     `json:"tunaCountry,omitempty"`
-	InPrice              []string     `json:"inPrice,omitempty"`
-	OutPrice             []string     `json:"outPrice,omitempty"`
-	Tags                 []string     `json:"tags,omitempty"`
+	Addr                 string             `json:"addr"`
+	LocalIP              *localIPJSON       `json:"localIP"`
+	AdminHTTPAPIDisabled bool               `json:"adminHttpApiDisabled"`
+	Version              string             `json:"version"`
+	Tuna                 bool               `json:"tuna"`
+	TunaServiceName      string             `json:"tunaServiceName,omitempty"`
+	TunaCountry          []string           `json:"tunaCountry,omitempty"`
+	InPrice              []string           `json:"inPrice,omitempty"`
+	OutPrice             []string           `json:"outPrice,omitempty"`
+	Tags                 []string           `json:"tags,omitempty"`
+	AcceptPaused         bool               `json:"acceptPaused,omitempty"`
+	Host                 *monitor.HostStats `json:"host,omitempty"`
+	Load                 *monitor.Load      `json:"load,omitempty"`
+	DataUsage            *monitor.DataUsage `json:"dataUsage,omitempty"`
+	NATType              string             `json:"natType,omitempty"`
+	DirectAddrs          []string           `json:"directAddrs,omitempty"`
+	Cipher               string             `json:"cipher,omitempty"`
+	CipherNote           string             `json:"cipherNote,omitempty"`
+	IdentityConflict     string             `json:"identityConflict,omitempty"`
+	AuditAnomalies       map[string]int64   `json:"auditAnomalies,omitempty"`
+	ClockSkew            int64              `json:"clockSkew,omitempty"` // in seconds, positive if local clock is ahead
+	ClockWarning         string             `json:"clockWarning,omitempty"`
+	NetworkEvents        []NetworkEvent     `json:"networkEvents,omitempty"`
+	PendingRestart       []string           `json:"pendingRestart,omitempty"` // changes that take effect after restart
+	RPCVersion           int                `json:"rpcVersion,omitempty"`
+	MinRPCVersion        int                `json:"minRPCVersion,omitempty"`
+	Revision             uint64             `json:"revision,omitempty"`  // config and state revision
+	DNSSearch            []string           `json:"dnsSearch,omitempty"` // DNS search domains pushed to VPN clients
+}
+
+type getInfoJSON struct {
+	Name    string `json:"name"`              // friendly name of the client
+	Version string `json:"version,omitempty"` // nConnect version of the client
+	TunAddr string `json:"tunAddr,omitempty"` // TUN IP address of the client, empty if TUN is not enabled
+}
+
+type clientJSON struct {
+	PubKey string `json:"pubKey"`
+	config.ClientInfo
 }
 
 type setSeedJSON struct {
@@ -103,21 +236,54 @@ type tunaConfigJSON struct {
 	DisallowIp      []string `json:"disallowIp"`
 }
 
+type configBackupJSON struct {
+	Name string `json:"name"`
+}
+
 type getLogJSON struct {
-	MaxSize int `json:"maxSize"`
+	MaxSize int       `json:"maxSize"`
+	Redact  bool      `json:"redact"`
+	Since   time.Time `json:"since"` // only lines logged at or after, including rotated log files
+	Until   time.Time `json:"until"` // only lines logged at or before, including rotated log files
 }
 
 func handleRequest(req *rpcReq, persistConf, mergedConf *config.Config, tun *tunnel.Tunnel, rpcPerm permission) *rpcResp {
 	resp := &rpcResp{}
 
+	version, err := negotiateRPCVersion(req.Version)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp
+	}
+	resp.Version = version
+	resp.Deprecated = rpcDeprecationNote(version)
+
 	if rpcPermissions[req.Method]&rpcPerm == 0 {
 		resp.Error = errPermissionDenied.Error()
 		return resp
 	}
 
+	if rpcMutating[req.Method] {
+		revisionLock.Lock()
+		defer revisionLock.Unlock()
+		if req.IfRevision > 0 && req.IfRevision != Revision() {
+			resp.Error = errRevisionConflict(req.IfRevision).Error()
+			resp.Result = currentState(req.Method, persistConf)
+			setRevision(resp, Revision())
+			return resp
+		}
+	}
+
 	switch req.Method {
 	case "getAdminToken":
 		resp.Result = getAdminToken()
+	case "getNKNToken":
+		res, err := getNKNToken(persistConf, req.Token)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = res
 	case "getAddrs":
 		resp.Result = getAddrs(persistConf)
 	case "setAddrs":
@@ -127,116 +293,686 @@ func handleRequest(req *rpcReq, persistConf, mergedConf *config.Config, tun *tun
 			resp.Error = err.Error()
 			break
 		}
-		err = setAddrs(persistConf, addrs, tun)
+		err = checkAddrsPermission(addrs, rpcPerm)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = setAddrs(persistConf, addrs, tun)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = getAddrs(persistConf)
+	case "addAddrs":
+		addrs := &addrsJSON{}
+		err := util.JSONConvert(req.Params, addrs)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = checkAddrsPermission(addrs, rpcPerm)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = addAddrs(persistConf, addrs, tun)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = getAddrs(persistConf)
+	case "removeAddrs":
+		addrs := &addrsJSON{}
+		err := util.JSONConvert(req.Params, addrs)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = checkAddrsPermission(addrs, rpcPerm)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = removeAddrs(persistConf, addrs, tun)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = getAddrs(persistConf)
+	case "getLocalIP":
+		localIP, err := getLocalIP()
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = localIP
+	case "getInfo":
+		params := &getInfoJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		if len(req.src) > 0 && len(params.Name) > 0 {
+			err = persistConf.SetClientName(addrPubKey(req.src), sanitizeClientName(params.Name))
+			if err != nil {
+				log.Println("Save client name error:", err)
+			}
+		}
+		if len(req.src) > 0 && rpcPerm&(rpcPermissionAcceptClient|rpcPermissionAdminClient|rpcPermissionAdminOperator) != 0 {
+			recordSeenClient(persistConf, req.src, params)
+		}
+		info, err := getInfo(persistConf, mergedConf, tun, rpcPerm&rpcPermissionOperational != 0)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = info
+	case "getBalance":
+		balance, err := getBalance(tun)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = balance
+	case "getTransactions":
+		params := &getTransactionsJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		txs, err := getTransactions(mergedConf, tun, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = txs
+	case "setAdminHttpApi":
+		params := &adminHTTPAPIJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = setAdminHTTPAPI(persistConf, mergedConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = resultSuccess
+	case "getSeed":
+		resp.Result = mergedConf.Seed
+	case "setSeed":
+		params := &setSeedJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = persistConf.SetSeed(params.Seed)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		util.DefaultRedactor.AddSecrets(params.Seed)
+		AddPendingRestart("seed")
+		resp.Result = resultSuccess
+	case "setTunaConfig":
+		params := &tunaConfigJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = setTunaConfig(tun, persistConf, mergedConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = resultSuccess
+	case "getLog":
+		params := &getLogJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		logContent, err := getLog(mergedConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = logContent
+	case "getAuditLog":
+		params := &getAuditLogJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		res, err := getAuditLog(mergedConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = res
+	case "followLog":
+		params := &followLogJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		res, err := followLog(mergedConf, req.src, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = res
+	case "stopFollowLog":
+		params := &stopFollowLogJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = stopFollowLog(req.src, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = resultSuccess
+	case "startDebugSession":
+		params := &startDebugSessionJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		res, err := startDebugSession(req.src, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = res
+	case "stopDebugSession":
+		params := &stopDebugSessionJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = stopDebugSession(req.src, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = resultSuccess
+	case "getSupportBundle":
+		bundle, err := getSupportBundle(persistConf, mergedConf)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = bundle
+	case "getClients":
+		resp.Result = getClients(persistConf)
+	case "getSeenClients":
+		clients, err := getSeenClients(persistConf)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = clients
+	case "forgetSeenClient":
+		params := &forgetSeenClientJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = forgetSeenClient(persistConf, params.PubKey)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = resultSuccess
+	case "pair":
+		params := &pairJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = pair(persistConf, tun, req.src, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = resultSuccess
+	case "importClients":
+		params := &importClientsJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		_, err = importClients(persistConf, tun, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = getAddrs(persistConf)
+	case "exportClients":
+		params := &exportClientsJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		data, err := exportClients(persistConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = data
+	case "backupConfig":
+		name, err := persistConf.Backup()
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = &configBackupJSON{Name: name}
+	case "getConfigBackups":
+		names, err := persistConf.ListBackups()
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = names
+	case "restoreConfig":
+		params := &configBackupJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = restoreConfig(persistConf, mergedConf, params, tun)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = getAddrs(persistConf)
+	case "applyConfig":
+		result, err := applyConfig(persistConf, mergedConf, tun, req.Params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = result
+	case "reloadConfig":
+		result, err := reloadConfig()
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = result
+	case "restart":
+		params := &restartJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		result, err := restart(params, tun)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = result
+	case "getSubsystems":
+		resp.Result = getSubsystems(mergedConf)
+	case "setSubsystem":
+		params := &subsystemJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = setSubsystem(persistConf, mergedConf, tun, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = getSubsystems(mergedConf)
+	case "setRollout":
+		params := &rolloutJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = setRollout(persistConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result, err = getRollout(persistConf)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+	case "pauseRollout":
+		params := &pauseRolloutJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = pauseRollout(persistConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result, err = getRollout(persistConf)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+	case "getRollout":
+		state, err := getRollout(persistConf)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = state
+	case "setClientSettings":
+		params := &setClientSettingsJSON{}
+		err := util.JSONConvert(req.Params, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		resp.Result = getAddrs(persistConf)
-	case "addAddrs":
-		addrs := &addrsJSON{}
-		err := util.JSONConvert(req.Params, addrs)
+		err = setClientSettings(persistConf, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		err = addAddrs(persistConf, addrs, tun)
+		resp.Result, err = getClientSettings(persistConf, "")
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		resp.Result = getAddrs(persistConf)
-	case "removeAddrs":
-		addrs := &addrsJSON{}
-		err := util.JSONConvert(req.Params, addrs)
+	case "getClientSettings":
+		settings, err := getClientSettings(persistConf, req.src)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		err = removeAddrs(persistConf, addrs, tun)
+		resp.Result = settings
+	case "getUpdate":
+		params := &getUpdateJSON{}
+		err := util.JSONConvert(req.Params, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		resp.Result = getAddrs(persistConf)
-	case "getLocalIP":
-		localIP, err := getLocalIP()
+		if len(req.src) == 0 {
+			resp.Error = errNoRolloutClient.Error()
+			break
+		}
+		update, err := getUpdate(persistConf, addrPubKey(req.src), params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		resp.Result = localIP
-	case "getInfo":
-		info, err := getInfo(mergedConf, tun)
+		resp.Result = update
+	case "reportUpdate":
+		params := &reportUpdateJSON{}
+		err := util.JSONConvert(req.Params, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		resp.Result = info
-	case "getBalance":
-		balance, err := getBalance(tun)
+		if len(req.src) == 0 {
+			resp.Error = errNoRolloutClient.Error()
+			break
+		}
+		err = reportUpdate(persistConf, addrPubKey(req.src), params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		resp.Result = balance
-	case "setAdminHttpApi":
-		params := &adminHTTPAPIJSON{}
+	case "speedTest":
+		params := &speedTestJSON{}
 		err := util.JSONConvert(req.Params, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		err = setAdminHTTPAPI(persistConf, mergedConf, params)
+		payload, err := speedTest(params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = payload
+	case "runPathProbe":
+		params := &runPathProbeJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		res, err := runPathProbe(params, tun)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = res
+	case "webLogin":
+		params := &webLoginJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		res, err := webLogin(persistConf, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
+		resp.Result = res
+	case "webLogout":
+		webSessions.remove(req.Token)
 		resp.Result = resultSuccess
-	case "getSeed":
-		resp.Result = mergedConf.Seed
-	case "setSeed":
-		params := &setSeedJSON{}
+	case "changeWebPassword":
+		params := &changeWebPasswordJSON{}
 		err := util.JSONConvert(req.Params, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		err = persistConf.SetSeed(params.Seed)
+		err = changeWebPassword(persistConf, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
 		resp.Result = resultSuccess
-	case "setTunaConfig":
-		params := &tunaConfigJSON{}
+	case "getWebUsers":
+		resp.Result = getWebUsers(persistConf)
+	case "setWebUser":
+		params := &webUserJSON{}
 		err := util.JSONConvert(req.Params, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		err = setTunaConfig(tun, persistConf, mergedConf, params)
+		err = setWebUser(persistConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = getWebUsers(persistConf)
+	case "removeWebUser":
+		params := &webUserJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = removeWebUser(persistConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = getWebUsers(persistConf)
+	case "enrollWebTOTP":
+		res, err := enrollWebTOTP(req.Token)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = res
+	case "confirmWebTOTP":
+		params := &webTOTPCodeJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		res, err := confirmWebTOTP(persistConf, req.Token, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = res
+	case "disableWebTOTP":
+		params := &webTOTPCodeJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = disableWebTOTP(persistConf, req.Token, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
 		resp.Result = resultSuccess
-	case "getLog":
-		params := &getLogJSON{}
+	case "resetWebTOTP":
+		params := &webUserJSON{}
 		err := util.JSONConvert(req.Params, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		logContent, err := getLog(mergedConf, params)
+		err = resetWebTOTP(persistConf, params)
 		if err != nil {
 			resp.Error = err.Error()
 			break
 		}
-		resp.Result = logContent
+		resp.Result = getWebUsers(persistConf)
+	case "getRPCChunk":
+		params := &getRPCChunkJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		chunk, err := chunkStore.get(req.src, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = chunk
+	case "getRevision":
+		resp.Result = &revisionJSON{Revision: Revision()}
+	case "grantAssist":
+		params := &grantAssistJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		audit, err := grantAssist(persistConf, tun, req.src, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = audit
+	case "revokeAssist":
+		params := &revokeAssistJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = revokeAssist(persistConf, tun, req.src, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = resultSuccess
+	case "getAssistAudit":
+		params := &getAssistAuditJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		entries, err := getAssistAudit(persistConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = entries
+	case "issueToken":
+		params := &issueTokenJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		token, err := issueToken(persistConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = token
+	case "listTokens":
+		tokens, err := listTokens(persistConf)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = tokens
+	case "revokeToken":
+		params := &revokeTokenJSON{}
+		err := util.JSONConvert(req.Params, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		err = revokeToken(persistConf, params)
+		if err != nil {
+			resp.Error = err.Error()
+			break
+		}
+		resp.Result = resultSuccess
 	default:
 		resp.Error = errUnknownMethod.Error()
 	}
+
+	if rpcMutating[req.Method] && len(resp.Error) == 0 {
+		setRevision(resp, BumpRevision())
+		if req.Method != "reloadConfig" { // published by config reloader with changed fields
+			events.Publish(events.ConfigChanged{Method: req.Method})
+		}
+	} else {
+		setRevision(resp, Revision())
+	}
 	return resp
 }
 
@@ -250,31 +986,100 @@ func getAdminToken() *adminTokenJSON {
 	}
 }
 
+// addrPubKey returns the public key part of an NKN address.
+func addrPubKey(addr string) string {
+	return addr[strings.LastIndex(addr, ".")+1:]
+}
+
+// sanitizeClientName removes control characters and limits name length.
+func sanitizeClientName(name string) string {
+	name = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name))
+	if r := []rune(name); len(r) > maxClientNameLength {
+		name = string(r[:maxClientNameLength])
+	}
+	return name
+}
+
+func getClients(conf *config.Config) []*clientJSON {
+	infos := conf.GetClients()
+	clients := make([]*clientJSON, 0, len(infos))
+	for pubKey, info := range infos {
+		clients = append(clients, &clientJSON{PubKey: pubKey, ClientInfo: info})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].Name < clients[j].Name
+	})
+	return clients
+}
+
+// getAddrs returns addresses not expired, and all address entries with their
+// metadata.
 func getAddrs(conf *config.Config) *addrsJSON {
 	return &addrsJSON{
-		AcceptAddrs: conf.GetAcceptAddrs(),
-		AdminAddrs:  conf.GetAdminAddrs(),
+		AcceptAddrs:       conf.GetAcceptAddrs(),
+		AdminAddrs:        conf.GetAdminAddrs(),
+		AcceptAddrEntries: conf.GetAcceptAddrEntries(),
+		AdminAddrEntries:  conf.GetAdminAddrEntries(),
+		Clients:           conf.GetClients(),
+	}
+}
+
+// checkAddrsPermission returns errPermissionDenied if addrs changes admin
+// addresses but rpcPerm is only acl, which may change accept addresses only,
+// so an acl token can't make any address an owner or remove the owners.
+func checkAddrsPermission(addrs *addrsJSON, rpcPerm permission) error {
+	if rpcPerm&(rpcPermissionAdminClient|rpcPermissionWeb) != 0 {
+		return nil
 	}
+	if addrs.AdminAddrs != nil || addrs.AdminAddrEntries != nil {
+		return errPermissionDenied
+	}
+	return nil
 }
 
 func setAddrs(conf *config.Config, addrs *addrsJSON, tun *tunnel.Tunnel) error {
-	if addrs.AcceptAddrs != nil {
+	if addrs.AcceptAddrEntries != nil {
+		err := conf.SetAcceptAddrEntries(addrs.AcceptAddrEntries)
+		if err != nil {
+			return err
+		}
+	} else if addrs.AcceptAddrs != nil {
 		conf.SetAcceptAddrs(addrs.AcceptAddrs)
 	}
-	if addrs.AdminAddrs != nil {
+	if addrs.AdminAddrEntries != nil {
+		err := conf.SetAdminAddrEntries(addrs.AdminAddrEntries)
+		if err != nil {
+			return err
+		}
+	} else if addrs.AdminAddrs != nil {
 		conf.SetAdminAddrs(addrs.AdminAddrs)
 	}
-	return tun.SetAcceptAddrs(nkn.NewStringArray(conf.GetAcceptAddrs()...))
+	return applyAcceptAddrs(conf, tun)
 }
 
 func addAddrs(conf *config.Config, addrs *addrsJSON, tun *tunnel.Tunnel) error {
-	if addrs.AcceptAddrs != nil {
+	if addrs.AcceptAddrEntries != nil {
+		err := conf.AddAcceptAddrEntries(addrs.AcceptAddrEntries)
+		if err != nil {
+			return err
+		}
+	} else if addrs.AcceptAddrs != nil {
 		conf.AddAcceptAddrs(addrs.AcceptAddrs)
 	}
-	if addrs.AdminAddrs != nil {
+	if addrs.AdminAddrEntries != nil {
+		err := conf.AddAdminAddrEntries(addrs.AdminAddrEntries)
+		if err != nil {
+			return err
+		}
+	} else if addrs.AdminAddrs != nil {
 		conf.AddAdminAddrs(addrs.AdminAddrs)
 	}
-	return tun.SetAcceptAddrs(nkn.NewStringArray(conf.GetAcceptAddrs()...))
+	return applyAcceptAddrs(conf, tun)
 }
 
 func removeAddrs(conf *config.Config, addrs *addrsJSON, tun *tunnel.Tunnel) error {
@@ -284,7 +1089,136 @@ func removeAddrs(conf *config.Config, addrs *addrsJSON, tun *tunnel.Tunnel) erro
 	if addrs.AdminAddrs != nil {
 		conf.RemoveAdminAddrs(addrs.AdminAddrs)
 	}
-	return tun.SetAcceptAddrs(nkn.NewStringArray(conf.GetAcceptAddrs()...))
+	return applyAcceptAddrs(conf, tun)
+}
+
+// restoreConfig restores persisted config from a backup and applies accept
+// addresses and admin http api setting immediately. Other settings take
+// effect after restart.
+func restoreConfig(persistConf, mergedConf *config.Config, params *configBackupJSON, tun *tunnel.Tunnel) error {
+	err := persistConf.Restore(params.Name)
+	if err != nil {
+		return err
+	}
+	util.DefaultRedactor.AddSecrets(persistConf.Seed, persistConf.Password)
+	err = mergedConf.SetAdminHTTPAPI(persistConf.DisableAdminHTTPAPI)
+	if err != nil {
+		return err
+	}
+	// other restored fields take effect after restart
+	AddPendingRestart("restoreConfig")
+	return applyAcceptAddrs(persistConf, tun)
+}
+
+// applyAcceptAddrs updates tunnel accept addresses from conf unless accepting
+// new connections is paused.
+func applyAcceptAddrs(conf *config.Config, tun *tunnel.Tunnel) error {
+	acceptLock.Lock()
+	defer acceptLock.Unlock()
+	if acceptPaused {
+		return nil
+	}
+	ScheduleAddrExpiry(conf, tun)
+	err := tun.SetAcceptAddrs(nkn.NewStringArray(conf.GetAcceptAddrs()...))
+	if err != nil {
+		return err
+	}
+	return applyTunaSubsystem(tun)
+}
+
+// SetAcceptPaused stops (or resumes) accepting new tunnel connections without
+// affecting existing ones.
+func SetAcceptPaused(conf *config.Config, tun *tunnel.Tunnel, paused bool) error {
+	acceptLock.Lock()
+	defer acceptLock.Unlock()
+	acceptPaused = paused
+	if paused {
+		return tun.SetAcceptAddrs(nkn.NewStringArray())
+	}
+	ScheduleAddrExpiry(conf, tun)
+	err := tun.SetAcceptAddrs(nkn.NewStringArray(conf.GetAcceptAddrs()...))
+	if err != nil {
+		return err
+	}
+	return applyTunaSubsystem(tun)
+}
+
+func IsAcceptPaused() bool {
+	acceptLock.Lock()
+	defer acceptLock.Unlock()
+	return acceptPaused
+}
+
+// SetIdentityConflict records that another live node appears to use the same
+// identity, so it is shown in get info api.
+func SetIdentityConflict(reason string) {
+	identityLock.Lock()
+	defer identityLock.Unlock()
+	identityConflict = reason
+}
+
+func getIdentityConflict() string {
+	identityLock.Lock()
+	defer identityLock.Unlock()
+	return identityConflict
+}
+
+// SetClockSkew records the measured local clock skew and a warning if it
+// exceeds thresholds, so they are shown in get info api.
+func SetClockSkew(skew time.Duration, warning string) {
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	clockSkew = skew
+	clockWarning = warning
+}
+
+func getClockSkew() (time.Duration, string) {
+	clockLock.Lock()
+	defer clockLock.Unlock()
+	return clockSkew, clockWarning
+}
+
+// SetDirectPort sets the port of the direct connection server so it is
+// advertised in get info api. 0 means direct connection is disabled.
+func SetDirectPort(port int) {
+	directPort = port
+}
+
+// SetLoadMeter sets the load meter of the server so its load is published in
+// get info api.
+func SetLoadMeter(m *monitor.LoadMeter) {
+	loadMeter = m
+}
+
+// SetDataCap sets the monthly data cap so its usage is published in get info
+// api, and its limits are changed when config changes.
+func SetDataCap(d *monitor.DataCap) {
+	dataCap = d
+}
+
+// setDataCapLimits applies monthly data cap config to data cap if enabled.
+func setDataCapLimits(conf *config.Config) {
+	if dataCap != nil {
+		dataCap.SetLimits(conf.MonthlyDataCapBytes(), conf.DataCapResetDay, conf.DataCapWarnPercent)
+	}
+}
+
+// getDirectAddrs returns the addresses clients may try to connect directly.
+// Public addresses are only included when NAT detection says the host is
+// reachable from outside.
+func getDirectAddrs(localIP *localIPJSON) []string {
+	if directPort == 0 {
+		return nil
+	}
+	open := nat.Detected() == nat.TypeOpen
+	addrs := make([]string, 0, len(localIP.Ipv4))
+	for _, ip := range localIP.Ipv4 {
+		if !open && !net.ParseIP(ip).IsPrivate() {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(ip, strconv.Itoa(directPort)))
+	}
+	return addrs
 }
 
 func getLocalIP() (*localIPJSON, error) {
@@ -325,7 +1259,10 @@ func getLocalIP() (*localIPJSON, error) {
 	return &localIPJSON{Ipv4: ipv4}, nil
 }
 
-func getInfo(conf *config.Config, tun *tunnel.Tunnel) (*GetInfoJSON, error) {
+// getInfo returns info of the server. Host resources and operational state
+// are only included if operational is true, otherwise only the load score is
+// included for clients to choose servers by.
+func getInfo(persistConf, conf *config.Config, tun *tunnel.Tunnel, operational bool) (*GetInfoJSON, error) {
 	localIP, err := getLocalIP()
 	if err != nil {
 		return nil, err
@@ -338,6 +1275,13 @@ func getInfo(conf *config.Config, tun *tunnel.Tunnel) (*GetInfoJSON, error) {
 		TunaServiceName:      conf.TunaServiceName,
 		TunaCountry:          conf.TunaCountry,
 		Version:              config.Version,
+		Revision:             Revision(),
+		Cipher:               conf.Cipher,
+		RPCVersion:           RPCVersion,
+		MinRPCVersion:        MinRPCVersion,
+	}
+	if conf.Cipher == config.CipherDummy {
+		info.CipherNote = config.DummyCipherNote
 	}
 	tunaPubAddrs := tun.TunaPubAddrs()
 	if tunaPubAddrs != nil {
@@ -353,6 +1297,48 @@ func getInfo(conf *config.Config, tun *tunnel.Tunnel) (*GetInfoJSON, error) {
 	if len(conf.Tags) > 0 {
 		info.Tags = conf.Tags
 	}
+	if len(conf.VPNDNSSearch) > 0 {
+		info.DNSSearch = conf.VPNDNSSearch
+	}
+	info.AcceptPaused = IsAcceptPaused()
+	info.DirectAddrs = getDirectAddrs(localIP)
+	if loadMeter != nil {
+		load := loadMeter.Load()
+		if load != nil && !operational {
+			load = &monitor.Load{Score: load.Score}
+		}
+		info.Load = load
+	}
+	if !operational {
+		return info, nil
+	}
+
+	info.NATType = string(nat.Detected())
+	if conf.StrictAudit {
+		info.AuditAnomalies = ss.GetAuditAnomalies()
+	}
+	info.IdentityConflict = getIdentityConflict()
+	skew, warning := getClockSkew()
+	info.ClockSkew = int64(skew.Round(time.Second) / time.Second)
+	info.ClockWarning = warning
+	info.NetworkEvents = GetNetworkEvents()
+	if pending := getPendingRestart(); len(pending) > 0 {
+		info.PendingRestart = pending
+	}
+
+	diskPath := filepath.Dir(persistConf.Path())
+	if len(conf.LogFileName) > 0 {
+		diskPath = filepath.Dir(conf.LogFileName)
+	}
+	info.Host, err = monitor.GetHostStats(diskPath)
+	if err != nil {
+		log.Println("Get host stats error:", err)
+	}
+	if dataCap != nil {
+		usage := dataCap.Usage()
+		info.DataUsage = &usage
+	}
+
 	return info, nil
 }
 
@@ -425,10 +1411,16 @@ func getLog(conf *config.Config, params *getLogJSON) (string, error) {
 	if len(conf.LogFileName) == 0 {
 		return "", nil
 	}
+	if !params.Since.IsZero() || !params.Until.IsZero() {
+		return getLogRange(conf, params)
+	}
 	b, err := ioutil.ReadFile(conf.LogFileName)
 	if err != nil {
 		return "", err
 	}
+	if params.Redact {
+		b = util.DefaultRedactor.Redact(b)
+	}
 	if conf.LogAPIResponseSize > 0 && len(b) > conf.LogAPIResponseSize {
 		b = b[len(b)-conf.LogAPIResponseSize:]
 	}
@@ -437,3 +1429,23 @@ func getLog(conf *config.Config, params *getLogJSON) (string, error) {
 	}
 	return string(b), nil
 }
+
+// getLogRange returns log lines in the time range of params using sparse
+// indexes of log files. If since is set, the earliest part is kept when log
+// exceeds size limits, so callers can page forward from the time of the last
+// line returned.
+func getLogRange(conf *config.Config, params *getLogJSON) (string, error) {
+	limit := conf.LogAPIResponseSize
+	if params.MaxSize > 0 && (limit == 0 || params.MaxSize < limit) {
+		limit = params.MaxSize
+	}
+	fromStart := !params.Since.IsZero()
+	b, err := readLogRange(conf.LogFileName, params.Since, params.Until, limit)
+	if err != nil {
+		return "", err
+	}
+	if params.Redact {
+		b = util.DefaultRedactor.Redact(b)
+	}
+	return string(trimLog(b, limit, fromStart)), nil
+}
@@ -0,0 +1,350 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/events"
+	"github.com/nknorg/nconnect/ss"
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const (
+	defaultDebugSessionDuration = 10 * time.Minute
+	maxDebugSessionDuration     = time.Hour
+	maxDebugSessions            = 4
+	debugSessionBufferSize      = 64 * 1024 // lines beyond are dropped until sent
+	debugTimeFormat             = "2006/01/02 15:04:05"
+)
+
+var (
+	errDebugScopeRequired   = errors.New("clientAddr or tag is required to scope debug session")
+	errTooManyDebugSessions = errors.New("too many debug sessions")
+	errDebugSessionNotFound = errors.New("debug session not found")
+)
+
+var (
+	debugSessions = newDebugSessionStore()
+)
+
+type startDebugSessionJSON struct {
+	ClientAddr string `json:"clientAddr,omitempty"` // regex of client NKN address, or source address of proxy connections
+	Tag        string `json:"tag,omitempty"`        // connection tag of proxy connections
+	Duration   int    `json:"duration,omitempty"`   // in seconds, 600 if 0 and at most 3600
+}
+
+type debugSessionJSON struct {
+	SessionID string    `json:"sessionId"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+type stopDebugSessionJSON struct {
+	SessionID string `json:"sessionId"`
+}
+
+// debugSession collects debug lines of the client address and connection tag
+// it is scoped to, whether verbose logs are on or not, and pushes them to
+// dest as logLines messages like a log follower until it is stopped or
+// expires. Lines are not written to log, so a busy server does not need
+// verbose logs of all clients to debug one of them.
+type debugSession struct {
+	id         string
+	dest       string
+	clientAddr *regexp.Regexp
+	tag        string
+	expiresAt  time.Time
+
+	lock    sync.Mutex
+	lines   []byte
+	dropped int
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+type debugSessionStore struct {
+	lock     sync.RWMutex
+	sessions map[string]*debugSession
+}
+
+func newDebugSessionStore() *debugSessionStore {
+	return &debugSessionStore{
+		sessions: make(map[string]*debugSession),
+	}
+}
+
+// add adds d, and starts collecting proxy connection lines with the first
+// session.
+func (s *debugSessionStore) add(d *debugSession) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.sessions) >= maxDebugSessions {
+		return errTooManyDebugSessions
+	}
+	s.sessions[d.id] = d
+	if len(s.sessions) == 1 {
+		ss.SetDebugSink(func(l *ss.DebugLine) {
+			s.publish(l.Source, l.Tag, "proxy: "+l.Line)
+		})
+	}
+	return nil
+}
+
+func (s *debugSessionStore) remove(id, src string) error {
+	s.lock.RLock()
+	d, ok := s.sessions[id]
+	s.lock.RUnlock()
+	if !ok || d.dest != src {
+		return errDebugSessionNotFound
+	}
+	d.close()
+	return nil
+}
+
+// delete deletes session of id, and stops collecting proxy connection lines
+// with the last session.
+func (s *debugSessionStore) delete(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, id)
+	if len(s.sessions) == 0 {
+		ss.SetDebugSink(nil)
+	}
+}
+
+func (s *debugSessionStore) active() bool {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return len(s.sessions) > 0
+}
+
+// publish adds line about clientAddr and tag, either of which can be empty, to
+// sessions scoped to them.
+func (s *debugSessionStore) publish(clientAddr, tag, line string) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	for _, d := range s.sessions {
+		if d.match(clientAddr, tag) {
+			d.add(line)
+		}
+	}
+}
+
+// publishRPC adds an admin rpc received over NKN from src, with secret params
+// redacted, to sessions scoped to src.
+func (s *debugSessionStore) publishRPC(src string, req *rpcReq, resp *rpcResp, elapsed time.Duration) {
+	if !s.active() {
+		return
+	}
+	params, _ := json.Marshal(redactAuditParams(req.Params))
+	result := AuditResultOK
+	if len(resp.Error) > 0 {
+		result = AuditResultError + ": " + resp.Error
+	}
+	s.publish(src, "", fmt.Sprintf("rpc %s %s from %s in %v: %s", req.Method, params, src, elapsed.Round(time.Millisecond), result))
+}
+
+func newDebugSession(dest string, params *startDebugSessionJSON) (*debugSession, error) {
+	if len(params.ClientAddr) == 0 && len(params.Tag) == 0 {
+		return nil, errDebugScopeRequired
+	}
+	d := &debugSession{
+		dest: dest,
+		tag:  params.Tag,
+		stop: make(chan struct{}),
+	}
+	if len(params.ClientAddr) > 0 {
+		var err error
+		d.clientAddr, err = regexp.Compile(params.ClientAddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	duration := time.Duration(params.Duration) * time.Second
+	if duration <= 0 {
+		duration = defaultDebugSessionDuration
+	}
+	if duration > maxDebugSessionDuration {
+		duration = maxDebugSessionDuration
+	}
+	d.expiresAt = time.Now().Add(duration)
+
+	b := make([]byte, logFollowerIDSize)
+	rand.Read(b)
+	d.id = hex.EncodeToString(b)
+	return d, nil
+}
+
+func (d *debugSession) close() {
+	d.stopOnce.Do(func() {
+		close(d.stop)
+	})
+}
+
+// match returns whether a line about clientAddr and tag is in the scope of
+// d. Lines without tag are not in the scope of a session with tag.
+func (d *debugSession) match(clientAddr, tag string) bool {
+	if d.clientAddr != nil && (len(clientAddr) == 0 || !d.clientAddr.MatchString(clientAddr)) {
+		return false
+	}
+	if len(d.tag) > 0 && d.tag != tag {
+		return false
+	}
+	return true
+}
+
+func (d *debugSession) add(line string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if len(d.lines)+len(line) >= debugSessionBufferSize {
+		d.dropped++
+		return
+	}
+	d.lines = append(d.lines, time.Now().Format(debugTimeFormat)...)
+	d.lines = append(d.lines, ' ')
+	d.lines = append(d.lines, line...)
+	d.lines = append(d.lines, '\n')
+}
+
+// take returns lines collected since the last call.
+func (d *debugSession) take() []byte {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	lines := d.lines
+	if d.dropped > 0 {
+		lines = append(lines, fmt.Sprintf("%s %d debug lines dropped as they came faster than sent\n", time.Now().Format(debugTimeFormat), d.dropped)...)
+		d.dropped = 0
+	}
+	d.lines = nil
+	return lines
+}
+
+// eventClientAddr returns the client address of e, or empty if it is not
+// about a client.
+func eventClientAddr(e events.Event) string {
+	switch e := e.(type) {
+	case events.ClientConnected:
+		return e.Addr
+	case events.ClientAccepted:
+		return e.Addr
+	case events.ClientRejected:
+		return e.Addr
+	}
+	return ""
+}
+
+func (d *debugSession) run(m *nkn.MultiClient) {
+	defer debugSessions.delete(d.id)
+	defer d.close()
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	ticker := time.NewTicker(followLogInterval)
+	defer ticker.Stop()
+	expire := time.NewTimer(time.Until(d.expiresAt))
+	defer expire.Stop()
+
+	var seq uint64
+	var pending []byte
+	misses := 0
+	for {
+		select {
+		case e := <-ch:
+			if addr := eventClientAddr(e); d.match(addr, "") {
+				b, _ := json.Marshal(e)
+				d.add(fmt.Sprintf("event %s %s", e.Type(), b))
+			}
+			continue
+		case <-ticker.C:
+		case <-expire.C:
+			log.Printf("Debug session %s of %s expired", d.id, d.dest)
+			return
+		case <-d.stop:
+			return
+		}
+
+		if len(pending) == 0 {
+			pending = d.take()
+		}
+		if len(pending) == 0 {
+			continue
+		}
+		ack, err := sendLogLines(m, d.dest, d.id, seq, pending, d.stop)
+		if err != nil {
+			misses++
+			if misses >= followLogMaxMisses {
+				log.Printf("Stop debug session %s of %s: %v", d.id, d.dest, err)
+				return
+			}
+			continue
+		}
+		misses = 0
+		if ack.Stop {
+			return
+		}
+		if ack.Seq == seq {
+			pending = nil
+			seq++
+		}
+	}
+}
+
+func startDebugSession(src string, params *startDebugSessionJSON) (*debugSessionJSON, error) {
+	if serverMultiClient == nil || len(src) == 0 {
+		return nil, errNKNServerNotReady
+	}
+
+	d, err := newDebugSession(src, params)
+	if err != nil {
+		return nil, err
+	}
+	err = debugSessions.add(d)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Debug session %s of %s started for clientAddr %q tag %q until %v", d.id, src, params.ClientAddr, params.Tag, d.expiresAt.Format(debugTimeFormat))
+	go d.run(serverMultiClient)
+
+	return &debugSessionJSON{SessionID: d.id, ExpiresAt: d.expiresAt}, nil
+}
+
+func stopDebugSession(src string, params *stopDebugSessionJSON) error {
+	return debugSessions.remove(params.SessionID, src)
+}
+
+// StartDebugSession asks the server at addr to stream debug lines of clients
+// matching clientAddr regex and proxy connections of tag for duration (server
+// default if 0), and calls onLines with each chunk in order. The returned
+// function stops the session.
+func (c *Client) StartDebugSession(addr, clientAddr, tag string, duration time.Duration, onLines func(string)) (func() error, error) {
+	c.msgLoopOnce.Do(func() {
+		go c.handleMessages()
+	})
+
+	c.beginFollow()
+	res := &debugSessionJSON{}
+	err := c.RPCCall(addr, "startDebugSession", &startDebugSessionJSON{ClientAddr: clientAddr, Tag: tag, Duration: int(duration / time.Second)}, res)
+	if err != nil {
+		c.endFollow("", nil)
+		return nil, err
+	}
+	c.endFollow(res.SessionID, onLines)
+
+	stop := func() error {
+		c.lock.Lock()
+		delete(c.logHandlers, res.SessionID)
+		c.lock.Unlock()
+		return c.RPCCall(addr, "stopDebugSession", &stopDebugSessionJSON{SessionID: res.SessionID}, nil)
+	}
+
+	return stop, nil
+}
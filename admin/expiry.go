@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	tunnel "github.com/nknorg/nkn-tunnel"
+)
+
+var (
+	addrExpiryLock  sync.Mutex
+	addrExpiryTimer *time.Timer
+)
+
+// ScheduleAddrExpiry re-applies accept addresses when the next accept or
+// admin address entry expires, so tunnels stop accepting it without waiting
+// for addresses to change. Admin addresses are checked on each request and
+// expire on time anyway. It replaces the previously scheduled expiry.
+func ScheduleAddrExpiry(conf *config.Config, tun *tunnel.Tunnel) {
+	addrExpiryLock.Lock()
+	defer addrExpiryLock.Unlock()
+
+	if addrExpiryTimer != nil {
+		addrExpiryTimer.Stop()
+		addrExpiryTimer = nil
+	}
+	next := conf.NextAddrExpiry()
+	if next.IsZero() {
+		return
+	}
+	addrExpiryTimer = time.AfterFunc(time.Until(next), func() {
+		log.Println("Accept or admin address entry expired")
+		BumpRevision()
+		err := applyAcceptAddrs(conf, tun)
+		if err != nil {
+			log.Printf("Apply accept addresses error: %v", err)
+		}
+	})
+}
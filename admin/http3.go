@@ -0,0 +1,39 @@
+//go:build http3
+// +build http3
+
+package admin
+
+import (
+	"crypto/tls"
+	"net/http"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Server serves admin web GUI over HTTP/3 next to the TLS server, which
+// advertises it to browsers with Alt-Svc header.
+type http3Server struct {
+	*http3.Server
+}
+
+func newHTTP3Server(listenAddr string, handler http.Handler, tlsConfig *tls.Config) (*http3Server, error) {
+	return &http3Server{
+		Server: &http3.Server{
+			Addr:      listenAddr,
+			Handler:   handler,
+			TLSConfig: tlsConfig,
+		},
+	}, nil
+}
+
+func (s *http3Server) setAltSvc(header http.Header) {
+	s.SetQuicHeaders(header)
+}
+
+func (s *http3Server) listenAndServe() error {
+	return s.ListenAndServe()
+}
+
+func (s *http3Server) close() error {
+	return s.Close()
+}
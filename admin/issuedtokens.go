@@ -0,0 +1,262 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/storage"
+)
+
+const (
+	issuedTokensKey   = "admin-tokens.json"
+	issuedTokenIDSize = 8 // in hex chars of token checksum
+
+	DefaultIssuedTokenTTL = 24 * time.Hour
+	MaxIssuedTokenTTL     = 365 * 24 * time.Hour
+
+	TokenScopeRead  = "read"  // methods allowed to viewers
+	TokenScopeWrite = "write" // methods allowed to operators
+	TokenScopeACL   = "acl"   // get addresses and change accept addresses only
+)
+
+var (
+	errTokenScopesEmpty = errors.New("token scopes should not be empty")
+	errTokenNotFound    = errors.New("token not found")
+
+	issuedTokens = &issuedTokenStore{}
+
+	tokenScopePermissions = map[string]permission{
+		TokenScopeRead:  rpcPermissionWebViewer,
+		TokenScopeWrite: rpcPermissionAdminOperator,
+		TokenScopeACL:   rpcPermissionACL,
+	}
+)
+
+type issueTokenJSON struct {
+	Scopes []string `json:"scopes"`
+	TTL    int64    `json:"ttl"`             // in seconds, DefaultIssuedTokenTTL if not positive
+	Label  string   `json:"label,omitempty"` // free form, e.g. what the token is for
+}
+
+type revokeTokenJSON struct {
+	ID string `json:"id"`
+}
+
+// IssuedTokenJSON is a token issued by issueToken rpc. The token itself is
+// only returned when it is issued, and only its checksum is kept.
+type IssuedTokenJSON struct {
+	ID        string     `json:"id"` // prefix of token checksum
+	Token     string     `json:"token,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	Label     string     `json:"label,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	RevokedAt *time.Time `json:"revokedAt,omitempty"` // revoked tokens are listed until they expire
+}
+
+// issuedTokenStore keeps issued tokens by their checksum in state storage,
+// and in memory after first use.
+type issuedTokenStore struct {
+	lock   sync.Mutex
+	loaded bool
+	tokens map[string]*IssuedTokenJSON
+}
+
+// load should be called with lock held.
+func (s *issuedTokenStore) load(conf *config.Config) error {
+	if s.loaded {
+		return nil
+	}
+	store, err := conf.GetStorage()
+	if err != nil {
+		return err
+	}
+	tokens := make(map[string]*IssuedTokenJSON)
+	b, err := store.Get(issuedTokensKey)
+	if err == nil {
+		err = json.Unmarshal(b, &tokens)
+		if err != nil {
+			return err
+		}
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+	s.tokens, s.loaded = tokens, true
+	return nil
+}
+
+// save removes expired tokens and saves the rest. It should be called with
+// lock held.
+func (s *issuedTokenStore) save(conf *config.Config) error {
+	now := time.Now()
+	for k, t := range s.tokens {
+		if !now.Before(t.ExpiresAt) {
+			delete(s.tokens, k)
+		}
+	}
+	store, err := conf.GetStorage()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(s.tokens)
+	if err != nil {
+		return err
+	}
+	return store.Put(issuedTokensKey, b)
+}
+
+// permission returns the permission of token, and false if it is not an
+// issued token that is neither expired nor revoked.
+func (s *issuedTokenStore) permission(conf *config.Config, token string) (permission, bool) {
+	if len(token) == 0 {
+		return 0, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.load(conf)
+	if err != nil {
+		log.Printf("Load issued tokens error: %v", err)
+		return 0, false
+	}
+	t, ok := s.tokens[checksum([]byte(token))]
+	if !ok || t.RevokedAt != nil || !time.Now().Before(t.ExpiresAt) {
+		return 0, false
+	}
+	var perm permission
+	for _, scope := range t.Scopes {
+		perm |= tokenScopePermissions[scope]
+	}
+	return perm, true
+}
+
+// issueToken creates a token with scopes that expires after ttl.
+func issueToken(conf *config.Config, params *issueTokenJSON) (*IssuedTokenJSON, error) {
+	if len(params.Scopes) == 0 {
+		return nil, errTokenScopesEmpty
+	}
+	for _, scope := range params.Scopes {
+		if _, ok := tokenScopePermissions[scope]; !ok {
+			return nil, fmt.Errorf("token scope should be %s, %s or %s", TokenScopeRead, TokenScopeWrite, TokenScopeACL)
+		}
+	}
+	ttl := time.Duration(params.TTL) * time.Second
+	if ttl <= 0 {
+		ttl = DefaultIssuedTokenTTL
+	}
+	if ttl > MaxIssuedTokenTTL {
+		return nil, fmt.Errorf("token ttl should be at most %v", MaxIssuedTokenTTL)
+	}
+
+	now := time.Now()
+	token := NewToken(ttl).Token
+	sum := checksum([]byte(token))
+	t := &IssuedTokenJSON{
+		ID:        sum[:issuedTokenIDSize],
+		Scopes:    params.Scopes,
+		Label:     sanitizeClientName(params.Label),
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+
+	s := issuedTokens
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.load(conf)
+	if err != nil {
+		return nil, err
+	}
+	s.tokens[sum] = t
+	err = s.save(conf)
+	if err != nil {
+		delete(s.tokens, sum)
+		return nil, err
+	}
+	log.Printf("Issued admin token %s with scopes %s until %v", t.ID, strings.Join(t.Scopes, ","), t.ExpiresAt.Format(time.RFC3339))
+
+	res := *t
+	res.Token = token
+	return &res, nil
+}
+
+// listTokens returns issued tokens that have not expired, including revoked
+// ones, oldest first.
+func listTokens(conf *config.Config) ([]*IssuedTokenJSON, error) {
+	s := issuedTokens
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.load(conf)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	res := make([]*IssuedTokenJSON, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		if now.Before(t.ExpiresAt) {
+			t := *t
+			res = append(res, &t)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CreatedAt.Before(res[j].CreatedAt) })
+	return res, nil
+}
+
+// revokeToken revokes the issued token with id, which is kept in revocation
+// list until it expires.
+func revokeToken(conf *config.Config, params *revokeTokenJSON) error {
+	s := issuedTokens
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	err := s.load(conf)
+	if err != nil {
+		return err
+	}
+	for _, t := range s.tokens {
+		if t.ID != params.ID || t.RevokedAt != nil {
+			continue
+		}
+		now := time.Now()
+		t.RevokedAt = &now
+		err = s.save(conf)
+		if err != nil {
+			t.RevokedAt = nil
+			return err
+		}
+		log.Printf("Revoked admin token %s", t.ID)
+		return nil
+	}
+	return errTokenNotFound
+}
+
+// IssueToken issues a token with scopes that expires after ttl on the node at
+// addr.
+func (c *Client) IssueToken(addr string, scopes []string, ttl time.Duration, label string) (*IssuedTokenJSON, error) {
+	res := &IssuedTokenJSON{}
+	err := c.RPCCall(addr, "issueToken", &issueTokenJSON{Scopes: scopes, TTL: int64(ttl.Seconds()), Label: label}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ListTokens returns issued tokens of the node at addr that have not
+// expired.
+func (c *Client) ListTokens(addr string) ([]*IssuedTokenJSON, error) {
+	var res []*IssuedTokenJSON
+	err := c.RPCCall(addr, "listTokens", nil, &res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// RevokeToken revokes the issued token with id on the node at addr.
+func (c *Client) RevokeToken(addr, id string) error {
+	return c.RPCCall(addr, "revokeToken", &revokeTokenJSON{ID: id}, nil)
+}
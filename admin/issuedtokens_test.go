@@ -0,0 +1,118 @@
+package admin
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestConfig(t *testing.T) *config.Config {
+	conf, err := config.LoadOrNewConfig(filepath.Join(t.TempDir(), "config.json"))
+	require.NoError(t, err)
+	return conf
+}
+
+// callWithToken handles an rpc request with the permission of an issued
+// token, and returns its error.
+func callWithToken(conf *config.Config, token, method string, params map[string]interface{}) string {
+	perm, _ := issuedTokens.permission(conf, token)
+	req := &rpcReq{Method: method, Params: params, Version: RPCVersion}
+	return handleRequest(req, conf, conf, nil, perm).Error
+}
+
+func TestIssuedTokenScopes(t *testing.T) {
+	issuedTokens = &issuedTokenStore{}
+	conf := newTestConfig(t)
+
+	tests := []struct {
+		scope   string
+		allowed []string
+		denied  []string
+	}{
+		{
+			scope:   TokenScopeRead,
+			allowed: []string{"getAddrs", "getConfigBackups", "getRevision"},
+			denied:  []string{"setAddrs", "backupConfig", "getSeed", "issueToken"},
+		},
+		{
+			scope:   TokenScopeWrite,
+			allowed: []string{"getAddrs", "getConfigBackups", "backupConfig"},
+			denied:  []string{"setAddrs", "getSeed", "restoreConfig", "issueToken"},
+		},
+		{
+			scope:   TokenScopeACL,
+			allowed: []string{"getAddrs"},
+			denied:  []string{"getConfigBackups", "backupConfig", "getSeed", "issueToken"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scope, func(t *testing.T) {
+			token, err := issueToken(conf, &issueTokenJSON{Scopes: []string{tt.scope}})
+			require.NoError(t, err)
+			perm, ok := issuedTokens.permission(conf, token.Token)
+			require.True(t, ok)
+			require.Equal(t, tokenScopePermissions[tt.scope], perm)
+
+			for _, method := range tt.allowed {
+				require.Empty(t, callWithToken(conf, token.Token, method, nil), method)
+			}
+			for _, method := range tt.denied {
+				require.Equal(t, errPermissionDenied.Error(), callWithToken(conf, token.Token, method, nil), method)
+			}
+		})
+	}
+
+	// acl scope can change accept addresses but not admin addresses.
+	token, err := issueToken(conf, &issueTokenJSON{Scopes: []string{TokenScopeACL}})
+	require.NoError(t, err)
+	perm, _ := issuedTokens.permission(conf, token.Token)
+	require.NoError(t, checkAddrsPermission(&addrsJSON{AcceptAddrs: []string{"a"}}, perm))
+	require.Equal(t, errPermissionDenied, checkAddrsPermission(&addrsJSON{AdminAddrs: []string{"a"}}, perm))
+	require.Equal(t, errPermissionDenied.Error(), callWithToken(conf, token.Token, "setAddrs", map[string]interface{}{"adminAddrs": []string{"a"}}))
+}
+
+func TestIssuedTokenExpiredAndRevoked(t *testing.T) {
+	issuedTokens = &issuedTokenStore{}
+	conf := newTestConfig(t)
+
+	_, ok := issuedTokens.permission(conf, "")
+	require.False(t, ok, "empty token")
+	_, ok = issuedTokens.permission(conf, "unknown")
+	require.False(t, ok, "unknown token")
+
+	expired, err := issueToken(conf, &issueTokenJSON{Scopes: []string{TokenScopeRead}})
+	require.NoError(t, err)
+	_, ok = issuedTokens.permission(conf, expired.Token)
+	require.True(t, ok)
+	issuedTokens.lock.Lock()
+	issuedTokens.tokens[checksum([]byte(expired.Token))].ExpiresAt = time.Now().Add(-time.Second)
+	issuedTokens.lock.Unlock()
+	_, ok = issuedTokens.permission(conf, expired.Token)
+	require.False(t, ok, "expired token")
+	require.Equal(t, errPermissionDenied.Error(), callWithToken(conf, expired.Token, "getAddrs", nil))
+
+	revoked, err := issueToken(conf, &issueTokenJSON{Scopes: []string{TokenScopeRead, TokenScopeWrite}})
+	require.NoError(t, err)
+	require.NoError(t, revokeToken(conf, &revokeTokenJSON{ID: revoked.ID}))
+	_, ok = issuedTokens.permission(conf, revoked.Token)
+	require.False(t, ok, "revoked token")
+	require.Equal(t, errPermissionDenied.Error(), callWithToken(conf, revoked.Token, "getAddrs", nil))
+	require.Equal(t, errTokenNotFound, revokeToken(conf, &revokeTokenJSON{ID: revoked.ID}), "revoked twice")
+
+	// Revocation and expiry are kept in state storage.
+	issuedTokens = &issuedTokenStore{}
+	_, ok = issuedTokens.permission(conf, revoked.Token)
+	require.False(t, ok, "revoked token after reload")
+	_, ok = issuedTokens.permission(conf, expired.Token)
+	require.False(t, ok, "expired token after reload")
+
+	tokens, err := listTokens(conf)
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+	require.Equal(t, revoked.ID, tokens[0].ID)
+	require.NotNil(t, tokens[0].RevokedAt)
+}
@@ -0,0 +1,434 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const (
+	followLogInterval   = time.Second
+	followLogChunkSize  = 64 * 1024
+	followLogMaxMisses  = 3
+	maxLogFollowers     = 8
+	logFollowerIDSize   = 8
+	logLinesMethod      = "logLines"
+	followLogAckTimeout = 10 * time.Second
+	followLogPath       = "/log/follow"
+
+	// Levels of log lines to follow. A line is a warning if it starts with
+	// "WARNING:", or an error if it mentions an error or panic.
+	LogLevelInfo    = "info"
+	LogLevelWarning = "warning"
+	LogLevelError   = "error"
+)
+
+var (
+	errLogFileNotSet     = errors.New("log file is not set")
+	errTooManyFollowers  = errors.New("too many log followers")
+	errFollowerNotFound  = errors.New("log follower not found")
+	errNKNServerNotReady = errors.New("admin NKN server is not ready")
+	errInvalidLogLevel   = errors.New("invalid log level")
+)
+
+var (
+	logFollowers = newLogFollowerStore()
+)
+
+type followLogJSON struct {
+	Backlog  int64  `json:"backlog"`
+	Level    string `json:"level,omitempty"`    // only lines of this level or above
+	Contains string `json:"contains,omitempty"` // only lines containing this
+}
+
+type followLogResultJSON struct {
+	FollowID string `json:"followId"`
+}
+
+type stopFollowLogJSON struct {
+	FollowID string `json:"followId"`
+}
+
+type logLinesJSON struct {
+	FollowID string `json:"followId"`
+	Seq      uint64 `json:"seq"`
+	Lines    string `json:"lines"`
+}
+
+type logLinesAckJSON struct {
+	Seq  uint64 `json:"seq"`
+	Stop bool   `json:"stop"`
+}
+
+// logFilter keeps log lines of at least a level that contain a substring.
+type logFilter struct {
+	level    int
+	contains []byte
+}
+
+var logLevels = map[string]int{
+	"":              0,
+	LogLevelInfo:    0,
+	LogLevelWarning: 1,
+	LogLevelError:   2,
+}
+
+func newLogFilter(level, contains string) (*logFilter, error) {
+	l, ok := logLevels[strings.ToLower(level)]
+	if !ok {
+		return nil, errInvalidLogLevel
+	}
+	return &logFilter{level: l, contains: []byte(contains)}, nil
+}
+
+func logLineLevel(line []byte) int {
+	if bytes.Contains(line, []byte("WARNING:")) {
+		return logLevels[LogLevelWarning]
+	}
+	lower := bytes.ToLower(line)
+	if bytes.Contains(lower, []byte("error")) || bytes.Contains(lower, []byte("panic")) {
+		return logLevels[LogLevelError]
+	}
+	return logLevels[LogLevelInfo]
+}
+
+// apply returns lines in b that pass the filter.
+func (lf *logFilter) apply(b []byte) []byte {
+	if lf.level == 0 && len(lf.contains) == 0 {
+		return b
+	}
+	var out []byte
+	for len(b) > 0 {
+		line := b
+		if i := bytes.IndexByte(b, '\n'); i >= 0 {
+			line = b[:i+1]
+		}
+		b = b[len(line):]
+		if logLineLevel(line) >= lf.level && bytes.Contains(line, lf.contains) {
+			out = append(out, line...)
+		}
+	}
+	return out
+}
+
+// logFollower tails a log file and pushes new lines that pass filter to dest,
+// or to write if set. A chunk is only committed after dest acks it, so a slow
+// reader never has more than one chunk in flight and lines written meanwhile
+// are batched into the next one.
+type logFollower struct {
+	id     string
+	dest   string
+	path   string
+	offset int64
+	seq    uint64
+	filter *logFilter
+	write  func([]byte) error // for followers over HTTP
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+type logFollowerStore struct {
+	lock      sync.Mutex
+	followers map[string]*logFollower
+}
+
+func newLogFollowerStore() *logFollowerStore {
+	return &logFollowerStore{
+		followers: make(map[string]*logFollower),
+	}
+}
+
+func (s *logFollowerStore) add(f *logFollower) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.followers) >= maxLogFollowers {
+		return errTooManyFollowers
+	}
+	s.followers[f.id] = f
+	return nil
+}
+
+func (s *logFollowerStore) remove(id, src string) error {
+	s.lock.Lock()
+	f, ok := s.followers[id]
+	if ok && f.dest == src {
+		delete(s.followers, id)
+	}
+	s.lock.Unlock()
+	if !ok || f.dest != src {
+		return errFollowerNotFound
+	}
+	f.close()
+	return nil
+}
+
+func (s *logFollowerStore) delete(id string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.followers, id)
+}
+
+func newLogFollower(path, dest string, params *followLogJSON) (*logFollower, error) {
+	filter, err := newLogFilter(params.Level, params.Contains)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	offset := fi.Size()
+	if params.Backlog > 0 {
+		offset -= params.Backlog
+		if offset < 0 {
+			offset = 0
+		}
+	}
+	b := make([]byte, logFollowerIDSize)
+	rand.Read(b)
+	return &logFollower{
+		id:     hex.EncodeToString(b),
+		dest:   dest,
+		path:   path,
+		offset: offset,
+		filter: filter,
+		stop:   make(chan struct{}),
+	}, nil
+}
+
+func (f *logFollower) close() {
+	f.stopOnce.Do(func() {
+		close(f.stop)
+	})
+}
+
+// read returns complete lines written after the current offset, and the
+// offset they start at, which is reset to 0 if the log has been rotated.
+func (f *logFollower) read() ([]byte, int64, error) {
+	file, err := os.Open(f.path)
+	if err != nil {
+		return nil, f.offset, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return nil, f.offset, err
+	}
+	offset := f.offset
+	if fi.Size() < offset { // log file has been rotated
+		offset = 0
+	}
+	if fi.Size() == offset {
+		return nil, offset, nil
+	}
+
+	b := make([]byte, followLogChunkSize)
+	n, err := file.ReadAt(b, offset)
+	if err != nil && err != io.EOF {
+		return nil, offset, err
+	}
+	b = b[:n]
+	if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+		b = b[:i+1]
+	} else if n < followLogChunkSize {
+		return nil, offset, nil // wait for the line to be completed
+	}
+	return b, offset, nil
+}
+
+// sendLogLines pushes lines to dest as a logLines message of follow id with
+// seq and waits for its ack, or until stop is closed.
+func sendLogLines(m *nkn.MultiClient, dest, id string, seq uint64, lines []byte, stop <-chan struct{}) (*logLinesAckJSON, error) {
+	req, err := json.Marshal(&rpcReq{
+		ID:     "nConnect",
+		Method: logLinesMethod,
+		Params: map[string]interface{}{
+			"followId": id,
+			"seq":      seq,
+			"lines":    string(lines),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	onReply, err := m.Send(nkn.NewStringArray(dest), req, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-onReply.C:
+		ack := &logLinesAckJSON{}
+		err = json.Unmarshal(reply.Data, ack)
+		if err != nil {
+			return nil, err
+		}
+		return ack, nil
+	case <-time.After(followLogAckTimeout):
+		return nil, errReplyTimeout
+	case <-stop:
+		return &logLinesAckJSON{Stop: true}, nil
+	}
+}
+
+func (f *logFollower) run(m *nkn.MultiClient) {
+	defer logFollowers.delete(f.id)
+	defer f.close()
+
+	ticker := time.NewTicker(followLogInterval)
+	defer ticker.Stop()
+
+	misses := 0
+	for {
+		select {
+		case <-ticker.C:
+		case <-f.stop:
+			return
+		}
+
+		chunk, offset, err := f.read()
+		if err != nil {
+			if os.IsNotExist(err) { // log file is being rotated
+				continue
+			}
+			log.Printf("Follow log %s error: %v", f.id, err)
+			return
+		}
+		f.offset = offset
+		if len(chunk) == 0 {
+			continue
+		}
+		lines := f.filter.apply(chunk)
+		if len(lines) == 0 {
+			f.offset += int64(len(chunk))
+			continue
+		}
+
+		if f.write != nil {
+			if f.write(lines) != nil {
+				return
+			}
+			f.offset += int64(len(chunk))
+			continue
+		}
+
+		ack, err := sendLogLines(m, f.dest, f.id, f.seq, lines, f.stop)
+		if err != nil {
+			misses++
+			if misses >= followLogMaxMisses {
+				log.Printf("Stop following log for %s: %v", f.dest, err)
+				return
+			}
+			continue
+		}
+		misses = 0
+		if ack.Stop {
+			return
+		}
+		if ack.Seq == f.seq {
+			f.offset += int64(len(chunk))
+			f.seq++
+		}
+	}
+}
+
+func followLog(conf *config.Config, src string, params *followLogJSON) (*followLogResultJSON, error) {
+	if len(conf.LogFileName) == 0 {
+		return nil, errLogFileNotSet
+	}
+	if serverMultiClient == nil || len(src) == 0 {
+		return nil, errNKNServerNotReady
+	}
+
+	f, err := newLogFollower(conf.LogFileName, src, params)
+	if err != nil {
+		return nil, err
+	}
+	err = logFollowers.add(f)
+	if err != nil {
+		return nil, err
+	}
+	go f.run(serverMultiClient)
+
+	return &followLogResultJSON{FollowID: f.id}, nil
+}
+
+func stopFollowLog(src string, params *stopFollowLogJSON) error {
+	return logFollowers.remove(params.FollowID, src)
+}
+
+// serveFollowLog streams new log lines that pass the filter in query to c as
+// plain text until either side stops or ctx is done. Query has the same
+// fields as followLog params, and web session token as token if there are web
+// users.
+func serveFollowLog(ctx context.Context, c *gin.Context, persistConf, mergedConf *config.Config) {
+	if !authorizeWebStream(c, persistConf, mergedConf) {
+		return
+	}
+	if len(mergedConf.LogFileName) == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": errLogFileNotSet.Error()})
+		return
+	}
+
+	params := &followLogJSON{Level: c.Query("level"), Contains: c.Query("contains")}
+	if backlog := c.Query("backlog"); len(backlog) > 0 {
+		var err error
+		params.Backlog, err = strconv.ParseInt(backlog, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	f, err := newLogFollower(mergedConf.LogFileName, "", params)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	err = logFollowers.add(f)
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("Content-Type", "text/plain; charset=utf-8")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+	f.write = func(lines []byte) error {
+		_, err := c.Writer.Write(lines)
+		if err != nil {
+			return err
+		}
+		c.Writer.Flush()
+		return nil
+	}
+
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.Request.Context().Done():
+		case <-f.stop:
+		}
+		f.close()
+	}()
+	f.run(nil)
+}
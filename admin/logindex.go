@@ -0,0 +1,281 @@
+package admin
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// logIndexInterval is the minimum number of bytes between two entries of
+	// a log index, so an index of a 1 MB log file has at most 16 entries.
+	logIndexInterval = 64 * 1024
+	// logTimeLayout is the timestamp prefix of log lines with standard flags.
+	logTimeLayout = "2006/01/02 15:04:05"
+	// logBackupTimeFormat is the timestamp lumberjack puts in names of rotated
+	// log files.
+	logBackupTimeFormat = "2006-01-02T15-04-05.000"
+)
+
+var (
+	logIndexes = newLogIndexStore()
+)
+
+type logIndexEntry struct {
+	time   time.Time
+	offset int64 // offset of the first line logged at time
+}
+
+// logIndex is a sparse index of a log file from timestamps to offsets of
+// lines, so a time range can be read without scanning the whole file. It is
+// extended incrementally as the file grows, and rebuilt if the file is
+// replaced by rotation.
+type logIndex struct {
+	fi       os.FileInfo
+	size     int64 // bytes of complete lines indexed
+	entries  []logIndexEntry
+	lastTime time.Time // time of the last indexed line with timestamp
+}
+
+type logIndexStore struct {
+	lock    sync.Mutex
+	indexes map[string]*logIndex
+}
+
+func newLogIndexStore() *logIndexStore {
+	return &logIndexStore{
+		indexes: make(map[string]*logIndex),
+	}
+}
+
+// get returns the up to date index of log file at path.
+func (s *logIndexStore) get(path string) (logIndex, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return logIndex{}, err
+	}
+	defer file.Close()
+
+	fi, err := file.Stat()
+	if err != nil {
+		return logIndex{}, err
+	}
+
+	idx, ok := s.indexes[path]
+	if !ok || !os.SameFile(idx.fi, fi) || fi.Size() < idx.size {
+		idx = &logIndex{}
+		s.indexes[path] = idx
+	}
+	idx.fi = fi
+	if fi.Size() > idx.size {
+		err = idx.extend(file)
+		if err != nil {
+			return logIndex{}, err
+		}
+	}
+	return *idx, nil
+}
+
+// keep removes indexes of log files not in paths, e.g. deleted backups.
+func (s *logIndexStore) keep(paths []string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	keep := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		keep[path] = true
+	}
+	for path := range s.indexes {
+		if !keep[path] {
+			delete(s.indexes, path)
+		}
+	}
+}
+
+// extend indexes complete lines of file after the indexed part.
+func (idx *logIndex) extend(file *os.File) error {
+	_, err := file.Seek(idx.size, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil // wait for the last line to be completed
+		}
+		if err != nil {
+			return err
+		}
+		if t, ok := parseLogTime(line); ok {
+			n := len(idx.entries)
+			if n == 0 || idx.size-idx.entries[n-1].offset >= logIndexInterval {
+				idx.entries = append(idx.entries, logIndexEntry{time: t, offset: idx.size})
+			}
+			idx.lastTime = t
+		}
+		idx.size += int64(len(line))
+	}
+}
+
+// seek returns the offset to start reading lines logged at or after t, and
+// the time of the line there.
+func (idx *logIndex) seek(t time.Time) (int64, time.Time) {
+	i := sort.Search(len(idx.entries), func(i int) bool {
+		return !idx.entries[i].time.Before(t)
+	})
+	if i == 0 {
+		return 0, time.Time{}
+	}
+	return idx.entries[i-1].offset, idx.entries[i-1].time
+}
+
+// parseLogTime parses the timestamp of a log line, in local time as it is
+// written by the log package.
+func parseLogTime(line []byte) (time.Time, bool) {
+	if len(line) < len(logTimeLayout) {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation(logTimeLayout, string(line[:len(logTimeLayout)]), time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// logFiles returns rotated backups of log file at path, oldest first,
+// followed by the log file itself.
+func logFiles(path string) ([]string, error) {
+	dir := filepath.Dir(path)
+	base := filepath.Base(path)
+	ext := filepath.Ext(base)
+	prefix := base[:len(base)-len(ext)] + "-"
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	type backup struct {
+		path string
+		time time.Time
+	}
+	var backups []backup
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || len(name) < len(prefix)+len(ext) || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.Parse(logBackupTimeFormat, name[len(prefix):len(name)-len(ext)])
+		if err != nil {
+			continue
+		}
+		backups = append(backups, backup{path: filepath.Join(dir, name), time: t})
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].time.Before(backups[j].time)
+	})
+
+	files := make([]string, 0, len(backups)+1)
+	for _, b := range backups {
+		files = append(files, b.path)
+	}
+	return append(files, path), nil
+}
+
+// readLogRange returns lines of log file at path and its rotated backups
+// logged between since and until, either of which can be zero for no bound.
+// Lines without timestamp belong to the line before them. If limit is
+// positive, reading stops after limit bytes when since is set, as callers
+// page forward from since.
+func readLogRange(path string, since, until time.Time, limit int) ([]byte, error) {
+	files, err := logFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	logIndexes.keep(files)
+
+	var b []byte
+	for _, file := range files {
+		idx, err := logIndexes.get(file)
+		if err != nil {
+			if os.IsNotExist(err) { // rotated or removed meanwhile
+				continue
+			}
+			return nil, err
+		}
+		if len(idx.entries) == 0 {
+			continue
+		}
+		if !since.IsZero() && idx.lastTime.Before(since) {
+			continue
+		}
+		if !until.IsZero() && idx.entries[0].time.After(until) {
+			break
+		}
+
+		done, err := idx.read(file, since, until, limit, &b)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+	return b, nil
+}
+
+// read appends to b indexed lines of file logged between since and until. It
+// returns true if no more lines should be read after these.
+func (idx *logIndex) read(path string, since, until time.Time, limit int, b *[]byte) (bool, error) {
+	offset, lineTime := idx.seek(since)
+
+	file, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(io.NewSectionReader(file, offset, idx.size-offset))
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		if t, ok := parseLogTime(line); ok {
+			lineTime = t
+		}
+		if !since.IsZero() && lineTime.Before(since) {
+			continue
+		}
+		if !until.IsZero() && lineTime.After(until) {
+			return true, nil
+		}
+		*b = append(*b, line...)
+		if !since.IsZero() && limit > 0 && len(*b) >= limit {
+			return true, nil
+		}
+	}
+}
+
+// trimLog keeps at most size bytes of log b, the earliest part if reading
+// from a given time and the latest part otherwise.
+func trimLog(b []byte, size int, fromStart bool) []byte {
+	if size <= 0 || len(b) <= size {
+		return b
+	}
+	if fromStart {
+		return b[:size]
+	}
+	return b[len(b)-size:]
+}
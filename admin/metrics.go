@@ -0,0 +1,164 @@
+package admin
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/monitor"
+	"github.com/nknorg/nconnect/ss"
+	"github.com/nknorg/nkn-sdk-go"
+	tunnel "github.com/nknorg/nkn-tunnel"
+	"github.com/nknorg/nkn/v2/common"
+)
+
+const (
+	metricsPath        = "/metrics"
+	metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+	// Wallet balance is fetched from NKN nodes, so it is not fetched on every
+	// scrape.
+	metricsBalanceInterval = time.Minute
+)
+
+var (
+	nknReconnects int64
+	walletBalance = &balanceTracker{}
+)
+
+// AddNKNReconnects counts NKN clients reconnected, e.g. after local network
+// changed, shown in metrics.
+func AddNKNReconnects(n int) {
+	atomic.AddInt64(&nknReconnects, int64(n))
+}
+
+// metricSample is a sample of a metric, with labels in Prometheus text format
+// (e.g. direction="upload") or empty if it has none.
+type metricSample struct {
+	labels string
+	value  float64
+}
+
+// writeMetric writes a metric with its samples in Prometheus text format.
+func writeMetric(w io.Writer, name, typ, help string, samples ...metricSample) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+	for _, s := range samples {
+		value := strconv.FormatFloat(s.value, 'g', -1, 64)
+		if len(s.labels) > 0 {
+			fmt.Fprintf(w, "%s{%s} %s\n", name, s.labels, value)
+		} else {
+			fmt.Fprintf(w, "%s %s\n", name, value)
+		}
+	}
+}
+
+// writeMetrics writes server metrics in Prometheus text format.
+func writeMetrics(w io.Writer, persistConf, mergedConf *config.Config, tun *tunnel.Tunnel) {
+	stats := ss.GetServerStats()
+
+	writeMetric(w, "nconnect_info", "gauge", "nConnect version.",
+		metricSample{labels: "version=" + strconv.Quote(config.Version), value: 1})
+	writeMetric(w, "nconnect_active_sessions", "gauge", "Proxied TCP connections.",
+		metricSample{value: float64(stats.Active)})
+	writeMetric(w, "nconnect_sessions_total", "counter", "Proxied TCP connections since start.",
+		metricSample{value: float64(stats.Total)})
+	writeMetric(w, "nconnect_bytes_total", "counter", "Proxied TCP and UDP traffic since start, upload is from clients to targets.",
+		metricSample{labels: `direction="upload"`, value: float64(stats.BytesSent)},
+		metricSample{labels: `direction="download"`, value: float64(stats.BytesReceived)})
+	writeMetric(w, "nconnect_dial_errors_total", "counter", "Failed TCP connections to targets since start.",
+		metricSample{value: float64(stats.DialErrors)})
+	writeMetric(w, "nconnect_accept_addrs", "gauge", "Active entries of accept addresses.",
+		metricSample{value: float64(len(persistConf.GetAcceptAddrs()))})
+	writeMetric(w, "nconnect_nkn_reconnects_total", "counter", "NKN clients reconnected since start.",
+		metricSample{value: float64(atomic.LoadInt64(&nknReconnects))})
+	writeMetric(w, "nconnect_admin_msgs_dropped_total", "counter", "Admin NKN messages dropped by per source rate limit since start.",
+		metricSample{value: float64(atomic.LoadInt64(&adminMsgsDropped))})
+	writeListenerMetrics(w)
+
+	if tun == nil || tun.MultiClient() == nil {
+		return
+	}
+	balance, spent, err := walletBalance.get(tun.MultiClient())
+	if err != nil {
+		return
+	}
+	writeMetric(w, "nconnect_wallet_balance_nkn", "gauge", "Wallet balance in NKN.",
+		metricSample{value: fixed64Float(balance)})
+	if mergedConf.Tuna {
+		writeMetric(w, "nconnect_tuna_spent_nkn_total", "counter", "Decrease of wallet balance in NKN since start, which is spent on tuna unless other transfers are made.",
+			metricSample{value: fixed64Float(spent)})
+	}
+}
+
+// writeListenerMetrics writes connection counters of local listeners, labeled
+// by listener name.
+func writeListenerMetrics(w io.Writer) {
+	stats := monitor.GetListenerStats()
+	names := make([]string, 0, len(stats))
+	for name := range stats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	samples := func(value func(monitor.ListenerStats) int64) []metricSample {
+		res := make([]metricSample, len(names))
+		for i, name := range names {
+			res[i] = metricSample{labels: "listener=" + strconv.Quote(name), value: float64(value(stats[name]))}
+		}
+		return res
+	}
+	writeMetric(w, "nconnect_listener_accepts_total", "counter", "Connections accepted by local listener since start.",
+		samples(func(s monitor.ListenerStats) int64 { return s.Accepts })...)
+	writeMetric(w, "nconnect_listener_accept_errors_total", "counter", "Accept errors of local listener since start, e.g. too many open files.",
+		samples(func(s monitor.ListenerStats) int64 { return s.AcceptErrors })...)
+	writeMetric(w, "nconnect_listener_handshake_failures_total", "counter", "Connections of local listener closed before a request was read, e.g. failed socks or TLS handshake.",
+		samples(func(s monitor.ListenerStats) int64 { return s.HandshakeFailures })...)
+	writeMetric(w, "nconnect_listener_auth_failures_total", "counter", "Connections or requests of local listener refused for source not allowed, wrong cipher key or permission denied.",
+		samples(func(s monitor.ListenerStats) int64 { return s.AuthFailures })...)
+	writeMetric(w, "nconnect_listener_drops_total", "counter", "Connections of local listener closed right after accept by connection limit.",
+		samples(func(s monitor.ListenerStats) int64 { return s.Drops })...)
+	if runtime.GOOS == "linux" {
+		writeMetric(w, "nconnect_listener_queue_length", "gauge", "Connections waiting in accept queue of local listener.",
+			samples(func(s monitor.ListenerStats) int64 { return s.QueueLength })...)
+	}
+}
+
+func fixed64Float(f common.Fixed64) float64 {
+	return float64(f) / common.StorageFactor
+}
+
+// balanceTracker keeps wallet balance fetched at most once per
+// metricsBalanceInterval, and the sum of its decreases since the first fetch.
+type balanceTracker struct {
+	lock      sync.Mutex
+	fetchedAt time.Time
+	fetched   bool
+	balance   common.Fixed64
+	spent     common.Fixed64
+}
+
+func (b *balanceTracker) get(m *nkn.MultiClient) (balance, spent common.Fixed64, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if !b.fetched || time.Since(b.fetchedAt) >= metricsBalanceInterval {
+		amount, err := m.Balance()
+		if err != nil {
+			return 0, 0, err
+		}
+		balance := amount.ToFixed64()
+		if b.fetched && balance < b.balance {
+			b.spent += b.balance - balance
+		}
+		b.balance = balance
+		b.fetched = true
+		b.fetchedAt = time.Now()
+	}
+	return b.balance, b.spent, nil
+}
@@ -0,0 +1,107 @@
+package admin
+
+import (
+	"log"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+)
+
+// Idle sources are removed at most this often, so the limiter does not grow
+// with every address that ever sent a message.
+const msgLimiterSweepInterval = time.Minute
+
+var (
+	adminMsgLimiter  = newMsgLimiter()
+	adminMsgsDropped int64
+)
+
+// msgBucket is the token bucket of a source address. Tokens are refilled
+// continuously up to burst, and each message takes one.
+type msgBucket struct {
+	tokens      float64
+	last        time.Time
+	exceeded    int // messages dropped in a row
+	bannedUntil time.Time
+}
+
+// msgLimiter limits the rate of admin NKN messages of each source address,
+// and bans a source for a while if it keeps exceeding the rate.
+type msgLimiter struct {
+	lock      sync.Mutex
+	buckets   map[string]*msgBucket
+	lastSweep time.Time
+}
+
+func newMsgLimiter() *msgLimiter {
+	return &msgLimiter{buckets: make(map[string]*msgBucket)}
+}
+
+// allow returns whether a message from src received at now is allowed by
+// admin message rate, burst and ban time of conf.
+func (l *msgLimiter) allow(conf *config.Config, src string, now time.Time) bool {
+	rate := conf.GetAdminMsgRate()
+	if rate == 0 {
+		return true
+	}
+	burst := float64(conf.GetAdminMsgBurst())
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if now.Sub(l.lastSweep) >= msgLimiterSweepInterval {
+		l.sweep(rate, burst, now)
+		l.lastSweep = now
+	}
+
+	b, ok := l.buckets[src]
+	if !ok {
+		b = &msgBucket{tokens: burst, last: now}
+		l.buckets[src] = b
+	}
+	if now.Before(b.bannedUntil) {
+		return false
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		b.exceeded = 0
+		return true
+	}
+
+	b.exceeded++
+	if banTime := conf.GetAdminMsgBanTime(); banTime > 0 && float64(b.exceeded) >= burst {
+		b.bannedUntil = now.Add(banTime)
+		b.exceeded = 0
+		log.Printf("Admin messages from %s keep exceeding rate limit, ignore it for %v", src, banTime)
+	}
+	return false
+}
+
+// sweep removes sources that are not banned and whose bucket has been refilled,
+// which are the same as new ones.
+func (l *msgLimiter) sweep(rate, burst float64, now time.Time) {
+	for src, b := range l.buckets {
+		if now.Before(b.bannedUntil) {
+			continue
+		}
+		if b.tokens+now.Sub(b.last).Seconds()*rate >= burst {
+			delete(l.buckets, src)
+		}
+	}
+}
+
+// allowAdminMsg returns whether an admin NKN message from src is allowed by
+// rate limit, and counts it as dropped if not.
+func allowAdminMsg(conf *config.Config, src string) bool {
+	if adminMsgLimiter.allow(conf, src, time.Now()) {
+		return true
+	}
+	atomic.AddInt64(&adminMsgsDropped, 1)
+	return false
+}
@@ -0,0 +1,125 @@
+package admin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMsgLimiter(t *testing.T) {
+	type msg struct {
+		src     string
+		at      time.Duration // since start
+		allowed bool
+	}
+
+	tests := []struct {
+		name string
+		conf *config.Config
+		msgs []msg
+	}{
+		{
+			name: "no limit",
+			conf: &config.Config{AdminMsgRate: -1, AdminMsgBurst: 1},
+			msgs: []msg{{"a", 0, true}, {"a", 0, true}, {"a", 0, true}},
+		},
+		{
+			name: "burst then rate",
+			conf: &config.Config{AdminMsgRate: 1, AdminMsgBurst: 3, AdminMsgBanTime: -1},
+			msgs: []msg{
+				{"a", 0, true},
+				{"a", 0, true},
+				{"a", 0, true},
+				{"a", 0, false},
+				{"a", 500 * time.Millisecond, false},
+				{"a", time.Second, true},
+				{"a", time.Second, false},
+				{"a", 3 * time.Second, true},
+				{"a", 3 * time.Second, true},
+				{"a", 3 * time.Second, false},
+			},
+		},
+		{
+			name: "banned after burst exceeded in a row",
+			conf: &config.Config{AdminMsgRate: 1, AdminMsgBurst: 3, AdminMsgBanTime: 10},
+			msgs: []msg{
+				{"a", 0, true},
+				{"a", 0, true},
+				{"a", 0, true},
+				{"a", 0, false},
+				{"a", 0, false},
+				{"a", 0, false}, // banned for 10s
+				{"a", 5 * time.Second, false},
+				{"a", 9 * time.Second, false},
+				{"a", 10 * time.Second, true},
+				{"a", 10 * time.Second, true},
+				{"a", 10 * time.Second, true},
+				{"a", 10 * time.Second, false},
+			},
+		},
+		{
+			name: "allowed message resets exceeded count",
+			conf: &config.Config{AdminMsgRate: 1, AdminMsgBurst: 3, AdminMsgBanTime: 10},
+			msgs: []msg{
+				{"a", 0, true},
+				{"a", 0, true},
+				{"a", 0, true},
+				{"a", 0, false},
+				{"a", 0, false},
+				{"a", time.Second, true},
+				{"a", time.Second, false},
+				{"a", time.Second, false},
+				{"a", 2 * time.Second, true},
+			},
+		},
+		{
+			name: "separate counts per source",
+			conf: &config.Config{AdminMsgRate: 1, AdminMsgBurst: 2, AdminMsgBanTime: 10},
+			msgs: []msg{
+				{"a", 0, true},
+				{"a", 0, true},
+				{"b", 0, true},
+				{"a", 0, false},
+				{"a", 0, false}, // a banned
+				{"b", 0, true},
+				{"b", 0, false},
+				{"c", time.Second, true},
+				{"c", time.Second, true},
+				{"b", time.Second, true},
+				{"a", time.Second, false},
+				{"a", 10 * time.Second, true},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newMsgLimiter()
+			start := time.Now()
+			for i, m := range tt.msgs {
+				require.Equal(t, m.allowed, l.allow(tt.conf, m.src, start.Add(m.at)), "message %d from %s at %v", i, m.src, m.at)
+			}
+		})
+	}
+}
+
+func TestMsgLimiterSweep(t *testing.T) {
+	conf := &config.Config{AdminMsgRate: 1, AdminMsgBurst: 2, AdminMsgBanTime: 600}
+	l := newMsgLimiter()
+	start := time.Now()
+
+	require.True(t, l.allow(conf, "idle", start))
+	for i := 0; i < 4; i++ {
+		l.allow(conf, "banned", start)
+	}
+	require.Len(t, l.buckets, 2)
+
+	// Idle sources with refilled bucket are removed, banned ones are kept.
+	require.True(t, l.allow(conf, "new", start.Add(msgLimiterSweepInterval)))
+	require.Len(t, l.buckets, 2)
+	require.Contains(t, l.buckets, "banned")
+	require.Contains(t, l.buckets, "new")
+	require.False(t, l.allow(conf, "banned", start.Add(msgLimiterSweepInterval)))
+}
@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	NetworkEventChanged = "changed" // local IPs changed and clients reconnected
+	NetworkEventDown    = "down"    // all local IPs are gone
+	NetworkEventResumed = "resumed" // system resumed from suspend and clients reconnected
+
+	maxNetworkEvents = 20
+)
+
+var (
+	networkEventLock sync.Mutex
+	networkEvents    []NetworkEvent
+)
+
+// NetworkEvent is a change of local network, e.g. roaming between Wi-Fi and
+// LTE, or resuming from laptop sleep.
+type NetworkEvent struct {
+	Time   int64    `json:"time"` // unix time
+	Event  string   `json:"event"`
+	OldIPs []string `json:"oldIPs"`
+	NewIPs []string `json:"newIPs"`
+}
+
+// AddNetworkEvent records a local network change so it is shown in get info
+// api. Only the latest events are kept.
+func AddNetworkEvent(event string, oldIPs, newIPs []string) {
+	networkEventLock.Lock()
+	defer networkEventLock.Unlock()
+	networkEvents = append(networkEvents, NetworkEvent{
+		Time:   time.Now().Unix(),
+		Event:  event,
+		OldIPs: oldIPs,
+		NewIPs: newIPs,
+	})
+	if len(networkEvents) > maxNetworkEvents {
+		networkEvents = networkEvents[len(networkEvents)-maxNetworkEvents:]
+	}
+}
+
+// GetNetworkEvents returns recorded local network changes, oldest first.
+func GetNetworkEvents() []NetworkEvent {
+	networkEventLock.Lock()
+	defer networkEventLock.Unlock()
+	events := make([]NetworkEvent, len(networkEvents))
+	copy(events, networkEvents)
+	return events
+}
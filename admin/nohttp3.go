@@ -0,0 +1,28 @@
+//go:build !http3
+// +build !http3
+
+package admin
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+)
+
+type http3Server struct{}
+
+// newHTTP3Server returns an error as HTTP/3 support is not built in. Build
+// with -tags http3 to enable it.
+func newHTTP3Server(listenAddr string, handler http.Handler, tlsConfig *tls.Config) (*http3Server, error) {
+	return nil, errors.New("admin http3 is not supported by this build, rebuild with -tags http3")
+}
+
+func (s *http3Server) setAltSvc(header http.Header) {}
+
+func (s *http3Server) listenAndServe() error {
+	return nil
+}
+
+func (s *http3Server) close() error {
+	return nil
+}
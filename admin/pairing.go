@@ -0,0 +1,154 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/events"
+	"github.com/nknorg/nconnect/storage"
+	tunnel "github.com/nknorg/nkn-tunnel"
+)
+
+const (
+	pairingTokensKey       = "pairing-tokens.json"
+	pairingTokenSize       = 16
+	PairingTokenExpiration = 24 * time.Hour
+)
+
+var (
+	errInvalidPairingToken = errors.New("pairing token is invalid, used or expired")
+
+	// pairingLock serializes changes to pairing tokens in state storage. Tokens
+	// are created by the qr command in another process, so they are always
+	// read from storage instead of cached.
+	pairingLock sync.Mutex
+)
+
+type pairJSON struct {
+	Token string `json:"token"`
+	Name  string `json:"name,omitempty"` // friendly name of client
+}
+
+// loadPairingTokens returns unexpired pairing tokens by their checksum. It
+// should be called with pairingLock held.
+func loadPairingTokens(store storage.Storage) (map[string]time.Time, error) {
+	tokens := make(map[string]time.Time)
+	b, err := store.Get(pairingTokensKey)
+	if err == nil {
+		err = json.Unmarshal(b, &tokens)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != storage.ErrNotFound {
+		return nil, err
+	}
+	now := time.Now()
+	for k, expiresAt := range tokens {
+		if !now.Before(expiresAt) {
+			delete(tokens, k)
+		}
+	}
+	return tokens, nil
+}
+
+// NewPairingToken creates a one-time token that lets a client not in accept
+// addresses add itself to them with pair rpc before expiration. Only its
+// checksum is kept in state storage.
+func NewPairingToken(conf *config.Config, expiration time.Duration) (string, error) {
+	b := make([]byte, pairingTokenSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	store, err := conf.GetStorage()
+	if err != nil {
+		return "", err
+	}
+
+	pairingLock.Lock()
+	defer pairingLock.Unlock()
+	tokens, err := loadPairingTokens(store)
+	if err != nil {
+		return "", err
+	}
+	tokens[checksum([]byte(token))] = time.Now().Add(expiration)
+	b, err = json.Marshal(tokens)
+	if err != nil {
+		return "", err
+	}
+	err = store.Put(pairingTokensKey, b)
+	if err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// usePairingToken removes token from state storage, or returns
+// errInvalidPairingToken if it is not there.
+func usePairingToken(conf *config.Config, token string) error {
+	store, err := conf.GetStorage()
+	if err != nil {
+		return err
+	}
+
+	pairingLock.Lock()
+	defer pairingLock.Unlock()
+	tokens, err := loadPairingTokens(store)
+	if err != nil {
+		return err
+	}
+	sum := checksum([]byte(token))
+	if _, ok := tokens[sum]; !ok {
+		return errInvalidPairingToken
+	}
+	delete(tokens, sum)
+	b, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	return store.Put(pairingTokensKey, b)
+}
+
+// pair adds the public key of client at src to accept addresses if it has a
+// valid pairing token, then applies accept addresses to tun if not nil.
+func pair(conf *config.Config, tun *tunnel.Tunnel, src string, params *pairJSON) error {
+	if len(params.Token) == 0 {
+		return errInvalidPairingToken
+	}
+	err := usePairingToken(conf, params.Token)
+	if err != nil {
+		return err
+	}
+
+	pubKey := addrPubKey(src)
+	name := sanitizeClientName(params.Name)
+	err = conf.AddAcceptAddrEntries([]config.AddrEntry{{Addr: pubKey + "$", Label: name}})
+	if err != nil {
+		return err
+	}
+	if len(name) > 0 {
+		err = conf.SetClientName(pubKey, name)
+		if err != nil {
+			return err
+		}
+	}
+	events.Publish(events.ClientAccepted{Addr: src, Via: events.ViaPair})
+
+	if tun == nil {
+		return nil
+	}
+	return applyAcceptAddrs(conf, tun)
+}
+
+// Pair adds this client to accept addresses of the server at addr with a
+// one-time pairing token.
+func (c *Client) Pair(addr, token string) error {
+	return c.RPCCall(addr, "pair", &pairJSON{Token: token, Name: c.name}, nil)
+}
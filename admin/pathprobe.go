@@ -0,0 +1,292 @@
+package admin
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	tunnel "github.com/nknorg/nkn-tunnel"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+const (
+	defaultPathProbeCount   = 5
+	maxPathProbeCount       = 8 // a probe takes about count seconds, which should be within RPC reply timeout
+	defaultPathProbeMaxHops = 30
+	maxPathProbeMaxHops     = 64
+	pathProbeRoundTimeout   = time.Second
+)
+
+var (
+	pathProbeLock sync.Mutex
+
+	errPathProbeRunning = errors.New("another path probe is running")
+	errNoTunaRelay      = errors.New("no tuna relay is used, target is required")
+)
+
+type runPathProbeJSON struct {
+	Target  string `json:"target,omitempty"`  // host or IP, the current tuna relay if empty
+	Count   int    `json:"count,omitempty"`   // probes sent to each hop
+	MaxHops int    `json:"maxHops,omitempty"` // max TTL
+}
+
+// PathProbeHop is latency and loss of a hop toward the probe target. Hops
+// that don't reply (e.g. routers dropping ICMP) have no address and 100% loss,
+// which doesn't mean packets are lost there if later hops reply.
+type PathProbeHop struct {
+	TTL      int     `json:"ttl"`
+	Addr     string  `json:"addr,omitempty"`
+	Sent     int     `json:"sent"`
+	Received int     `json:"received"`
+	Loss     float64 `json:"loss"` // percent
+	MinMs    float64 `json:"minMs,omitempty"`
+	AvgMs    float64 `json:"avgMs,omitempty"`
+	MaxMs    float64 `json:"maxMs,omitempty"`
+}
+
+// PathProbeJSON is the result of an mtr-style hop by hop probe from the
+// server toward a target.
+type PathProbeJSON struct {
+	Target  string          `json:"target"`
+	IP      string          `json:"ip"`
+	Relay   bool            `json:"relay"`   // target is the current tuna relay
+	Reached bool            `json:"reached"` // target replied
+	Hops    []*PathProbeHop `json:"hops"`
+}
+
+// TunaRelayIP returns the IP of the first tuna relay the server is using.
+func TunaRelayIP(tun *tunnel.Tunnel) string {
+	if tun == nil {
+		return ""
+	}
+	pubAddrs := tun.TunaPubAddrs()
+	if pubAddrs == nil {
+		return ""
+	}
+	for _, addr := range pubAddrs.Addrs {
+		if len(addr.IP) > 0 {
+			return addr.IP
+		}
+	}
+	return ""
+}
+
+// runPathProbe sends ICMP echo requests with increasing TTL toward target in
+// rounds, and returns latency and loss of each hop that replies. It needs
+// permission to open raw sockets, e.g. root or CAP_NET_RAW on Linux.
+func runPathProbe(params *runPathProbeJSON, tun *tunnel.Tunnel) (*PathProbeJSON, error) {
+	count, maxHops := params.Count, params.MaxHops
+	if count == 0 {
+		count = defaultPathProbeCount
+	}
+	if maxHops == 0 {
+		maxHops = defaultPathProbeMaxHops
+	}
+	if count < 0 || count > maxPathProbeCount {
+		return nil, fmt.Errorf("path probe count should be between 1 and %d", maxPathProbeCount)
+	}
+	if maxHops < 0 || maxHops > maxPathProbeMaxHops {
+		return nil, fmt.Errorf("path probe max hops should be between 1 and %d", maxPathProbeMaxHops)
+	}
+
+	res := &PathProbeJSON{Target: params.Target}
+	if len(res.Target) == 0 {
+		res.Target = TunaRelayIP(tun)
+		if len(res.Target) == 0 {
+			return nil, errNoTunaRelay
+		}
+		res.Relay = true
+	}
+	host := res.Target
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	dst, err := net.ResolveIPAddr("ip", host)
+	if err != nil {
+		return nil, err
+	}
+	res.IP = dst.IP.String()
+
+	if !pathProbeLock.TryLock() {
+		return nil, errPathProbeRunning
+	}
+	defer pathProbeLock.Unlock()
+
+	ipv6Target := dst.IP.To4() == nil
+	network, listenAddr, proto := "ip4:icmp", "0.0.0.0", 1
+	var echoType, replyType, exceededType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply, ipv4.ICMPTypeTimeExceeded
+	if ipv6Target {
+		network, listenAddr, proto = "ip6:ipv6-icmp", "::", 58
+		echoType, replyType, exceededType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply, ipv6.ICMPTypeTimeExceeded
+	}
+	conn, err := icmp.ListenPacket(network, listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("open ICMP socket error, path probe needs permission to open raw sockets: %v", err)
+	}
+	defer conn.Close()
+
+	setTTL := func(ttl int) error {
+		if ipv6Target {
+			return conn.IPv6PacketConn().SetHopLimit(ttl)
+		}
+		return conn.IPv4PacketConn().SetTTL(ttl)
+	}
+
+	b := make([]byte, 2)
+	_, err = rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	id := int(binary.BigEndian.Uint16(b))
+
+	hops := make([]*PathProbeHop, maxHops)
+	rtts := make([][]time.Duration, maxHops)
+	for i := range hops {
+		hops[i] = &PathProbeHop{TTL: i + 1}
+	}
+	reachedTTL := maxHops
+	buf := make([]byte, 1500)
+
+	for round := 0; round < count; round++ {
+		sentAt := make(map[int]time.Time, reachedTTL)
+		for ttl := 1; ttl <= reachedTTL; ttl++ {
+			err = setTTL(ttl)
+			if err != nil {
+				return nil, err
+			}
+			seq := round*maxPathProbeMaxHops + ttl - 1
+			msg := &icmp.Message{
+				Type: echoType,
+				Body: &icmp.Echo{ID: id, Seq: seq, Data: []byte("nConnect path probe")},
+			}
+			wb, err := msg.Marshal(nil)
+			if err != nil {
+				return nil, err
+			}
+			sentAt[seq] = time.Now()
+			_, err = conn.WriteTo(wb, dst)
+			if err != nil {
+				return nil, err
+			}
+			hops[ttl-1].Sent++
+		}
+
+		deadline := time.Now().Add(pathProbeRoundTimeout)
+		for len(sentAt) > 0 {
+			err = conn.SetReadDeadline(deadline)
+			if err != nil {
+				return nil, err
+			}
+			n, peer, err := conn.ReadFrom(buf)
+			if err != nil {
+				if e, ok := err.(net.Error); ok && e.Timeout() {
+					break
+				}
+				return nil, err
+			}
+			now := time.Now()
+			msg, err := icmp.ParseMessage(proto, buf[:n])
+			if err != nil {
+				continue
+			}
+
+			var replyID, replySeq int
+			switch {
+			case msg.Type == replyType:
+				echo, ok := msg.Body.(*icmp.Echo)
+				if !ok {
+					continue
+				}
+				replyID, replySeq = echo.ID, echo.Seq
+			case msg.Type == exceededType:
+				body, ok := msg.Body.(*icmp.TimeExceeded)
+				if !ok {
+					continue
+				}
+				replyID, replySeq, ok = quotedEcho(body.Data, ipv6Target)
+				if !ok {
+					continue
+				}
+			default:
+				continue
+			}
+			sent, ok := sentAt[replySeq]
+			if replyID != id || !ok {
+				continue
+			}
+			delete(sentAt, replySeq)
+
+			ttl := replySeq%maxPathProbeMaxHops + 1
+			hop := hops[ttl-1]
+			hop.Received++
+			if len(hop.Addr) == 0 {
+				hop.Addr = peer.String()
+			}
+			rtts[ttl-1] = append(rtts[ttl-1], now.Sub(sent))
+			if msg.Type == replyType {
+				res.Reached = true
+				if ttl < reachedTTL {
+					reachedTTL = ttl
+				}
+			}
+		}
+	}
+
+	res.Hops = hops[:reachedTTL]
+	for i, hop := range res.Hops {
+		hop.Loss = 100 * float64(hop.Sent-hop.Received) / float64(hop.Sent)
+		if len(rtts[i]) == 0 {
+			continue
+		}
+		min, max, sum := time.Duration(math.MaxInt64), time.Duration(0), time.Duration(0)
+		for _, rtt := range rtts[i] {
+			if rtt < min {
+				min = rtt
+			}
+			if rtt > max {
+				max = rtt
+			}
+			sum += rtt
+		}
+		hop.MinMs = float64(min) / float64(time.Millisecond)
+		hop.MaxMs = float64(max) / float64(time.Millisecond)
+		hop.AvgMs = float64(sum) / float64(len(rtts[i])) / float64(time.Millisecond)
+	}
+
+	return res, nil
+}
+
+// quotedEcho returns ID and sequence of the echo request quoted in an ICMP
+// time exceeded message, which starts with the IP header of the request.
+func quotedEcho(data []byte, ipv6Target bool) (int, int, bool) {
+	headerLen := ipv6.HeaderLen
+	if !ipv6Target {
+		if len(data) < ipv4.HeaderLen {
+			return 0, 0, false
+		}
+		headerLen = int(data[0]&0x0f) * 4
+	}
+	if len(data) < headerLen+8 {
+		return 0, 0, false
+	}
+	echo := data[headerLen:]
+	return int(binary.BigEndian.Uint16(echo[4:6])), int(binary.BigEndian.Uint16(echo[6:8])), true
+}
+
+// RunPathProbe asks the server at addr to probe latency and loss of each hop
+// toward target, or toward its current tuna relay if target is empty.
+func (c *Client) RunPathProbe(addr, target string, count, maxHops int) (*PathProbeJSON, error) {
+	res := &PathProbeJSON{}
+	err := c.RPCCall(addr, "runPathProbe", &runPathProbeJSON{Target: target, Count: count, MaxHops: maxHops}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,122 @@
+package admin
+
+import (
+	"sync"
+
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const (
+	adminQueueSize  = 64
+	clientQueueSize = 256
+	rpcWorkers      = 4 // shared by all requests, admin requests first
+)
+
+// nknRequest is an authorized admin rpc request received over NKN.
+type nknRequest struct {
+	msg    *nkn.Message
+	req    *rpcReq
+	perm   permission
+	seen   bool // whether to record the sender as a seen client
+	assist bool // whether the sender has been granted assist, so its calls are audited
+}
+
+// rpcQueue queues requests of admins separately from the ones of clients and
+// viewers. Shared workers always take admin requests first, and one more
+// worker only handles admin requests, so admins are served even when all
+// shared workers are busy with slow client requests. Client requests are
+// dropped when their queue is full, while admin ones are only dropped if
+// admins themselves flood the queue.
+type rpcQueue struct {
+	admin  chan *nknRequest
+	client chan *nknRequest
+
+	wg       sync.WaitGroup
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+func newRPCQueue() *rpcQueue {
+	return &rpcQueue{
+		admin:  make(chan *nknRequest, adminQueueSize),
+		client: make(chan *nknRequest, clientQueueSize),
+		stop:   make(chan struct{}),
+	}
+}
+
+// start starts workers calling handle for queued requests.
+func (q *rpcQueue) start(handle func(*nknRequest)) {
+	q.wg.Add(rpcWorkers + 1)
+	for i := 0; i < rpcWorkers; i++ {
+		go q.work(handle, false)
+	}
+	go q.work(handle, true)
+}
+
+// close stops workers after their current requests, and waits for them.
+// Requests still queued are dropped.
+func (q *rpcQueue) close() {
+	q.stopOnce.Do(func() {
+		close(q.stop)
+	})
+	q.wg.Wait()
+}
+
+// push queues r and returns false if its queue is full.
+func (q *rpcQueue) push(r *nknRequest) bool {
+	ch := q.client
+	if isPriorityRequest(r) {
+		ch = q.admin
+	}
+	select {
+	case ch <- r:
+		return true
+	default:
+		return false
+	}
+}
+
+func (q *rpcQueue) work(handle func(*nknRequest), adminOnly bool) {
+	defer q.wg.Done()
+	for {
+		r, ok := q.next(adminOnly)
+		if !ok {
+			return
+		}
+		handle(r)
+	}
+}
+
+// next returns the next request to handle, admin requests first.
+func (q *rpcQueue) next(adminOnly bool) (*nknRequest, bool) {
+	select {
+	case r := <-q.admin:
+		return r, true
+	case <-q.stop:
+		return nil, false
+	default:
+	}
+	if adminOnly {
+		select {
+		case r := <-q.admin:
+			return r, true
+		case <-q.stop:
+			return nil, false
+		}
+	}
+	select {
+	case r := <-q.admin:
+		return r, true
+	case r := <-q.client:
+		return r, true
+	case <-q.stop:
+		return nil, false
+	}
+}
+
+// isPriorityRequest returns whether r is from an admin address with owner or
+// operator role, or with an admin token, the NKN token of a web admin user or
+// an issued token with write or acl scope.
+func isPriorityRequest(r *nknRequest) bool {
+	return r.perm&(rpcPermissionAdminClient|rpcPermissionAdminOperator|rpcPermissionACL) != 0
+}
@@ -0,0 +1,146 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nkn-sdk-go"
+	tunnel "github.com/nknorg/nkn-tunnel"
+)
+
+const (
+	publishPathPrefix = "/publish/"
+)
+
+// publisher routes requests to client services by hostname or path prefix,
+// and proxies them over NKN sessions dialed to the clients.
+type publisher struct {
+	services []config.PublishedService
+	proxies  map[string]*httputil.ReverseProxy // map service name to proxy
+}
+
+func newPublisher(services []config.PublishedService, tun *tunnel.Tunnel) *publisher {
+	p := &publisher{
+		services: services,
+		proxies:  make(map[string]*httputil.ReverseProxy, len(services)),
+	}
+	for _, s := range services {
+		p.proxies[s.Name] = newServiceProxy(s, tun.MultiClient())
+	}
+	return p
+}
+
+// newServiceProxy returns a reverse proxy that sends requests to a client
+// service. Each connection is a session to the client publish address that
+// starts with the local service name in a line.
+func newServiceProxy(s config.PublishedService, m *nkn.MultiClient) *httputil.ReverseProxy {
+	service := s.Service
+	if len(service) == 0 {
+		service = s.Name
+	}
+	return &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = "http"
+			r.URL.Host = service
+			r.Header.Del("Authorization")
+		},
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				conn, err := m.Dial(s.ClientAddr)
+				if err != nil {
+					return nil, err
+				}
+				_, err = conn.Write([]byte(service + "\n"))
+				if err != nil {
+					conn.Close()
+					return nil, err
+				}
+				return conn, nil
+			},
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Printf("Proxy published service %s error: %v", s.Name, err)
+			w.WriteHeader(http.StatusBadGateway)
+		},
+	}
+}
+
+// match returns the service a request is for, and the request path on the
+// client service.
+func (p *publisher) match(r *http.Request) (*config.PublishedService, string) {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	for i, s := range p.services {
+		if len(s.Host) > 0 && strings.EqualFold(s.Host, host) {
+			return &p.services[i], r.URL.Path
+		}
+	}
+	if !strings.HasPrefix(r.URL.Path, publishPathPrefix) {
+		return nil, ""
+	}
+	name := strings.TrimPrefix(r.URL.Path, publishPathPrefix)
+	path := "/"
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, path = name[:i], name[i:]
+	}
+	for i, s := range p.services {
+		if s.Name == name {
+			return &p.services[i], path
+		}
+	}
+	return nil, ""
+}
+
+// serve proxies r to its service and returns whether r is for a service.
+func (p *publisher) serve(w http.ResponseWriter, r *http.Request) bool {
+	s, path := p.match(r)
+	if s == nil {
+		return false
+	}
+	if !IsSubsystemEnabled(SubsystemPublish) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return true
+	}
+	if !s.Public {
+		user, password, ok := r.BasicAuth()
+		if !ok || subtle.ConstantTimeCompare([]byte(user+":"+password), []byte(s.Auth)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="`+s.Name+`"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return true
+		}
+	}
+	r.URL.Path = path
+	r.URL.RawPath = ""
+	p.proxies[s.Name].ServeHTTP(w, r)
+	return true
+}
+
+func (p *publisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !p.serve(w, r) {
+		http.NotFound(w, r)
+	}
+}
+
+// middleware serves published services on admin web server before other
+// routes.
+func (p *publisher) middleware(c *gin.Context) {
+	if p.serve(c.Writer, c.Request) {
+		c.Abort()
+	}
+}
+
+// StartPublishServer serves published client services on listenAddr until
+// ctx is done.
+func StartPublishServer(ctx context.Context, listenAddr string, tun *tunnel.Tunnel, mergedConf *config.Config) error {
+	s := &http.Server{Addr: listenAddr, Handler: newPublisher(mergedConf.PublishedServices, tun)}
+	return serveHTTP(ctx, s, s.ListenAndServe)
+}
@@ -0,0 +1,170 @@
+package admin
+
+import (
+	"errors"
+	"log"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/ss"
+	tunnel "github.com/nknorg/nkn-tunnel"
+)
+
+var (
+	// Config fields that take effect without restart when config file is
+	// reloaded, in addition to hotConfigFields and liveConfigFields. Log
+	// fields are applied by the caller of ReloadConfig.
+	reloadHotConfigFields = map[string]bool{
+		"acceptAddrs":   true,
+		"adminAddrs":    true,
+		"log":           true,
+		"logMaxSize":    true,
+		"logMaxBackups": true,
+	}
+
+	// Config fields that only take effect on server, where tun is available.
+	serverHotConfigFields = map[string]bool{
+		"tunaServiceName":     true,
+		"tunaCountry":         true,
+		"tunaAllowNknAddr":    true,
+		"tunaDisallowNknAddr": true,
+		"tunaAllowIp":         true,
+		"tunaDisallowIp":      true,
+		"acceptAddrs":         true,
+		"maxUploadMbps":       true,
+		"maxDownloadMbps":     true,
+	}
+
+	errNoConfigReloader = errors.New("config reload is not available")
+
+	configReloader func() ([]string, []string, error)
+)
+
+// SetConfigReloader sets the function reloadConfig rpc calls, which should
+// call ReloadConfig and apply the fields it cannot apply by itself.
+func SetConfigReloader(f func() ([]string, []string, error)) {
+	configReloader = f
+}
+
+// ReloadConfig reads config file again and applies fields changed in it to
+// running persistConf and mergedConf. It returns json names of fields that
+// have taken effect and fields that take effect after restart. Fields given
+// in command line arguments keep their value. tun is nil in client mode.
+func ReloadConfig(persistConf, mergedConf *config.Config, tun *tunnel.Tunnel) ([]string, []string, error) {
+	loaded, err := persistConf.ReadFile()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed := persistConf.Diff(loaded)
+	if len(changed) == 0 {
+		return nil, nil, nil
+	}
+
+	// A field given in command line differs from the persisted value in
+	// merged config, so command line still wins after reload.
+	var merged []string
+	for _, name := range changed {
+		if mergedConf.FieldEqual(persistConf, name) {
+			merged = append(merged, name)
+		}
+	}
+
+	updated, _, err := mergedConf.WithPartial(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = updated.CopyFields(loaded, merged)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tun != nil {
+		err = updated.VerifyServer()
+	} else {
+		err = updated.VerifyClient()
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = persistConf.CopyFields(loaded, changed)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = mergedConf.CopyFields(loaded, merged)
+	if err != nil {
+		return nil, nil, err
+	}
+	BumpRevision()
+
+	var applied, deferred []string
+	tunaChanged, acceptChanged := false, false
+	for _, name := range merged {
+		hot := hotConfigFields[name] || reloadHotConfigFields[name]
+		if serverHotConfigFields[name] && tun == nil {
+			hot = false
+		}
+		if !hot {
+			if applyLiveField(name) {
+				applied = append(applied, name)
+			} else {
+				deferred = append(deferred, name)
+			}
+			continue
+		}
+		applied = append(applied, name)
+		switch name {
+		case "configBackups":
+			persistConf.SetMaxBackups(loaded.ConfigBackups)
+		case "maxUploadMbps", "maxDownloadMbps":
+			ss.SetRateLimit(mergedConf.MaxUploadMbps, mergedConf.MaxDownloadMbps)
+		case "monthlyDataCapGB", "dataCapResetDay", "dataCapWarnPercent":
+			setDataCapLimits(mergedConf)
+		case "acceptAddrs":
+			acceptChanged = true
+		case "tunaServiceName", "tunaCountry", "tunaAllowNknAddr", "tunaDisallowNknAddr", "tunaAllowIp", "tunaDisallowIp":
+			tunaChanged = true
+		}
+	}
+
+	AddPendingRestart(deferred...)
+
+	if acceptChanged {
+		err = applyAcceptAddrs(persistConf, tun)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	if tunaChanged {
+		err = setTunaConfig(tun, persistConf, mergedConf, &tunaConfigJSON{
+			ServiceName:     loaded.TunaServiceName,
+			Country:         loaded.TunaCountry,
+			AllowNknAddr:    loaded.TunaAllowNknAddr,
+			DisallowNknAddr: loaded.TunaDisallowNknAddr,
+			AllowIp:         loaded.TunaAllowIp,
+			DisallowIp:      loaded.TunaDisallowIp,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(merged) < len(changed) {
+		log.Printf("Config reloaded, %d changed fields are overridden by command line arguments", len(changed)-len(merged))
+	}
+
+	return applied, deferred, nil
+}
+
+func reloadConfig() (*ApplyConfigResultJSON, error) {
+	if configReloader == nil {
+		return nil, errNoConfigReloader
+	}
+	applied, deferred, err := configReloader()
+	if err != nil {
+		return nil, err
+	}
+	result := &ApplyConfigResultJSON{Applied: make([]string, 0), Deferred: make([]string, 0)}
+	result.Applied = append(result.Applied, applied...)
+	result.Deferred = append(result.Deferred, deferred...)
+	return result, nil
+}
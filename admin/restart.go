@@ -0,0 +1,91 @@
+package admin
+
+import (
+	"errors"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	tunnel "github.com/nknorg/nkn-tunnel"
+)
+
+const (
+	restartConfirmExpiration = time.Minute
+	restartDelay             = time.Second // so restart response can be sent
+)
+
+var (
+	errInvalidRestartToken = errors.New("invalid or expired restart confirmation token, call restart without token to get a new one")
+
+	restartLock    sync.Mutex
+	pendingRestart = make(map[string]bool)
+	restartToken   *Token
+)
+
+type restartJSON struct {
+	Token string `json:"token"`
+}
+
+type restartResultJSON struct {
+	Confirm    *Token `json:"confirm,omitempty"` // pass its token to restart again to confirm
+	Restarting bool   `json:"restarting,omitempty"`
+}
+
+// AddPendingRestart records config fields or changes that only take effect
+// after restart, so they are shown in get info api.
+func AddPendingRestart(changes ...string) {
+	restartLock.Lock()
+	defer restartLock.Unlock()
+	for _, c := range changes {
+		pendingRestart[c] = true
+	}
+}
+
+func getPendingRestart() []string {
+	restartLock.Lock()
+	defer restartLock.Unlock()
+	changes := make([]string, 0, len(pendingRestart))
+	for c := range pendingRestart {
+		changes = append(changes, c)
+	}
+	sort.Strings(changes)
+	return changes
+}
+
+// restart returns a confirmation token if params has no token. With a valid
+// token, it closes tunnel and restarts this process with the same arguments
+// after a short delay.
+func restart(params *restartJSON, tun *tunnel.Tunnel) (*restartResultJSON, error) {
+	restartLock.Lock()
+	defer restartLock.Unlock()
+
+	if len(params.Token) == 0 {
+		restartToken = NewToken(restartConfirmExpiration)
+		return &restartResultJSON{Confirm: restartToken}, nil
+	}
+	if !restartToken.IsValid(params.Token) {
+		return nil, errInvalidRestartToken
+	}
+	restartToken = nil
+
+	go func() {
+		time.Sleep(restartDelay)
+		log.Println("Restarting by admin request")
+		if tun != nil {
+			tun.Close()
+		}
+		err := restartSelf()
+		log.Println("Restart error:", err)
+		os.Exit(1)
+	}()
+
+	return &restartResultJSON{Restarting: true}, nil
+}
+
+// RestartSelf restarts this process with the same executable path and
+// arguments, e.g. after the executable is updated. It only returns on error.
+func RestartSelf() error {
+	return restartSelf()
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package admin
+
+import (
+	"os"
+	"syscall"
+)
+
+// restartSelf replaces this process with a new one of the same executable and
+// arguments. It only returns on error.
+func restartSelf() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(exe, os.Args, os.Environ())
+}
@@ -0,0 +1,26 @@
+//go:build windows
+// +build windows
+
+package admin
+
+import (
+	"os"
+	"os/exec"
+)
+
+// restartSelf starts a new process of the same executable and arguments, then
+// exits. It only returns on error.
+func restartSelf() error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	os.Exit(0)
+	return nil
+}
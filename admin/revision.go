@@ -0,0 +1,109 @@
+package admin
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+)
+
+// Revision of config and state is bumped by each successful mutating rpc and
+// by config reload, so GUIs can poll getRevision cheaply and only fetch more
+// when it changes. It starts from the time of start in milliseconds, so it
+// does not go back after restart.
+var (
+	revision     atomic.Uint64
+	revisionLock sync.Mutex // serializes revision check and mutation
+)
+
+// rpcMutating are rpc methods that change config or state.
+var rpcMutating = map[string]bool{
+	"setAddrs":          true,
+	"addAddrs":          true,
+	"removeAddrs":       true,
+	"setAdminHttpApi":   true,
+	"setSeed":           true,
+	"setTunaConfig":     true,
+	"forgetSeenClient":  true,
+	"importClients":     true,
+	"backupConfig":      true,
+	"restoreConfig":     true,
+	"applyConfig":       true,
+	"reloadConfig":      true,
+	"restart":           true,
+	"setSubsystem":      true,
+	"setRollout":        true,
+	"pauseRollout":      true,
+	"setClientSettings": true,
+	"reportUpdate":      true,
+	"pair":              true,
+	"changeWebPassword": true,
+	"setWebUser":        true,
+	"removeWebUser":     true,
+	"confirmWebTOTP":    true,
+	"disableWebTOTP":    true,
+	"resetWebTOTP":      true,
+	"grantAssist":       true,
+	"revokeAssist":      true,
+	"issueToken":        true,
+	"revokeToken":       true,
+}
+
+type revisionJSON struct {
+	Revision uint64 `json:"revision"`
+}
+
+func init() {
+	revision.Store(uint64(time.Now().UnixMilli()))
+}
+
+// Revision returns the current config and state revision.
+func Revision() uint64 {
+	return revision.Load()
+}
+
+// BumpRevision marks config or state as changed outside of rpc, e.g. when
+// data cap suspends proxying.
+func BumpRevision() uint64 {
+	return revision.Add(1)
+}
+
+// setRevision sets revision of resp, and of its result if it is a state that
+// callers pass back as ifRevision.
+func setRevision(resp *rpcResp, rev uint64) {
+	resp.Revision = rev
+	if addrs, ok := resp.Result.(*addrsJSON); ok {
+		addrs.Revision = rev
+	}
+}
+
+// currentState returns the current state a mutating method changes, which is
+// returned together with a revision conflict error so the caller can merge
+// its changes into it instead of silently dropping the ones of another admin.
+// It returns nil if the method has no such state.
+func currentState(method string, persistConf *config.Config) interface{} {
+	switch method {
+	case "setAddrs", "addAddrs", "removeAddrs":
+		return getAddrs(persistConf)
+	}
+	return nil
+}
+
+// errRevisionConflict is returned by a mutating rpc with ifRevision that is
+// not the current revision, which means another admin has changed config or
+// state since the caller read it.
+func errRevisionConflict(expected uint64) error {
+	return fmt.Errorf("revision conflict: expected revision %d but current revision is %d, reload and try again", expected, Revision())
+}
+
+// GetRevision returns config and state revision of the node at addr.
+func (c *Client) GetRevision(addr string) (uint64, error) {
+	res := &revisionJSON{}
+	err := c.RPCCall(addr, "getRevision", nil, res)
+	if err != nil {
+		return 0, err
+	}
+	return res.Revision, nil
+}
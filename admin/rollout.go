@@ -0,0 +1,380 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/storage"
+)
+
+const (
+	rolloutKey = "rollout.json"
+
+	defaultRolloutStageInterval = 3600 // in seconds
+	defaultRolloutMaxFailures   = 3
+	maxRolloutSpread            = 10 * time.Minute
+
+	RolloutStatusUpdating = "updating" // update command is running
+	RolloutStatusUpdated  = "updated"  // client runs target version
+	RolloutStatusFailed   = "failed"   // update command failed
+)
+
+var (
+	defaultRolloutStages = []int{5, 25, 100}
+
+	errNoRolloutClient = errors.New("update check is only available to clients over NKN")
+
+	rolloutLock   sync.Mutex
+	rolloutLoaded bool
+	rollout       *rolloutStateJSON // nil if there is no rollout
+)
+
+// rolloutJSON is a staged rollout of a target version to clients. Clients
+// are added to the rollout stage by stage, and it is paused automatically
+// when too many clients fail to update.
+type rolloutJSON struct {
+	Version       string   `json:"version"`                 // target version, empty to cancel rollout
+	Channel       string   `json:"channel,omitempty"`       // only clients on this update channel, all if empty
+	Groups        []string `json:"groups,omitempty"`        // only clients in one of these groups, all if empty
+	Stages        []int    `json:"stages,omitempty"`        // percent of clients updated in each stage
+	StageInterval int64    `json:"stageInterval,omitempty"` // seconds before moving to next stage
+	MaxFailures   int      `json:"maxFailures,omitempty"`   // pause rollout when this many clients fail
+}
+
+type rolloutClientJSON struct {
+	Name       string    `json:"name,omitempty"`
+	Version    string    `json:"version"`
+	Channel    string    `json:"channel,omitempty"`
+	Groups     []string  `json:"groups,omitempty"`
+	Status     string    `json:"status,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	UpdateTime time.Time `json:"updateTime"`
+}
+
+type rolloutStateJSON struct {
+	rolloutJSON
+	Stage      int                           `json:"stage"` // index of current stage
+	StageStart time.Time                     `json:"stageStart"`
+	Paused     bool                          `json:"paused"`
+	PauseNote  string                        `json:"pauseNote,omitempty"`
+	Failures   int                           `json:"failures"`
+	Clients    map[string]*rolloutClientJSON `json:"clients"` // map client public key to its report
+}
+
+type pauseRolloutJSON struct {
+	Paused bool `json:"paused"`
+}
+
+// getUpdateJSON is what a client tells server when checking for update.
+type getUpdateJSON struct {
+	Version string   `json:"version"`
+	Channel string   `json:"channel,omitempty"`
+	Groups  []string `json:"groups,omitempty"`
+}
+
+// UpdateJSON is the version a client should update to. Version is empty if
+// there is nothing to update.
+type UpdateJSON struct {
+	Version string `json:"version,omitempty"`
+	Delay   int64  `json:"delay,omitempty"` // seconds to wait before updating, to spread load
+}
+
+type reportUpdateJSON struct {
+	Version string `json:"version"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+// loadRollout loads rollout state from runtime state storage on first use.
+// It should be called with rolloutLock held.
+func loadRollout(persistConf *config.Config) error {
+	if rolloutLoaded {
+		return nil
+	}
+	store, err := persistConf.GetStorage()
+	if err != nil {
+		return err
+	}
+	b, err := store.Get(rolloutKey)
+	if err == nil {
+		state := &rolloutStateJSON{}
+		err = json.Unmarshal(b, state)
+		if err != nil {
+			return err
+		}
+		rollout = state
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+	rolloutLoaded = true
+	return nil
+}
+
+// saveRollout should be called with rolloutLock held.
+func saveRollout(persistConf *config.Config) error {
+	store, err := persistConf.GetStorage()
+	if err != nil {
+		return err
+	}
+	if rollout == nil {
+		return store.Delete(rolloutKey)
+	}
+	b, err := json.Marshal(rollout)
+	if err != nil {
+		return err
+	}
+	return store.Put(rolloutKey, b)
+}
+
+// advanceRollout moves rollout to next stage when stage interval has passed.
+// It should be called with rolloutLock held.
+func advanceRollout() bool {
+	if rollout == nil || rollout.Paused || rollout.Stage >= len(rollout.Stages)-1 {
+		return false
+	}
+	if time.Since(rollout.StageStart) < time.Duration(rollout.StageInterval)*time.Second {
+		return false
+	}
+	rollout.Stage++
+	rollout.StageStart = time.Now()
+	log.Printf("Rollout of version %s moved to stage %d (%d%% of clients)", rollout.Version, rollout.Stage+1, rollout.Stages[rollout.Stage])
+	return true
+}
+
+// rolloutBucket maps a client to [0, 100) so a client stays selected in later
+// stages of the same rollout.
+func rolloutBucket(pubKey, version string) int {
+	h := fnv.New32a()
+	h.Write([]byte(version + "/" + pubKey))
+	return int(h.Sum32() % 100)
+}
+
+func setRollout(persistConf *config.Config, params *rolloutJSON) error {
+	rolloutLock.Lock()
+	defer rolloutLock.Unlock()
+	err := loadRollout(persistConf)
+	if err != nil {
+		return err
+	}
+
+	if len(params.Version) == 0 {
+		if rollout != nil {
+			log.Printf("Rollout of version %s canceled", rollout.Version)
+		}
+		rollout = nil
+		return saveRollout(persistConf)
+	}
+
+	if len(params.Stages) == 0 {
+		params.Stages = defaultRolloutStages
+	}
+	for i, p := range params.Stages {
+		if p <= 0 || p > 100 || (i > 0 && p <= params.Stages[i-1]) {
+			return errors.New("rollout stages should be increasing percents between 1 and 100")
+		}
+	}
+	if params.StageInterval < 0 || params.MaxFailures < 0 {
+		return errors.New("rollout stage interval and max failures should not be negative")
+	}
+	if params.StageInterval == 0 {
+		params.StageInterval = defaultRolloutStageInterval
+	}
+	if params.MaxFailures == 0 {
+		params.MaxFailures = defaultRolloutMaxFailures
+	}
+
+	rollout = &rolloutStateJSON{
+		rolloutJSON: *params,
+		StageStart:  time.Now(),
+		Clients:     make(map[string]*rolloutClientJSON),
+	}
+	log.Printf("Rollout of version %s started with stages %v", params.Version, params.Stages)
+	return saveRollout(persistConf)
+}
+
+func pauseRollout(persistConf *config.Config, params *pauseRolloutJSON) error {
+	rolloutLock.Lock()
+	defer rolloutLock.Unlock()
+	err := loadRollout(persistConf)
+	if err != nil {
+		return err
+	}
+	if rollout == nil {
+		return errors.New("there is no rollout")
+	}
+	rollout.Paused = params.Paused
+	if params.Paused {
+		rollout.PauseNote = "paused by admin"
+	} else {
+		rollout.PauseNote = ""
+		rollout.Failures = 0
+		rollout.StageStart = time.Now()
+	}
+	return saveRollout(persistConf)
+}
+
+func getRollout(persistConf *config.Config) (*rolloutStateJSON, error) {
+	rolloutLock.Lock()
+	defer rolloutLock.Unlock()
+	err := loadRollout(persistConf)
+	if err != nil {
+		return nil, err
+	}
+	if advanceRollout() {
+		err = saveRollout(persistConf)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if rollout == nil {
+		return nil, nil
+	}
+	b, err := json.Marshal(rollout)
+	if err != nil {
+		return nil, err
+	}
+	state := &rolloutStateJSON{}
+	err = json.Unmarshal(b, state)
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+// inRollout returns whether a client is targeted by rollout at all,
+// regardless of stage.
+func inRollout(params *getUpdateJSON, group string) bool {
+	if len(rollout.Channel) > 0 && rollout.Channel != params.Channel {
+		return false
+	}
+	if len(rollout.Groups) == 0 {
+		return true
+	}
+	for _, g := range rollout.Groups {
+		if g == group {
+			return true
+		}
+		for _, cg := range params.Groups {
+			if g == cg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getUpdate records the version of a client and returns the version it
+// should update to, if it is in the current stage of rollout.
+func getUpdate(persistConf *config.Config, pubKey string, params *getUpdateJSON) (*UpdateJSON, error) {
+	rolloutLock.Lock()
+	defer rolloutLock.Unlock()
+	err := loadRollout(persistConf)
+	if err != nil {
+		return nil, err
+	}
+	advanceRollout()
+	if rollout == nil {
+		return &UpdateJSON{}, nil
+	}
+
+	info := persistConf.GetClients()[pubKey]
+	if !inRollout(params, info.Group) {
+		return &UpdateJSON{}, nil
+	}
+
+	c, ok := rollout.Clients[pubKey]
+	if !ok {
+		c = &rolloutClientJSON{}
+		rollout.Clients[pubKey] = c
+	}
+	c.Name = info.Name
+	c.Version = params.Version
+	c.Channel = params.Channel
+	c.Groups = params.Groups
+	c.UpdateTime = time.Now()
+	if params.Version == rollout.Version {
+		c.Status = RolloutStatusUpdated
+		c.Error = ""
+	}
+	err = saveRollout(persistConf)
+	if err != nil {
+		return nil, err
+	}
+
+	if params.Version == rollout.Version || rollout.Paused || c.Status == RolloutStatusFailed {
+		return &UpdateJSON{}, nil
+	}
+	if rolloutBucket(pubKey, rollout.Version) >= rollout.Stages[rollout.Stage] {
+		return &UpdateJSON{}, nil
+	}
+
+	spread := time.Duration(rollout.StageInterval) * time.Second / 4
+	if spread > maxRolloutSpread {
+		spread = maxRolloutSpread
+	}
+	var delay int64
+	if spread >= time.Second {
+		delay = rand.Int63n(int64(spread / time.Second))
+	}
+	return &UpdateJSON{Version: rollout.Version, Delay: delay}, nil
+}
+
+// reportUpdate records update result of a client, and pauses rollout when
+// failures reach the limit.
+func reportUpdate(persistConf *config.Config, pubKey string, params *reportUpdateJSON) error {
+	switch params.Status {
+	case RolloutStatusUpdating, RolloutStatusUpdated, RolloutStatusFailed:
+	default:
+		return fmt.Errorf("unknown update status %q", params.Status)
+	}
+
+	rolloutLock.Lock()
+	defer rolloutLock.Unlock()
+	err := loadRollout(persistConf)
+	if err != nil {
+		return err
+	}
+	if rollout == nil || params.Version != rollout.Version {
+		return nil
+	}
+	c, ok := rollout.Clients[pubKey]
+	if !ok {
+		return nil
+	}
+	if params.Status == RolloutStatusFailed && c.Status != RolloutStatusFailed {
+		rollout.Failures++
+		log.Printf("Client %s failed to update to %s: %s", pubKey, params.Version, params.Error)
+		if !rollout.Paused && rollout.Failures >= rollout.MaxFailures {
+			rollout.Paused = true
+			rollout.PauseNote = fmt.Sprintf("paused after %d clients failed to update", rollout.Failures)
+			log.Printf("Rollout of version %s %s", rollout.Version, rollout.PauseNote)
+		}
+	}
+	c.Status = params.Status
+	c.Error = params.Error
+	c.UpdateTime = time.Now()
+	return saveRollout(persistConf)
+}
+
+// GetUpdate checks the server at addr for the version this client should
+// update to.
+func (c *Client) GetUpdate(addr, version, channel string, groups []string) (*UpdateJSON, error) {
+	res := &UpdateJSON{}
+	err := c.RPCCall(addr, "getUpdate", &getUpdateJSON{Version: version, Channel: channel, Groups: groups}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReportUpdate reports update status of this client to the server at addr.
+func (c *Client) ReportUpdate(addr, version, status, errMsg string) error {
+	return c.RPCCall(addr, "reportUpdate", &reportUpdateJSON{Version: version, Status: status, Error: errMsg}, nil)
+}
@@ -0,0 +1,44 @@
+package admin
+
+import (
+	"fmt"
+)
+
+const (
+	// RPCVersion is the admin rpc protocol version of this build. Requests
+	// without version are from clients older than versioning and treated as
+	// version 1.
+	RPCVersion = 2
+
+	// MinRPCVersion is the oldest protocol version still served. Versions
+	// from it to RPCVersion-1 are deprecated but keep working, so old web GUIs
+	// and admin clients have time to upgrade.
+	MinRPCVersion = 1
+
+	// rpcVersionChunked is the first version that understands chunked replies.
+	rpcVersionChunked = 2
+)
+
+// negotiateRPCVersion returns the protocol version used to answer a request
+// of version v, which is the highest version both sides support.
+func negotiateRPCVersion(v int) (int, error) {
+	if v <= 0 {
+		v = 1
+	}
+	if v < MinRPCVersion {
+		return 0, fmt.Errorf("rpc version %d is no longer supported, minimum is %d, please upgrade client", v, MinRPCVersion)
+	}
+	if v > RPCVersion {
+		v = RPCVersion
+	}
+	return v, nil
+}
+
+// rpcDeprecationNote returns a note for clients using a deprecated protocol
+// version, or empty string if v is current.
+func rpcDeprecationNote(v int) string {
+	if v >= RPCVersion {
+		return ""
+	}
+	return fmt.Sprintf("rpc version %d is deprecated and will be removed in a future release, current version is %d", v, RPCVersion)
+}
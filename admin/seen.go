@@ -0,0 +1,189 @@
+package admin
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/events"
+	"github.com/nknorg/nconnect/storage"
+	"github.com/nknorg/nconnect/util"
+)
+
+const (
+	seenClientsKey = "clients-seen.json"
+
+	// seenSaveInterval limits how often last seen time alone is saved to state
+	// storage. New clients and changed client info are saved at once.
+	seenSaveInterval = time.Minute
+
+	// A client seen again after this long is reported as connected again.
+	seenReconnectGap = 10 * time.Minute
+)
+
+var (
+	seenLock     sync.Mutex
+	seenLoaded   bool
+	seenClients  map[string]*seenClientJSON // map client public key to what server knows about it
+	seenLastSave time.Time
+)
+
+// seenClientJSON is a client that has successfully talked to this server.
+type seenClientJSON struct {
+	Addr      string    `json:"addr"`              // last NKN address the client used
+	Name      string    `json:"name,omitempty"`    // friendly name reported by client
+	Version   string    `json:"version,omitempty"` // nConnect version reported by client
+	TunAddr   string    `json:"tunAddr,omitempty"` // last TUN IP address of client over the tunnel
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+type forgetSeenClientJSON struct {
+	PubKey string `json:"pubKey"`
+}
+
+type seenClientInfoJSON struct {
+	PubKey string `json:"pubKey"`
+	seenClientJSON
+	Group  string `json:"group,omitempty"`
+	Paired bool   `json:"paired"` // still in accept or admin addresses
+}
+
+// loadSeenClients should be called with seenLock held.
+func loadSeenClients(persistConf *config.Config) error {
+	if seenLoaded {
+		return nil
+	}
+	store, err := persistConf.GetStorage()
+	if err != nil {
+		return err
+	}
+	seenClients = make(map[string]*seenClientJSON)
+	b, err := store.Get(seenClientsKey)
+	if err == nil {
+		err = json.Unmarshal(b, &seenClients)
+		if err != nil {
+			return err
+		}
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+	seenLoaded = true
+	return nil
+}
+
+// saveSeenClients should be called with seenLock held.
+func saveSeenClients(persistConf *config.Config) error {
+	store, err := persistConf.GetStorage()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(seenClients)
+	if err != nil {
+		return err
+	}
+	err = store.Put(seenClientsKey, b)
+	if err != nil {
+		return err
+	}
+	seenLastSave = time.Now()
+	return nil
+}
+
+// recordSeenClient updates last seen time of the client at addr, and its
+// info if params is not nil.
+func recordSeenClient(persistConf *config.Config, addr string, params *getInfoJSON) {
+	seenLock.Lock()
+	defer seenLock.Unlock()
+	err := loadSeenClients(persistConf)
+	if err != nil {
+		log.Println("Load seen clients error:", err)
+		return
+	}
+
+	now := time.Now()
+	pubKey := addrPubKey(addr)
+	c, ok := seenClients[pubKey]
+	changed := !ok
+	if !ok {
+		c = &seenClientJSON{FirstSeen: now}
+		seenClients[pubKey] = c
+	}
+	if c.Addr != addr {
+		c.Addr = addr
+		changed = true
+	}
+	if params != nil {
+		name := sanitizeClientName(params.Name)
+		if len(name) > 0 && name != c.Name {
+			c.Name = name
+			changed = true
+		}
+		if len(params.Version) > 0 && params.Version != c.Version {
+			c.Version = params.Version
+			changed = true
+		}
+		if params.TunAddr != c.TunAddr {
+			c.TunAddr = params.TunAddr
+			changed = true
+		}
+	}
+	if !ok || now.Sub(c.LastSeen) >= seenReconnectGap {
+		events.Publish(events.ClientConnected{Addr: addr, Name: c.Name, FirstSeen: !ok})
+	}
+	c.LastSeen = now
+
+	if !changed && now.Sub(seenLastSave) < seenSaveInterval {
+		return
+	}
+	err = saveSeenClients(persistConf)
+	if err != nil {
+		log.Println("Save seen clients error:", err)
+	}
+}
+
+// getSeenClients returns clients that have talked to this server, most
+// recently seen first.
+func getSeenClients(persistConf *config.Config) ([]*seenClientInfoJSON, error) {
+	seenLock.Lock()
+	defer seenLock.Unlock()
+	err := loadSeenClients(persistConf)
+	if err != nil {
+		return nil, err
+	}
+
+	infos := persistConf.GetClients()
+	acceptAddrs, adminAddrs := persistConf.GetAcceptAddrs(), persistConf.GetAdminAddrs()
+	clients := make([]*seenClientInfoJSON, 0, len(seenClients))
+	for pubKey, c := range seenClients {
+		clients = append(clients, &seenClientInfoJSON{
+			PubKey:         pubKey,
+			seenClientJSON: *c,
+			Group:          infos[pubKey].Group,
+			Paired:         util.MatchRegex(acceptAddrs, c.Addr) || util.MatchRegex(adminAddrs, c.Addr),
+		})
+	}
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].LastSeen.After(clients[j].LastSeen)
+	})
+	return clients, nil
+}
+
+// forgetSeenClient removes a client from seen clients, e.g. a decommissioned
+// device.
+func forgetSeenClient(persistConf *config.Config, pubKey string) error {
+	seenLock.Lock()
+	defer seenLock.Unlock()
+	err := loadSeenClients(persistConf)
+	if err != nil {
+		return err
+	}
+	if _, ok := seenClients[pubKey]; !ok {
+		return nil
+	}
+	delete(seenClients, pubKey)
+	return saveSeenClients(persistConf)
+}
@@ -1,27 +1,127 @@
 package admin
 
 import (
+	"context"
 	"encoding/json"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/events"
+	"github.com/nknorg/nconnect/monitor"
 	"github.com/nknorg/nconnect/util"
 	"github.com/nknorg/nkn-sdk-go"
 	tunnel "github.com/nknorg/nkn-tunnel"
 )
 
-func StartNKNServer(account *nkn.Account, identifier string, clientConfig *nkn.ClientConfig, tun *tunnel.Tunnel, persistConf, mergedConf *config.Config) error {
-	m, err := nkn.NewMultiClient(account, identifier, 4, false, clientConfig)
-	if err != nil {
-		return err
+const (
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = time.Minute
+	clientCheckInterval = 10 * time.Second
+)
+
+// StartNKNServer starts the admin NKN server and keeps it online. When the
+// multiclient is closed or all of its clients are disconnected, it is created
+// again with the same account and identifier, so the admin address does not
+// change. It returns nil after closing the multiclient when ctx is done.
+func StartNKNServer(ctx context.Context, account *nkn.Account, identifier string, clientConfig *nkn.ClientConfig, tun *tunnel.Tunnel, persistConf, mergedConf *config.Config) error {
+	backoff := minReconnectBackoff
+	for {
+		m, err := nkn.NewMultiClient(account, identifier, mergedConf.GetAdminNumSubClients(), false, clientConfig)
+		if err != nil {
+			log.Printf("Create admin NKN client error: %v, retry in %v", err, backoff)
+			if !monitor.Sleep(ctx, backoff) {
+				return nil
+			}
+			backoff = nextReconnectBackoff(backoff)
+			continue
+		}
+
+		select {
+		case <-m.OnConnect.C:
+		case <-ctx.Done():
+			m.Close()
+			return nil
+		}
+		backoff = minReconnectBackoff
+
+		serverAdminAddr = m.Address()
+		serverMultiClient = m
+
+		serveNKN(ctx, m, tun, persistConf, mergedConf)
+
+		m.Close()
+		if ctx.Err() != nil {
+			return nil
+		}
+		AddNKNReconnects(1)
+		log.Printf("Admin NKN client disconnected, reconnect in %v", backoff)
+		if !monitor.Sleep(ctx, backoff) {
+			return nil
+		}
+		backoff = nextReconnectBackoff(backoff)
 	}
+}
 
-	<-m.OnConnect.C
+func nextReconnectBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff > maxReconnectBackoff {
+		return maxReconnectBackoff
+	}
+	return backoff
+}
+
+// isDisconnected returns whether a multiclient can no longer receive
+// messages, either closed or with all clients closed.
+func isDisconnected(m *nkn.MultiClient) bool {
+	if m.IsClosed() {
+		return true
+	}
+	for _, c := range m.GetClients() {
+		if !c.IsClosed() {
+			return false
+		}
+	}
+	return true
+}
 
-	serverAdminAddr = m.Address()
+// serveNKN handles admin requests until the multiclient is disconnected or
+// ctx is done.
+// Messages are rate limited per source address before being parsed, then
+// requests are authorized as they arrive and handled by workers, with the
+// ones of admins first, so a flood of client requests or a slow rpc does not
+// make the server unmanageable.
+func serveNKN(ctx context.Context, m *nkn.MultiClient, tun *tunnel.Tunnel, persistConf, mergedConf *config.Config) {
+	ticker := time.NewTicker(clientCheckInterval)
+	defer ticker.Stop()
+
+	q := newRPCQueue()
+	defer q.close()
+	q.start(func(r *nknRequest) {
+		handleNKNRequest(r, persistConf, mergedConf, tun)
+	})
 
 	for {
-		msg := <-m.OnMessage.C
+		var msg *nkn.Message
+		var ok bool
+		select {
+		case msg, ok = <-m.OnMessage.C:
+			if !ok {
+				return
+			}
+		case <-ticker.C:
+			if isDisconnected(m) {
+				return
+			}
+			continue
+		case <-ctx.Done():
+			return
+		}
+
+		if !allowAdminMsg(mergedConf, msg.Src) {
+			continue
+		}
 
 		req := &rpcReq{}
 		err := json.Unmarshal(msg.Data, req)
@@ -31,37 +131,113 @@ func StartNKNServer(account *nkn.Account, identifier string, clientConfig *nkn.C
 		}
 
 		isAcceptAddr := util.MatchRegex(persistConf.GetAcceptAddrs(), msg.Src)
-		isAdminAddr := util.MatchRegex(persistConf.GetAdminAddrs(), msg.Src)
+		adminRole, isAdminAddr := persistConf.GetAdminRole(msg.Src)
+
+		if adminRole != config.AdminRoleOwner && tokenStore.IsValid(req.Token) {
+			adminRole, isAdminAddr = config.AdminRoleOwner, true
+		}
+
+		var perm permission
+		if adminRole != config.AdminRoleOwner {
+			var user string
+			perm, user = nknTokenPermission(persistConf, req.Token)
+			if len(user) > 0 && !strings.HasPrefix(req.Method, "get") {
+				log.Printf("Web user %s called %s over NKN", user, req.Method)
+			}
+			if tokenPerm, ok := issuedTokens.permission(persistConf, req.Token); ok {
+				perm |= tokenPerm
+			}
+		}
 
-		if !isAdminAddr && tokenStore.IsValid(req.Token) {
-			isAdminAddr = true
+		if !isAcceptAddr && !isAdminAddr && perm == 0 && req.Method == "pair" {
+			perm = rpcPermissionPairing // checked against pairing token by pair
 		}
 
-		if !isAcceptAddr && !isAdminAddr {
+		if !isAcceptAddr && !isAdminAddr && perm == 0 {
 			log.Println("Ignore authorized message from", msg.Src)
+			events.Publish(events.ClientRejected{Addr: msg.Src, Via: events.ViaAdmin})
 			continue
 		}
 
-		var perm permission
+		req.src = msg.Src
+
 		if isAcceptAddr {
 			perm |= rpcPermissionAcceptClient
 		}
 		if isAdminAddr {
-			perm |= rpcPermissionAdminClient
+			perm |= adminRolePermission(adminRole)
 		}
 
-		resp := handleRequest(req, persistConf, mergedConf, tun, perm)
+		r := &nknRequest{msg: msg, req: req, perm: perm, seen: isAcceptAddr || isAdminAddr}
+		r.assist = isAdminAddr && isAssistAddr(persistConf, msg.Src)
+		if !q.push(r) {
+			log.Printf("Drop %s request from %s: rpc queue is full", req.Method, msg.Src)
+		}
+	}
+}
+
+// handleNKNRequest handles an authorized request received over NKN and
+// replies to it.
+func handleNKNRequest(r *nknRequest, persistConf, mergedConf *config.Config, tun *tunnel.Tunnel) {
+	msg, req := r.msg, r.req
+
+	if r.seen && req.Method != "getInfo" {
+		recordSeenClient(persistConf, msg.Src, nil) // getInfo records client info itself
+	}
 
-		b, err := json.Marshal(resp)
+	start := time.Now()
+	var resp *rpcResp
+	if r.assist {
+		if err := checkAssistRequest(req); err != nil {
+			resp = &rpcResp{Error: err.Error()}
+		}
+	}
+	if resp == nil {
+		resp = handleRequest(req, persistConf, mergedConf, tun, r.perm)
+	}
+	debugSessions.publishRPC(msg.Src, req, resp, time.Since(start))
+	recordAudit(mergedConf, AuditTransportNKN, msg.Src, "", req, resp, r.perm)
+	if r.assist {
+		auditAssistCall(persistConf, msg.Src, req.Method, resp.Error)
+	}
+
+	b, err := json.Marshal(resp)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	maxReplySize := mergedConf.GetAdminMaxReplySize()
+	if len(b) > maxReplySize && resp.Version < rpcVersionChunked {
+		b, err = json.Marshal(&rpcResp{Version: resp.Version, Error: errReplyTooLarge.Error()})
 		if err != nil {
 			log.Println(err)
-			continue
+			return
 		}
-
-		err = msg.Reply(string(b))
+	} else if len(b) > maxReplySize {
+		b, err = json.Marshal(&rpcResp{Version: resp.Version, Chunk: chunkStore.split(msg.Src, b, maxReplySize/2)})
 		if err != nil {
 			log.Println(err)
-			continue
+			return
 		}
 	}
+
+	err = msg.Reply(string(b))
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+// adminRolePermission returns the permission of an admin address with role.
+func adminRolePermission(role string) permission {
+	switch role {
+	case config.AdminRoleOwner:
+		return rpcPermissionAdminClient
+	case config.AdminRoleOperator:
+		return rpcPermissionAdminOperator
+	case config.AdminRoleViewer:
+		return rpcPermissionWebViewer
+	default:
+		return 0
+	}
 }
@@ -0,0 +1,40 @@
+package admin
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+const (
+	DefaultSpeedTestSize = 4 << 20
+	maxSpeedTestSize     = 16 << 20
+)
+
+type speedTestJSON struct {
+	Size int `json:"size"` // payload size in bytes
+}
+
+// speedTest returns random payload of the requested size so clients can
+// measure throughput to this server. Large payload is sent in chunks.
+func speedTest(params *speedTestJSON) ([]byte, error) {
+	if params.Size <= 0 || params.Size > maxSpeedTestSize {
+		return nil, fmt.Errorf("speed test size should be between 1 and %d", maxSpeedTestSize)
+	}
+	b := make([]byte, params.Size)
+	_, err := rand.Read(b)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SpeedTest downloads a random payload of size bytes from the server at addr
+// and returns the number of bytes received.
+func (c *Client) SpeedTest(addr string, size int) (int, error) {
+	var res []byte
+	err := c.RPCCall(addr, "speedTest", &speedTestJSON{Size: size}, &res)
+	if err != nil {
+		return 0, err
+	}
+	return len(res), nil
+}
@@ -0,0 +1,118 @@
+package admin
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/ss"
+	"github.com/nknorg/nkn-sdk-go"
+	tunnel "github.com/nknorg/nkn-tunnel"
+)
+
+// Subsystems that can be disabled and enabled at runtime, e.g. during an
+// incident. Changes are not saved to config and are reset by restart.
+const (
+	SubsystemUDP     = "udp"     // proxy UDP packets
+	SubsystemTuna    = "tuna"    // accept new tuna sessions
+	SubsystemWebAPI  = "webApi"  // admin web API
+	SubsystemDirect  = "direct"  // accept new direct connections
+	SubsystemPublish = "publish" // published client services
+)
+
+var (
+	subsystemLock      sync.Mutex
+	disabledSubsystems = make(map[string]bool)
+)
+
+type subsystemJSON struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+// IsSubsystemEnabled returns whether a subsystem is not disabled at runtime.
+func IsSubsystemEnabled(name string) bool {
+	subsystemLock.Lock()
+	defer subsystemLock.Unlock()
+	return !disabledSubsystems[name]
+}
+
+// configuredSubsystems returns subsystems enabled in config, which are the
+// ones that can be toggled at runtime.
+func configuredSubsystems(conf *config.Config) []string {
+	var names []string
+	if conf.UDP {
+		names = append(names, SubsystemUDP)
+	}
+	if conf.Tuna {
+		names = append(names, SubsystemTuna)
+	}
+	if len(conf.AdminHTTPAddr) > 0 {
+		names = append(names, SubsystemWebAPI)
+	}
+	if len(conf.DirectListenAddr) > 0 {
+		names = append(names, SubsystemDirect)
+	}
+	if len(conf.PublishedServices) > 0 {
+		names = append(names, SubsystemPublish)
+	}
+	return names
+}
+
+func getSubsystems(conf *config.Config) []subsystemJSON {
+	names := configuredSubsystems(conf)
+	subsystemLock.Lock()
+	defer subsystemLock.Unlock()
+	subsystems := make([]subsystemJSON, len(names))
+	for i, name := range names {
+		subsystems[i] = subsystemJSON{Name: name, Enabled: !disabledSubsystems[name]}
+	}
+	return subsystems
+}
+
+func setSubsystem(persistConf, mergedConf *config.Config, tun *tunnel.Tunnel, params *subsystemJSON) error {
+	configured := false
+	for _, name := range configuredSubsystems(mergedConf) {
+		if name == params.Name {
+			configured = true
+			break
+		}
+	}
+	if !configured {
+		return fmt.Errorf("subsystem %s is unknown or not enabled in config", params.Name)
+	}
+
+	subsystemLock.Lock()
+	disabledSubsystems[params.Name] = !params.Enabled
+	subsystemLock.Unlock()
+
+	switch params.Name {
+	case SubsystemUDP:
+		ss.SetUDPEnabled(params.Enabled)
+	case SubsystemTuna:
+		err := applyAcceptAddrs(persistConf, tun)
+		if err != nil {
+			return err
+		}
+	}
+
+	state := "disabled"
+	if params.Enabled {
+		state = "enabled"
+	}
+	log.Printf("Subsystem %s %s at runtime", params.Name, state)
+	return nil
+}
+
+// applyTunaSubsystem stops tuna from accepting new sessions if it is disabled
+// at runtime. It should be called after tunnel accept addresses are set.
+func applyTunaSubsystem(tun *tunnel.Tunnel) error {
+	if IsSubsystemEnabled(SubsystemTuna) {
+		return nil
+	}
+	if tsClient := tun.TunaSessionClient(); tsClient != nil {
+		return tsClient.Listen(nkn.NewStringArray())
+	}
+	return nil
+}
@@ -0,0 +1,275 @@
+package admin
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nkn-sdk-go"
+	tunnel "github.com/nknorg/nkn-tunnel"
+	"github.com/nknorg/nkn/v2/common"
+	"github.com/nknorg/nkn/v2/pb"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// maxTransactionScanBlocks is the number of recent blocks scanned for
+	// transactions on first call, about 4 hours of blocks. NKN nodes do not
+	// index transactions by address, so older ones are not available.
+	maxTransactionScanBlocks   = 720
+	transactionScanConcurrency = 8
+	transactionScanTimeout     = 2 * time.Minute
+	maxCachedTransactions      = 500
+	defaultTransactionsLimit   = 50
+
+	TransactionTypeTransfer = "transfer"
+	TransactionTypeNanoPay  = "nanoPay"
+)
+
+var (
+	errWalletNotReady = errors.New("wallet is not ready")
+
+	txHistory = &transactionHistory{}
+)
+
+type getTransactionsJSON struct {
+	Limit int `json:"limit"` // max number of transactions returned, newest first
+}
+
+// TransactionJSON is a transaction from or to the node's wallet.
+type TransactionJSON struct {
+	Hash      string    `json:"hash"`
+	Type      string    `json:"type"` // transfer or nanoPay settlement
+	Height    uint32    `json:"height"`
+	Time      time.Time `json:"time"`
+	Sender    string    `json:"sender"`
+	Recipient string    `json:"recipient"`
+	Amount    string    `json:"amount"` // in NKN
+	Fee       string    `json:"fee"`    // in NKN
+	Outgoing  bool      `json:"outgoing"`
+}
+
+// TransactionsJSON is the result of getTransactions rpc.
+type TransactionsJSON struct {
+	Address      string             `json:"address"`
+	Transactions []*TransactionJSON `json:"transactions"`
+	ScannedFrom  uint32             `json:"scannedFrom"` // transactions in blocks before this height are not included
+	Height       uint32             `json:"height"`      // height of the latest block scanned
+}
+
+// transactionHistory caches transactions of the node's wallet found in
+// blocks scanned so far, so each call only scans blocks added since the last
+// one.
+type transactionHistory struct {
+	lock    sync.Mutex
+	address string
+	from    uint32 // first height scanned
+	height  uint32 // last height scanned, 0 if none
+	txs     []*TransactionJSON
+}
+
+// blockInfoJSON is the part of getblock rpc result about transactions.
+type blockInfoJSON struct {
+	Header struct {
+		Height    uint32 `json:"height"`
+		Timestamp int64  `json:"timestamp"`
+	} `json:"header"`
+	Transactions []struct {
+		TxType      string `json:"txType"`
+		PayloadData string `json:"payloadData"`
+		Fee         int64  `json:"fee"`
+		Hash        string `json:"hash"`
+	} `json:"transactions"`
+}
+
+// getTransactions returns recent transfers and nanopay settlements from or to
+// the wallet of tun, newest first.
+func getTransactions(conf *config.Config, tun *tunnel.Tunnel, params *getTransactionsJSON) (*TransactionsJSON, error) {
+	if tun == nil || tun.MultiClient() == nil {
+		return nil, errWalletNotReady
+	}
+	account := tun.MultiClient().Account()
+	address, err := account.ProgramHash.ToAddress()
+	if err != nil {
+		return nil, err
+	}
+
+	rpcConf := nkn.GetDefaultRPCConfig()
+	if len(conf.SeedRPCServerAddr) > 0 {
+		rpcConf.SeedRPCServerAddr = nkn.NewStringArray(conf.SeedRPCServerAddr...)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), transactionScanTimeout)
+	defer cancel()
+
+	limit := params.Limit
+	if limit <= 0 {
+		limit = defaultTransactionsLimit
+	}
+	return txHistory.get(ctx, address, account.ProgramHash, rpcConf, limit)
+}
+
+func (h *transactionHistory) get(ctx context.Context, address string, programHash common.Uint160, rpcConf nkn.RPCConfigInterface, limit int) (*TransactionsJSON, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.address != address { // seed changed
+		*h = transactionHistory{address: address}
+	}
+
+	height, err := nkn.GetHeightContext(ctx, rpcConf)
+	if err != nil {
+		return nil, err
+	}
+	to := uint32(height)
+	from := h.height + 1
+	if h.height == 0 || to-h.height > maxTransactionScanBlocks {
+		from = 1
+		if to > maxTransactionScanBlocks {
+			from = to - maxTransactionScanBlocks + 1
+		}
+		h.txs = nil
+		h.from = from
+	}
+
+	if from <= to {
+		txs, err := scanTransactions(ctx, programHash, from, to, rpcConf)
+		if err != nil {
+			return nil, err
+		}
+		h.txs = append(txs, h.txs...)
+		if len(h.txs) > maxCachedTransactions {
+			h.txs = h.txs[:maxCachedTransactions]
+		}
+		h.height = to
+	}
+
+	txs := h.txs
+	if len(txs) > limit {
+		txs = txs[:limit]
+	}
+	return &TransactionsJSON{
+		Address:      address,
+		Transactions: txs,
+		ScannedFrom:  h.from,
+		Height:       h.height,
+	}, nil
+}
+
+// scanTransactions returns transactions of programHash in blocks from height
+// from to height to, newest first.
+func scanTransactions(ctx context.Context, programHash common.Uint160, from, to uint32, rpcConf nkn.RPCConfigInterface) ([]*TransactionJSON, error) {
+	blocks := make([][]*TransactionJSON, to-from+1)
+	heights := make(chan uint32)
+	errs := make(chan error, transactionScanConcurrency)
+
+	var wg sync.WaitGroup
+	for i := 0; i < transactionScanConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for height := range heights {
+				txs, err := blockTransactions(ctx, programHash, height, rpcConf)
+				if err != nil {
+					errs <- err
+					return
+				}
+				blocks[height-from] = txs
+			}
+		}()
+	}
+
+	var err error
+send:
+	for height := from; height <= to; height++ {
+		select {
+		case heights <- height:
+		case err = <-errs:
+			break send
+		}
+	}
+	close(heights)
+	wg.Wait()
+	if err == nil && len(errs) > 0 {
+		err = <-errs
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var txs []*TransactionJSON
+	for i := len(blocks) - 1; i >= 0; i-- {
+		txs = append(txs, blocks[i]...)
+	}
+	return txs, nil
+}
+
+// blockTransactions returns transfers and nanopay settlements of
+// programHash in block at height.
+func blockTransactions(ctx context.Context, programHash common.Uint160, height uint32, rpcConf nkn.RPCConfigInterface) ([]*TransactionJSON, error) {
+	block := &blockInfoJSON{}
+	err := nkn.RPCCall(ctx, "getblock", map[string]interface{}{"height": height}, block, rpcConf)
+	if err != nil {
+		return nil, err
+	}
+
+	var txs []*TransactionJSON
+	for _, t := range block.Transactions {
+		var sender, recipient []byte
+		var amount int64
+		var typ string
+		data, err := hex.DecodeString(t.PayloadData)
+		if err != nil {
+			continue
+		}
+		switch t.TxType {
+		case pb.PayloadType_TRANSFER_ASSET_TYPE.String():
+			p := &pb.TransferAsset{}
+			if proto.Unmarshal(data, p) != nil {
+				continue
+			}
+			typ, sender, recipient, amount = TransactionTypeTransfer, p.Sender, p.Recipient, p.Amount
+		case pb.PayloadType_NANO_PAY_TYPE.String():
+			p := &pb.NanoPay{}
+			if proto.Unmarshal(data, p) != nil {
+				continue
+			}
+			typ, sender, recipient, amount = TransactionTypeNanoPay, p.Sender, p.Recipient, p.Amount
+		default:
+			continue
+		}
+
+		senderHash, recipientHash := common.BytesToUint160(sender), common.BytesToUint160(recipient)
+		if senderHash != programHash && recipientHash != programHash {
+			continue
+		}
+		senderAddr, _ := senderHash.ToAddress()
+		recipientAddr, _ := recipientHash.ToAddress()
+		txs = append(txs, &TransactionJSON{
+			Hash:      t.Hash,
+			Type:      typ,
+			Height:    block.Header.Height,
+			Time:      time.Unix(block.Header.Timestamp, 0),
+			Sender:    senderAddr,
+			Recipient: recipientAddr,
+			Amount:    common.Fixed64(amount).String(),
+			Fee:       common.Fixed64(t.Fee).String(),
+			Outgoing:  senderHash == programHash,
+		})
+	}
+	return txs, nil
+}
+
+// GetTransactions returns recent transactions of the wallet of the node at
+// addr, newest first.
+func (c *Client) GetTransactions(addr string, limit int) (*TransactionsJSON, error) {
+	res := &TransactionsJSON{}
+	err := c.RPCCall(addr, "getTransactions", &getTransactionsJSON{Limit: limit}, res)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
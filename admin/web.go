@@ -1,26 +1,84 @@
 package admin
 
 import (
+	"bytes"
+	"context"
+	"crypto/tls"
 	"errors"
+	"io"
+	"log"
+	"net"
 	"net/http"
 	"path"
+	"strings"
+	"time"
 
 	"github.com/gin-contrib/gzip"
 	"github.com/gin-gonic/gin"
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/monitor"
 	tunnel "github.com/nknorg/nkn-tunnel"
 )
 
+const (
+	httpShutdownTimeout = 5 * time.Second
+)
+
 var (
 	errAdminHTTPAPIDisabled = errors.New("Web API is disabled")
+
+	// webListener counts connections of admin HTTP listener. Its address is
+	// set when web server starts.
+	webListener = monitor.NewListener(monitor.ListenerAdminHTTP, nil)
 )
 
-func StartWebServer(listenAddr string, tun *tunnel.Tunnel, persistConf, mergedConf *config.Config) error {
+// handshakeErrorCounter counts TLS handshake errors logged by HTTP server as
+// handshake failures of admin HTTP listener, and writes logs to w.
+type handshakeErrorCounter struct {
+	w io.Writer
+}
+
+func (c *handshakeErrorCounter) Write(b []byte) (int, error) {
+	if bytes.Contains(b, []byte("TLS handshake error")) {
+		webListener.HandshakeFailed()
+	}
+	return c.w.Write(b)
+}
+
+// StartWebServer serves admin web GUI and HTTP API on listenAddr until ctx is
+// done.
+func StartWebServer(ctx context.Context, listenAddr string, tun *tunnel.Tunnel, persistConf, mergedConf *config.Config) error {
 	gin.SetMode(gin.ReleaseMode)
 
 	r := gin.Default()
 
-	r.Use(gzip.Gzip(gzip.DefaultCompression))
+	if len(mergedConf.PublishedServices) > 0 && len(mergedConf.PublishAddr) == 0 {
+		r.Use(newPublisher(mergedConf.PublishedServices, tun).middleware)
+	}
+
+	// Streamed log lines are sent as they are written, not buffered by gzip.
+	r.Use(gzip.Gzip(gzip.DefaultCompression, gzip.WithExcludedPaths([]string{followLogPath})))
+
+	var tlsConfig *tls.Config
+	if mergedConf.AdminHTTPTLS {
+		var err error
+		tlsConfig, err = adminTLSConfig(persistConf, mergedConf)
+		if err != nil {
+			return err
+		}
+	}
+
+	var http3Server *http3Server
+	if tlsConfig != nil && mergedConf.AdminHTTP3 {
+		var err error
+		http3Server, err = newHTTP3Server(listenAddr, r, tlsConfig)
+		if err != nil {
+			return err
+		}
+		r.Use(func(c *gin.Context) {
+			http3Server.setAltSvc(c.Writer.Header())
+		})
+	}
 
 	r.POST("/rpc/admin", func(c *gin.Context) {
 		req := &rpcReq{}
@@ -28,14 +86,36 @@ func StartWebServer(listenAddr string, tun *tunnel.Tunnel, persistConf, mergedCo
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		if mergedConf.DisableAdminHTTPAPI {
+		if mergedConf.DisableAdminHTTPAPI || !IsSubsystemEnabled(SubsystemWebAPI) {
 			c.JSON(http.StatusOK, &rpcResp{Error: errAdminHTTPAPIDisabled.Error()})
 			return
 		}
-		resp := handleRequest(req, persistConf, mergedConf, tun, rpcPermissionWeb)
+		perm, user := httpPermission(c.Request, persistConf, req)
+		if len(user) > 0 && !strings.HasPrefix(req.Method, "get") {
+			log.Printf("Web user %s called %s", user, req.Method)
+		}
+		resp := handleRequest(req, persistConf, mergedConf, tun, perm)
+		recordAudit(mergedConf, AuditTransportHTTP, user, c.ClientIP(), req, resp, perm)
+		if resp.Error == errPermissionDenied.Error() || req.Method == "webLogin" && len(resp.Error) > 0 && resp.Error != config.ErrWebTOTPRequired.Error() {
+			webListener.AuthFailed()
+		}
 		c.JSON(http.StatusOK, resp)
 	})
 
+	r.GET(wsPath, func(c *gin.Context) {
+		serveEvents(ctx, c, persistConf, mergedConf)
+	})
+	r.GET(followLogPath, func(c *gin.Context) {
+		serveFollowLog(ctx, c, persistConf, mergedConf)
+	})
+
+	if mergedConf.AdminHTTPMetrics {
+		r.GET(metricsPath, func(c *gin.Context) {
+			c.Header("Content-Type", metricsContentType)
+			writeMetrics(c.Writer, persistConf, mergedConf, tun)
+		})
+	}
+
 	r.StaticFile("/", path.Join(mergedConf.WebRootPath, "index.html"))
 	r.StaticFile("/favicon.ico", path.Join(mergedConf.WebRootPath, "favicon.ico"))
 	r.StaticFile("/sw.js", path.Join(mergedConf.WebRootPath, "sw.js"))
@@ -45,5 +125,61 @@ func StartWebServer(listenAddr string, tun *tunnel.Tunnel, persistConf, mergedCo
 	r.Static("/zh", path.Join(mergedConf.WebRootPath, "zh"))
 	r.Static("/zh-TW", path.Join(mergedConf.WebRootPath, "zh-TW"))
 
-	return r.Run(listenAddr)
+	l, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return err
+	}
+	l = monitor.NewListener(monitor.ListenerAdminHTTP, l.Addr()).Count(l)
+
+	// Failed TLS handshakes are only reported to error log of server.
+	errorLog := log.New(&handshakeErrorCounter{w: log.Writer()}, "", log.LstdFlags)
+	s := &http.Server{Addr: listenAddr, Handler: r.Handler(), TLSConfig: tlsConfig, ErrorLog: errorLog}
+	if tlsConfig == nil {
+		return serveHTTP(ctx, s, func() error { return s.Serve(l) })
+	}
+
+	errChan := make(chan error, 2)
+	if http3Server != nil {
+		go func() {
+			errChan <- http3Server.listenAndServe()
+		}()
+		defer http3Server.close()
+		log.Println("Admin web dashboard also serves HTTP/3 on UDP", listenAddr)
+	}
+	go func() {
+		errChan <- serveHTTP(ctx, s, func() error {
+			return s.ServeTLS(l, "", "")
+		})
+	}()
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return <-errChan
+	}
+}
+
+// serveHTTP calls serve of s, and shuts s down when ctx is done. Connections
+// still active after httpShutdownTimeout are closed. It returns nil if s is
+// shut down by ctx.
+func serveHTTP(ctx context.Context, s *http.Server, serve func() error) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+			defer cancel()
+			if s.Shutdown(shutdownCtx) != nil {
+				s.Close()
+			}
+		case <-stop:
+		}
+	}()
+
+	err := serve()
+	if errors.Is(err, http.ErrServerClosed) && ctx.Err() != nil {
+		return nil
+	}
+	return err
 }
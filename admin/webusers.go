@@ -0,0 +1,386 @@
+package admin
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+)
+
+const (
+	WebSessionExpiration = 12 * time.Hour
+)
+
+var (
+	webSessions = &webSessionStore{sessions: make(map[string]*webSession)}
+)
+
+type webSession struct {
+	user     string
+	token    *Token
+	nknToken *Token // handed off to NKN admin clients, nil until requested
+
+	// TOTP secret being enrolled, which is saved to config once confirmed
+	// with a code.
+	pendingTOTPSecret string
+}
+
+// webSessionStore keeps logged in web sessions in memory, so all sessions
+// are logged out when server restarts.
+type webSessionStore struct {
+	lock     sync.Mutex
+	sessions map[string]*webSession // map token to session
+}
+
+func (s *webSessionStore) add(user string) *Token {
+	t := NewToken(WebSessionExpiration)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for k, v := range s.sessions {
+		if !v.token.IsValid(k) {
+			delete(s.sessions, k)
+		}
+	}
+	s.sessions[t.Token] = &webSession{user: user, token: t}
+	return t
+}
+
+func (s *webSessionStore) get(token string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[token]
+	if !ok {
+		return "", false
+	}
+	if !session.token.IsValid(token) {
+		delete(s.sessions, token)
+		return "", false
+	}
+	return session.user, true
+}
+
+// nknToken returns the NKN token of a session, minting one on first call.
+// NKN admin clients, e.g. nkn-client-js in browser, can use it with the
+// permission of the session user until the session expires or logs out.
+func (s *webSessionStore) nknToken(token string) (*Token, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[token]
+	if !ok || !session.token.IsValid(token) {
+		return nil, false
+	}
+	if session.nknToken == nil {
+		session.nknToken = NewToken(time.Until(time.Time(session.token.ExpiresAt)))
+	}
+	return session.nknToken, true
+}
+
+// getByNKNToken returns the user of the session that owns an NKN token.
+func (s *webSessionStore) getByNKNToken(token string) (string, bool) {
+	if len(token) == 0 {
+		return "", false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, session := range s.sessions {
+		if session.nknToken.IsValid(token) {
+			return session.user, true
+		}
+	}
+	return "", false
+}
+
+// setPendingTOTP sets the TOTP secret being enrolled by a session and returns
+// the session user.
+func (s *webSessionStore) setPendingTOTP(token, secret string) (string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[token]
+	if !ok || !session.token.IsValid(token) {
+		return "", false
+	}
+	session.pendingTOTPSecret = secret
+	return session.user, true
+}
+
+// pendingTOTP returns the session user and the TOTP secret it is enrolling.
+func (s *webSessionStore) pendingTOTP(token string) (string, string, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	session, ok := s.sessions[token]
+	if !ok || !session.token.IsValid(token) {
+		return "", "", false
+	}
+	return session.user, session.pendingTOTPSecret, true
+}
+
+func (s *webSessionStore) remove(token string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.sessions, token)
+}
+
+// removeUser logs out all sessions of a user.
+func (s *webSessionStore) removeUser(user string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for k, v := range s.sessions {
+		if v.user == user {
+			delete(s.sessions, k)
+		}
+	}
+}
+
+type webLoginJSON struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+	Code     string `json:"code,omitempty"` // TOTP or backup code, if two-factor authentication is enabled
+}
+
+type webLoginResultJSON struct {
+	Token              *Token `json:"token"`
+	Role               string `json:"role"`
+	MustChangePassword bool   `json:"mustChangePassword,omitempty"`
+}
+
+type changeWebPasswordJSON struct {
+	Name        string `json:"name"`
+	OldPassword string `json:"oldPassword"`
+	NewPassword string `json:"newPassword"`
+}
+
+// nknTokenJSON is a token for NKN admin clients to call admin rpc on addr.
+type nknTokenJSON struct {
+	Addr  string `json:"addr"`
+	Token *Token `json:"token"`
+	Role  string `json:"role"`
+}
+
+type webTOTPCodeJSON struct {
+	Code string `json:"code"`
+}
+
+type webTOTPEnrollJSON struct {
+	Secret string `json:"secret"` // base32, for manual entry
+	URI    string `json:"uri"`    // otpauth URI, for QR code
+}
+
+type webTOTPBackupCodesJSON struct {
+	BackupCodes []string `json:"backupCodes"`
+}
+
+type webUserJSON struct {
+	Name     string `json:"name"`
+	Role     string `json:"role,omitempty"`
+	Password string `json:"password,omitempty"`
+}
+
+type webUserInfoJSON struct {
+	Name               string `json:"name"`
+	Role               string `json:"role"`
+	LastLogin          int64  `json:"lastLogin,omitempty"`
+	MustChangePassword bool   `json:"mustChangePassword,omitempty"`
+	TOTP               bool   `json:"totp,omitempty"` // two-factor authentication is enabled
+}
+
+// webPermission returns the permission of a web request. Web requests have
+// full web permission if no web user is configured, otherwise they need a
+// session token of a user that does not have to change password, or an issued
+// token with the permission of its scopes.
+func webPermission(persistConf *config.Config, req *rpcReq) (permission, string) {
+	if !persistConf.HasWebUsers() {
+		return rpcPermissionWeb, ""
+	}
+	if perm, ok := issuedTokens.permission(persistConf, req.Token); ok {
+		return perm, ""
+	}
+	name, ok := webSessions.get(req.Token)
+	if !ok {
+		return rpcPermissionWebLogin, ""
+	}
+	u := getWebUser(persistConf, name)
+	if u == nil {
+		return rpcPermissionWebLogin, ""
+	}
+	if u.MustChangePassword {
+		return rpcPermissionWebLogin, name
+	}
+	if u.Role == config.WebRoleAdmin {
+		return rpcPermissionWeb | rpcPermissionWebLogin, name
+	}
+	return rpcPermissionWebViewer | rpcPermissionWebLogin, name
+}
+
+// nknTokenPermission returns the permission of an NKN request with the NKN
+// token of a web session: an admin user has admin client permission, and a
+// viewer can only call methods allowed to web viewers.
+func nknTokenPermission(persistConf *config.Config, token string) (permission, string) {
+	name, ok := webSessions.getByNKNToken(token)
+	if !ok {
+		return 0, ""
+	}
+	u := getWebUser(persistConf, name)
+	if u == nil || u.MustChangePassword {
+		return 0, ""
+	}
+	if u.Role == config.WebRoleAdmin {
+		return rpcPermissionAdminClient, name
+	}
+	return rpcPermissionWebViewer, name
+}
+
+func getWebUser(persistConf *config.Config, name string) *config.WebUser {
+	for _, u := range persistConf.GetWebUsers() {
+		if u.Name == name {
+			return &u
+		}
+	}
+	return nil
+}
+
+// getNKNToken hands off a web login to NKN admin clients. Without web users,
+// web requests have full permission and get the rotating admin token.
+func getNKNToken(persistConf *config.Config, sessionToken string) (*nknTokenJSON, error) {
+	if len(serverAdminAddr) == 0 {
+		return nil, errors.New("admin NKN client is not started")
+	}
+	if !persistConf.HasWebUsers() {
+		return &nknTokenJSON{Addr: serverAdminAddr, Token: tokenStore.GetCurrentToken(), Role: config.WebRoleAdmin}, nil
+	}
+	name, ok := webSessions.get(sessionToken)
+	if !ok {
+		return nil, errPermissionDenied
+	}
+	u := getWebUser(persistConf, name)
+	if u == nil {
+		return nil, errPermissionDenied
+	}
+	t, ok := webSessions.nknToken(sessionToken)
+	if !ok {
+		return nil, errPermissionDenied
+	}
+	return &nknTokenJSON{Addr: serverAdminAddr, Token: t, Role: u.Role}, nil
+}
+
+func webLogin(persistConf *config.Config, params *webLoginJSON) (*webLoginResultJSON, error) {
+	u, err := persistConf.AuthenticateWebUser(params.Name, params.Password, params.Code)
+	if err == config.ErrWebTOTPRequired {
+		return nil, err
+	}
+	if err != nil {
+		log.Printf("Web user %s login failed: %v", params.Name, err)
+		return nil, err
+	}
+	log.Printf("Web user %s logged in", u.Name)
+	return &webLoginResultJSON{
+		Token:              webSessions.add(u.Name),
+		Role:               u.Role,
+		MustChangePassword: u.MustChangePassword,
+	}, nil
+}
+
+func changeWebPassword(persistConf *config.Config, params *changeWebPasswordJSON) error {
+	err := persistConf.ChangeWebPassword(params.Name, params.OldPassword, params.NewPassword)
+	if err != nil {
+		return err
+	}
+	webSessions.removeUser(params.Name)
+	return nil
+}
+
+func getWebUsers(persistConf *config.Config) []webUserInfoJSON {
+	users := persistConf.GetWebUsers()
+	res := make([]webUserInfoJSON, len(users))
+	for i, u := range users {
+		res[i] = webUserInfoJSON{Name: u.Name, Role: u.Role, LastLogin: u.LastLogin, MustChangePassword: u.MustChangePassword, TOTP: u.HasTOTP()}
+	}
+	return res
+}
+
+func setWebUser(persistConf *config.Config, params *webUserJSON) error {
+	err := persistConf.SetWebUser(params.Name, params.Role, params.Password)
+	if err != nil {
+		return err
+	}
+	webSessions.removeUser(params.Name)
+	return nil
+}
+
+func removeWebUser(persistConf *config.Config, params *webUserJSON) error {
+	err := persistConf.RemoveWebUser(params.Name)
+	if err != nil {
+		return err
+	}
+	webSessions.removeUser(params.Name)
+	return nil
+}
+
+// enrollWebTOTP starts two-factor authentication enrollment of the user logged
+// in with session token. It takes effect after confirmWebTOTP.
+func enrollWebTOTP(sessionToken string) (*webTOTPEnrollJSON, error) {
+	secret, err := config.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	name, ok := webSessions.setPendingTOTP(sessionToken, secret)
+	if !ok {
+		return nil, errPermissionDenied
+	}
+	return &webTOTPEnrollJSON{Secret: secret, URI: config.TOTPURI("nConnect", name, secret)}, nil
+}
+
+// confirmWebTOTP enables two-factor authentication of the user logged in with
+// session token once code matches the secret being enrolled, and returns
+// backup codes.
+func confirmWebTOTP(persistConf *config.Config, sessionToken string, params *webTOTPCodeJSON) (*webTOTPBackupCodesJSON, error) {
+	name, secret, ok := webSessions.pendingTOTP(sessionToken)
+	if !ok {
+		return nil, errPermissionDenied
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("call enrollWebTOTP first")
+	}
+	codes, err := persistConf.EnableWebTOTP(name, secret, params.Code)
+	if err != nil {
+		return nil, err
+	}
+	webSessions.setPendingTOTP(sessionToken, "")
+	log.Printf("Web user %s enabled two-factor authentication", name)
+	return &webTOTPBackupCodesJSON{BackupCodes: codes}, nil
+}
+
+// disableWebTOTP disables two-factor authentication of the user logged in
+// with session token after checking a TOTP or backup code.
+func disableWebTOTP(persistConf *config.Config, sessionToken string, params *webTOTPCodeJSON) error {
+	name, ok := webSessions.get(sessionToken)
+	if !ok {
+		return errPermissionDenied
+	}
+	err := persistConf.DisableWebTOTP(name, params.Code)
+	if err != nil {
+		return err
+	}
+	log.Printf("Web user %s disabled two-factor authentication", name)
+	return nil
+}
+
+// resetWebTOTP disables two-factor authentication of a web user without a
+// code, which is the recovery path for users who lost their authenticator and
+// backup codes.
+func resetWebTOTP(persistConf *config.Config, params *webUserJSON) error {
+	err := persistConf.ResetWebTOTP(params.Name)
+	if err != nil {
+		return err
+	}
+	webSessions.removeUser(params.Name)
+	log.Printf("Two-factor authentication of web user %s is reset", params.Name)
+	return nil
+}
+
+// ResetWebTOTP disables two-factor authentication of web user name on the node
+// at addr, so the user can log in with password only.
+func (c *Client) ResetWebTOTP(addr, name string) error {
+	return c.RPCCall(addr, "resetWebTOTP", &webUserJSON{Name: name}, nil)
+}
@@ -0,0 +1,95 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/events"
+)
+
+const (
+	wsPath         = "/ws"
+	wsPingInterval = 30 * time.Second
+	wsWriteTimeout = 10 * time.Second
+)
+
+// Only same origin pages (the web GUI) may connect, which is the default
+// origin check of the upgrader.
+var wsUpgrader = websocket.Upgrader{}
+
+// wsEventJSON is a message pushed to the web GUI over websocket.
+type wsEventJSON struct {
+	Type  string       `json:"type"`
+	Event events.Event `json:"event"`
+}
+
+// authorizeWebStream checks that web API is enabled and c has a verified client
+// certificate, or the web session token of a user with web or web viewer
+// permission in token query if there are web users, and replies with the
+// error if not.
+func authorizeWebStream(c *gin.Context, persistConf, mergedConf *config.Config) bool {
+	if mergedConf.DisableAdminHTTPAPI || !IsSubsystemEnabled(SubsystemWebAPI) {
+		c.JSON(http.StatusForbidden, gin.H{"error": errAdminHTTPAPIDisabled.Error()})
+		return false
+	}
+	perm, _ := httpPermission(c.Request, persistConf, &rpcReq{Token: c.Query("token")})
+	if perm&(rpcPermissionWeb|rpcPermissionWebViewer) == 0 {
+		webListener.AuthFailed()
+		c.JSON(http.StatusUnauthorized, gin.H{"error": errPermissionDenied.Error()})
+		return false
+	}
+	return true
+}
+
+// serveEvents upgrades c to a websocket and pushes events to it until either
+// side closes it or ctx is done.
+func serveEvents(ctx context.Context, c *gin.Context, persistConf, mergedConf *config.Config) {
+	if !authorizeWebStream(c, persistConf, mergedConf) {
+		return
+	}
+
+	conn, err := wsUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		return // upgrader has replied with the error
+	}
+	defer conn.Close()
+
+	ch, unsubscribe := events.Subscribe()
+	defer unsubscribe()
+
+	// Messages from GUI are not used, but have to be read to handle pong and
+	// close messages.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(wsPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case e := <-ch:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+			err = conn.WriteJSON(&wsEventJSON{Type: e.Type(), Event: e})
+		case <-ticker.C:
+			err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+		case <-closed:
+			return
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, ""), time.Now().Add(wsWriteTimeout))
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package nconnect
+
+import (
+	"log"
+	"net"
+	"os"
+
+	"github.com/nknorg/nconnect/mdns"
+)
+
+const (
+	proxyServiceType = "_socks._tcp"
+)
+
+// advertiseProxy advertises local socks proxy listening at proxyAddr on LAN,
+// named after client name or hostname.
+func (nc *nconnect) advertiseProxy(proxyAddr *net.TCPAddr) (*mdns.Advertiser, error) {
+	name := nc.opts.Name
+	if len(name) == 0 {
+		name, _ = os.Hostname()
+	}
+	a, err := mdns.Advertise(mdns.Service{
+		Instance: "nConnect on " + name,
+		Service:  proxyServiceType,
+		Port:     proxyAddr.Port,
+		IP:       proxyAddr.IP,
+		Text:     []string{"txtvers=1", "proxy=socks5"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Advertising socks proxy on LAN as %s", proxyServiceType)
+	return a, nil
+}
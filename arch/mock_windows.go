@@ -0,0 +1,276 @@
+//go:build windows && routetest
+
+package arch
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// MockAdapter replaces TUN adapters, netsh and NRPT commands in routetest
+// builds, so route and DNS handling can be tested on Windows runners without
+// administrator privilege or a TAP driver. It keeps the routes and NRPT rules
+// a real system would have after each command.
+type MockAdapter struct {
+	sync.Mutex
+	adapters map[string]bool              // opened adapter names
+	routes   map[string]map[string]string // adapter name -> route destination -> next hop
+	nrpt     map[string][]string          // namespace -> name servers
+	suffixes []string                     // global DNS suffix search list
+	commands [][]string
+	failures map[string]error // command prefix -> error returned once
+}
+
+// Mock is the mock adapter used by this package in routetest builds.
+var Mock = NewMockAdapter()
+
+var (
+	removeNrptNamespace = regexp.MustCompile(`Where-Object Namespace -eq '([^']*)'`)
+	quotedString        = regexp.MustCompile(`'([^']*)'`)
+)
+
+func init() {
+	runCmd = Mock.run
+	openTunDevice = Mock.open
+}
+
+// NewMockAdapter creates an empty mock adapter.
+func NewMockAdapter() *MockAdapter {
+	m := &MockAdapter{}
+	m.Reset()
+	return m
+}
+
+// Reset removes all adapters, routes, NRPT rules, DNS suffixes, recorded
+// commands and pending failures.
+func (m *MockAdapter) Reset() {
+	m.Lock()
+	defer m.Unlock()
+	m.adapters = make(map[string]bool)
+	m.routes = make(map[string]map[string]string)
+	m.nrpt = make(map[string][]string)
+	m.suffixes = nil
+	m.commands = nil
+	m.failures = make(map[string]error)
+}
+
+// AddRoute adds a route without running a command, e.g. a route left over
+// from a previous run.
+func (m *MockAdapter) AddRoute(devName, dest, nextHop string) {
+	m.Lock()
+	defer m.Unlock()
+	if m.routes[devName] == nil {
+		m.routes[devName] = make(map[string]string)
+	}
+	m.routes[devName][dest] = nextHop
+}
+
+// Routes returns route destinations of an adapter mapped to next hop.
+func (m *MockAdapter) Routes(devName string) map[string]string {
+	m.Lock()
+	defer m.Unlock()
+	routes := make(map[string]string, len(m.routes[devName]))
+	for k, v := range m.routes[devName] {
+		routes[k] = v
+	}
+	return routes
+}
+
+// NRPTRules returns name resolution policy table rules, namespace mapped to
+// name servers.
+func (m *MockAdapter) NRPTRules() map[string][]string {
+	m.Lock()
+	defer m.Unlock()
+	rules := make(map[string][]string, len(m.nrpt))
+	for k, v := range m.nrpt {
+		rules[k] = append([]string(nil), v...)
+	}
+	return rules
+}
+
+// DNSSuffixes returns the global DNS suffix search list.
+func (m *MockAdapter) DNSSuffixes() []string {
+	m.Lock()
+	defer m.Unlock()
+	return append([]string(nil), m.suffixes...)
+}
+
+// Commands returns commands run since last reset.
+func (m *MockAdapter) Commands() [][]string {
+	m.Lock()
+	defer m.Unlock()
+	return append([][]string(nil), m.commands...)
+}
+
+// FailNext makes the next command starting with prefix fail with err.
+func (m *MockAdapter) FailNext(prefix string, err error) {
+	m.Lock()
+	defer m.Unlock()
+	m.failures[prefix] = err
+}
+
+func (m *MockAdapter) open(name, addr, gw, mask string, dnsServers []string, persist bool) (io.ReadWriteCloser, error) {
+	m.Lock()
+	defer m.Unlock()
+	m.adapters[name] = true
+	return newMockDevice(), nil
+}
+
+func (m *MockAdapter) run(name string, arg ...string) ([]byte, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	cmd := append([]string{name}, arg...)
+	m.commands = append(m.commands, cmd)
+	line := strings.Join(cmd, " ")
+	for prefix, err := range m.failures {
+		if strings.HasPrefix(line, prefix) {
+			delete(m.failures, prefix)
+			return nil, err
+		}
+	}
+
+	switch name {
+	case "netsh":
+		return m.runNetsh(arg)
+	case "powershell":
+		return m.runPowershell(arg)
+	}
+	return nil, fmt.Errorf("%s is not supported by mock adapter", name)
+}
+
+// runNetsh handles "netsh interface ipv4|ipv6 add|set|delete route <dest> [nexthop=<gw>] interface=<name> ...".
+func (m *MockAdapter) runNetsh(arg []string) ([]byte, error) {
+	if len(arg) < 5 || arg[0] != "interface" || (arg[1] != "ipv4" && arg[1] != "ipv6") || arg[3] != "route" {
+		return nil, fmt.Errorf("unsupported netsh command: %v", arg)
+	}
+	op, dest := arg[2], arg[4]
+	params := make(map[string]string)
+	for _, a := range arg[5:] {
+		if kv := strings.SplitN(a, "=", 2); len(kv) == 2 {
+			params[kv[0]] = kv[1]
+		}
+	}
+
+	devName := params["interface"]
+	if !m.adapters[devName] {
+		return nil, errors.New("The filename, directory name, or volume label syntax is incorrect.")
+	}
+	routes := m.routes[devName]
+	if routes == nil {
+		routes = make(map[string]string)
+		m.routes[devName] = routes
+	}
+	_, exists := routes[dest]
+
+	switch op {
+	case "add":
+		if exists {
+			return nil, errors.New("The object already exists.")
+		}
+		routes[dest] = params["nexthop"]
+	case "set":
+		if !exists {
+			return nil, errors.New("Element not found.")
+		}
+		routes[dest] = params["nexthop"]
+	case "delete":
+		if !exists {
+			return nil, errors.New("Element not found.")
+		}
+		delete(routes, dest)
+	default:
+		return nil, fmt.Errorf("unsupported netsh route operation %s", op)
+	}
+	return []byte("Ok.\r\n"), nil
+}
+
+// runPowershell handles adding NRPT rules and removing them by namespace, and
+// adding and removing global DNS suffixes.
+func (m *MockAdapter) runPowershell(arg []string) ([]byte, error) {
+	if len(arg) < 2 || arg[0] != "-Command" {
+		return nil, fmt.Errorf("unsupported powershell command: %v", arg)
+	}
+	switch arg[1] {
+	case "Add-DnsClientNrptRule":
+		var namespace, servers string
+		for i := 2; i+1 < len(arg); i += 2 {
+			switch arg[i] {
+			case "-Namespace":
+				namespace = arg[i+1]
+			case "-NameServers":
+				servers = arg[i+1]
+			}
+		}
+		if len(namespace) == 0 || len(servers) == 0 {
+			return nil, fmt.Errorf("invalid NRPT rule: %v", arg)
+		}
+		m.nrpt[namespace] = strings.Split(servers, ",")
+		return nil, nil
+	default:
+		if strings.HasPrefix(arg[1], "Set-DnsClientGlobalSetting -SuffixSearchList") {
+			m.setSuffixes(arg[1])
+			return nil, nil
+		}
+		match := removeNrptNamespace.FindStringSubmatch(arg[1])
+		if match == nil || !strings.Contains(arg[1], "Remove-DnsClientNrptRule") {
+			return nil, fmt.Errorf("unsupported powershell command: %v", arg)
+		}
+		delete(m.nrpt, match[1])
+		return nil, nil
+	}
+}
+
+// setSuffixes adds the quoted suffixes of a Set-DnsClientGlobalSetting
+// command to the search list, or removes them if it filters the list.
+func (m *MockAdapter) setSuffixes(command string) {
+	remove := strings.Contains(command, "-notcontains")
+	for _, match := range quotedString.FindAllStringSubmatch(command, -1) {
+		suffix, found := match[1], -1
+		for i, s := range m.suffixes {
+			if s == suffix {
+				found = i
+				break
+			}
+		}
+		if remove && found >= 0 {
+			m.suffixes = append(m.suffixes[:found], m.suffixes[found+1:]...)
+		} else if !remove && found < 0 {
+			m.suffixes = append(m.suffixes, suffix)
+		}
+	}
+}
+
+// mockDevice is an adapter that never receives packets and drops packets
+// written to it. Read returns io.EOF after it's closed.
+type mockDevice struct {
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func newMockDevice() *mockDevice {
+	return &mockDevice{closed: make(chan struct{})}
+}
+
+func (d *mockDevice) Read(b []byte) (int, error) {
+	<-d.closed
+	return 0, io.EOF
+}
+
+func (d *mockDevice) Write(b []byte) (int, error) {
+	select {
+	case <-d.closed:
+		return 0, io.ErrClosedPipe
+	default:
+		return len(b), nil
+	}
+}
+
+func (d *mockDevice) Close() error {
+	d.closeOnce.Do(func() { close(d.closed) })
+	return nil
+}
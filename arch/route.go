@@ -0,0 +1,17 @@
+package arch
+
+import (
+	"net"
+)
+
+// isOnLinkRoute returns whether dest should be routed to the device without
+// gateway, as gateway is of another IP version, e.g. IPv6 routes of an IPv4
+// TUN device.
+func isOnLinkRoute(dest *net.IPNet, gateway string) bool {
+	gw := net.ParseIP(gateway)
+	return gw == nil || isIPv6(dest) != (gw.To4() == nil)
+}
+
+func isIPv6(dest *net.IPNet) bool {
+	return dest.IP.To4() == nil
+}
@@ -1,11 +1,30 @@
 package arch
 
 import (
+	"fmt"
 	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	resolverDir = "/etc/resolver"
 )
 
 func AddRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
+	if isOnLinkRoute(dest, gateway) {
+		family := "-inet"
+		if isIPv6(dest) {
+			family = "-inet6"
+		}
+		b, err := exec.Command("route", "-n", "add", family, "-net", dest.String(), "-interface", devName).Output()
+		if err == nil {
+			return b, nil
+		}
+		return exec.Command("route", "-n", "change", family, "-net", dest.String(), "-interface", devName).Output()
+	}
 	b, err := exec.Command("route", "-n", "add", "-net", dest.String(), gateway).Output()
 	if err == nil {
 		return b, nil
@@ -14,5 +33,78 @@ func AddRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
 }
 
 func DeleteRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
+	if isOnLinkRoute(dest, gateway) {
+		family := "-inet"
+		if isIPv6(dest) {
+			family = "-inet6"
+		}
+		return exec.Command("route", "-n", "delete", family, "-net", dest.String(), "-interface", devName).Output()
+	}
 	return exec.Command("route", "-n", "delete", "-net", dest.String(), gateway).Output()
 }
+
+// NetworkStateCmds returns commands that print interfaces, routes and DNS
+// settings, used for diagnostics.
+func NetworkStateCmds() [][]string {
+	return [][]string{
+		{"ifconfig"},
+		{"netstat", "-rn"},
+		{"scutil", "--dns"},
+	}
+}
+
+// SetDNSScopeCmd resolves domains with dnsServers by adding resolver files,
+// since device specific DNS is not supported. searchDomains are added as a
+// supplemental DNS configuration of the device with scutil.
+func SetDNSScopeCmd(devName string, dnsServers, domains, searchDomains []string) ([]byte, error) {
+	if len(dnsServers) == 0 {
+		return nil, nil
+	}
+	if len(domains) > 0 {
+		err := os.MkdirAll(resolverDir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var b strings.Builder
+	for _, server := range dnsServers {
+		fmt.Fprintf(&b, "nameserver %s\n", server)
+	}
+	for _, domain := range domains {
+		err := os.WriteFile(filepath.Join(resolverDir, domain), []byte(b.String()), 0644)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(searchDomains) == 0 {
+		return nil, nil
+	}
+	return scutil(fmt.Sprintf("d.init\nd.add ServerAddresses * %s\nd.add SearchDomains * %s\nd.add SupplementalMatchDomains * %s\nset %s\n",
+		strings.Join(dnsServers, " "), strings.Join(searchDomains, " "), strings.Join(searchDomains, " "), dnsServiceKey(devName)))
+}
+
+func DeleteDNSScopeCmd(devName string, domains, searchDomains []string) ([]byte, error) {
+	for _, domain := range domains {
+		err := os.Remove(filepath.Join(resolverDir, domain))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if len(searchDomains) == 0 {
+		return nil, nil
+	}
+	return scutil(fmt.Sprintf("remove %s\n", dnsServiceKey(devName)))
+}
+
+// dnsServiceKey returns the dynamic store key of the DNS configuration set
+// for a device.
+func dnsServiceKey(devName string) string {
+	return "State:/Network/Service/nConnect-" + devName + "/DNS"
+}
+
+// scutil runs scutil with commands as input.
+func scutil(commands string) ([]byte, error) {
+	cmd := exec.Command("scutil")
+	cmd.Stdin = strings.NewReader(commands)
+	return cmd.Output()
+}
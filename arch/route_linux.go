@@ -6,6 +6,13 @@ import (
 )
 
 func AddRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
+	if isOnLinkRoute(dest, gateway) {
+		out, err := exec.Command("ip", "route", "add", dest.String(), "dev", devName).Output()
+		if err == nil {
+			return out, nil
+		}
+		return exec.Command("ip", "route", "change", dest.String(), "dev", devName).Output()
+	}
 	out, err := exec.Command("ip", "route", "add", dest.String(), "via", gateway, "dev", devName).Output()
 	if err == nil {
 		return out, nil
@@ -22,9 +29,48 @@ func AddRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
 }
 
 func DeleteRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
+	if isOnLinkRoute(dest, gateway) {
+		return exec.Command("ip", "route", "del", dest.String(), "dev", devName).Output()
+	}
 	out, err := exec.Command("ip", "route", "del", dest.String(), "via", gateway, "dev", devName).Output()
 	if err == nil {
 		return out, nil
 	}
 	return exec.Command("route", "-n", "del", dest.String(), "gw", gateway).Output()
 }
+
+// NetworkStateCmds returns commands that print interfaces, routes and DNS
+// settings, used for diagnostics.
+func NetworkStateCmds() [][]string {
+	return [][]string{
+		{"ip", "addr"},
+		{"ip", "route"},
+		{"cat", "/etc/resolv.conf"},
+	}
+}
+
+// SetDNSScopeCmd resolves domains with dnsServers through the device using
+// systemd-resolved, and adds searchDomains to the device's search domains,
+// which are resolved through the device too.
+func SetDNSScopeCmd(devName string, dnsServers, domains, searchDomains []string) ([]byte, error) {
+	if len(dnsServers) == 0 || len(domains)+len(searchDomains) == 0 {
+		return nil, nil
+	}
+	out, err := exec.Command("resolvectl", append([]string{"dns", devName}, dnsServers...)...).Output()
+	if err != nil {
+		return out, err
+	}
+	linkDomains := make([]string, 0, len(domains)+len(searchDomains))
+	for _, domain := range domains {
+		linkDomains = append(linkDomains, "~"+domain)
+	}
+	linkDomains = append(linkDomains, searchDomains...)
+	return exec.Command("resolvectl", append([]string{"domain", devName}, linkDomains...)...).Output()
+}
+
+func DeleteDNSScopeCmd(devName string, domains, searchDomains []string) ([]byte, error) {
+	if len(domains)+len(searchDomains) == 0 {
+		return nil, nil
+	}
+	return exec.Command("resolvectl", "revert", devName).Output()
+}
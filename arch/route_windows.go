@@ -1,18 +1,107 @@
 package arch
 
 import (
+	"fmt"
 	"net"
 	"os/exec"
+	"strings"
 )
 
+// runCmd runs a command and returns its output. It's replaced by the mock
+// adapter in routetest builds.
+var runCmd = func(name string, arg ...string) ([]byte, error) {
+	return exec.Command(name, arg...).Output()
+}
+
+// ipFamily returns netsh interface context of dest.
+func ipFamily(dest *net.IPNet) string {
+	if isIPv6(dest) {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
 func AddRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
-	out, err := exec.Command("netsh", "interface", "ipv4", "add", "route", dest.String(), "nexthop="+gateway, "interface="+devName, "metric=0", "store=active").Output()
+	if isOnLinkRoute(dest, gateway) {
+		out, err := runCmd("netsh", "interface", ipFamily(dest), "add", "route", dest.String(), "interface="+devName, "metric=0", "store=active")
+		if err == nil {
+			return out, nil
+		}
+		return runCmd("netsh", "interface", ipFamily(dest), "set", "route", dest.String(), "interface="+devName, "metric=0", "store=active")
+	}
+	out, err := runCmd("netsh", "interface", "ipv4", "add", "route", dest.String(), "nexthop="+gateway, "interface="+devName, "metric=0", "store=active")
 	if err == nil {
 		return out, nil
 	}
-	return exec.Command("netsh", "interface", "ipv4", "set", "route", dest.String(), "nexthop="+gateway, "interface="+devName, "metric=0", "store=active").Output()
+	return runCmd("netsh", "interface", "ipv4", "set", "route", dest.String(), "nexthop="+gateway, "interface="+devName, "metric=0", "store=active")
 }
 
 func DeleteRouteCmd(dest *net.IPNet, gateway, devName string) ([]byte, error) {
-	return exec.Command("netsh", "interface", "ipv4", "delete", "route", dest.String(), "interface="+devName).Output()
+	if isOnLinkRoute(dest, gateway) {
+		return runCmd("netsh", "interface", ipFamily(dest), "delete", "route", dest.String(), "interface="+devName)
+	}
+	return runCmd("netsh", "interface", "ipv4", "delete", "route", dest.String(), "interface="+devName)
+}
+
+// NetworkStateCmds returns commands that print interfaces, routes and DNS
+// settings, used for diagnostics.
+func NetworkStateCmds() [][]string {
+	return [][]string{
+		{"ipconfig", "/all"},
+		{"route", "print"},
+	}
+}
+
+// SetDNSScopeCmd resolves domains with dnsServers using name resolution policy
+// table rules, and adds searchDomains to the global DNS suffix search list.
+// Without domains, dnsServers are already applied to the device when it's
+// opened.
+func SetDNSScopeCmd(devName string, dnsServers, domains, searchDomains []string) ([]byte, error) {
+	if len(dnsServers) == 0 {
+		return nil, nil
+	}
+	var out []byte
+	for _, domain := range domains {
+		b, err := runCmd("powershell", "-Command", "Add-DnsClientNrptRule", "-Namespace", "."+domain, "-NameServers", strings.Join(dnsServers, ","))
+		out = append(out, b...)
+		if err != nil {
+			return out, err
+		}
+	}
+	if len(searchDomains) > 0 {
+		b, err := runCmd("powershell", "-Command", fmt.Sprintf("Set-DnsClientGlobalSetting -SuffixSearchList (@((Get-DnsClientGlobalSetting).SuffixSearchList) + %s | Select-Object -Unique)", psList(searchDomains)))
+		out = append(out, b...)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+func DeleteDNSScopeCmd(devName string, domains, searchDomains []string) ([]byte, error) {
+	var out []byte
+	for _, domain := range domains {
+		b, err := runCmd("powershell", "-Command", fmt.Sprintf("Get-DnsClientNrptRule | Where-Object Namespace -eq '.%s' | Remove-DnsClientNrptRule -Force", domain))
+		out = append(out, b...)
+		if err != nil {
+			return out, err
+		}
+	}
+	if len(searchDomains) > 0 {
+		b, err := runCmd("powershell", "-Command", fmt.Sprintf("Set-DnsClientGlobalSetting -SuffixSearchList @((Get-DnsClientGlobalSetting).SuffixSearchList | Where-Object { %s -notcontains $_ })", psList(searchDomains)))
+		out = append(out, b...)
+		if err != nil {
+			return out, err
+		}
+	}
+	return out, nil
+}
+
+// psList returns a powershell array literal of strings.
+func psList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = "'" + strings.ReplaceAll(item, "'", "''") + "'"
+	}
+	return "@(" + strings.Join(quoted, ",") + ")"
 }
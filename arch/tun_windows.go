@@ -10,6 +10,9 @@ const (
 	tunComponentID = "tap0901"
 )
 
+// openTunDevice is replaced by the mock adapter in routetest builds.
+var openTunDevice = tun.OpenTunDevice
+
 func OpenTunDevice(name, addr, gw, mask string, dnsServers []string, persist bool) (io.ReadWriteCloser, error) {
-	return tun.OpenTunDevice(name, addr, gw, mask, dnsServers, persist)
+	return openTunDevice(name, addr, gw, mask, dnsServers, persist)
 }
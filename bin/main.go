@@ -1,15 +1,118 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"github.com/jessevdk/go-flags"
 	"github.com/nknorg/nconnect"
 	"github.com/nknorg/nconnect/config"
 )
 
+const usage = `[OPTIONS] [client|server] [command] [args...]
+
+Modes (same as -c and -s):
+  client                         Run in client mode
+  server                         Run in server mode
+
+Commands:
+  init                           Create config with setup wizard or --template
+  status [json]                  Show status of remote servers (client)
+  version                        Print version
+  address                        Print client address or server admin address
+  wallet-address                 Print wallet address (server)
+  config-schema                  Print JSON Schema of config file
+  effective-config               Print config in effect and source of each value
+  admin <command> [args...]      Manage config, clients and servers, see below
+
+Admin commands (also accepted without the admin prefix):
+  check-config                   Check config and print problems
+  fix-perms                      Fix permissions of config and secrets files
+  regen-identity                 Generate a new seed and identifier
+  push-config <json>             Push partial config to servers (client) or clients (server)
+  import-clients <file>          Import clients from .json or .csv file
+  export-clients [file]          Export clients to .json or .csv file
+  qr [token] [file.png]          Print pairing QR code, with a one-time token if given (server)
+  pair <nconnect://pair?...>     Add server from pairing QR code to config (client)
+  add-web-user <name> [role]     Add web GUI user with admin or viewer role
+  remove-web-user <name>         Remove web GUI user
+  speedtest [save] [json]        Measure throughput to remote servers (client)
+  path-probe [target] [json]     Probe hops from remote servers to target (client)
+  support-bundle [path]          Write support bundle`
+
+// adminCommands are commands grouped under admin command.
+var adminCommands = map[string]bool{
+	"check-config":    true,
+	"fix-perms":       true,
+	"regen-identity":  true,
+	"push-config":     true,
+	"import-clients":  true,
+	"export-clients":  true,
+	"qr":              true,
+	"pair":            true,
+	"add-web-user":    true,
+	"remove-web-user": true,
+	"speedtest":       true,
+	"path-probe":      true,
+	"support-bundle":  true,
+}
+
+// parseCommand consumes mode and admin prefix from args, and turns commands
+// that replace boolean flags into those flags. It returns the rest of args,
+// starting with the command if any.
+func parseCommand(opts *config.Opts, args []string) []string {
+	for len(args) > 0 {
+		switch args[0] {
+		case "client":
+			opts.Client = true
+		case "server":
+			opts.Server = true
+		case "admin":
+			if len(args) < 2 || !adminCommands[args[1]] {
+				log.Fatal("Usage: admin <command> [args...], run with --help for available commands")
+			}
+		case "version":
+			opts.Version = true
+		case "address":
+			opts.Address = true
+		case "wallet-address":
+			opts.WalletAddress = true
+		case "config-schema":
+			opts.PrintConfigSchema = true
+		case "effective-config":
+			opts.PrintEffectiveConfig = true
+		case "fix-perms":
+			opts.FixPerms = true
+		default:
+			return args
+		}
+		args = args[1:]
+	}
+	return args
+}
+
+// flagFields returns names of struct fields of options set on command line,
+// excluding the ones only set to their defaults.
+func flagFields(cmd *flags.Command) map[string]bool {
+	fields := make(map[string]bool)
+	var addGroup func(g *flags.Group)
+	addGroup = func(g *flags.Group) {
+		for _, opt := range g.Options() {
+			if opt.IsSet() && !opt.IsSetDefault() {
+				fields[opt.Field().Name] = true
+			}
+		}
+		for _, sub := range g.Groups() {
+			addGroup(sub)
+		}
+	}
+	addGroup(cmd.Group)
+	return fields
+}
+
 func main() {
 	defer func() {
 		if r := recover(); r != nil {
@@ -18,32 +121,239 @@ func main() {
 	}()
 
 	var opts = &config.Opts{}
-	_, err := flags.Parse(opts)
+	parser := flags.NewParser(opts, flags.Default)
+	parser.Usage = usage
+	args, err := parser.Parse()
 	if err != nil {
 		if flagsErr, ok := err.(*flags.Error); ok && flagsErr.Type == flags.ErrHelp {
 			os.Exit(0)
 		}
 		log.Fatal(err)
 	}
+	args = parseCommand(opts, args)
 
 	if opts.Version {
 		fmt.Println(config.Version)
 		os.Exit(0)
 	}
 
+	if opts.PrintConfigSchema {
+		b, err := config.Schema()
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(string(b))
+		os.Exit(0)
+	}
+
+	if opts.FixPerms {
+		c, err := config.ReadConfigFile(opts.ConfigFile, opts.ConfigFormat)
+		if err != nil {
+			log.Fatal(err)
+		}
+		err = c.FixFilePerms()
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, p := range c.CheckFilePerms() {
+			fmt.Println(p)
+		}
+		paths := c.FilePaths()
+		if p := c.SecretsPath(); len(p) > 0 {
+			paths = append(paths, p)
+		}
+		fmt.Println("Fixed permissions of", strings.Join(paths, ", "))
+		os.Exit(0)
+	}
+
+	if opts.PrintEffectiveConfig {
+		err = nconnect.PrintEffectiveConfig(opts, flagFields(parser.Command), os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if opts.DryRun {
+		err = nconnect.DryRun(opts, os.Stdout)
+		if err != nil {
+			log.Fatal(err)
+		}
+		os.Exit(0)
+	}
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "support-bundle":
+			path := config.DefaultSupportBundlePath
+			if len(args) > 1 {
+				path = args[1]
+			}
+			err = nconnect.WriteSupportBundle(opts, path)
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "push-config":
+			if len(args) < 2 {
+				log.Fatal("Usage: push-config <partial config json>")
+			}
+			err = nconnect.PushConfig(opts, args[1])
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "import-clients":
+			if len(args) < 2 {
+				log.Fatal("Usage: import-clients <file.json|file.csv>")
+			}
+			err = nconnect.ImportClients(opts, args[1])
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "export-clients":
+			var path string
+			if len(args) > 1 {
+				path = args[1]
+			}
+			err = nconnect.ExportClients(opts, path)
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "qr":
+			var withToken bool
+			var pngPath string
+			for _, arg := range args[1:] {
+				if arg == "token" {
+					withToken = true
+				} else if strings.HasSuffix(strings.ToLower(arg), ".png") && len(pngPath) == 0 {
+					pngPath = arg
+				} else {
+					log.Fatal("Usage: qr [token] [file.png]")
+				}
+			}
+			p, err := nconnect.GetPairingInfo(opts, withToken)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = nconnect.WritePairingQR(os.Stdout, p, pngPath)
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "pair":
+			if len(args) < 2 {
+				log.Fatal("Usage: pair <nconnect://pair?...>")
+			}
+			err = nconnect.ImportPairing(opts, args[1])
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "add-web-user":
+			if len(args) < 2 {
+				log.Fatal("Usage: add-web-user <name> [admin|viewer]")
+			}
+			role := config.WebRoleAdmin
+			if len(args) > 2 {
+				role = args[2]
+			}
+			password, err := nconnect.AddWebUser(opts, args[1], role)
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Web user %s (%s) added with temporary password: %s\n", args[1], role, password)
+		case "remove-web-user":
+			if len(args) < 2 {
+				log.Fatal("Usage: remove-web-user <name>")
+			}
+			err = nconnect.RemoveWebUser(opts, args[1])
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "status", "servers-status":
+			status, err := nconnect.GetServersStatus(opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = nconnect.WriteServersStatus(os.Stdout, status, len(args) > 1 && args[1] == "json")
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "speedtest":
+			var save, asJSON bool
+			for _, arg := range args[1:] {
+				switch arg {
+				case "save":
+					save = true
+				case "json":
+					asJSON = true
+				default:
+					log.Fatal("Usage: speedtest [save] [json]")
+				}
+			}
+			results, err := nconnect.SpeedTest(opts, 0, save)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = nconnect.WriteSpeedTest(os.Stdout, results, asJSON)
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "path-probe":
+			var target string
+			var asJSON bool
+			for _, arg := range args[1:] {
+				if arg == "json" {
+					asJSON = true
+				} else if len(target) == 0 {
+					target = arg
+				} else {
+					log.Fatal("Usage: path-probe [target] [json]")
+				}
+			}
+			results, err := nconnect.PathProbe(opts, target)
+			if err != nil {
+				log.Fatal(err)
+			}
+			err = nconnect.WritePathProbe(os.Stdout, results, asJSON)
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "init":
+			if len(opts.Template) == 0 {
+				err = nconnect.InitWizard(opts, os.Stdin, os.Stdout)
+			} else {
+				err = nconnect.InitConfig(opts, opts.Template, os.Stdout)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "check-config":
+			err = nconnect.CheckConfig(opts, os.Stdout)
+			if err != nil {
+				log.Fatal(err)
+			}
+		case "regen-identity":
+			err = nconnect.RegenIdentity(opts)
+			if err != nil {
+				log.Fatal(err)
+			}
+		default:
+			log.Fatalf("Unknown command %q", args[0])
+		}
+		os.Exit(0)
+	}
+
 	nc, err := nconnect.NewNconnect(opts)
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	if opts.Client {
-		err = nc.StartClient()
+		err = nc.StartClient(context.Background())
 		if err != nil {
 			log.Fatal(err)
 		}
 	}
 	if opts.Server {
-		err = nc.StartServer()
+		err = nc.StartServer(context.Background())
 		if err != nil {
 			log.Fatal(err)
 		}
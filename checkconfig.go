@@ -0,0 +1,179 @@
+package nconnect
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+
+	"github.com/imdario/mergo"
+	"github.com/nknorg/nconnect/config"
+	nkn "github.com/nknorg/nkn-sdk-go"
+)
+
+// CheckConfig validates the config file merged with command line options in
+// the selected mode without starting any tunnel, and writes problems found
+// to w with hints to fix them. It returns an error if there is any problem.
+func CheckConfig(opts *config.Opts, w io.Writer) error {
+	if opts.Client == opts.Server {
+		return errors.New("select exactly one mode to check, -c for client or -s for server")
+	}
+
+	err := (&opts.Config).SetPlatformSpecificDefaultValues()
+	if err != nil {
+		return err
+	}
+
+	fileConf, err := config.ReadConfigFile(opts.ConfigFile, opts.ConfigFormat)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return fmt.Errorf("config file %s does not exist, create one with init --template or use --config-file", opts.ConfigFile)
+		}
+		return fmt.Errorf("load config file %s error: %v", opts.ConfigFile, err)
+	}
+
+	err = mergo.Merge(&opts.Config, fileConf)
+	if err != nil {
+		return err
+	}
+
+	mode := "server"
+	if opts.Client {
+		mode = "client"
+	}
+	fmt.Fprintf(w, "Checking %s config %s\n", mode, opts.ConfigFile)
+
+	var problems []string
+	addProblem := func(format string, a ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, a...))
+	}
+
+	if opts.Client {
+		err = opts.VerifyClient()
+	} else {
+		err = opts.VerifyServer()
+	}
+	if _, ok := err.(config.ConfigProblemsError); err != nil && !ok {
+		addProblem("%v", err)
+	}
+	for _, p := range opts.CrossFieldProblems(opts.Client) {
+		if p.Fatal {
+			addProblem("%s", p.Message)
+		} else {
+			fmt.Fprintln(w, "Warning:", p.Message)
+		}
+	}
+
+	if len(opts.Seed) > 0 {
+		seed, err := hex.DecodeString(opts.Seed)
+		if err == nil {
+			_, err = nkn.NewAccount(seed)
+		}
+		if err != nil {
+			addProblem("seed is invalid: %v, remove it to generate a new one (NKN address will change)", err)
+		}
+	}
+
+	if opts.Client {
+		if len(opts.TunDevices) > 0 {
+			for i := range opts.TunDevices {
+				checkTunDeviceConfig(&opts.TunDevices[i], fmt.Sprintf("tunDevices[%d].", i), addProblem)
+			}
+		} else if opts.Tun || opts.VPN {
+			checkTunDeviceConfig(&config.TunDeviceConfig{
+				Addr:     opts.TunAddr,
+				Gateway:  opts.TunGateway,
+				Mask:     opts.TunMask,
+				DNS:      opts.TunDNS,
+				VPNRoute: opts.VPNRoute,
+			}, "", addProblem)
+		}
+		checkListenAddr("localSocksAddr", "--local-socks-addr", opts.LocalSocksAddr, opts.AutoPort, w, addProblem)
+	} else {
+		checkListenAddr("adminHttpAddr", "--admin-http", opts.AdminHTTPAddr, opts.AutoPort, w, addProblem)
+	}
+
+	for _, p := range fileConf.CheckFilePerms() {
+		addProblem("%s, run with --fix-perms to fix it", p)
+	}
+
+	if opts.SecurityLint != config.SecurityLintOff {
+		for _, issue := range securityLint(opts, fileConf) {
+			if opts.SecurityLint == config.SecurityLintWarn {
+				fmt.Fprintln(w, "Warning: insecure config:", issue)
+			} else {
+				addProblem("insecure config: %s", issue)
+			}
+		}
+	}
+
+	if len(problems) == 0 {
+		fmt.Fprintln(w, "Config OK")
+		return nil
+	}
+	fmt.Fprintf(w, "Found %d problem(s):\n", len(problems))
+	for i, p := range problems {
+		fmt.Fprintf(w, "  %d. %s\n", i+1, p)
+	}
+	return fmt.Errorf("config %s has %d problem(s)", opts.ConfigFile, len(problems))
+}
+
+// warnIgnoredConfig logs config fields ignored because of other fields.
+func warnIgnoredConfig(opts *config.Opts) {
+	for _, p := range opts.CrossFieldProblems(opts.Client) {
+		if !p.Fatal {
+			log.Println("WARNING:", p.Message)
+		}
+	}
+}
+
+// checkTunDeviceConfig checks TUN address, routes and DNS of a device. Field
+// names in problems are prefixed with prefix.
+func checkTunDeviceConfig(d *config.TunDeviceConfig, prefix string, addProblem func(string, ...interface{})) {
+	_, err := config.TunSubnet(d.Addr, d.Mask)
+	if err != nil {
+		addProblem("%stunAddr %q and tunMask %q: %v, use an IPv4 address with netmask like 255.255.255.0, or IPv6 address with prefix length", prefix, d.Addr, d.Mask, err)
+	}
+	if len(d.Gateway) > 0 && net.ParseIP(d.Gateway) == nil {
+		addProblem("%stunGateway %q is not an IP address", prefix, d.Gateway)
+	}
+	for i, route := range d.VPNRoute {
+		_, _, err := net.ParseCIDR(route)
+		if err != nil {
+			addProblem("%svpnRoute[%d] %q is not a valid CIDR, use address/prefix like 10.0.0.0/8 or 1.2.3.4/32", prefix, i, route)
+		}
+	}
+	for i, dns := range d.DNS {
+		if net.ParseIP(dns) != nil {
+			continue
+		}
+		addrs, err := net.LookupHost(dns)
+		if err != nil {
+			addProblem("%stunDNS[%d] %q is not an IP address and can't be resolved: %v", prefix, i, dns, err)
+		} else {
+			addProblem("%stunDNS[%d] %q is not an IP address, use one of %v instead", prefix, i, dns, addrs)
+		}
+	}
+}
+
+// checkListenAddr checks if a TCP listen address is valid and not in use. A
+// port in use is only a warning if autoPort is true, as the next free port
+// will be used.
+func checkListenAddr(name, flag, addr string, autoPort bool, w io.Writer, addProblem func(string, ...interface{})) {
+	if len(addr) == 0 {
+		return
+	}
+	err := tryListen(addr)
+	switch {
+	case err == nil:
+	case !isAddrInUse(err):
+		addProblem("%s %s can't be listened on: %v, choose another address with %s", name, addr, err, flag)
+	case autoPort:
+		fmt.Fprintf(w, "Warning: %s %s is already in use, the next free port will be used\n", name, addr)
+	default:
+		addProblem("%s", portInUseMessage(name, flag, addr))
+	}
+}
@@ -0,0 +1,150 @@
+package nconnect
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/monitor"
+	"github.com/nknorg/nconnect/storage"
+)
+
+const (
+	clientSettingsRevisionKey = "client-settings-revision"
+)
+
+// syncClientSettings periodically saves client settings recommended by the
+// default remote server to config until ctx is done.
+func (nc *nconnect) syncClientSettings(ctx context.Context) {
+	interval := time.Duration(nc.opts.UpdateCheckInterval) * time.Second
+	if interval <= 0 {
+		interval = config.DefaultUpdateCheckInterval
+	}
+	for {
+		err := nc.applyClientSettings()
+		if err != nil {
+			log.Println("Apply client settings error:", err)
+		}
+		if !monitor.Sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+// applyClientSettings saves client settings recommended by the default remote
+// server to config, if they have not been applied. Settings take effect after
+// restart, except monthly data quota of this client which is applied at once.
+func (nc *nconnect) applyClientSettings() error {
+	if len(nc.opts.RemoteAdminAddr) == 0 {
+		return nil
+	}
+	addr := nc.opts.RemoteAdminAddr[0].Addr
+
+	c, err := nc.getAdminClient()
+	if err != nil {
+		return err
+	}
+	settings, err := c.GetClientSettings(addr)
+	if err != nil {
+		return err
+	}
+	if nc.dataCap != nil {
+		nc.dataCap.SetQuota(settings.QuotaMB * 1e6)
+	}
+	if settings.Revision == 0 {
+		return nil
+	}
+
+	store, err := nc.persistConf.GetStorage()
+	if err != nil {
+		return err
+	}
+	revision := strconv.FormatInt(settings.Revision, 10)
+	b, err := store.Get(clientSettingsRevisionKey)
+	if err == nil && string(b) == revision {
+		return nil
+	}
+	if err != nil && err != storage.ErrNotFound {
+		return err
+	}
+
+	if len(settings.Settings) > 0 {
+		err = admin.VerifyClientSettings(settings.Settings)
+		if err != nil {
+			return fmt.Errorf("settings from %s are refused: %v", addr, err)
+		}
+		updated, _, err := nc.opts.WithPartial(settings.Settings)
+		if err != nil {
+			return err
+		}
+		err = updated.VerifyClient()
+		if err != nil {
+			return fmt.Errorf("settings from %s are refused: %v", addr, err)
+		}
+
+		updated, changed, err := nc.persistConf.WithPartial(settings.Settings)
+		if err != nil {
+			return err
+		}
+		if len(changed) > 0 {
+			err = nc.persistConf.SetFields(updated, changed)
+			if err != nil {
+				return err
+			}
+			log.Printf("Saved client settings %v recommended by %s, restart to take effect", changed, addr)
+		}
+	}
+
+	return store.Put(clientSettingsRevisionKey, []byte(revision))
+}
+
+// PushConfig pushes a partial config in JSON, e.g. tuna filters, to remote
+// servers in client mode. The client should be in their admin addresses. In
+// server mode, it sets client settings recommended to clients instead.
+func PushConfig(opts *config.Opts, data string) error {
+	partial := make(map[string]interface{})
+	err := json.Unmarshal([]byte(data), &partial)
+	if err != nil {
+		return fmt.Errorf("parse config error: %v", err)
+	}
+
+	if opts.Server {
+		persistConf, err := loadConfig(opts)
+		if err != nil {
+			return err
+		}
+		err = admin.SetClientSettings(persistConf, partial)
+		if err != nil {
+			return err
+		}
+		log.Println("Client settings are recommended to clients")
+		return nil
+	}
+
+	if len(opts.RemoteAdminAddr) == 0 {
+		return errors.New("remoteAdminAddr is required to push config to remote servers")
+	}
+
+	nc, err := NewNconnect(opts)
+	if err != nil {
+		return err
+	}
+	c, err := nc.getAdminClient()
+	if err != nil {
+		return err
+	}
+	for _, remoteAdminAddr := range opts.RemoteAdminAddrs() {
+		result, err := c.ApplyConfig(remoteAdminAddr, partial)
+		if err != nil {
+			return fmt.Errorf("push config to %s error: %v", remoteAdminAddr, err)
+		}
+		log.Printf("Pushed config to %s, applied: %v, take effect after restart: %v", remoteAdminAddr, result.Applied, result.Deferred)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package nconnect
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const (
+	clockSkewWarnThreshold   = 30 * time.Second
+	clockSkewSevereThreshold = 10 * time.Minute
+	clockCheckSamples        = 3
+	clockCheckTimeout        = 10 * time.Second
+)
+
+// measureClockSkew returns how far local clock is ahead of NKN RPC node
+// timestamps. The sample with the lowest round trip time is used, assuming
+// the node reads its clock half way through the round trip.
+func (nc *nconnect) measureClockSkew() (time.Duration, error) {
+	var skew, minRTT time.Duration
+	var lastErr error
+	for i := 0; i < clockCheckSamples; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), clockCheckTimeout)
+		start := time.Now()
+		state, err := nkn.GetNodeStateContext(ctx, nc.walletConfig)
+		rtt := time.Since(start)
+		cancel()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if minRTT == 0 || rtt < minRTT {
+			minRTT = rtt
+			skew = start.Add(rtt / 2).Sub(time.Unix(state.CurrTimeStamp, 0))
+		}
+	}
+	if minRTT == 0 {
+		return 0, lastErr
+	}
+	return skew, nil
+}
+
+// checkClockSkew measures local clock skew and warns if it exceeds
+// thresholds, since skewed clock causes nanopay and handshake failures that
+// are hard to diagnose otherwise. The result is shown in get info api.
+func (nc *nconnect) checkClockSkew() {
+	skew, err := nc.measureClockSkew()
+	if err != nil {
+		log.Println("Check clock skew error:", err)
+		return
+	}
+
+	abs := skew
+	if abs < 0 {
+		abs = -abs
+	}
+	var warning string
+	switch {
+	case abs >= clockSkewSevereThreshold:
+		warning = "local clock is off by " + skew.Round(time.Second).String() + ", nanopay and handshake will likely fail, sync system clock"
+		log.Printf("WARNING: %s!", warning)
+	case abs >= clockSkewWarnThreshold:
+		warning = "local clock is off by " + skew.Round(time.Second).String() + ", sync system clock to avoid nanopay and handshake failures"
+		log.Printf("WARNING: %s", warning)
+	default:
+		if nc.opts.Verbose {
+			log.Println("Local clock skew:", skew.Round(time.Second))
+		}
+	}
+	admin.SetClockSkew(skew, warning)
+}
@@ -0,0 +1,369 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"time"
+)
+
+// Roles of admin address entries. Owner can call all admin rpc methods,
+// operator can also operate the server (e.g. restart, reload config, toggle
+// subsystems) but not change accept or admin addresses, seed or web users,
+// and viewer can only call methods that read state. Entries without role are
+// owners.
+const (
+	AdminRoleOwner    = "owner"
+	AdminRoleOperator = "operator"
+	AdminRoleViewer   = "viewer"
+)
+
+// adminRoleRanks ranks admin roles from least to most privileged.
+var adminRoleRanks = map[string]int{
+	AdminRoleViewer:   1,
+	AdminRoleOperator: 2,
+	AdminRoleOwner:    3,
+	"":                3,
+}
+
+// AddrEntry is an accept or admin address pattern with optional metadata, so
+// admins can tell what an entry is for and grant temporary access. In config
+// file it is either a pattern string, or an object if it has metadata.
+type AddrEntry struct {
+	Addr       string    `json:"addr"`                 // regular expression matched against client address
+	Label      string    `json:"label,omitempty"`      // free form label, e.g. owner or device
+	SourceHint string    `json:"sourceHint,omitempty"` // CIDR the client is expected to connect from, informational only
+	Created    time.Time `json:"created,omitempty"`
+	Expires    time.Time `json:"expires,omitempty"` // entry no longer matches after this time if not zero
+	Role       string    `json:"role,omitempty"`    // admin role of admin address entries, owner if empty
+}
+
+// addrEntryJSON is AddrEntry in config file, with zero times omitted.
+type addrEntryJSON struct {
+	Addr       string     `json:"addr"`
+	Label      string     `json:"label,omitempty"`
+	SourceHint string     `json:"sourceHint,omitempty"`
+	Created    *time.Time `json:"created,omitempty"`
+	Expires    *time.Time `json:"expires,omitempty"`
+	Role       string     `json:"role,omitempty"`
+}
+
+// HasMetadata returns whether the entry has anything besides its address.
+func (e AddrEntry) HasMetadata() bool {
+	return len(e.Label) > 0 || len(e.SourceHint) > 0 || !e.Created.IsZero() || !e.Expires.IsZero() || len(e.Role) > 0
+}
+
+// Expired returns whether the entry has expired at time now.
+func (e AddrEntry) Expired(now time.Time) bool {
+	return !e.Expires.IsZero() && !now.Before(e.Expires)
+}
+
+func (e AddrEntry) String() string {
+	return e.Addr
+}
+
+func (e *AddrEntry) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '"' {
+		*e = AddrEntry{}
+		return json.Unmarshal(b, &e.Addr)
+	}
+	var j addrEntryJSON
+	err := json.Unmarshal(b, &j)
+	if err != nil {
+		return err
+	}
+	*e = AddrEntry{Addr: j.Addr, Label: j.Label, SourceHint: j.SourceHint, Role: j.Role}
+	if j.Created != nil {
+		e.Created = *j.Created
+	}
+	if j.Expires != nil {
+		e.Expires = *j.Expires
+	}
+	return nil
+}
+
+// MarshalJSON encodes an entry without metadata as its address string, so
+// config files without metadata stay unchanged.
+func (e AddrEntry) MarshalJSON() ([]byte, error) {
+	if !e.HasMetadata() {
+		return json.Marshal(e.Addr)
+	}
+	j := addrEntryJSON{Addr: e.Addr, Label: e.Label, SourceHint: e.SourceHint, Role: e.Role}
+	if !e.Created.IsZero() {
+		j.Created = &e.Created
+	}
+	if !e.Expires.IsZero() {
+		j.Expires = &e.Expires
+	}
+	return json.Marshal(j)
+}
+
+// NewAddrEntries returns entries of address patterns without metadata.
+func NewAddrEntries(addrs []string) []AddrEntry {
+	entries := make([]AddrEntry, len(addrs))
+	for i, addr := range addrs {
+		entries[i] = AddrEntry{Addr: addr}
+	}
+	return entries
+}
+
+// activeAddrs returns address patterns of entries not expired at time now.
+func activeAddrs(entries []AddrEntry, now time.Time) []string {
+	addrs := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if !e.Expired(now) {
+			addrs = append(addrs, e.Addr)
+		}
+	}
+	return addrs
+}
+
+// setAddrs returns entries of addrs in order, keeping metadata of the ones
+// already in entries.
+func setAddrs(entries []AddrEntry, addrs []string) []AddrEntry {
+	existing := make(map[string]AddrEntry, len(entries))
+	for _, e := range entries {
+		existing[e.Addr] = e
+	}
+	res := make([]AddrEntry, 0, len(addrs))
+	seen := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		if seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		e, ok := existing[addr]
+		if !ok {
+			e = AddrEntry{Addr: addr}
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// mergeAddrs returns entries with added ones appended, or replacing the ones
+// with the same address. Creation time of replaced entries is kept unless
+// given.
+func mergeAddrs(entries, added []AddrEntry) []AddrEntry {
+	res := append(make([]AddrEntry, 0, len(entries)+len(added)), entries...)
+	index := make(map[string]int, len(res))
+	for i, e := range res {
+		index[e.Addr] = i
+	}
+	for _, e := range added {
+		i, ok := index[e.Addr]
+		if !ok {
+			index[e.Addr] = len(res)
+			res = append(res, e)
+			continue
+		}
+		if !e.HasMetadata() {
+			continue
+		}
+		if e.Created.IsZero() {
+			e.Created = res[i].Created
+		}
+		res[i] = e
+	}
+	return res
+}
+
+// removeAddrs returns entries without the ones of addrs.
+func removeAddrs(entries []AddrEntry, addrs []string) []AddrEntry {
+	removed := make(map[string]bool, len(addrs))
+	for _, addr := range addrs {
+		removed[addr] = true
+	}
+	res := make([]AddrEntry, 0, len(entries))
+	for _, e := range entries {
+		if !removed[e.Addr] {
+			res = append(res, e)
+		}
+	}
+	return res
+}
+
+// nextAddrExpiry returns the earliest expiry of entries after time now, or
+// zero if none.
+func nextAddrExpiry(entries []AddrEntry, now time.Time) time.Time {
+	var next time.Time
+	for _, e := range entries {
+		if e.Expired(now) || e.Expires.IsZero() {
+			continue
+		}
+		if next.IsZero() || e.Expires.Before(next) {
+			next = e.Expires
+		}
+	}
+	return next
+}
+
+// newAddrEntries returns entries with creation time set to now if not set.
+func newAddrEntries(entries []AddrEntry, now time.Time) []AddrEntry {
+	res := make([]AddrEntry, len(entries))
+	for i, e := range entries {
+		if e.Created.IsZero() {
+			e.Created = now
+		}
+		res[i] = e
+	}
+	return res
+}
+
+func verifyAddrEntries(name string, entries []AddrEntry) error {
+	for i, e := range entries {
+		if len(e.Addr) == 0 {
+			return fmt.Errorf("%s[%d]: addr should not be empty", name, i)
+		}
+		if _, err := regexp.Compile(e.Addr); err != nil {
+			return fmt.Errorf("%s[%d]: invalid addr %q: %v", name, i, e.Addr, err)
+		}
+		if len(e.SourceHint) > 0 {
+			if _, _, err := net.ParseCIDR(e.SourceHint); err != nil {
+				return fmt.Errorf("%s[%d]: invalid sourceHint %q: %v", name, i, e.SourceHint, err)
+			}
+		}
+	}
+	return nil
+}
+
+// verifyAddrRoles checks roles of accept and admin address entries in config
+// file, which are not verified when loaded.
+func verifyAddrRoles(acceptAddrs, adminAddrs []AddrEntry) error {
+	for i, e := range acceptAddrs {
+		if len(e.Role) > 0 {
+			return fmt.Errorf("acceptAddrs[%d]: role is only for admin addresses", i)
+		}
+	}
+	for i, e := range adminAddrs {
+		if _, ok := adminRoleRanks[e.Role]; !ok {
+			return fmt.Errorf("adminAddrs[%d]: role should be %s, %s or %s", i, AdminRoleOwner, AdminRoleOperator, AdminRoleViewer)
+		}
+	}
+	return nil
+}
+
+// GetAcceptAddrEntries returns a copy of accept address entries, including
+// expired ones.
+func (c *Config) GetAcceptAddrEntries() []AddrEntry {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]AddrEntry(nil), c.AcceptAddrs...)
+}
+
+// GetAdminAddrEntries returns a copy of admin address entries, including
+// expired ones.
+func (c *Config) GetAdminAddrEntries() []AddrEntry {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return append([]AddrEntry(nil), c.AdminAddrs...)
+}
+
+// SetAcceptAddrEntries replaces accept address entries. Entries without
+// creation time are created now.
+func (c *Config) SetAcceptAddrEntries(entries []AddrEntry) error {
+	err := verifyAddrEntries("acceptAddrs", entries)
+	if err != nil {
+		return err
+	}
+	err = verifyAddrRoles(entries, nil)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.AcceptAddrs = newAddrEntries(entries, time.Now())
+	return c.save()
+}
+
+// SetAdminAddrEntries replaces admin address entries. Entries without
+// creation time are created now.
+func (c *Config) SetAdminAddrEntries(entries []AddrEntry) error {
+	err := verifyAddrEntries("adminAddrs", entries)
+	if err != nil {
+		return err
+	}
+	err = verifyAddrRoles(nil, entries)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.AdminAddrs = newAddrEntries(entries, time.Now())
+	return c.save()
+}
+
+// AddAcceptAddrEntries adds accept address entries, or replaces the ones
+// with the same address. Entries without creation time are created now.
+func (c *Config) AddAcceptAddrEntries(entries []AddrEntry) error {
+	err := verifyAddrEntries("acceptAddrs", entries)
+	if err != nil {
+		return err
+	}
+	err = verifyAddrRoles(entries, nil)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.AcceptAddrs = mergeAddrs(c.AcceptAddrs, newAddrEntries(entries, time.Now()))
+	return c.save()
+}
+
+// AddAdminAddrEntries adds admin address entries, or replaces the ones with
+// the same address. Entries without creation time are created now.
+func (c *Config) AddAdminAddrEntries(entries []AddrEntry) error {
+	err := verifyAddrEntries("adminAddrs", entries)
+	if err != nil {
+		return err
+	}
+	err = verifyAddrRoles(nil, entries)
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.AdminAddrs = mergeAddrs(c.AdminAddrs, newAddrEntries(entries, time.Now()))
+	return c.save()
+}
+
+// GetAdminRole returns the role of admin address addr, and false if it does not
+// match any entry not expired. If it matches several, the most privileged
+// role is returned.
+func (c *Config) GetAdminRole(addr string) (string, bool) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	now := time.Now()
+	role, found := "", false
+	for _, e := range c.AdminAddrs {
+		if e.Expired(now) {
+			continue
+		}
+		if ok, _ := regexp.MatchString(e.Addr, addr); !ok {
+			continue
+		}
+		if !found || adminRoleRanks[e.Role] > adminRoleRanks[role] {
+			role, found = e.Role, true
+		}
+	}
+	if found && len(role) == 0 {
+		role = AdminRoleOwner
+	}
+	return role, found
+}
+
+// NextAddrExpiry returns the earliest time an accept or admin address entry
+// expires, or zero if none will.
+func (c *Config) NextAddrExpiry() time.Time {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	now := time.Now()
+	next := nextAddrExpiry(c.AcceptAddrs, now)
+	if t := nextAddrExpiry(c.AdminAddrs, now); !t.IsZero() && (next.IsZero() || t.Before(next)) {
+		next = t
+	}
+	return next
+}
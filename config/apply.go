@@ -0,0 +1,139 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// jsonFieldIndex returns the index of the exported field of Config with the
+// given json name, or -1 if there is none.
+func jsonFieldIndex(name string) int {
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := strings.Split(f.Tag.Get("json"), ",")[0]
+		if tag == name && tag != "-" {
+			return i
+		}
+	}
+	return -1
+}
+
+// WithPartial returns a copy of c with fields in partial, a map of json field
+// name to value, replaced, and json names of fields whose value changed in
+// ascending order. Unknown field names are rejected.
+func (c *Config) WithPartial(partial map[string]interface{}) (*Config, []string, error) {
+	for name := range partial {
+		if jsonFieldIndex(name) < 0 {
+			return nil, nil, fmt.Errorf("unknown config field %s", name)
+		}
+	}
+
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	updated := NewConfig()
+	err = json.Unmarshal(b, updated)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err = json.Marshal(partial)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = json.Unmarshal(b, updated)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	changed := make([]string, 0, len(partial))
+	v, uv := reflect.ValueOf(c).Elem(), reflect.ValueOf(updated).Elem()
+	for name := range partial {
+		i := jsonFieldIndex(name)
+		if !reflect.DeepEqual(v.Field(i).Interface(), uv.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+
+	return updated, changed, nil
+}
+
+// SetFields copies fields with the given json names from src to c, and saves
+// c if it is persisted.
+func (c *Config) SetFields(src *Config, names []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	err := c.copyFields(src, names)
+	if err != nil {
+		return err
+	}
+	return c.save()
+}
+
+// CopyFields copies fields with the given json names from src to c without
+// saving, e.g. when src is loaded from config file.
+func (c *Config) CopyFields(src *Config, names []string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.copyFields(src, names)
+}
+
+func (c *Config) copyFields(src *Config, names []string) error {
+	v, sv := reflect.ValueOf(c).Elem(), reflect.ValueOf(src).Elem()
+	for _, name := range names {
+		i := jsonFieldIndex(name)
+		if i < 0 {
+			return fmt.Errorf("unknown config field %s", name)
+		}
+		v.Field(i).Set(sv.Field(i))
+	}
+	return nil
+}
+
+// Diff returns json names of exported fields whose value differs between c
+// and other in ascending order.
+func (c *Config) Diff(other *Config) []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	var changed []string
+	v, ov := reflect.ValueOf(c).Elem(), reflect.ValueOf(other).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if len(name) == 0 || name == "-" {
+			continue
+		}
+		if !reflect.DeepEqual(v.Field(i).Interface(), ov.Field(i).Interface()) {
+			changed = append(changed, name)
+		}
+	}
+	sort.Strings(changed)
+	return changed
+}
+
+// FieldEqual returns whether the field with the given json name has the same
+// value in c and other.
+func (c *Config) FieldEqual(other *Config, name string) bool {
+	i := jsonFieldIndex(name)
+	if i < 0 {
+		return false
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return reflect.DeepEqual(reflect.ValueOf(c).Elem().Field(i).Interface(), reflect.ValueOf(other).Elem().Field(i).Interface())
+}
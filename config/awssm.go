@@ -0,0 +1,178 @@
+package config
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	awsSecretsManagerService = "secretsmanager"
+	awsSigningAlgorithm      = "AWS4-HMAC-SHA256"
+)
+
+var (
+	errAWSNotConfigured = errors.New("AWS_REGION (or AWS_DEFAULT_REGION), AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY should be set")
+)
+
+// awsSecretsManagerProvider reads secrets from AWS Secrets Manager with region
+// and credentials from the standard AWS_REGION, AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN env. Path is the secret name or
+// ARN. A secret string that is a JSON object is looked up by field, otherwise
+// it is the seed itself.
+type awsSecretsManagerProvider struct{}
+
+func (awsSecretsManagerProvider) GetSecret(path, field string) (string, error) {
+	region := os.Getenv("AWS_REGION")
+	if len(region) == 0 {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	accessKey, secretKey := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if len(region) == 0 || len(accessKey) == 0 || len(secretKey) == 0 {
+		return "", errAWSNotConfigured
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER")
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://%s.%s.amazonaws.com", awsSecretsManagerService, region)
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": path})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signAWSRequest(req, body, region, accessKey, secretKey, os.Getenv("AWS_SESSION_TOKEN"), time.Now())
+
+	resp, err := secretProviderClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("AWS Secrets Manager returned %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+
+	var res struct {
+		SecretString string `json:"SecretString"`
+	}
+	err = json.Unmarshal(b, &res)
+	if err != nil {
+		return "", err
+	}
+	return secretField(res.SecretString, field)
+}
+
+// signAWSRequest signs req to Secrets Manager with AWS Signature Version 4.
+func signAWSRequest(req *http.Request, body []byte, region, accessKey, secretKey, sessionToken string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if len(sessionToken) > 0 {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	// headers are in sorted order
+	headers := [][2]string{
+		{"content-type", req.Header.Get("Content-Type")},
+		{"host", req.URL.Host},
+		{"x-amz-date", amzDate},
+	}
+	if len(sessionToken) > 0 {
+		headers = append(headers, [2]string{"x-amz-security-token", sessionToken})
+	}
+	headers = append(headers, [2]string{"x-amz-target", req.Header.Get("X-Amz-Target")})
+
+	path := req.URL.EscapedPath()
+	if len(path) == 0 {
+		path = "/"
+	}
+	canonicalRequest, signedHeaders := awsCanonicalRequest(req.Method, path, req.URL.RawQuery, headers, body)
+	scope := awsScope(date, region, awsSecretsManagerService)
+	stringToSign := awsStringToSign(amzDate, scope, canonicalRequest)
+	signature := awsSignature(secretKey, date, region, awsSecretsManagerService, stringToSign)
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigningAlgorithm, accessKey, scope, signedHeaders, signature))
+}
+
+// awsCanonicalRequest returns the canonical request and signed headers.
+// Header names must be lower case and in sorted order, and query must be
+// already sorted and escaped.
+func awsCanonicalRequest(method, path, query string, headers [][2]string, body []byte) (string, string) {
+	var canonicalHeaders strings.Builder
+	names := make([]string, len(headers))
+	for i, h := range headers {
+		canonicalHeaders.WriteString(h[0] + ":" + strings.TrimSpace(h[1]) + "\n")
+		names[i] = h[0]
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		method,
+		path,
+		query,
+		canonicalHeaders.String(),
+		signedHeaders,
+		sha256Hex(body),
+	}, "\n")
+	return canonicalRequest, signedHeaders
+}
+
+func awsScope(date, region, service string) string {
+	return date + "/" + region + "/" + service + "/aws4_request"
+}
+
+func awsStringToSign(amzDate, scope, canonicalRequest string) string {
+	return strings.Join([]string{
+		awsSigningAlgorithm,
+		amzDate,
+		scope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+}
+
+func awsSignature(secretKey, date, region, service, stringToSign string) string {
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func sha256Hex(b []byte) string {
+	h := sha256.Sum256(b)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, s string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(s))
+	return h.Sum(nil)
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Test vectors from the AWS Signature Version 4 test suite and the IAM
+// ListUsers example of the AWS General Reference.
+func TestAWSSignatureV4(t *testing.T) {
+	const (
+		secretKey = "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"
+		amzDate   = "20150830T123600Z"
+		date      = "20150830"
+		region    = "us-east-1"
+	)
+
+	tests := []struct {
+		name             string
+		service          string
+		method           string
+		query            string
+		headers          [][2]string
+		body             string
+		canonicalRequest string
+		signedHeaders    string
+		stringToSign     string
+		signature        string
+	}{
+		{
+			name:    "get-vanilla",
+			service: "service",
+			method:  "GET",
+			headers: [][2]string{
+				{"host", "example.amazonaws.com"},
+				{"x-amz-date", amzDate},
+			},
+			canonicalRequest: "GET\n/\n\n" +
+				"host:example.amazonaws.com\n" +
+				"x-amz-date:20150830T123600Z\n\n" +
+				"host;x-amz-date\n" +
+				"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			signedHeaders: "host;x-amz-date",
+			stringToSign: "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/service/aws4_request\n" +
+				"bb579772317eb040ac9ed261061d46c1f17a8133879d6129b6e1c25292927e63",
+			signature: "5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:    "post-vanilla",
+			service: "service",
+			method:  "POST",
+			headers: [][2]string{
+				{"host", "example.amazonaws.com"},
+				{"x-amz-date", amzDate},
+			},
+			canonicalRequest: "POST\n/\n\n" +
+				"host:example.amazonaws.com\n" +
+				"x-amz-date:20150830T123600Z\n\n" +
+				"host;x-amz-date\n" +
+				"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			signedHeaders: "host;x-amz-date",
+			stringToSign: "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/service/aws4_request\n" +
+				"553f88c9e4d10fc9e109e2aeb65f030801b70c2f6468faca261d401ae622fc87",
+			signature: "5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:    "post-x-www-form-urlencoded",
+			service: "service",
+			method:  "POST",
+			headers: [][2]string{
+				{"content-type", "application/x-www-form-urlencoded"},
+				{"host", "example.amazonaws.com"},
+				{"x-amz-date", amzDate},
+			},
+			body: "Param1=value1",
+			canonicalRequest: "POST\n/\n\n" +
+				"content-type:application/x-www-form-urlencoded\n" +
+				"host:example.amazonaws.com\n" +
+				"x-amz-date:20150830T123600Z\n\n" +
+				"content-type;host;x-amz-date\n" +
+				"9095672bbd1f56dfc5b65f3e153adc8731a4a654192329106275f4c7b24d0b6e",
+			signedHeaders: "content-type;host;x-amz-date",
+			stringToSign: "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/service/aws4_request\n" +
+				"42a5e5bb34198acb3e84da4f085bb7927f2bc277ca766e6d19c73c2154021281",
+			signature: "ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			name:    "iam-list-users",
+			service: "iam",
+			method:  "GET",
+			query:   "Action=ListUsers&Version=2010-05-08",
+			headers: [][2]string{
+				{"content-type", "application/x-www-form-urlencoded; charset=utf-8"},
+				{"host", "iam.amazonaws.com"},
+				{"x-amz-date", amzDate},
+			},
+			canonicalRequest: "GET\n/\nAction=ListUsers&Version=2010-05-08\n" +
+				"content-type:application/x-www-form-urlencoded; charset=utf-8\n" +
+				"host:iam.amazonaws.com\n" +
+				"x-amz-date:20150830T123600Z\n\n" +
+				"content-type;host;x-amz-date\n" +
+				"e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			signedHeaders: "content-type;host;x-amz-date",
+			stringToSign: "AWS4-HMAC-SHA256\n20150830T123600Z\n20150830/us-east-1/iam/aws4_request\n" +
+				"f536975d06c0309214f805bb90ccff089219ecd68b2577efef23edd43b7e1a59",
+			signature: "5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			canonicalRequest, signedHeaders := awsCanonicalRequest(tt.method, "/", tt.query, tt.headers, []byte(tt.body))
+			require.Equal(t, tt.canonicalRequest, canonicalRequest)
+			require.Equal(t, tt.signedHeaders, signedHeaders)
+
+			stringToSign := awsStringToSign(amzDate, awsScope(date, region, tt.service), canonicalRequest)
+			require.Equal(t, tt.stringToSign, stringToSign)
+
+			require.Equal(t, tt.signature, awsSignature(secretKey, date, region, tt.service, stringToSign))
+		})
+	}
+}
+
+func TestSignAWSRequest(t *testing.T) {
+	body := []byte(`{"SecretId":"nconnect"}`)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+
+	for _, sessionToken := range []string{"", "token"} {
+		req, err := http.NewRequest(http.MethodPost, "https://secretsmanager.us-east-1.amazonaws.com/", nil)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+		req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+		signAWSRequest(req, body, "us-east-1", "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", sessionToken, now)
+
+		require.Equal(t, "20150830T123600Z", req.Header.Get("X-Amz-Date"))
+		require.Equal(t, sessionToken, req.Header.Get("X-Amz-Security-Token"))
+
+		headers := [][2]string{
+			{"content-type", "application/x-amz-json-1.1"},
+			{"host", "secretsmanager.us-east-1.amazonaws.com"},
+			{"x-amz-date", "20150830T123600Z"},
+		}
+		if len(sessionToken) > 0 {
+			headers = append(headers, [2]string{"x-amz-security-token", sessionToken})
+		}
+		headers = append(headers, [2]string{"x-amz-target", "secretsmanager.GetSecretValue"})
+		canonicalRequest, signedHeaders := awsCanonicalRequest(http.MethodPost, "/", "", headers, body)
+		scope := awsScope("20150830", "us-east-1", awsSecretsManagerService)
+		signature := awsSignature("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20150830", "us-east-1", awsSecretsManagerService,
+			awsStringToSign("20150830T123600Z", scope, canonicalRequest))
+
+		auth := req.Header.Get("Authorization")
+		require.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/secretsmanager/aws4_request, "))
+		require.Contains(t, auth, "SignedHeaders="+signedHeaders+", ")
+		require.True(t, strings.HasSuffix(auth, "Signature="+signature))
+	}
+}
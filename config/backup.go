@@ -0,0 +1,217 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nknorg/nconnect/storage"
+)
+
+const (
+	backupTimeFormat = "20060102-150405.000"
+	backupExt        = ".bak"
+)
+
+var (
+	errNoConfigPath   = errors.New("config is not saved to file")
+	errBackupNotFound = errors.New("config backup not found")
+)
+
+// SetStorage sets the storage config backups are kept in. Backups are kept as
+// files next to config file if not set.
+func (c *Config) SetStorage(s storage.Storage) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.store = s
+}
+
+// GetStorage returns the runtime state storage, which is files next to config
+// file if not set.
+func (c *Config) GetStorage() (storage.Storage, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.backupStorage()
+}
+
+func (c *Config) backupStorage() (storage.Storage, error) {
+	if c.store == nil {
+		s, err := storage.NewFileStorage(filepath.Dir(c.path))
+		if err != nil {
+			return nil, err
+		}
+		c.store = s
+	}
+	return c.store, nil
+}
+
+// SetMaxBackups sets how many config backups are kept. A backup of the config
+// file is made before each change when it is greater than 0.
+func (c *Config) SetMaxBackups(n int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.maxBackups = n
+}
+
+func (c *Config) backupPrefix() string {
+	return filepath.Base(c.path) + "."
+}
+
+// backupFile copies the config file to a timestamped backup if its content
+// differs from b, then removes the oldest backups beyond the limit.
+func (c *Config) backupFile(b []byte) error {
+	old, err := os.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	if bytes.Equal(old, b) {
+		return nil
+	}
+	_, err = c.writeBackup(old)
+	return err
+}
+
+func (c *Config) writeBackup(b []byte) (string, error) {
+	s, err := c.backupStorage()
+	if err != nil {
+		return "", err
+	}
+
+	name := c.backupPrefix() + time.Now().Format(backupTimeFormat) + backupExt
+	err = s.Put(name, b)
+	if err != nil {
+		return "", err
+	}
+
+	if c.maxBackups > 0 {
+		names, err := c.listBackups()
+		if err != nil {
+			return "", err
+		}
+		for i := c.maxBackups; i < len(names); i++ {
+			err = s.Delete(names[i])
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	return name, nil
+}
+
+// listBackups returns backup names, newest first.
+func (c *Config) listBackups() ([]string, error) {
+	s, err := c.backupStorage()
+	if err != nil {
+		return nil, err
+	}
+	keys, err := s.List(c.backupPrefix())
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if strings.HasSuffix(k, backupExt) {
+			names = append(names, k)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	return names, nil
+}
+
+// Backup saves the current config as a backup and returns its name.
+func (c *Config) Backup() (string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.path) == 0 {
+		return "", errNoConfigPath
+	}
+	b, err := c.marshal()
+	if err != nil {
+		return "", err
+	}
+	return c.writeBackup(b)
+}
+
+// ListBackups returns available backup names, newest first.
+func (c *Config) ListBackups() ([]string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	if len(c.path) == 0 {
+		return nil, errNoConfigPath
+	}
+	return c.listBackups()
+}
+
+// Restore replaces config with the backup of the given name, or the newest
+// backup if name is empty, and saves it. The replaced config is backed up as
+// usual, so a restore can be undone.
+func (c *Config) Restore(name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.path) == 0 {
+		return errNoConfigPath
+	}
+
+	names, err := c.listBackups()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, n := range names {
+		if n == name || len(name) == 0 {
+			name = n
+			found = true
+			break
+		}
+	}
+	if !found {
+		return errBackupNotFound
+	}
+
+	s, err := c.backupStorage()
+	if err != nil {
+		return err
+	}
+	b, err := s.Get(name)
+	if err != nil {
+		return err
+	}
+	restored := NewConfig()
+	err = unmarshalConfig(b, c.format, restored)
+	if err != nil {
+		return err
+	}
+	// Backups have no seed or password kept in secrets file, keychain, seed
+	// file or secret provider, so they are loaded from there again instead of
+	// being cleared.
+	err = restored.loadSecrets(c.path)
+	if err != nil {
+		return err
+	}
+	err = restored.loadKeychainSeed()
+	if err != nil {
+		return err
+	}
+	if c.seedExternal != nil {
+		restored.Seed = c.Seed
+	}
+
+	dst := reflect.ValueOf(c).Elem()
+	src := reflect.ValueOf(restored).Elem()
+	for i := 0; i < dst.NumField(); i++ {
+		if dst.Type().Field(i).IsExported() {
+			dst.Field(i).Set(src.Field(i))
+		}
+	}
+
+	return c.save()
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRestoreWithSecretsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	seed := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	writeTestFile(t, path, `{
+ "identifier": "before",
+ "seed": "`+seed+`",
+ "password": "socks-password",
+ "secretsFile": "secrets.json",
+ "acceptAddrs": [],
+ "adminAddrs": []
+}`)
+
+	c, err := LoadOrNewConfig(path)
+	require.NoError(t, err)
+	require.Equal(t, seed, c.Seed)
+	require.Empty(t, readTestFile(t, path)["seed"], "seed moved to secrets file")
+
+	name, err := c.Backup()
+	require.NoError(t, err)
+
+	c.Identifier = "after"
+	require.NoError(t, c.Save())
+
+	require.NoError(t, c.Restore(name))
+	require.Equal(t, "before", c.Identifier)
+	require.Equal(t, seed, c.Seed)
+	require.Equal(t, "socks-password", c.Password)
+
+	b, err := os.ReadFile(filepath.Join(dir, "secrets.json"))
+	require.NoError(t, err)
+	s := &secretsJSON{}
+	require.NoError(t, json.Unmarshal(b, s))
+	require.Equal(t, seed, s.Seed)
+	require.Equal(t, "socks-password", s.Password)
+}
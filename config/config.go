@@ -3,16 +3,19 @@ package config
 import (
 	"crypto/ed25519"
 	"encoding/hex"
-	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
 	"math/rand"
+	"net"
 	"os"
+	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/nknorg/nconnect/util"
+	"github.com/nknorg/nconnect/storage"
 	"github.com/nknorg/nkn/v2/common"
 )
 
@@ -23,6 +26,51 @@ const (
 	DefaultTunNameNonLinux = "nConnect-tap0"
 	FallbackTunaMaxPrice   = "0.01"
 	DefaultUDPTimeout      = time.Hour * 720
+
+	DefaultResourceCheckInterval = 10 * time.Second
+	DefaultStatusInterval        = 10 * time.Second
+	DataCapCheckInterval         = 10 * time.Second
+	DefaultUpdateCheckInterval   = time.Hour
+	DefaultStatsTopicInterval    = 5 * time.Minute
+	MinStatsTopicInterval        = time.Minute
+	DefaultSupportBundlePath     = "nconnect-support-bundle.tar.gz"
+
+	IdentifierConflictWarn   = "warn"
+	IdentifierConflictSuffix = "suffix"
+	IdentifierConflictIgnore = "ignore"
+
+	VPNIPv6Tunnel = "tunnel"
+	VPNIPv6Block  = "block"
+	VPNIPv6Bypass = "bypass"
+
+	VPNProbeNone          = "none"
+	DefaultVPNProbeAddr   = "1.1.1.1:53"
+	DefaultVPNGracePeriod = time.Minute
+
+	SecurityLintRefuse = "refuse"
+	SecurityLintWarn   = "warn"
+	SecurityLintOff    = "off"
+
+	CipherAuto    = "auto"
+	CipherDummy   = "dummy"
+	DefaultCipher = "chacha20-ietf-poly1305" // used by auto if not on first run or remote cipher is unknown
+
+	DummyCipherNote = "Socks proxy traffic is not encrypted between local programs and nConnect as it only goes through loopback, but it is still end to end encrypted by NKN tunnel."
+
+	DefaultAdminNumSubClients = 4
+	DefaultAdminMaxReplySize  = 2 << 20
+	DefaultAdminMsgRate       = 5
+	DefaultAdminMsgBurst      = 20
+	DefaultAdminMsgBanTime    = 5 * time.Minute
+
+	maxNumSubClients     = 32
+	maxNumTunaListeners  = 32
+	minAdminMaxReplySize = 64 << 10
+	maxAdminMaxReplySize = 3 << 20 // NKN messages are limited to 4MB
+	maxMsgChanLen        = 1 << 16
+	maxRPCConcurrency    = 16
+	minSessionMTU        = 64
+	maxSessionMTU        = 64 << 10
 )
 
 var (
@@ -38,44 +86,122 @@ type Opts struct {
 	Server bool `short:"s" long:"server" description:"Server mode"`
 
 	Config
-	ConfigFile string `short:"f" long:"config-file" default:"config.json" description:"Config file path"`
+	ConfigFile   string `short:"f" long:"config-file" default:"config.json" description:"Config file path"`
+	ConfigFormat string `long:"config-format" description:"Config file format, detected from config file extension (.json, .yaml, .yml or .toml) if not set" choice:"json" choice:"yaml" choice:"toml"`
 
 	Address       bool `long:"address" description:"Print client address (client mode) or admin address (server mode)"`
 	WalletAddress bool `long:"wallet-address" description:"Print wallet address (server only)"`
 	Version       bool `long:"version" description:"Print version"`
+
+	PrintConfigSchema    bool `long:"print-config-schema" description:"Print JSON Schema of config file, for GUIs and editors to validate and autocomplete config"`
+	FixPerms             bool `long:"fix-perms" description:"Make config files only writable and secrets only accessible by their owner, then exit"`
+	DryRun               bool `long:"dry-run" description:"Print NKN identifiers, listeners, TUN devices, routes and tuna services that would be used, then exit without touching network or routing table"`
+	PrintEffectiveConfig bool `long:"print-effective-config" description:"Print config in effect after merging config file, flags and defaults as JSON with the source of each value, then exit. Secrets are redacted."`
+
+	Template string `long:"template" description:"Config template used by init command" choice:"home-server" choice:"travel-client" choice:"router-gateway" choice:"headless-iot"`
 }
 
 type Config struct {
-	path string
+	path       string
+	format     string
+	maxBackups int
+	store      storage.Storage
+	files      []*configFile // main config file and included files, nil if there is no include list
+
+	migratedFrom int   // config version migrated from when loaded, 0 if not migrated
+	seedExternal error // why seed is not saved if it is read from seed file or secret provider
+
+	// Other config files deep merged into this one in order, e.g. accept
+	// addresses managed by other tooling. Only available in config file.
+	Include []string `json:"include,omitempty"`
+
+	// Version of config fields, used to upgrade renamed fields when config is
+	// loaded. Only available in config file.
+	ConfigVersion int `json:"configVersion,omitempty"`
 
 	// Account config
-	Identifier string `json:"identifier" long:"identifier" description:"NKN client identifier. A random one will be generated and saved to config.json if not provided."`
-	Seed       string `json:"seed" long:"seed" description:"NKN client secret seed. A random one will be generated and saved to config.json if not provided."`
+	Identifier   string `json:"identifier" long:"identifier" description:"NKN client identifier. A random one will be generated and saved to config.json if not provided."`
+	Seed         string `json:"seed" long:"seed" description:"NKN client secret seed. A random one will be generated and saved to config.json if not provided."`
+	SeedFile     string `json:"seedFile,omitempty" long:"seed-file" description:"Read seed from the first line of this file, or of stdin if -, e.g. injected by an orchestration system. Seed is then never saved to config file."`
+	SeedProvider string `json:"seedProvider,omitempty" long:"seed-provider" description:"Fetch seed at start from a secrets manager instead of disk: vault://<path>[#field] for HashiCorp Vault (VAULT_ADDR and VAULT_TOKEN env), or awssm://<secret id>[#field] for AWS Secrets Manager (AWS_REGION and AWS credentials env). Field is seed if not given. Seed is then never saved to config file."`
+	// Hash of machine ID the random identifier was generated on, to detect
+	// config copied to another machine. Only available in config file.
+	MachineBinding string `json:"machineBinding,omitempty"`
+
+	SeedStorage      string `json:"seedStorage,omitempty" long:"seed-storage" description:"Where seed is kept: config file, or OS keychain (macOS Keychain, Windows Credential Manager or Linux secret service via secret-tool). A seed in config file is moved to keychain automatically." choice:"config" choice:"keychain"`
+	SeedKeychainName string `json:"seedKeychainName,omitempty"` // name of seed item in OS keychain, generated on first save
+
+	SecretsFile string `json:"secretsFile,omitempty" long:"secrets-file" description:"Keep seed and socks password in this file (e.g. secrets.json, relative to config file directory) that only its owner can access, so config file can be readable by GUIs and other tools. Secrets in config file are moved to it automatically."`
+
+	IdentifierConflict string `json:"identifierConflict,omitempty" long:"identifier-conflict" description:"Action when identifier is already used by another running node with the same seed (e.g. cloned disk image): warn, suffix (append a random suffix and save to config) or ignore (skip detection)" choice:"warn" choice:"suffix" choice:"ignore" default:"warn"`
+
+	SecurityLint string `json:"securityLint,omitempty" long:"security-lint" description:"Action when config has dangerous combinations at startup, such as admin web GUI on a public address without TLS: refuse (do not start), warn (log loudly and start) or off" choice:"refuse" choice:"warn" choice:"off" default:"refuse"`
 
 	// NKN Client config
 	SeedRPCServerAddr []string `json:"seedRPCServerAddr,omitempty" long:"rpc" description:"Seed RPC server address"`
 	ConnectRetries    int32    `json:"connectRetries,omitempty" long:"connect-retries" description:"client connect retries, a negative value means unlimited retries."`
+	DisableRoaming    bool     `json:"disableRoaming,omitempty" long:"disable-roaming" description:"(client only) Do not reconnect when local IPs change (e.g. when switching between Wi-Fi and LTE) or system resumes from suspend"`
+
+	// Advanced NKN client config. 0 is for default for all of them.
+	AdminNumSubClients int     `json:"adminNumSubClients,omitempty" long:"admin-num-sub-clients" description:"(advanced) Number of NKN sub-clients of admin and publish clients. Default is 4."`
+	MsgChanLen         int32   `json:"msgChanLen,omitempty" long:"msg-chan-len" description:"(advanced) Number of received NKN messages buffered before being handled"`
+	RPCConcurrency     int32   `json:"rpcConcurrency,omitempty" long:"rpc-concurrency" description:"(advanced) Number of seed RPC nodes each NKN RPC request is sent to concurrently"`
+	AdminMaxReplySize  int     `json:"adminMaxReplySize,omitempty" long:"admin-max-reply-size" description:"(advanced, server only) Maximum size in bytes of admin rpc reply sent in a single NKN message. Larger replies are split into chunks. Default is 2097152."`
+	AdminMsgRate       float64 `json:"adminMsgRate,omitempty" long:"admin-msg-rate" description:"(advanced, server only) Admin NKN messages per second allowed from each source address. Messages beyond are dropped before being parsed. Default is 5, negative is for no limit."`
+	AdminMsgBurst      int     `json:"adminMsgBurst,omitempty" long:"admin-msg-burst" description:"(advanced, server only) Admin NKN messages allowed at once from each source address above adminMsgRate. Default is 20."`
+	AdminMsgBanTime    int32   `json:"adminMsgBanTime,omitempty" long:"admin-msg-ban-time" description:"(advanced, server only) Time (in seconds) all admin NKN messages from a source address are dropped after it exceeded adminMsgRate by adminMsgBurst messages in a row. Default is 300, negative is for no ban."`
 
 	// Cipher config
-	Cipher   string `json:"cipher,omitempty" long:"cipher" description:"Socks proxy cipher. Dummy (no cipher) will not reduce security because NKN tunnel already has end to end encryption." choice:"dummy" choice:"chacha20-ietf-poly1305" choice:"aes-128-gcm" choice:"aes-256-gcm" default:"chacha20-ietf-poly1305"`
+	Cipher   string `json:"cipher,omitempty" long:"cipher" description:"Socks proxy cipher. Dummy (no cipher) will not reduce security because NKN tunnel already has end to end encryption. Auto benchmarks ciphers on first run and records the fastest one in config (server), or uses the cipher of remote server (client)." choice:"auto" choice:"dummy" choice:"chacha20-ietf-poly1305" choice:"aes-128-gcm" choice:"aes-256-gcm" default:"auto"`
 	Password string `json:"password,omitempty" long:"password" description:"Socks proxy password"`
 
+	LoopbackDummyCipher         bool `json:"loopbackDummyCipher,omitempty" long:"loopback-dummy-cipher" description:"Use dummy cipher in auto mode if local socks proxy only listens on loopback address. Should be enabled on both client and server."`
+	AllowDummyCipherNonLoopback bool `json:"allowDummyCipherNonLoopback,omitempty" long:"allow-dummy-cipher-non-loopback" description:"(client only) Allow dummy cipher when local socks proxy listens on non-loopback address"`
+	StrictAudit                 bool `json:"strictAudit,omitempty" long:"strict-audit" description:"Log and count replayed, reordered or modified socks proxy data as anomalies, which indicate a misbehaving tuna relay. Stream and packet sequence are authenticated by AEAD cipher, so dummy cipher is refused."`
+
 	// Session config
 	DialTimeout       int32 `json:"dialTimeout,omitempty" long:"dial-timeout" description:"dial timeout in milliseconds"`
 	SessionWindowSize int32 `json:"sessionWindowSize,omitempty" long:"session-window-size" description:"tuna session window size (byte)."`
 
+	// Advanced session config. 0 is for library default for all of them.
+	NumSubClients                       int   `json:"numSubClients,omitempty" long:"num-sub-clients" description:"(advanced) Number of NKN sub-clients, i.e. concurrent connections, of each tunnel"`
+	NumTunaListeners                    int   `json:"numTunaListeners,omitempty" long:"num-tuna-listeners" description:"(advanced, server only) Number of tuna service nodes to listen on"`
+	SessionMTU                          int32 `json:"sessionMTU,omitempty" long:"session-mtu" description:"(advanced) Session packet MTU (byte)"`
+	SessionMinConnectionWindowSize      int32 `json:"sessionMinConnectionWindowSize,omitempty" long:"session-min-connection-window-size" description:"(advanced) Session min connection congestion window size (packet)"`
+	SessionFlushInterval                int32 `json:"sessionFlushInterval,omitempty" long:"session-flush-interval" description:"(advanced) Session flush interval (ms) of partially filled packets"`
+	SessionLinger                       int32 `json:"sessionLinger,omitempty" long:"session-linger" description:"(advanced) Time (ms) to wait for unsent data when closing a session"`
+	SessionInitialRetransmissionTimeout int32 `json:"sessionInitialRetransmissionTimeout,omitempty" long:"session-initial-rto" description:"(advanced) Session initial retransmission timeout (ms)"`
+	SessionMaxRetransmissionTimeout     int32 `json:"sessionMaxRetransmissionTimeout,omitempty" long:"session-max-rto" description:"(advanced) Session max retransmission timeout (ms)"`
+	SessionSendAckInterval              int32 `json:"sessionSendAckInterval,omitempty" long:"session-send-ack-interval" description:"(advanced) Session ack sending interval (ms)"`
+
 	// Log config
 	LogFileName        string `json:"log,omitempty" long:"log" description:"Log file path. Will write log to stdout if not provided."`
 	LogMaxSize         int    `json:"logMaxSize,omitempty" long:"log-max-size" description:"Maximum size in megabytes of the log file before it gets rotated." default:"1"`
 	LogMaxBackups      int    `json:"logMaxBackups,omitempty" long:"log-max-backups" description:"Maximum number of old log files to retain." default:"3"`
+	AuditLogFileName   string `json:"auditLog,omitempty" long:"audit-log" description:"(server only) Audit log file path. Every admin RPC is appended to it as a JSON line if provided."`
+	AuditLogMaxSize    int    `json:"auditLogMaxSize,omitempty" long:"audit-log-max-size" description:"(server only) Maximum size in megabytes of the audit log file before it gets rotated." default:"10"`
+	AuditLogMaxBackups int    `json:"auditLogMaxBackups,omitempty" long:"audit-log-max-backups" description:"(server only) Maximum number of old audit log files to retain." default:"10"`
 	LogAPIResponseSize int    `json:"logAPIResponseSize,omitempty" long:"log-api-response-size" description:"(server only) Maximum size in bytes of get log api response. If log size is greater than this value, only the lastest part of the log will be returned."`
 
+	// Client identity config
+	Name string `json:"name,omitempty" long:"name" description:"(client only) Friendly name shown to servers, e.g. Anna's laptop. Hostname will be used if not provided."`
+
+	// Fleet update config
+	UpdateChannel        string   `json:"updateChannel,omitempty" long:"update-channel" description:"(client only) Update channel of this client, e.g. stable or beta. Staged rollouts on server can target a channel." default:"stable"`
+	FleetGroups          []string `json:"fleetGroups,omitempty" long:"fleet-group" description:"(client only) Group tag of this client for staged rollouts, can be specified multiple times"`
+	UpdateCommand        string   `json:"updateCommand,omitempty" long:"update-command" description:"(client only) Shell command that updates nConnect when a staged rollout on remote server targets this client. Target version is passed in NCONNECT_UPDATE_VERSION environment variable. Updates are not checked if empty."`
+	UpdateCheckInterval  int32    `json:"updateCheckInterval,omitempty" long:"update-check-interval" description:"(client only) Staged rollout and server recommended settings check interval (in seconds)" default:"3600"`
+	AcceptServerSettings bool     `json:"acceptServerSettings,omitempty" long:"accept-server-settings" description:"(client only) Save client settings recommended by remote server, such as cipher and VPN routes, to config. They take effect after restart."`
+
 	// Remote address
-	RemoteAdminAddr  []string `json:"remoteAdminAddr,omitempty" short:"a" long:"remote-admin-addr" description:"(client only) Remote server admin address"`
-	RemoteTunnelAddr []string `json:"remoteTunnelAddr,omitempty" short:"r" long:"remote-tunnel-addr" description:"(client only) Remote server tunnel address, not needed if remote server admin address is given"`
+	RemoteAdminAddr  []RemoteServer `json:"remoteAdminAddr,omitempty" short:"a" long:"remote-admin-addr" description:"(client only) Remote server admin address. In config file it can also be an object with addr and per server dialTimeout, sessionWindowSize, tuna and labels."`
+	RemoteTunnelAddr []string       `json:"remoteTunnelAddr,omitempty" short:"r" long:"remote-tunnel-addr" description:"(client only) Remote server tunnel address, not needed if remote server admin address is given"`
 
 	// Socks proxy config
-	LocalSocksAddr string `json:"localSocksAddr,omitempty" short:"l" long:"local-socks-addr" description:"(client only) Local socks proxy listen address" default:"127.0.0.1:1080"`
+	LocalSocksAddr  string   `json:"localSocksAddr,omitempty" short:"l" long:"local-socks-addr" description:"(client only) Local socks proxy listen address" default:"127.0.0.1:1080"`
+	SocksAllowedIPs []string `json:"socksAllowedIPs,omitempty" long:"socks-allowed-ip" description:"(client only) IP or CIDR allowed to connect to local socks proxy, can be specified multiple times. Loopback is always allowed. All sources are allowed if empty."`
+	AdvertiseProxy  bool     `json:"advertiseProxy,omitempty" long:"advertise-proxy" description:"(client only) Advertise local socks proxy on LAN via mDNS/DNS-SD as _socks._tcp, so other devices can discover it. Local socks proxy should listen on a non-loopback address."`
+	AutoPort        bool     `json:"autoPort,omitempty" long:"auto-port" description:"Listen on the next free port if local socks proxy (client) or admin web GUI (server) address is already in use, instead of failing to start"`
 
 	// TUN/TAP device config
 	Tun        bool     `json:"tun,omitempty" long:"tun" description:"(client only) Enable TUN device, might require root privilege"`
@@ -86,8 +212,20 @@ type Config struct {
 	TunName    string   `json:"tunName,omitempty" long:"tun-name" description:"(client only) TUN device name, will be ignored on MacOS. Default is nConnect-tun0 on Linux and nConnect-tap0 on Windows."`
 
 	// VPN mode config
-	VPN      bool     `json:"vpn,omitempty" long:"vpn" description:"(client only) Enable VPN mode, might require root privilege. TUN device will be enabled when VPN mode is enabled."`
-	VPNRoute []string `json:"vpnRoute,omitempty" long:"vpn-route" description:"(client only) VPN routing table destinations, each item should be a valid CIDR. If not given, remote server's local IP addresses will be used."`
+	VPN            bool     `json:"vpn,omitempty" long:"vpn" description:"(client only) Enable VPN mode, might require root privilege. TUN device will be enabled when VPN mode is enabled."`
+	VPNRoute       []string `json:"vpnRoute,omitempty" long:"vpn-route" description:"(client only) VPN routing table destinations, each item should be a valid CIDR. If not given, remote server's local IP addresses will be used."`
+	VPNIPv6Policy  string   `json:"vpnIPv6Policy,omitempty" long:"vpn-ipv6-policy" description:"(client only) What to do with IPv6 traffic when VPN routes take over IPv4 default route but have no IPv6 route: tunnel (route it through VPN too), block (drop it so it does not leak outside VPN) or bypass (leave it to the original network)" choice:"tunnel" choice:"block" choice:"bypass" default:"block"`
+	VPNProbeAddr   string   `json:"vpnProbeAddr,omitempty" long:"vpn-probe-addr" description:"(client only) DNS server (host:port) queried over TCP through the tunnel to verify it works before VPN routes and DNS are set, or none to set them without waiting" default:"1.1.1.1:53"`
+	VPNDNSSearch   []string `json:"vpnDNSSearch,omitempty" long:"vpn-dns-search" description:"(server only) DNS search domains pushed to VPN mode clients, so short host names resolve through the tunnel, e.g. corp.internal"`
+	VPNGracePeriod int32    `json:"vpnGracePeriod,omitempty" long:"vpn-grace-period" description:"(client only) Time after VPN routes and DNS are set during which they are reverted if the tunnel stops working (in seconds)" default:"60"`
+
+	// Multiple TUN devices config, only available in config file. Top level
+	// TUN and VPN config is ignored when it's not empty.
+	TunDevices []TunDeviceConfig `json:"tunDevices,omitempty"`
+
+	// Connection tagging config, only available in config file. Connections
+	// of TUN devices are also tagged by device tag.
+	ConnTags []ConnTagRule `json:"connTags,omitempty"`
 
 	// Tuna config
 	Tuna                        bool     `json:"tuna,omitempty" short:"t" long:"tuna" description:"Enable tuna sessions"`
@@ -102,7 +240,9 @@ type Config struct {
 	TunaAllowIp                 []string `json:"tunaAllowIp,omitempty" long:"tuna-allow-ip" description:"(server only) Tuna service node allowed IP. All IP will be allowed if not provided"`
 	TunaDisallowIp              []string `json:"tunaDisallowIp,omitempty" long:"tuna-disallow-ip" description:"(server only) Tuna service node disallowed IP. All IP will be allowed if not provided"`
 	TunaDisableDownloadGeoDB    bool     `json:"tunaDisableDownloadGeoDB,omitempty" long:"tuna-disable-download-geo-db" description:"(server only) Disable Tuna download geo db to disk"`
-	TunaGeoDBPath               string   `json:"tunaGeoDBPath,omitempty" long:"tuna-geo-db-path" description:"(server only) Path to store Tuna geo db" default:"."`
+	TunaGeoDBPath               string   `json:"tunaGeoDBPath,omitempty" long:"tuna-geo-db-path" description:"(server only) Path to store Tuna geo db, can be shared by instances on the same host" default:"."`
+	TunaGeoDBMirror             string   `json:"tunaGeoDBMirror,omitempty" long:"tuna-geo-db-mirror" description:"(server only) URL to download Tuna geo db from instead of default, with its sha256 checksum at the same URL with .sha256 suffix"`
+	TunaGeoDBSnapshot           string   `json:"tunaGeoDBSnapshot,omitempty" long:"tuna-geo-db-snapshot" description:"(server only) Path of a bundled Tuna geo db to use when it cannot be downloaded"`
 	TunaDisableMeasureBandwidth bool     `json:"tunaDisableMeasureBandwidth,omitempty" long:"tuna-disable-measure-bandwidth" description:"(server only) Disable Tuna measure bandwidth when selecting service nodes"`
 	TunaMeasureStoragePath      string   `json:"tunaMeasureStoragePath,omitempty" long:"tuna-measure-storage-path" description:"(server only) Path to store Tuna measurement results" default:"."`
 	TunaMeasureBandwidthBytes   int32    `json:"tunaMeasureBandwidthBytes,omitempty" long:"tuna-measure-bandwidth-bytes" description:"(server only) Tuna measure bandwidth bytes to transmit when selecting service nodes" default:"1"`
@@ -112,32 +252,131 @@ type Config struct {
 	UDPIdleTime int32 `json:"udpIdleTime,omitempty" long:"udp-idle-time" description:"UDP connections will be purged after idle time (in seconds). 0 is for no purge" default:"0"`
 
 	// Admin config
-	AdminIdentifier     string `json:"adminIdentifier,omitempty" long:"admin-identifier" description:"(server only) Admin NKN client identifier prefix" default:"nConnect"`
-	AdminHTTPAddr       string `json:"adminHttpAddr,omitempty" long:"admin-http" description:"(server only) Admin web GUI listen address (e.g. 127.0.0.1:8000)"`
-	DisableAdminHTTPAPI bool   `json:"disableAdminHttpApi,omitempty" long:"disable-admin-http-api" description:"(server only) Disable admin http api so admin web GUI only show static assets"`
-	WebRootPath         string `json:"webRootPath,omitempty" long:"web-root-path" description:"(server only) Web root path" default:"web/dist"`
+	AdminIdentifier            string `json:"adminIdentifier,omitempty" long:"admin-identifier" description:"(server only) Admin NKN client identifier prefix" default:"nConnect"`
+	AdminHTTPAddr              string `json:"adminHttpAddr,omitempty" long:"admin-http" description:"(server only) Admin web GUI listen address (e.g. 127.0.0.1:8000)"`
+	AdminHTTPTLS               bool   `json:"adminHttpTLS,omitempty" long:"admin-http-tls" description:"(server only) Serve admin web GUI over HTTPS. A self-signed certificate is generated and kept in state storage unless cert and key files are given"`
+	AdminHTTPCertFile          string `json:"adminHttpCertFile,omitempty" long:"admin-http-cert-file" description:"(server only) Admin web GUI TLS certificate file (PEM)"`
+	AdminHTTPKeyFile           string `json:"adminHttpKeyFile,omitempty" long:"admin-http-key-file" description:"(server only) Admin web GUI TLS private key file (PEM)"`
+	AdminHTTPClientCAFile      string `json:"adminHttpClientCAFile,omitempty" long:"admin-http-client-ca-file" description:"(server only) CA certificates file (PEM) for admin HTTP API client certificates. Requests with a client certificate signed by them are allowed as web admin without login, e.g. from automation"`
+	AdminHTTPRequireClientCert bool   `json:"adminHttpRequireClientCert,omitempty" long:"admin-http-require-client-cert" description:"(server only) Refuse admin web GUI and HTTP API connections without a client certificate signed by adminHttpClientCAFile"`
+	AdminHTTP3                 bool   `json:"adminHttp3,omitempty" long:"admin-http3" description:"(server only) Also serve admin web GUI over HTTP/3 (QUIC) on the same UDP port when TLS is enabled, which is more responsive over lossy links. Requires a build with http3 tag"`
+	DisableAdminHTTPAPI        bool   `json:"disableAdminHttpApi,omitempty" long:"disable-admin-http-api" description:"(server only) Disable admin http api so admin web GUI only show static assets"`
+	AdminHTTPMetrics           bool   `json:"adminHttpMetrics,omitempty" long:"admin-http-metrics" description:"(server only) Serve Prometheus metrics at /metrics of admin web GUI"`
+	WebRootPath                string `json:"webRootPath,omitempty" long:"web-root-path" description:"(server only) Web root path" default:"web/dist"`
+
+	// DNS cache config
+	DisableDNSCache     bool  `json:"disableDNSCache,omitempty" long:"disable-dns-cache" description:"(server only) Disable caching of resolved proxy target hosts"`
+	DNSCacheMinTTL      int32 `json:"dnsCacheMinTTL,omitempty" long:"dns-cache-min-ttl" description:"(server only) Minimal time (in seconds) to cache a resolved host, even if its DNS TTL is lower" default:"30"`
+	DNSCacheMaxTTL      int32 `json:"dnsCacheMaxTTL,omitempty" long:"dns-cache-max-ttl" description:"(server only) Maximal time (in seconds) to cache a resolved host, even if its DNS TTL is higher" default:"3600"`
+	DNSCacheNegativeTTL int32 `json:"dnsCacheNegativeTTL,omitempty" long:"dns-cache-negative-ttl" description:"(server only) Time (in seconds) to cache a nonexistent host. 0 is for no negative caching" default:"30"`
+
+	// Service publishing config. Services are only available in config file.
+	PublishAddr       string             `json:"publishAddr,omitempty" long:"publish-addr" description:"(server only) Listen address (e.g. 0.0.0.0:8080) of published client services. They are published on admin web GUI address if not provided"`
+	PublishedServices []PublishedService `json:"publishedServices,omitempty"`
+	LocalServices     []LocalService     `json:"localServices,omitempty"`
+
+	// NAT detection config
+	DisableNATDetection bool     `json:"disableNATDetection,omitempty" long:"disable-nat-detection" description:"Disable STUN based NAT type detection"`
+	STUNServers         []string `json:"stunServers,omitempty" long:"stun-server" description:"STUN servers used to detect NAT type, at least 2 are needed" default:"stun.l.google.com:19302" default:"stun.cloudflare.com:3478"`
+
+	// Direct connection config
+	DirectListenAddr string `json:"directListenAddr,omitempty" long:"direct-listen-addr" description:"(server only) Listen address (e.g. 0.0.0.0:30080) for direct encrypted connections from clients that can reach this server. Direct connection is disabled if not provided"`
+	Direct           bool   `json:"direct,omitempty" long:"direct" description:"(client only) Connect to server directly when it is reachable, and fall back to NKN tunnel otherwise"`
+
+	// Resource guard config
+	MaxCPUPercent         float64 `json:"maxCPUPercent,omitempty" long:"max-cpu-percent" description:"(server only) Pause accepting new connections while process CPU usage (percent of all cores) exceeds this value. 0 is for no limit"`
+	MaxMemoryMB           float64 `json:"maxMemoryMB,omitempty" long:"max-memory-mb" description:"(server only) Pause accepting new connections while process memory usage (in megabytes) exceeds this value. 0 is for no limit"`
+	ResourceCheckInterval int32   `json:"resourceCheckInterval,omitempty" long:"resource-check-interval" description:"(server only) Resource usage check interval (in seconds)" default:"10"`
+	BandwidthMbps         float64 `json:"bandwidthMbps,omitempty" long:"bandwidth-mbps" description:"(server only) Bandwidth capacity of this server in Mbps, used to compute bandwidth headroom in load score. Bandwidth is not counted in load score if 0"`
+	MaxUploadMbps         float64 `json:"maxUploadMbps,omitempty" long:"max-upload-mbps" description:"(server only) Cap total rate of traffic from clients to their destinations in Mbps, shared by all clients, e.g. on links with data caps. 0 is for no limit"`
+	MaxDownloadMbps       float64 `json:"maxDownloadMbps,omitempty" long:"max-download-mbps" description:"(server only) Cap total rate of traffic from destinations to clients in Mbps, shared by all clients. 0 is for no limit"`
+
+	// Data cap config
+	MonthlyDataCapGB       float64 `json:"monthlyDataCapGB,omitempty" long:"monthly-data-cap-gb" description:"Suspend proxying when traffic of current month exceeds this value (in gigabytes) until reset day. Server counts traffic of all clients, client counts its own traffic. 0 is for no cap"`
+	DataCapResetDay        int     `json:"dataCapResetDay,omitempty" long:"data-cap-reset-day" description:"Day of month (1-28) monthly data usage is reset" default:"1"`
+	DataUsageFlushInterval int32   `json:"dataUsageFlushInterval,omitempty" long:"data-usage-flush-interval" description:"How often data usage counted in memory is appended to a write-ahead journal (in seconds), which is replayed after a crash or power failure. Full data usage state is saved hourly" default:"60"`
+	DataCapWarnPercent     []int   `json:"dataCapWarnPercent,omitempty" long:"data-cap-warn-percent" description:"Warn when monthly data usage reaches these percents of data cap" default:"80" default:"90"`
+
+	// Low memory config
+	LowMemory     bool `json:"lowMemory,omitempty" long:"low-memory" description:"Shrink buffers and concurrency, and disable admin web GUI and Tuna geo db download, for devices with 32-64MB memory such as routers. Enabled by default in builds with lowmem tag."`
+	MaxProxyConns int  `json:"maxProxyConns,omitempty" long:"max-proxy-conns" description:"Maximum concurrent socks proxy connections, new connections are refused beyond it. 0 is for no limit, or 64 in low memory mode."`
+
+	// Config backup config
+	ConfigBackups int `json:"configBackups,omitempty" long:"config-backups" description:"Number of timestamped config file backups to keep. A backup is made before each change. 0 is for no backup" default:"10"`
+
+	// Runtime state storage config
+	StateStorage string `json:"stateStorage,omitempty" long:"state-storage" description:"Storage of runtime state such as config backups and Tuna measurement results. File keeps a file per entry in state dir, sqlite keeps them in a database file in state dir (requires a build with sqlite tag)." choice:"file" choice:"sqlite" default:"file"`
+	StateDir     string `json:"stateDir,omitempty" long:"state-dir" description:"Directory of runtime state storage, e.g. on a separate writable partition. Default is the directory of config file."`
+
+	// Status file config
+	StatusFile     string `json:"statusFile,omitempty" long:"status-file" description:"Periodically write read-only status JSON (state, addresses, relay and counters) to this path, e.g. for dashboards, desktop widgets and monitoring agents"`
+	StatusInterval int32  `json:"statusInterval,omitempty" long:"status-interval" description:"Status file update interval (in seconds)" default:"10"`
+
+	// Fleet stats config
+	StatsTopic         string `json:"statsTopic,omitempty" long:"stats-topic" description:"(server only) Publish anonymized aggregate stats (uptime, throughput, relay country) to subscribers of this NKN topic, so a fleet dashboard can aggregate many servers"`
+	StatsTopicInterval int32  `json:"statsTopicInterval,omitempty" long:"stats-topic-interval" description:"(server only) Stats topic publish interval (in seconds)" default:"300"`
 
 	Tags    []string `json:"tags,omitempty" long:"tags" description:"(server only) Tags that will be included in get info api"`
 	Verbose bool     `json:"verbose,omitempty" short:"v" long:"verbose" description:"Verbose mode, show logs on dialing/accepting connections"`
 
 	lock        sync.RWMutex
-	AcceptAddrs []string `json:"acceptAddrs"`
-	AdminAddrs  []string `json:"adminAddrs"`
+	AcceptAddrs []AddrEntry            `json:"acceptAddrs"`
+	AdminAddrs  []AddrEntry            `json:"adminAddrs"`
+	Clients     map[string]*ClientInfo `json:"clients,omitempty"` // map client public key to client info
+	WebUsers    []WebUser              `json:"webUsers,omitempty"`
+}
+
+// ClientInfo is the info server keeps about a client.
+type ClientInfo struct {
+	Name    string `json:"name,omitempty"`
+	Group   string `json:"group,omitempty"`
+	QuotaMB int64  `json:"quotaMB,omitempty"` // monthly traffic quota in megabytes, 0 is for unlimited
+}
+
+// TunDeviceConfig is the config of one TUN device when running multiple TUN
+// devices. Each device has its own remote servers, routes and DNS scope.
+type TunDeviceConfig struct {
+	Name             string   `json:"name"`
+	Addr             string   `json:"addr"`
+	Gateway          string   `json:"gateway"`
+	Mask             string   `json:"mask,omitempty"`
+	DNS              []string `json:"dns,omitempty"`        // DNS resolvers for the device
+	DNSDomains       []string `json:"dnsDomains,omitempty"` // only resolve these domains with DNS, all domains if empty on Windows
+	DNSSearch        []string `json:"dnsSearch,omitempty"`  // DNS search domains in VPN mode, the ones pushed by remote servers if empty
+	VPN              bool     `json:"vpn,omitempty"`
+	VPNRoute         []string `json:"vpnRoute,omitempty"`
+	VPNIPv6Policy    string   `json:"vpnIPv6Policy,omitempty"` // top level vpnIPv6Policy is used if empty
+	RemoteAdminAddr  []string `json:"remoteAdminAddr,omitempty"`
+	RemoteTunnelAddr []string `json:"remoteTunnelAddr,omitempty"` // not needed if remote admin address is given
+	Tag              string   `json:"tag,omitempty"`              // tag of connections routed to this device's remote server
 }
 
 func NewConfig() *Config {
 	return &Config{
-		AcceptAddrs: make([]string, 0),
-		AdminAddrs:  make([]string, 0),
+		ConfigVersion: ConfigVersion,
+		AcceptAddrs:   make([]AddrEntry, 0),
+		AdminAddrs:    make([]AddrEntry, 0),
 	}
 }
 
 func LoadOrNewConfig(path string) (*Config, error) {
+	return LoadOrNewConfigFormat(path, "")
+}
+
+// LoadOrNewConfigFormat is the same as LoadOrNewConfig, but config file is in
+// the given format, or the format detected from path extension if empty.
+func LoadOrNewConfigFormat(path, format string) (*Config, error) {
+	format, err := GetFormat(path, format)
+	if err != nil {
+		return nil, err
+	}
+
 	b, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
 			c := NewConfig()
 			c.path = path
+			c.format = format
 			err := c.save()
 			if err != nil {
 				return nil, err
@@ -148,17 +387,100 @@ func LoadOrNewConfig(path string) (*Config, error) {
 	}
 
 	c := &Config{
-		path: path,
+		path:   path,
+		format: format,
+	}
+
+	c.files, err = unmarshalConfigFile(path, b, format, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.migratedFrom > 0 {
+		err = c.backupMigrated()
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	err = json.Unmarshal(b, c)
+	move := c.hasPlainSecrets()
+	err = c.loadSecrets(path)
 	if err != nil {
 		return nil, err
 	}
+	if move || c.migratedFrom > 0 {
+		err = c.save() // move plaintext secrets to keychain or secrets file, or save migrated config
+		if err != nil {
+			return nil, err
+		}
+		if c.migratedFrom > 0 {
+			log.Printf("Migrated config from version %d to %d, old config files are kept with %s extension", c.migratedFrom, ConfigVersion, backupExt)
+		}
+	}
+	if !move {
+		err = c.loadKeychainSeed()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// ReadFile loads config file again without changing c, e.g. to reload
+// config after the file is edited.
+func (c *Config) ReadFile() (*Config, error) {
+	c.lock.RLock()
+	path, format := c.path, c.format
+	c.lock.RUnlock()
+	if len(path) == 0 {
+		return nil, errNoConfigPath
+	}
+	return readConfigFile(path, format)
+}
 
+// ReadConfigFile loads an existing config file without creating, migrating
+// or otherwise changing it. Format is detected from path if empty.
+func ReadConfigFile(path, format string) (*Config, error) {
+	format, err := GetFormat(path, format)
+	if err != nil {
+		return nil, err
+	}
+	c, err := readConfigFile(path, format)
+	if err != nil {
+		return nil, err
+	}
+	c.path = path
+	c.format = format
 	return c, nil
 }
 
+func readConfigFile(path, format string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	loaded := &Config{}
+	loaded.files, err = unmarshalConfigFile(path, b, format, loaded)
+	if err != nil {
+		return nil, err
+	}
+	err = loaded.loadSecrets(path)
+	if err != nil {
+		return nil, err
+	}
+	err = loaded.loadKeychainSeed()
+	if err != nil {
+		return nil, err
+	}
+	return loaded, nil
+}
+
+// Path returns the file path config is loaded from and saved to.
+func (c *Config) Path() string {
+	return c.path
+}
+
 func (c *Config) SetPlatformSpecificDefaultValues() error {
 	if len(c.TunName) == 0 {
 		switch runtime.GOOS {
@@ -171,7 +493,199 @@ func (c *Config) SetPlatformSpecificDefaultValues() error {
 	return nil
 }
 
+// verifySession checks advanced session config for out of range values and
+// combinations that would stall sessions.
+func (c *Config) verifySession() error {
+	if c.NumSubClients < 0 || c.NumSubClients > maxNumSubClients {
+		return fmt.Errorf("numSubClients should be between 0 and %d", maxNumSubClients)
+	}
+	if c.NumTunaListeners < 0 || c.NumTunaListeners > maxNumTunaListeners {
+		return fmt.Errorf("numTunaListeners should be between 0 and %d", maxNumTunaListeners)
+	}
+	if c.AdminNumSubClients < 0 || c.AdminNumSubClients > maxNumSubClients {
+		return fmt.Errorf("adminNumSubClients should be between 0 and %d", maxNumSubClients)
+	}
+	if c.MsgChanLen < 0 || c.MsgChanLen > maxMsgChanLen {
+		return fmt.Errorf("msgChanLen should be between 0 and %d", maxMsgChanLen)
+	}
+	if c.RPCConcurrency < 0 || c.RPCConcurrency > maxRPCConcurrency {
+		return fmt.Errorf("rpcConcurrency should be between 0 and %d", maxRPCConcurrency)
+	}
+	if c.AdminMaxReplySize != 0 && (c.AdminMaxReplySize < minAdminMaxReplySize || c.AdminMaxReplySize > maxAdminMaxReplySize) {
+		return fmt.Errorf("adminMaxReplySize should be between %d and %d", minAdminMaxReplySize, maxAdminMaxReplySize)
+	}
+	if c.AdminMsgBurst < 0 {
+		return errors.New("adminMsgBurst should not be negative")
+	}
+	if c.SessionWindowSize < 0 || c.SessionMinConnectionWindowSize < 0 || c.SessionFlushInterval < 0 || c.SessionLinger < 0 ||
+		c.SessionInitialRetransmissionTimeout < 0 || c.SessionMaxRetransmissionTimeout < 0 || c.SessionSendAckInterval < 0 {
+		return errors.New("session config should not be negative")
+	}
+	if c.SessionMTU != 0 && (c.SessionMTU < minSessionMTU || c.SessionMTU > maxSessionMTU) {
+		return fmt.Errorf("sessionMTU should be between %d and %d", minSessionMTU, maxSessionMTU)
+	}
+	if c.SessionMTU > 0 && c.SessionWindowSize > 0 && c.SessionWindowSize < c.SessionMTU {
+		return errors.New("sessionWindowSize should not be less than sessionMTU")
+	}
+	if c.SessionInitialRetransmissionTimeout > 0 && c.SessionMaxRetransmissionTimeout > 0 &&
+		c.SessionInitialRetransmissionTimeout > c.SessionMaxRetransmissionTimeout {
+		return errors.New("sessionInitialRetransmissionTimeout should not be greater than sessionMaxRetransmissionTimeout")
+	}
+	return nil
+}
+
+// GetAdminNumSubClients returns the number of NKN sub-clients of admin and
+// publish clients.
+func (c *Config) GetAdminNumSubClients() int {
+	if c.AdminNumSubClients > 0 {
+		return c.AdminNumSubClients
+	}
+	return DefaultAdminNumSubClients
+}
+
+// GetAdminMaxReplySize returns the maximum size of admin rpc reply sent in a
+// single NKN message.
+func (c *Config) GetAdminMaxReplySize() int {
+	if c.AdminMaxReplySize > 0 {
+		return c.AdminMaxReplySize
+	}
+	return DefaultAdminMaxReplySize
+}
+
+// GetAdminMsgRate returns admin NKN messages per second allowed from each
+// source address, or 0 if not limited.
+func (c *Config) GetAdminMsgRate() float64 {
+	if c.AdminMsgRate < 0 {
+		return 0
+	}
+	if c.AdminMsgRate > 0 {
+		return c.AdminMsgRate
+	}
+	return DefaultAdminMsgRate
+}
+
+// GetAdminMsgBurst returns admin NKN messages allowed at once from each source
+// address above admin message rate.
+func (c *Config) GetAdminMsgBurst() int {
+	if c.AdminMsgBurst > 0 {
+		return c.AdminMsgBurst
+	}
+	return DefaultAdminMsgBurst
+}
+
+// GetAdminMsgBanTime returns how long admin NKN messages from a source address
+// are dropped after it kept exceeding admin message rate, or 0 if not banned.
+func (c *Config) GetAdminMsgBanTime() time.Duration {
+	if c.AdminMsgBanTime < 0 {
+		return 0
+	}
+	if c.AdminMsgBanTime > 0 {
+		return time.Duration(c.AdminMsgBanTime) * time.Second
+	}
+	return DefaultAdminMsgBanTime
+}
+
+// verifyDataCap checks monthly data cap config for out of range values.
+func (c *Config) verifyDataCap() error {
+	if c.MonthlyDataCapGB < 0 {
+		return errors.New("monthlyDataCapGB should not be negative")
+	}
+	if c.DataCapResetDay < 0 || c.DataCapResetDay > 28 {
+		return errors.New("dataCapResetDay should be between 1 and 28")
+	}
+	if c.DataUsageFlushInterval < 0 {
+		return errors.New("dataUsageFlushInterval should not be negative")
+	}
+	for _, p := range c.DataCapWarnPercent {
+		if p <= 0 || p >= 100 {
+			return fmt.Errorf("dataCapWarnPercent %d should be between 1 and 99", p)
+		}
+	}
+	return nil
+}
+
+// MonthlyDataCapBytes returns monthly data cap in bytes, 0 is for no cap.
+func (c *Config) MonthlyDataCapBytes() int64 {
+	return int64(c.MonthlyDataCapGB * 1e9)
+}
+
+// VerifyCipher refuses dummy cipher if local socks proxy listens on
+// non-loopback address, unless explicitly allowed.
+func (c *Config) verifyStrictAudit() error {
+	if c.StrictAudit && c.Cipher == CipherDummy {
+		return errors.New("strict audit needs an AEAD cipher to authenticate data sequence, dummy cipher is refused")
+	}
+	return nil
+}
+
+func (c *Config) VerifyCipher() error {
+	err := c.verifyStrictAudit()
+	if err != nil {
+		return err
+	}
+	if c.Cipher == CipherDummy && !c.AllowDummyCipherNonLoopback && !IsLoopbackAddr(c.LocalSocksAddr) {
+		return fmt.Errorf("dummy cipher is refused as local socks proxy listens on non-loopback address %s, use a loopback address or enable allowDummyCipherNonLoopback", c.LocalSocksAddr)
+	}
+	return nil
+}
+
 func (c *Config) VerifyClient() error {
+	err := c.verifySession()
+	if err != nil {
+		return err
+	}
+	err = c.verifyCrossFields(true)
+	if err != nil {
+		return err
+	}
+	err = c.verifyDataCap()
+	if err != nil {
+		return err
+	}
+	err = c.VerifyCipher()
+	if err != nil {
+		return err
+	}
+	_, err = ParseAllowedIPs(c.SocksAllowedIPs)
+	if err != nil {
+		return fmt.Errorf("parse socksAllowedIPs error: %v", err)
+	}
+	err = c.verifyLocalServices()
+	if err != nil {
+		return err
+	}
+	err = c.verifyConnTags()
+	if err != nil {
+		return err
+	}
+	err = c.verifyRemoteServers()
+	if err != nil {
+		return err
+	}
+	if c.AdvertiseProxy && IsLoopbackAddr(c.LocalSocksAddr) {
+		return fmt.Errorf("advertiseProxy needs local socks proxy to listen on a non-loopback address, but it listens on %s", c.LocalSocksAddr)
+	}
+	if len(c.VPNProbeAddr) > 0 && c.VPNProbeAddr != VPNProbeNone {
+		if _, _, err := net.SplitHostPort(c.VPNProbeAddr); err != nil {
+			return fmt.Errorf("vpnProbeAddr should be host:port or %s: %v", VPNProbeNone, err)
+		}
+	}
+	if len(c.TunDevices) > 0 {
+		for i, d := range c.TunDevices {
+			if len(d.Name) == 0 || len(d.Addr) == 0 || len(d.Gateway) == 0 {
+				return fmt.Errorf("tunDevices[%d]: name, addr and gateway should not be empty", i)
+			}
+			if len(d.RemoteAdminAddr) == 0 && len(d.RemoteTunnelAddr) == 0 && len(c.RemoteAdminAddr) == 0 && len(c.RemoteTunnelAddr) == 0 {
+				return fmt.Errorf("tunDevices[%d]: remoteAdminAddr and remoteTunnelAddr are both empty", i)
+			}
+			switch d.VPNIPv6Policy {
+			case "", VPNIPv6Tunnel, VPNIPv6Block, VPNIPv6Bypass:
+			default:
+				return fmt.Errorf("tunDevices[%d]: vpnIPv6Policy should be one of %s, %s and %s", i, VPNIPv6Tunnel, VPNIPv6Block, VPNIPv6Bypass)
+			}
+		}
+		return nil
+	}
 	if len(c.RemoteAdminAddr) == 0 && len(c.RemoteTunnelAddr) == 0 {
 		return errors.New("remoteAdminAddr and remoteTunnelAddr are both empty")
 	}
@@ -179,7 +693,49 @@ func (c *Config) VerifyClient() error {
 }
 
 func (c *Config) VerifyServer() error {
-	_, err := common.StringToFixed64(c.TunaMinBalance)
+	err := c.verifySession()
+	if err != nil {
+		return err
+	}
+	err = c.verifyCrossFields(false)
+	if err != nil {
+		return err
+	}
+	err = c.verifyStrictAudit()
+	if err != nil {
+		return err
+	}
+	if len(c.StatsTopic) > 0 && c.StatsTopicInterval > 0 && time.Duration(c.StatsTopicInterval)*time.Second < MinStatsTopicInterval {
+		return fmt.Errorf("statsTopicInterval should be at least %d seconds", int(MinStatsTopicInterval.Seconds()))
+	}
+	if c.DNSCacheMinTTL < 0 || c.DNSCacheMaxTTL < 0 || c.DNSCacheNegativeTTL < 0 {
+		return errors.New("dns cache TTL should not be negative")
+	}
+	if c.MaxUploadMbps < 0 || c.MaxDownloadMbps < 0 {
+		return errors.New("maxUploadMbps and maxDownloadMbps should not be negative")
+	}
+	err = c.verifyDataCap()
+	if err != nil {
+		return err
+	}
+	err = c.verifyPublishedServices()
+	if err != nil {
+		return err
+	}
+	if c.AdminHTTP3 && !c.AdminHTTPTLS {
+		return errors.New("adminHttp3 needs adminHttpTLS to be enabled")
+	}
+	if len(c.AdminHTTPClientCAFile) > 0 && !c.AdminHTTPTLS {
+		return errors.New("adminHttpClientCAFile needs adminHttpTLS to be enabled")
+	}
+	if c.AdminHTTPRequireClientCert && len(c.AdminHTTPClientCAFile) == 0 {
+		return errors.New("adminHttpRequireClientCert needs adminHttpClientCAFile")
+	}
+	err = verifyAddrRoles(c.AcceptAddrs, c.AdminAddrs)
+	if err != nil {
+		return err
+	}
+	_, err = common.StringToFixed64(c.TunaMinBalance)
 	if err != nil {
 		return fmt.Errorf("parse TunaMinBalance error: %v", err)
 	}
@@ -194,57 +750,110 @@ func (c *Config) VerifyServer() error {
 	return nil
 }
 
+// GetAcceptAddrs returns accept address patterns of entries not expired.
 func (c *Config) GetAcceptAddrs() []string {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
-	return c.AcceptAddrs
+	return activeAddrs(c.AcceptAddrs, time.Now())
 }
 
+// SetAcceptAddrs replaces accept address patterns, keeping metadata of
+// entries already there.
 func (c *Config) SetAcceptAddrs(acceptAddrs []string) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.AcceptAddrs = acceptAddrs
+	c.AcceptAddrs = setAddrs(c.AcceptAddrs, acceptAddrs)
 	return c.save()
 }
 
 func (c *Config) AddAcceptAddrs(acceptAddrs []string) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.AcceptAddrs = util.MergeStrings(c.AcceptAddrs, acceptAddrs)
+	c.AcceptAddrs = mergeAddrs(c.AcceptAddrs, NewAddrEntries(acceptAddrs))
 	return c.save()
 }
 
 func (c *Config) RemoveAcceptAddrs(acceptAddrs []string) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.AcceptAddrs = util.RemoveStrings(c.AcceptAddrs, acceptAddrs)
+	c.AcceptAddrs = removeAddrs(c.AcceptAddrs, acceptAddrs)
 	return c.save()
 }
 
+// GetAdminAddrs returns admin address patterns of entries not expired.
 func (c *Config) GetAdminAddrs() []string {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
-	return c.AdminAddrs
+	return activeAddrs(c.AdminAddrs, time.Now())
 }
 
+// SetAdminAddrs replaces admin address patterns, keeping metadata of
+// entries already there.
 func (c *Config) SetAdminAddrs(adminAddrs []string) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.AdminAddrs = adminAddrs
+	c.AdminAddrs = setAddrs(c.AdminAddrs, adminAddrs)
 	return c.save()
 }
 
 func (c *Config) AddAdminAddrs(adminAddrs []string) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.AdminAddrs = util.MergeStrings(c.AdminAddrs, adminAddrs)
+	c.AdminAddrs = mergeAddrs(c.AdminAddrs, NewAddrEntries(adminAddrs))
 	return c.save()
 }
 
 func (c *Config) RemoveAdminAddrs(adminAddrs []string) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
-	c.AdminAddrs = util.RemoveStrings(c.AdminAddrs, adminAddrs)
+	c.AdminAddrs = removeAddrs(c.AdminAddrs, adminAddrs)
+	return c.save()
+}
+
+// GetClients returns a copy of client info map.
+func (c *Config) GetClients() map[string]ClientInfo {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	clients := make(map[string]ClientInfo, len(c.Clients))
+	for k, v := range c.Clients {
+		clients[k] = *v
+	}
+	return clients
+}
+
+// SetClientName saves the friendly name of client with public key pubKey,
+// config is only saved when the name changed.
+func (c *Config) SetClientName(pubKey, name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if info, ok := c.Clients[pubKey]; ok {
+		if info.Name == name {
+			return nil
+		}
+		info.Name = name
+		return c.save()
+	}
+	if c.Clients == nil {
+		c.Clients = make(map[string]*ClientInfo)
+	}
+	c.Clients[pubKey] = &ClientInfo{Name: name}
+	return c.save()
+}
+
+// AddClients adds accept and admin addresses and replaces info of the given
+// clients in one save.
+func (c *Config) AddClients(acceptAddrs, adminAddrs []string, clients map[string]ClientInfo) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.AcceptAddrs = mergeAddrs(c.AcceptAddrs, NewAddrEntries(acceptAddrs))
+	c.AdminAddrs = mergeAddrs(c.AdminAddrs, NewAddrEntries(adminAddrs))
+	if c.Clients == nil {
+		c.Clients = make(map[string]*ClientInfo)
+	}
+	for k, v := range clients {
+		info := v
+		c.Clients[k] = &info
+	}
 	return c.save()
 }
 
@@ -255,7 +864,8 @@ func (c *Config) SetAdminHTTPAPI(disable bool) error {
 	return c.save()
 }
 
-func (c *Config) SetSeed(s string) error {
+// verifySeed checks seed is a hex string of the right length.
+func verifySeed(s string) error {
 	seed, err := hex.DecodeString(s)
 	if err != nil {
 		return errors.New("invalid seed string, should be a hex string")
@@ -264,9 +874,20 @@ func (c *Config) SetSeed(s string) error {
 	if len(seed) != ed25519.SeedSize {
 		return fmt.Errorf("invalid seed string length %d, should be %d", len(s), 2*ed25519.SeedSize)
 	}
+	return nil
+}
+
+func (c *Config) SetSeed(s string) error {
+	err := verifySeed(s)
+	if err != nil {
+		return err
+	}
 
 	c.lock.Lock()
 	defer c.lock.Unlock()
+	if c.seedExternal != nil {
+		return c.seedExternal
+	}
 	c.Seed = s
 	return c.save()
 }
@@ -294,12 +915,23 @@ func (c *Config) save() error {
 		return nil
 	}
 
-	b, err := json.MarshalIndent(c, "", " ")
+	b, err := c.marshal()
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(c.path, b, 0666)
+	if len(c.files) > 0 {
+		return c.saveFiles(b)
+	}
+
+	if c.maxBackups > 0 {
+		err = c.backupFile(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.WriteFile(c.path, b, c.configFileMode())
 	if err != nil {
 		return err
 	}
@@ -307,6 +939,71 @@ func (c *Config) save() error {
 	return nil
 }
 
+// marshal encodes config in the format of config file, without seed if it is
+// read from seed file or secret provider or kept in OS keychain, and without
+// secrets if they are kept in secrets file. It should be called with lock held.
+func (c *Config) marshal() ([]byte, error) {
+	if c.seedExternal != nil {
+		seed := c.Seed
+		c.Seed = ""
+		defer func() {
+			c.Seed = seed
+		}()
+	}
+	if !c.hasPlainSecrets() {
+		return marshalConfig(c, c.format)
+	}
+	err := c.saveKeychainSeed()
+	if err != nil {
+		return nil, err
+	}
+	err = c.saveSecrets()
+	if err != nil {
+		return nil, err
+	}
+	seed, password := c.Seed, c.Password
+	c.Seed = ""
+	if c.secretsInFile() {
+		c.Password = ""
+	}
+	defer func() {
+		c.Seed, c.Password = seed, password
+	}()
+	return marshalConfig(c, c.format)
+}
+
+// GetStateDir returns the directory of runtime state storage, which is the
+// directory of config file if not set.
+func (opts *Opts) GetStateDir() string {
+	if len(opts.StateDir) > 0 {
+		return opts.StateDir
+	}
+	return filepath.Dir(opts.ConfigFile)
+}
+
+// GetTunaMeasureStoragePath returns where Tuna measurement results are kept.
+// They are moved to state dir if it is set and the path is not changed from
+// default, since Tuna writes them as files itself.
+func (opts *Opts) GetTunaMeasureStoragePath() string {
+	if len(opts.StateDir) > 0 && (opts.TunaMeasureStoragePath == "." || len(opts.TunaMeasureStoragePath) == 0) {
+		return opts.StateDir
+	}
+	return opts.TunaMeasureStoragePath
+}
+
+// IsLoopbackAddr returns whether a listen address only binds to loopback.
+func IsLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
 func RandomIdentifier() string {
 	b := make([]byte, RandomIdentifierLength)
 	for i := range b {
@@ -314,3 +1011,29 @@ func RandomIdentifier() string {
 	}
 	return string(b)
 }
+
+// ParseAllowedIPs parses a list of IPs or CIDRs. A single IP is treated as a
+// CIDR that only contains itself.
+func ParseAllowedIPs(ips []string) ([]*net.IPNet, error) {
+	cidrs := make([]*net.IPNet, 0, len(ips))
+	for _, s := range ips {
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP %s", s)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			cidrs = append(cidrs, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, cidr, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	return cidrs, nil
+}
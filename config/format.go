@@ -0,0 +1,138 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file formats.
+const (
+	FormatJSON = "json"
+	FormatYAML = "yaml"
+	FormatTOML = "toml"
+)
+
+// GetFormat returns config file format, which is detected from path extension
+// if not given. Unknown extensions are treated as JSON.
+func GetFormat(path, format string) (string, error) {
+	if len(format) > 0 {
+		switch strings.ToLower(format) {
+		case FormatJSON:
+			return FormatJSON, nil
+		case FormatYAML, "yml":
+			return FormatYAML, nil
+		case FormatTOML:
+			return FormatTOML, nil
+		default:
+			return "", fmt.Errorf("unknown config format %q, should be one of %s, %s, %s", format, FormatJSON, FormatYAML, FormatTOML)
+		}
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return FormatYAML, nil
+	case ".toml":
+		return FormatTOML, nil
+	default:
+		return FormatJSON, nil
+	}
+}
+
+// marshalConfig encodes config in the given format. YAML and TOML are
+// converted from JSON so they use the same field names.
+func marshalConfig(c *Config, format string) ([]byte, error) {
+	if format == FormatJSON || len(format) == 0 {
+		return json.MarshalIndent(c, "", " ")
+	}
+	b, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	return convertJSON(b, format)
+}
+
+// unmarshalConfig decodes config in the given format into c.
+func unmarshalConfig(b []byte, format string, c *Config) error {
+	var m map[string]interface{}
+	switch format {
+	case FormatYAML:
+		err := yaml.Unmarshal(b, &m)
+		if err != nil {
+			return err
+		}
+	case FormatTOML:
+		err := toml.Unmarshal(b, &m)
+		if err != nil {
+			return err
+		}
+	default:
+		return json.Unmarshal(b, c)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, c)
+}
+
+// convertJSON converts a JSON object to the given format.
+func convertJSON(b []byte, format string) ([]byte, error) {
+	if format == FormatJSON || len(format) == 0 {
+		return b, nil
+	}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	var m map[string]interface{}
+	err := d.Decode(&m)
+	if err != nil {
+		return nil, err
+	}
+	v := normalizeJSONValue(m)
+
+	switch format {
+	case FormatYAML:
+		return yaml.Marshal(v)
+	case FormatTOML:
+		return toml.Marshal(v)
+	default:
+		return nil, fmt.Errorf("unknown config format %q", format)
+	}
+}
+
+// normalizeJSONValue drops null values, which TOML can not represent, and
+// turns JSON numbers into integers when possible so they are not written as
+// floats or strings.
+func normalizeJSONValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for k, e := range v {
+			if e == nil {
+				delete(v, k)
+				continue
+			}
+			v[k] = normalizeJSONValue(e)
+		}
+		return v
+	case []interface{}:
+		for i, e := range v {
+			v[i] = normalizeJSONValue(e)
+		}
+		return v
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		if f, err := v.Float64(); err == nil {
+			return f
+		}
+		return v.String()
+	default:
+		return v
+	}
+}
@@ -0,0 +1,380 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+const includeKey = "include"
+
+// configFile is the main config file or a file in its include list, with
+// values as last loaded or saved. Values decide which file a changed value
+// is saved back to.
+type configFile struct {
+	path   string
+	format string
+	values map[string]interface{}
+}
+
+// FilePaths returns paths of main config file and included files.
+func (c *Config) FilePaths() []string {
+	if len(c.files) == 0 {
+		return []string{c.path}
+	}
+	paths := make([]string, 0, len(c.files))
+	for _, f := range c.files {
+		paths = append(paths, f.path)
+	}
+	return paths
+}
+
+// decodeConfigMap decodes a config file into a generic map. JSON numbers are
+// kept as json.Number so they are written back unchanged.
+func decodeConfigMap(b []byte, format string) (map[string]interface{}, error) {
+	var m map[string]interface{}
+	switch format {
+	case FormatYAML:
+		err := yaml.Unmarshal(b, &m)
+		if err != nil {
+			return nil, err
+		}
+	case FormatTOML:
+		err := toml.Unmarshal(b, &m)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		d := json.NewDecoder(bytes.NewReader(b))
+		d.UseNumber()
+		err := d.Decode(&m)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if m == nil {
+		m = make(map[string]interface{})
+	}
+	return m, nil
+}
+
+// encodeConfigMap encodes a generic config map in the given format. Top level
+// keys are in the order of Config fields, so JSON files look the same as
+// those written from Config directly.
+func encodeConfigMap(m map[string]interface{}, format string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	for i, k := range configKeyOrder(m) {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		kb, err := json.Marshal(k)
+		if err != nil {
+			return nil, err
+		}
+		vb, err := json.MarshalIndent(m[k], " ", " ")
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteString("\n ")
+		buf.Write(kb)
+		buf.WriteString(": ")
+		buf.Write(vb)
+	}
+	if len(m) > 0 {
+		buf.WriteString("\n")
+	}
+	buf.WriteString("}")
+	return convertJSON(buf.Bytes(), format)
+}
+
+// configKeyOrder returns keys of m in the order of Config fields, followed by
+// unknown keys in ascending order.
+func configKeyOrder(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	known := make(map[string]bool, len(m))
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		name, _ := jsonFieldName(f)
+		if _, ok := m[name]; ok && !known[name] {
+			keys = append(keys, name)
+			known[name] = true
+		}
+	}
+	var unknown []string
+	for k := range m {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return append(keys, unknown...)
+}
+
+func deepCopyValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = deepCopyValue(e)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, e := range v {
+			s[i] = deepCopyValue(e)
+		}
+		return s
+	default:
+		return v
+	}
+}
+
+// deepMerge merges src into dst. Objects are merged recursively, other values
+// in src replace those in dst.
+func deepMerge(dst, src map[string]interface{}) {
+	for k, v := range src {
+		if dm, ok := dst[k].(map[string]interface{}); ok {
+			if sm, ok := v.(map[string]interface{}); ok {
+				deepMerge(dm, sm)
+				continue
+			}
+		}
+		dst[k] = deepCopyValue(v)
+	}
+}
+
+// readIncludes reads files in include list of main config file at path,
+// which is relative to the directory of main config file if not absolute.
+// They are migrated from config version from if it is not 0.
+func readIncludes(path string, include []string, from int) ([]*configFile, error) {
+	files := make([]*configFile, 0, len(include))
+	for _, p := range include {
+		if !filepath.IsAbs(p) {
+			p = filepath.Join(filepath.Dir(path), p)
+		}
+		format, err := GetFormat(p, "")
+		if err != nil {
+			return nil, err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return nil, fmt.Errorf("read included config error: %v", err)
+		}
+		m, err := decodeConfigMap(b, format)
+		if err != nil {
+			return nil, fmt.Errorf("parse included config %s error: %v", p, err)
+		}
+		if _, ok := m[includeKey]; ok {
+			return nil, fmt.Errorf("included config %s should not include other files", p)
+		}
+		if from > 0 {
+			err = migrateConfigMap(m, from)
+			if err != nil {
+				return nil, fmt.Errorf("included config %s: %v", p, err)
+			}
+		}
+		files = append(files, &configFile{path: p, format: format, values: m})
+	}
+	return files, nil
+}
+
+// mergeConfigFiles returns values of main config file and included files
+// merged in order, later files taking precedence.
+func mergeConfigFiles(files []*configFile) map[string]interface{} {
+	merged := make(map[string]interface{})
+	for _, f := range files {
+		deepMerge(merged, f.values)
+	}
+	return merged
+}
+
+// unmarshalConfigFile decodes content b of main config file at path into c.
+// Files in its include list are deep merged on top of it in order. It
+// returns the main config file and included files, or nil if there is no
+// include list.
+func unmarshalConfigFile(path string, b []byte, format string, c *Config) ([]*configFile, error) {
+	b, from, err := migrateConfig(b, format)
+	if err != nil {
+		return nil, err
+	}
+	c.migratedFrom = from
+	c.ConfigVersion = ConfigVersion
+
+	err = unmarshalConfig(b, format, c)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.Include) == 0 {
+		return nil, nil
+	}
+
+	m, err := decodeConfigMap(b, format)
+	if err != nil {
+		return nil, err
+	}
+	includes, err := readIncludes(path, c.Include, from)
+	if err != nil {
+		return nil, err
+	}
+	files := append([]*configFile{{path: path, format: format, values: m}}, includes...)
+
+	b, err = json.Marshal(mergeConfigFiles(files))
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, c)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// splitConfigValues splits merged config values m back to files. A value is
+// written to the last file it was loaded from, which is the one that took
+// effect, and removed from others. Objects in several files are split
+// recursively. Values not in any file are written to file def.
+func splitConfigValues(m map[string]interface{}, loaded []map[string]interface{}, def int) []map[string]interface{} {
+	out := make([]map[string]interface{}, len(loaded))
+	for i := range out {
+		out[i] = make(map[string]interface{})
+	}
+	for k, v := range m {
+		var owners []int
+		for i, l := range loaded {
+			if _, ok := l[k]; ok {
+				owners = append(owners, i)
+			}
+		}
+		if len(owners) == 0 {
+			out[def][k] = v
+			continue
+		}
+		last := owners[len(owners)-1]
+
+		vm, ok := v.(map[string]interface{})
+		if len(owners) > 1 && ok {
+			sub := make([]map[string]interface{}, len(loaded))
+			for _, i := range owners {
+				sub[i], ok = loaded[i][k].(map[string]interface{})
+				if !ok {
+					break
+				}
+			}
+			if ok {
+				split := splitConfigValues(vm, sub, last)
+				for _, i := range owners {
+					out[i][k] = split[i]
+				}
+				continue
+			}
+		}
+		out[last][k] = v
+	}
+	return out
+}
+
+// saveFiles writes config b, which is encoded in main config file format, to
+// main config file and included files. Included files are only written when
+// changed. It should be called with lock held.
+func (c *Config) saveFiles(b []byte) error {
+	m, err := decodeConfigMap(b, c.format)
+	if err != nil {
+		return err
+	}
+	b, err = encodeConfigMap(m, c.format)
+	if err != nil {
+		return err
+	}
+
+	if c.maxBackups > 0 {
+		err = c.backupFiles(b)
+		if err != nil {
+			return err
+		}
+	}
+
+	loaded := make([]map[string]interface{}, len(c.files))
+	for i, f := range c.files {
+		loaded[i] = f.values
+	}
+	split := splitConfigValues(m, loaded, 0)
+
+	for i, f := range c.files {
+		fb, err := encodeConfigMap(split[i], f.format)
+		if err != nil {
+			return err
+		}
+		if i > 0 && !configFileChanged(f, fb) {
+			f.values = split[i]
+			continue
+		}
+		err = os.WriteFile(f.path, fb, c.configFileMode())
+		if err != nil {
+			return err
+		}
+		f.values = split[i]
+	}
+	return nil
+}
+
+// configFileChanged returns whether content b differs from file f on disk,
+// ignoring formatting of the file.
+func configFileChanged(f *configFile, b []byte) bool {
+	old, err := os.ReadFile(f.path)
+	if err != nil {
+		return true
+	}
+	m, err := decodeConfigMap(old, f.format)
+	if err != nil {
+		return true
+	}
+	old, err = encodeConfigMap(m, f.format)
+	if err != nil {
+		return true
+	}
+	return !bytes.Equal(old, b)
+}
+
+// backupFiles backs up config merged from main config file and included
+// files on disk if it differs from b.
+func (c *Config) backupFiles(b []byte) error {
+	var files []*configFile
+	for _, f := range c.files {
+		fb, err := os.ReadFile(f.path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		m, err := decodeConfigMap(fb, f.format)
+		if err != nil {
+			return err
+		}
+		files = append(files, &configFile{values: m})
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	old, err := encodeConfigMap(mergeConfigFiles(files), c.format)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(old, b) {
+		return nil
+	}
+	_, err = c.writeBackup(old)
+	return err
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitConfigValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		merged map[string]interface{}
+		loaded []map[string]interface{}
+		def    int
+		want   []map[string]interface{}
+	}{
+		{
+			name:   "key kept in its file",
+			merged: map[string]interface{}{"a": "1", "b": "2"},
+			loaded: []map[string]interface{}{{"a": "0"}, {"b": "0"}},
+			want:   []map[string]interface{}{{"a": "1"}, {"b": "2"}},
+		},
+		{
+			name:   "key moved from main to included file",
+			merged: map[string]interface{}{"a": "1"},
+			loaded: []map[string]interface{}{{}, {"a": "0"}},
+			want:   []map[string]interface{}{{}, {"a": "1"}},
+		},
+		{
+			name:   "key in several files saved to the last one",
+			merged: map[string]interface{}{"a": "1"},
+			loaded: []map[string]interface{}{{"a": "0"}, {"a": "0"}, {}},
+			want:   []map[string]interface{}{{}, {"a": "1"}, {}},
+		},
+		{
+			name:   "new key saved to default file",
+			merged: map[string]interface{}{"a": "1", "b": "2"},
+			loaded: []map[string]interface{}{{}, {"a": "0"}},
+			def:    0,
+			want:   []map[string]interface{}{{"b": "2"}, {"a": "1"}},
+		},
+		{
+			name:   "key removed from merged config",
+			merged: map[string]interface{}{"a": "1"},
+			loaded: []map[string]interface{}{{"a": "0", "b": "0"}, {"c": "0"}},
+			want:   []map[string]interface{}{{"a": "1"}, {}},
+		},
+		{
+			name: "nested object split across files",
+			merged: map[string]interface{}{
+				"obj": map[string]interface{}{"x": "1", "y": "2", "z": "3"},
+			},
+			loaded: []map[string]interface{}{
+				{"obj": map[string]interface{}{"x": "0"}},
+				{"obj": map[string]interface{}{"y": "0", "w": "0"}},
+			},
+			want: []map[string]interface{}{
+				{"obj": map[string]interface{}{"x": "1"}},
+				{"obj": map[string]interface{}{"y": "2", "z": "3"}},
+			},
+		},
+		{
+			name: "nested object in one file not split",
+			merged: map[string]interface{}{
+				"obj": map[string]interface{}{"x": "1", "y": "2"},
+			},
+			loaded: []map[string]interface{}{
+				{},
+				{"obj": map[string]interface{}{"x": "0"}},
+			},
+			want: []map[string]interface{}{
+				{},
+				{"obj": map[string]interface{}{"x": "1", "y": "2"}},
+			},
+		},
+		{
+			name:   "object replaced by value saved to the last file",
+			merged: map[string]interface{}{"obj": "1"},
+			loaded: []map[string]interface{}{
+				{"obj": map[string]interface{}{"x": "0"}},
+				{"obj": map[string]interface{}{"y": "0"}},
+			},
+			want: []map[string]interface{}{{}, {"obj": "1"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, splitConfigValues(tt.merged, tt.loaded, tt.def))
+		})
+	}
+}
+
+func writeTestFile(t *testing.T, path, content string) {
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+}
+
+func readTestFile(t *testing.T, path string) map[string]interface{} {
+	format, err := GetFormat(path, "")
+	require.NoError(t, err)
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	m, err := decodeConfigMap(b, format)
+	require.NoError(t, err)
+	return m
+}
+
+func TestSaveFiles(t *testing.T) {
+	dir := t.TempDir()
+	mainPath := filepath.Join(dir, "config.json")
+	yamlPath := filepath.Join(dir, "clients.yaml")
+	tomlPath := filepath.Join(dir, "tuna.toml")
+
+	writeTestFile(t, mainPath, `{
+ "include": ["clients.yaml", "tuna.toml"],
+ "identifier": "main",
+ "tuna": true,
+ "clients": {"pkA": {"name": "a"}},
+ "acceptAddrs": [],
+ "adminAddrs": []
+}`)
+	writeTestFile(t, yamlPath, `clients:
+  pkB:
+    name: b
+tunaServiceName: svc
+`)
+	writeTestFile(t, tomlPath, `identifier = "toml"
+tunaCountry = ["US"]
+`)
+
+	c, err := LoadOrNewConfig(mainPath)
+	require.NoError(t, err)
+	require.Equal(t, "toml", c.Identifier)
+	require.Equal(t, "svc", c.TunaServiceName)
+	require.Len(t, c.Clients, 2)
+
+	c.Tuna = false
+	c.TunaCountry = []string{"DE"}
+	require.NoError(t, c.SetClientName("pkB", "b2"))
+	require.NoError(t, c.SetClientName("pkC", "c"))
+
+	main := readTestFile(t, mainPath)
+	require.NotContains(t, main, "tuna", "key removed from merged config")
+	require.NotContains(t, main, "identifier", "key of later file")
+	require.Equal(t, map[string]interface{}{"pkA": map[string]interface{}{"name": "a"}}, main["clients"])
+
+	yml := readTestFile(t, yamlPath)
+	require.Equal(t, map[string]interface{}{
+		"pkB": map[string]interface{}{"name": "b2"},
+		"pkC": map[string]interface{}{"name": "c"},
+	}, yml["clients"])
+	require.Equal(t, "svc", yml["tunaServiceName"])
+
+	tml := readTestFile(t, tomlPath)
+	require.Equal(t, "toml", tml["identifier"])
+	require.Equal(t, []interface{}{"DE"}, tml["tunaCountry"])
+
+	// Move tunaServiceName from YAML to TOML file by hand, then changes of it
+	// are saved to TOML file.
+	writeTestFile(t, yamlPath, `clients:
+  pkB:
+    name: b2
+  pkC:
+    name: c
+`)
+	writeTestFile(t, tomlPath, `identifier = "toml"
+tunaCountry = ["DE"]
+tunaServiceName = "svc"
+`)
+	c, err = LoadOrNewConfig(mainPath)
+	require.NoError(t, err)
+	require.NoError(t, c.SetTunaConfig("svc2", c.TunaCountry, nil, nil, nil, nil))
+
+	yml = readTestFile(t, yamlPath)
+	require.NotContains(t, yml, "tunaServiceName")
+	tml = readTestFile(t, tomlPath)
+	require.Equal(t, "svc2", tml["tunaServiceName"])
+	require.NotContains(t, readTestFile(t, mainPath), "tunaServiceName")
+}
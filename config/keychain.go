@@ -0,0 +1,78 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+const (
+	SeedStorageConfig   = "config"
+	SeedStorageKeychain = "keychain"
+
+	keychainService = "nConnect"
+)
+
+var (
+	errKeychainUnsupported = errors.New("OS keychain is not supported on this platform")
+)
+
+// seedInKeychain returns whether seed is kept in OS keychain instead of config
+// file.
+func (c *Config) seedInKeychain() bool {
+	return c.SeedStorage == SeedStorageKeychain
+}
+
+// loadKeychainSeed reads seed from OS keychain if seed is kept there. A seed
+// still in config file is moved to keychain on next save.
+func (c *Config) loadKeychainSeed() error {
+	if !c.seedInKeychain() || len(c.Seed) > 0 || len(c.SeedKeychainName) == 0 {
+		return nil
+	}
+	seed, err := keychainGet(c.SeedKeychainName)
+	if err != nil {
+		return fmt.Errorf("read seed from OS keychain error: %v", err)
+	}
+	c.Seed = seed
+	return nil
+}
+
+// saveKeychainSeed writes seed to OS keychain if seed is kept there. It
+// should be called with lock held before config is marshaled.
+func (c *Config) saveKeychainSeed() error {
+	if !c.seedInKeychain() || len(c.Seed) == 0 {
+		return nil
+	}
+	if len(c.SeedKeychainName) == 0 {
+		b := make([]byte, 8)
+		_, err := rand.Read(b)
+		if err != nil {
+			return err
+		}
+		c.SeedKeychainName = "seed-" + hex.EncodeToString(b)
+	} else if seed, err := keychainGet(c.SeedKeychainName); err == nil && seed == c.Seed {
+		return nil
+	}
+	err := keychainSet(c.SeedKeychainName, c.Seed)
+	if err != nil {
+		return fmt.Errorf("save seed to OS keychain error: %v", err)
+	}
+	return nil
+}
+
+// SetSeedStorage changes where seed is kept and saves config. Moving seed to
+// keychain removes it from config file.
+func (c *Config) SetSeedStorage(storage string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.SeedStorage == storage {
+		return nil
+	}
+	c.SeedStorage = storage
+	err := c.loadKeychainSeed()
+	if err != nil {
+		return err
+	}
+	return c.save()
+}
@@ -0,0 +1,25 @@
+package config
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keychainGet reads a generic password from macOS Keychain.
+func keychainGet(name string) (string, error) {
+	out, err := exec.Command("security", "find-generic-password", "-s", keychainService, "-a", name, "-w").Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keychainSet adds or updates a generic password in macOS Keychain.
+func keychainSet(name, secret string) error {
+	out, err := exec.Command("security", "add-generic-password", "-U", "-s", keychainService, "-a", name, "-l", keychainService+" seed", "-w", secret).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// keychainGet reads a secret from secret service (e.g. GNOME Keyring or
+// KWallet) with secret-tool.
+func keychainGet(name string) (string, error) {
+	out, err := exec.Command("secret-tool", "lookup", "service", keychainService, "account", name).Output()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// keychainSet stores a secret in secret service with secret-tool. The secret
+// is passed in stdin so it does not show up in process list.
+func keychainSet(name, secret string) error {
+	cmd := exec.Command("secret-tool", "store", "--label="+keychainService+" seed", "service", keychainService, "account", name)
+	cmd.Stdin = strings.NewReader(secret)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,12 @@
+//go:build !darwin && !linux && !windows
+// +build !darwin,!linux,!windows
+
+package config
+
+func keychainGet(name string) (string, error) {
+	return "", errKeychainUnsupported
+}
+
+func keychainSet(name, secret string) error {
+	return errKeychainUnsupported
+}
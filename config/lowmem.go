@@ -0,0 +1,39 @@
+package config
+
+import "log"
+
+const (
+	LowMemorySessionWindowSize = 512 << 10
+	LowMemoryNumSubClients     = 2
+	LowMemoryNumTunaListeners  = 1
+	LowMemoryMaxProxyConns     = 64
+	LowMemoryUDPRecvBufferSize = 128 << 10
+	LowMemoryMaxUDPDatagrams   = 128
+	LowMemoryGCPercent         = 50
+)
+
+// ApplyLowMemory changes config for devices with 32-64MB memory. Buffers and
+// concurrency are shrunk unless set explicitly, while web GUI and geo db
+// download are disabled.
+func (c *Config) ApplyLowMemory() {
+	if c.SessionWindowSize == 0 {
+		c.SessionWindowSize = LowMemorySessionWindowSize
+	}
+	if c.NumSubClients == 0 {
+		c.NumSubClients = LowMemoryNumSubClients
+	}
+	if c.AdminNumSubClients == 0 {
+		c.AdminNumSubClients = LowMemoryNumSubClients
+	}
+	if c.NumTunaListeners == 0 {
+		c.NumTunaListeners = LowMemoryNumTunaListeners
+	}
+	if c.MaxProxyConns == 0 {
+		c.MaxProxyConns = LowMemoryMaxProxyConns
+	}
+	if len(c.AdminHTTPAddr) > 0 {
+		log.Println("Admin web GUI is disabled in low memory mode")
+		c.AdminHTTPAddr = ""
+	}
+	c.TunaDisableDownloadGeoDB = true
+}
@@ -0,0 +1,8 @@
+//go:build lowmem
+// +build lowmem
+
+package config
+
+// LowMemoryBuild is true when built with lowmem tag, which enables low memory
+// mode by default.
+const LowMemoryBuild = true
@@ -0,0 +1,26 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+var errMachineIDUnsupported = errors.New("machine ID is not supported on this platform")
+
+// MachineBinding returns a hash of the OS machine ID, so a config can tell
+// whether it is still on the machine its identifier was generated on without
+// keeping the machine ID itself.
+func MachineBinding() (string, error) {
+	id, err := machineID()
+	if err != nil {
+		return "", err
+	}
+	id = strings.ToLower(strings.TrimSpace(id))
+	if len(id) == 0 {
+		return "", errors.New("machine ID is empty")
+	}
+	h := sha256.Sum256([]byte("nConnect-machine:" + id))
+	return hex.EncodeToString(h[:16]), nil
+}
@@ -0,0 +1,22 @@
+package config
+
+import (
+	"errors"
+	"os/exec"
+	"regexp"
+)
+
+var platformUUIDRegex = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+// machineID reads hardware UUID of the Mac.
+func machineID() (string, error) {
+	out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return "", err
+	}
+	m := platformUUIDRegex.FindSubmatch(out)
+	if m == nil {
+		return "", errors.New("IOPlatformUUID not found")
+	}
+	return string(m[1]), nil
+}
@@ -0,0 +1,17 @@
+package config
+
+import (
+	"os"
+)
+
+// machineID reads systemd or D-Bus machine ID.
+func machineID() (string, error) {
+	b, err := os.ReadFile("/etc/machine-id")
+	if err != nil {
+		b, err = os.ReadFile("/var/lib/dbus/machine-id")
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
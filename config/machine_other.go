@@ -0,0 +1,8 @@
+//go:build !darwin && !linux && !windows
+// +build !darwin,!linux,!windows
+
+package config
+
+func machineID() (string, error) {
+	return "", errMachineIDUnsupported
+}
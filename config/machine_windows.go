@@ -0,0 +1,33 @@
+package config
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// machineID reads MachineGuid generated at Windows installation.
+func machineID() (string, error) {
+	path, err := syscall.UTF16PtrFromString(`SOFTWARE\Microsoft\Cryptography`)
+	if err != nil {
+		return "", err
+	}
+	var key syscall.Handle
+	err = syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, path, 0, syscall.KEY_READ|syscall.KEY_WOW64_64KEY, &key)
+	if err != nil {
+		return "", err
+	}
+	defer syscall.RegCloseKey(key)
+
+	name, err := syscall.UTF16PtrFromString("MachineGuid")
+	if err != nil {
+		return "", err
+	}
+	buf := make([]uint16, 64)
+	n := uint32(len(buf) * 2)
+	var typ uint32
+	err = syscall.RegQueryValueEx(key, name, nil, &typ, (*byte)(unsafe.Pointer(&buf[0])), &n)
+	if err != nil {
+		return "", err
+	}
+	return syscall.UTF16ToString(buf), nil
+}
@@ -0,0 +1,124 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+const (
+	configVersionKey = "configVersion"
+)
+
+var (
+	// configMigrations[i] upgrades config values of version i+1 to version
+	// i+2 in place. Append a migration when a config field is renamed or its
+	// value changes meaning, e.g. renameConfigField(m, "oldName", "newName").
+	configMigrations = []func(m map[string]interface{}) error{}
+
+	// ConfigVersion is the version of config written by this nConnect. Config
+	// without version is version 1.
+	ConfigVersion = 1 + len(configMigrations)
+)
+
+// renameConfigField renames a top level config field. The value of the new
+// name is kept if both exist.
+func renameConfigField(m map[string]interface{}, from, to string) {
+	v, ok := m[from]
+	if !ok {
+		return
+	}
+	delete(m, from)
+	if _, ok := m[to]; !ok {
+		m[to] = v
+	}
+}
+
+// configVersionOf returns the config version of decoded config values m.
+func configVersionOf(m map[string]interface{}) (int, error) {
+	v, ok := m[configVersionKey]
+	if !ok {
+		return 1, nil
+	}
+	var version int64
+	switch v := v.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			return 0, fmt.Errorf("invalid config version %v", v)
+		}
+		version = n
+	case int:
+		version = int64(v)
+	case int64:
+		version = v
+	case uint64:
+		version = int64(v)
+	case float64:
+		version = int64(v)
+	default:
+		return 0, fmt.Errorf("invalid config version %v", v)
+	}
+	if version < 1 {
+		return 0, fmt.Errorf("invalid config version %d", version)
+	}
+	return int(version), nil
+}
+
+// migrateConfigMap upgrades config values m of version from to the current
+// version in place.
+func migrateConfigMap(m map[string]interface{}, from int) error {
+	for v := from; v < ConfigVersion; v++ {
+		err := configMigrations[v-1](m)
+		if err != nil {
+			return fmt.Errorf("migrate config from version %d error: %v", v, err)
+		}
+	}
+	return nil
+}
+
+// migrateConfig upgrades content b of main config file in format to the
+// current version in memory. It returns the upgraded content and the version
+// it is upgraded from, or b and 0 if it is already current.
+func migrateConfig(b []byte, format string) ([]byte, int, error) {
+	m, err := decodeConfigMap(b, format)
+	if err != nil {
+		return nil, 0, err
+	}
+	from, err := configVersionOf(m)
+	if err != nil {
+		return nil, 0, err
+	}
+	if from > ConfigVersion {
+		return nil, 0, fmt.Errorf("config version %d is newer than version %d supported by this nConnect, please upgrade nConnect", from, ConfigVersion)
+	}
+	if from == ConfigVersion {
+		return b, 0, nil
+	}
+	err = migrateConfigMap(m, from)
+	if err != nil {
+		return nil, 0, err
+	}
+	m[configVersionKey] = ConfigVersion
+	b, err = encodeConfigMap(m, format)
+	if err != nil {
+		return nil, 0, err
+	}
+	return b, from, nil
+}
+
+// backupMigrated copies main config file and included files on disk to files
+// with .bak extension before they are overwritten by migrated config.
+func (c *Config) backupMigrated() error {
+	for _, path := range c.FilePaths() {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(path+backupExt, b, fileMode)
+		if err != nil {
+			return fmt.Errorf("back up config before migration error: %v", err)
+		}
+	}
+	return nil
+}
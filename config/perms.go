@@ -0,0 +1,66 @@
+package config
+
+import (
+	"os"
+)
+
+const (
+	// fileMode is the permission of files with the seed and other secrets
+	// written by nConnect, so only their owner can access them.
+	fileMode os.FileMode = 0600
+
+	// sharedFileMode is the permission of config files written by nConnect
+	// when secrets are kept in a separate secrets file, so GUIs and other
+	// tools can read them.
+	sharedFileMode os.FileMode = 0644
+)
+
+// permFile is a file whose permission and ownership are checked.
+type permFile struct {
+	path   string
+	kind   string
+	secret bool // has the seed or other secrets
+}
+
+// permFiles returns main config file, included files and secrets file.
+func (c *Config) permFiles() []permFile {
+	files := make([]permFile, 0)
+	for _, path := range c.FilePaths() {
+		files = append(files, permFile{path: path, kind: "config file", secret: !c.secretsInFile()})
+	}
+	if c.secretsInFile() {
+		files = append(files, permFile{path: c.secretsPath(c.path), kind: "secrets file", secret: true})
+	}
+	return files
+}
+
+// CheckFilePerms returns permission and ownership problems of main config
+// file, included files and secrets file, such as secrets being readable by
+// other users.
+func (c *Config) CheckFilePerms() []string {
+	var problems []string
+	for _, f := range c.permFiles() {
+		fi, err := os.Stat(f.path)
+		if err != nil {
+			continue
+		}
+		problems = append(problems, checkFilePerms(f, fi)...)
+	}
+	return problems
+}
+
+// FixFilePerms makes files with secrets only accessible by their owner, and
+// other config files only writable by their owner. Ownership is not changed.
+func (c *Config) FixFilePerms() error {
+	for _, f := range c.permFiles() {
+		_, err := os.Stat(f.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		err = fixFilePerms(f.path, f.secret)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
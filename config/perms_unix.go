@@ -0,0 +1,35 @@
+//go:build !windows
+// +build !windows
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func checkFilePerms(f permFile, fi os.FileInfo) []string {
+	var problems []string
+	if f.secret && fi.Mode().Perm()&0077 != 0 {
+		problems = append(problems, fmt.Sprintf("%s %s can be accessed by other users (%v), but may have the seed and other secrets", f.kind, f.path, fi.Mode().Perm()))
+	} else if !f.secret && fi.Mode().Perm()&0022 != 0 {
+		problems = append(problems, fmt.Sprintf("%s %s can be changed by other users (%v)", f.kind, f.path, fi.Mode().Perm()))
+	}
+	// A file owned by root can't be changed by other users either
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Geteuid() && st.Uid != 0 {
+		problems = append(problems, fmt.Sprintf("%s %s is owned by uid %d instead of the user running nConnect (uid %d), so that user can change it, run chown %d %s", f.kind, f.path, st.Uid, os.Geteuid(), os.Geteuid(), f.path))
+	}
+	return problems
+}
+
+func fixFilePerms(path string, secret bool) error {
+	if secret {
+		return os.Chmod(path, fileMode)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(path, fi.Mode().Perm()&^0022)
+}
@@ -0,0 +1,15 @@
+package config
+
+import (
+	"os"
+)
+
+// Windows has no permission bits for group and others, files are protected
+// by ACLs of user profile directory instead.
+func checkFilePerms(f permFile, fi os.FileInfo) []string {
+	return nil
+}
+
+func fixFilePerms(path string, secret bool) error {
+	return nil
+}
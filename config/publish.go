@@ -0,0 +1,66 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	PublishIdentifierPrefix = "publish"
+)
+
+// PublishedService is an HTTP service on a client that the server publishes
+// on its web server, so it can be reached without port forwarding on the
+// client side.
+type PublishedService struct {
+	Name       string `json:"name"`              // published under /publish/<name>/
+	Host       string `json:"host,omitempty"`    // also published at the root of this hostname
+	ClientAddr string `json:"clientAddr"`        // publish address of the client
+	Service    string `json:"service,omitempty"` // name of local service on the client, same as name if empty
+	Auth       string `json:"auth,omitempty"`    // user:password required by basic auth
+	Public     bool   `json:"public,omitempty"`  // allow access without auth
+}
+
+// LocalService is a local TCP service a client lets remote servers publish.
+type LocalService struct {
+	Name string `json:"name"`
+	Addr string `json:"addr"`
+}
+
+// verifyPublishedServices checks published services of server.
+func (c *Config) verifyPublishedServices() error {
+	if len(c.PublishedServices) == 0 {
+		return nil
+	}
+	if len(c.PublishAddr) == 0 && len(c.AdminHTTPAddr) == 0 {
+		return errors.New("publishedServices needs publishAddr or adminHttpAddr")
+	}
+	names := make(map[string]bool, len(c.PublishedServices))
+	for i, s := range c.PublishedServices {
+		if len(s.Name) == 0 || strings.Contains(s.Name, "/") {
+			return fmt.Errorf("publishedServices[%d]: name should be non-empty without /", i)
+		}
+		if names[s.Name] {
+			return fmt.Errorf("publishedServices[%d]: duplicated name %s", i, s.Name)
+		}
+		names[s.Name] = true
+		if len(s.ClientAddr) == 0 {
+			return fmt.Errorf("publishedServices[%d]: clientAddr should not be empty", i)
+		}
+		if !s.Public && !strings.Contains(s.Auth, ":") {
+			return fmt.Errorf("publishedServices[%d]: auth should be user:password unless public is true", i)
+		}
+	}
+	return nil
+}
+
+// verifyLocalServices checks local services of client.
+func (c *Config) verifyLocalServices() error {
+	for i, s := range c.LocalServices {
+		if len(s.Name) == 0 || len(s.Addr) == 0 {
+			return fmt.Errorf("localServices[%d]: name and addr should not be empty", i)
+		}
+	}
+	return nil
+}
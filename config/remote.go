@@ -0,0 +1,108 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// RemoteServer is a remote server admin address with options of the tunnel
+// to it, so servers of one client can differ, e.g. a far away one needs a
+// longer dial timeout. In config file it is either an address string, or an
+// object if it has options. Options not set fall back to client config.
+type RemoteServer struct {
+	Addr              string   `json:"addr"`
+	DialTimeout       int32    `json:"dialTimeout,omitempty"`       // in milliseconds
+	SessionWindowSize int32    `json:"sessionWindowSize,omitempty"` // in bytes
+	Tuna              *bool    `json:"tuna,omitempty"`              // whether to use tuna sessions to this server
+	Labels            []string `json:"labels,omitempty"`            // free form labels, e.g. region or provider
+}
+
+// HasTunnelOptions returns whether the tunnel to this server differs from the
+// one of client config.
+func (s RemoteServer) HasTunnelOptions() bool {
+	return s.DialTimeout != 0 || s.SessionWindowSize != 0 || s.Tuna != nil
+}
+
+func (s RemoteServer) String() string {
+	return s.Addr
+}
+
+// UnmarshalFlag parses an address given in command line.
+func (s *RemoteServer) UnmarshalFlag(value string) error {
+	*s = RemoteServer{Addr: value}
+	return nil
+}
+
+func (s *RemoteServer) UnmarshalJSON(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '"' {
+		*s = RemoteServer{}
+		return json.Unmarshal(b, &s.Addr)
+	}
+	// remoteServer has no methods so it is decoded as a plain struct
+	type remoteServer RemoteServer
+	return json.Unmarshal(b, (*remoteServer)(s))
+}
+
+// MarshalJSON encodes a server without options as its address string, so
+// config files without options stay unchanged.
+func (s RemoteServer) MarshalJSON() ([]byte, error) {
+	if !s.HasTunnelOptions() && len(s.Labels) == 0 {
+		return json.Marshal(s.Addr)
+	}
+	type remoteServer RemoteServer
+	return json.Marshal(remoteServer(s))
+}
+
+// RemoteAdminAddrs returns the admin addresses of remote servers.
+func (c *Config) RemoteAdminAddrs() []string {
+	addrs := make([]string, len(c.RemoteAdminAddr))
+	for i, s := range c.RemoteAdminAddr {
+		addrs[i] = s.Addr
+	}
+	return addrs
+}
+
+// GetRemoteServer returns remote server of admin address addr, which has no
+// options if addr is not in remoteAdminAddr.
+func (c *Config) GetRemoteServer(addr string) RemoteServer {
+	for _, s := range c.RemoteAdminAddr {
+		if s.Addr == addr {
+			return s
+		}
+	}
+	return RemoteServer{Addr: addr}
+}
+
+// AddRemoteAdminAddr adds remote server admin address addr without options,
+// and returns false if it is already there.
+func (c *Config) AddRemoteAdminAddr(addr string) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, s := range c.RemoteAdminAddr {
+		if s.Addr == addr {
+			return false, nil
+		}
+	}
+	c.RemoteAdminAddr = append(c.RemoteAdminAddr, RemoteServer{Addr: addr})
+	return true, c.save()
+}
+
+func (c *Config) verifyRemoteServers() error {
+	for i, s := range c.RemoteAdminAddr {
+		if len(s.Addr) == 0 {
+			return fmt.Errorf("remoteAdminAddr[%d]: addr should not be empty", i)
+		}
+		if s.DialTimeout < 0 || s.SessionWindowSize < 0 {
+			return fmt.Errorf("remoteAdminAddr[%d]: dialTimeout and sessionWindowSize should not be negative", i)
+		}
+		if s.SessionWindowSize > 0 && c.SessionMTU > 0 && s.SessionWindowSize < c.SessionMTU {
+			return fmt.Errorf("remoteAdminAddr[%d]: sessionWindowSize should not be less than sessionMTU", i)
+		}
+		if s.Tuna != nil && !*s.Tuna && c.UDP {
+			return fmt.Errorf("remoteAdminAddr[%d]: tuna should not be disabled when udp is enabled", i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const schemaDraft = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema returns JSON Schema of config file generated from Config struct
+// tags: json names, flag descriptions, defaults and choices. Fields of nested
+// objects without omitempty are required. Unknown fields are allowed, as they
+// are ignored when config is loaded.
+func Schema() ([]byte, error) {
+	s := structSchema(reflect.TypeOf(Config{}), false)
+	s["$schema"] = schemaDraft
+	s["title"] = "nConnect config"
+	return json.MarshalIndent(s, "", "  ")
+}
+
+func typeSchema(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		// nil slices are saved as null
+		return map[string]interface{}{"type": []string{"array", "null"}, "items": typeSchema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": []string{"object", "null"}, "additionalProperties": typeSchema(t.Elem())}
+	case reflect.Struct:
+		if t == reflect.TypeOf(RemoteServer{}) || t == reflect.TypeOf(AddrEntry{}) {
+			// address string or object with options or metadata
+			return map[string]interface{}{"anyOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				structSchema(t, true),
+			}}
+		}
+		return structSchema(t, true)
+	}
+	return map[string]interface{}{}
+}
+
+// structSchema returns schema of a struct type. Embedded structs are
+// flattened like encoding/json does.
+func structSchema(t reflect.Type, nested bool) map[string]interface{} {
+	props := make(map[string]interface{})
+	var required []string
+
+	var addFields func(t reflect.Type)
+	addFields = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous && f.Type.Kind() == reflect.Struct {
+				addFields(f.Type)
+				continue
+			}
+			if len(f.PkgPath) > 0 {
+				continue
+			}
+			name, omitempty := jsonFieldName(f)
+			if name == "-" {
+				continue
+			}
+
+			s := typeSchema(f.Type)
+			if desc := f.Tag.Get("description"); len(desc) > 0 {
+				s["description"] = desc
+			}
+			if choices := tagValues(f.Tag, "choice"); len(choices) > 0 {
+				s["enum"] = choices
+			}
+			if defaults := tagValues(f.Tag, "default"); len(defaults) > 0 {
+				if v, ok := schemaDefault(f.Type, defaults); ok {
+					s["default"] = v
+				}
+			}
+			props[name] = s
+
+			if nested && !omitempty {
+				required = append(required, name)
+			}
+		}
+	}
+	addFields(t)
+
+	s := map[string]interface{}{
+		"type":       "object",
+		"properties": props,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+// jsonFieldName returns the json name of a field and whether it has
+// omitempty option.
+func jsonFieldName(f reflect.StructField) (string, bool) {
+	tag := f.Tag.Get("json")
+	parts := strings.Split(tag, ",")
+	name := parts[0]
+	if len(name) == 0 {
+		name = f.Name
+	}
+	for _, opt := range parts[1:] {
+		if opt == "omitempty" {
+			return name, true
+		}
+	}
+	return name, false
+}
+
+// tagValues returns all values of a struct tag key, as go-flags allows
+// repeated default and choice tags.
+func tagValues(tag reflect.StructTag, key string) []string {
+	var values []string
+	prefix := key + ":"
+	s := string(tag)
+	for {
+		i := strings.Index(s, prefix)
+		if i < 0 {
+			return values
+		}
+		if i > 0 && s[i-1] != ' ' {
+			s = s[i+len(prefix):]
+			continue
+		}
+		s = s[i+len(prefix):]
+		v, err := strconv.QuotedPrefix(s)
+		if err != nil {
+			return values
+		}
+		s = s[len(v):]
+		v, err = strconv.Unquote(v)
+		if err != nil {
+			return values
+		}
+		values = append(values, v)
+	}
+}
+
+// schemaDefault converts default tag values to the JSON type of a field.
+func schemaDefault(t reflect.Type, defaults []string) (interface{}, bool) {
+	if t.Kind() == reflect.Slice {
+		values := make([]interface{}, 0, len(defaults))
+		for _, d := range defaults {
+			v, ok := schemaDefault(t.Elem(), []string{d})
+			if !ok {
+				return nil, false
+			}
+			values = append(values, v)
+		}
+		return values, true
+	}
+
+	d := defaults[0]
+	switch t.Kind() {
+	case reflect.Bool:
+		v, err := strconv.ParseBool(d)
+		return v, err == nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err := strconv.ParseInt(d, 10, 64)
+		return v, err == nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v, err := strconv.ParseUint(d, 10, 64)
+		return v, err == nil
+	case reflect.Float32, reflect.Float64:
+		v, err := strconv.ParseFloat(d, 64)
+		return v, err == nil
+	case reflect.String:
+		return d, true
+	}
+	return nil, false
+}
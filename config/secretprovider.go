@@ -0,0 +1,114 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSecretField is the field of a structured secret holding seed if
+	// not given in seed provider.
+	defaultSecretField    = "seed"
+	secretProviderTimeout = 10 * time.Second
+)
+
+var (
+	ErrSeedFromProvider = errors.New("seed is fetched from secret provider and never saved, change it in the secrets manager instead")
+
+	secretProviderLock sync.RWMutex
+	secretProviders    = map[string]SecretProvider{
+		"vault": vaultProvider{},
+		"awssm": awsSecretsManagerProvider{},
+	}
+
+	secretProviderClient = &http.Client{Timeout: secretProviderTimeout}
+)
+
+// SecretProvider fetches secrets from a secrets manager.
+type SecretProvider interface {
+	// GetSecret returns field of secret at path. A provider of unstructured
+	// secrets may return the whole secret if it is not structured.
+	GetSecret(path, field string) (string, error)
+}
+
+// RegisterSecretProvider makes provider p available to seed provider URIs
+// with scheme, e.g. to use a secrets manager not built in when nConnect is
+// used as library.
+func RegisterSecretProvider(scheme string, p SecretProvider) {
+	secretProviderLock.Lock()
+	defer secretProviderLock.Unlock()
+	secretProviders[scheme] = p
+}
+
+// parseSecretURI parses a secret URI like scheme://path#field.
+func parseSecretURI(uri string) (SecretProvider, string, string, error) {
+	scheme, rest, ok := strings.Cut(uri, "://")
+	if !ok || len(rest) == 0 {
+		return nil, "", "", fmt.Errorf("invalid seed provider %q, should be like vault://path#field", uri)
+	}
+	secretProviderLock.RLock()
+	p, ok := secretProviders[scheme]
+	secretProviderLock.RUnlock()
+	if !ok {
+		return nil, "", "", fmt.Errorf("unknown seed provider %q", scheme)
+	}
+	path, field, _ := strings.Cut(rest, "#")
+	if len(field) == 0 {
+		field = defaultSecretField
+	}
+	return p, path, field, nil
+}
+
+// FetchSeed fetches seed from the secrets manager of seed provider uri.
+func FetchSeed(uri string) (string, error) {
+	p, path, field, err := parseSecretURI(uri)
+	if err != nil {
+		return "", err
+	}
+	seed, err := p.GetSecret(path, field)
+	if err != nil {
+		return "", fmt.Errorf("fetch seed from %s error: %v", uri, err)
+	}
+	seed = strings.TrimSpace(seed)
+	err = verifySeed(seed)
+	if err != nil {
+		return "", fmt.Errorf("seed from %s: %v", uri, err)
+	}
+	return seed, nil
+}
+
+// SetSeedFromProvider sets seed fetched from a secret provider. It is never
+// written to config file, secrets file or OS keychain when config is saved.
+func (c *Config) SetSeedFromProvider(seed string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.Seed = seed
+	c.seedExternal = ErrSeedFromProvider
+}
+
+// secretField returns field of a secret that is a JSON object of strings, or
+// the secret itself if it is not.
+func secretField(secret, field string) (string, error) {
+	var m map[string]interface{}
+	if json.Unmarshal([]byte(secret), &m) != nil {
+		return secret, nil
+	}
+	return mapField(m, field)
+}
+
+func mapField(m map[string]interface{}, field string) (string, error) {
+	v, ok := m[field]
+	if !ok {
+		return "", fmt.Errorf("field %q not found in secret", field)
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q of secret is not a string", field)
+	}
+	return s, nil
+}
@@ -0,0 +1,159 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// secretsJSON is the content of secrets file.
+type secretsJSON struct {
+	Seed     string `json:"seed,omitempty"` // not kept here if seed is in OS keychain
+	Password string `json:"password,omitempty"`
+}
+
+// secretsInFile returns whether seed and socks password are kept in a
+// separate secrets file instead of config file.
+func (c *Config) secretsInFile() bool {
+	return len(c.SecretsFile) > 0
+}
+
+// secretsPath returns the path of secrets file of config file at configPath,
+// or empty if secrets are kept in config file. A relative secrets file is in
+// the directory of config file.
+func (c *Config) secretsPath(configPath string) string {
+	if !c.secretsInFile() {
+		return ""
+	}
+	if filepath.IsAbs(c.SecretsFile) || len(configPath) == 0 {
+		return c.SecretsFile
+	}
+	return filepath.Join(filepath.Dir(configPath), c.SecretsFile)
+}
+
+// SecretsPath returns the path of secrets file, or empty if seed and socks
+// password are kept in config file.
+func (c *Config) SecretsPath() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.secretsPath(c.path)
+}
+
+// hasPlainSecrets returns whether config file has secrets that should be
+// kept in OS keychain or secrets file instead.
+func (c *Config) hasPlainSecrets() bool {
+	if len(c.Seed) > 0 && (c.seedInKeychain() || c.secretsInFile()) {
+		return true
+	}
+	return len(c.Password) > 0 && c.secretsInFile()
+}
+
+// configFileMode returns the permission of config files written by nConnect.
+func (c *Config) configFileMode() os.FileMode {
+	if c.secretsInFile() {
+		return sharedFileMode
+	}
+	return fileMode
+}
+
+// loadSecrets reads secrets from secrets file of config file at configPath if
+// it is used. Secrets still in config file take precedence, and are moved to
+// secrets file on next save.
+func (c *Config) loadSecrets(configPath string) error {
+	path := c.secretsPath(configPath)
+	if len(path) == 0 {
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("read secrets file error: %v", err)
+	}
+	s := &secretsJSON{}
+	err = json.Unmarshal(b, s)
+	if err != nil {
+		return fmt.Errorf("parse secrets file %s error: %v", path, err)
+	}
+	if len(c.Seed) == 0 {
+		c.Seed = s.Seed
+	}
+	if len(c.Password) == 0 {
+		c.Password = s.Password
+	}
+	return nil
+}
+
+// saveSecrets writes secrets to secrets file if it is used, so that only its
+// owner can access it. It should be called with lock held before config is
+// marshaled.
+func (c *Config) saveSecrets() error {
+	path := c.secretsPath(c.path)
+	if len(path) == 0 {
+		return nil
+	}
+	s := &secretsJSON{Password: c.Password}
+	if !c.seedInKeychain() {
+		s.Seed = c.Seed
+	}
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	old, err := os.ReadFile(path)
+	if err == nil && bytes.Equal(old, b) {
+		return nil
+	}
+	if os.IsNotExist(err) && len(s.Seed) == 0 && len(s.Password) == 0 {
+		return nil
+	}
+	err = os.WriteFile(path, b, fileMode)
+	if err != nil {
+		return fmt.Errorf("save secrets file error: %v", err)
+	}
+	// WriteFile keeps permission of an existing file
+	return fixFilePerms(path, true)
+}
+
+// SetSecretsFile changes the secrets file seed and socks password are kept in
+// and saves config. Empty path moves them back to config file. The old
+// secrets file is removed.
+func (c *Config) SetSecretsFile(path string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.SecretsFile == path {
+		return nil
+	}
+	old := c.secretsPath(c.path)
+	c.SecretsFile = path
+	err := c.save()
+	if err != nil {
+		return err
+	}
+	if len(old) > 0 && old != c.secretsPath(c.path) {
+		err = os.Remove(old)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Secrets returns secret values of c, which are redacted wherever config is
+// printed or shared, e.g. effective config and support bundle: seed, socks
+// proxy password, password hashes, TOTP secrets and backup codes of web users,
+// and basic auth of published services.
+func (c *Config) Secrets() []string {
+	secrets := []string{c.Seed, c.Password}
+	for _, s := range c.PublishedServices {
+		secrets = append(secrets, s.Auth)
+	}
+	for _, u := range c.GetWebUsers() {
+		secrets = append(secrets, u.PasswordHash, u.TOTPSecret)
+		secrets = append(secrets, u.TOTPBackupCodes...)
+	}
+	return secrets
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// SeedFileStdin is the seed file name to read seed from stdin.
+	SeedFileStdin = "-"
+)
+
+var (
+	ErrSeedFromFile = errors.New("seed is read from seed file and never saved, change it in seed file instead")
+)
+
+// ReadSeedFile reads seed from the first line of a file, or of stdin if path
+// is "-", e.g. injected by an orchestration system.
+func ReadSeedFile(path string) (string, error) {
+	var line string
+	var err error
+	if path == SeedFileStdin {
+		line, err = bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && len(line) == 0 {
+			return "", fmt.Errorf("read seed from stdin error: %v", err)
+		}
+	} else {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("read seed file error: %v", err)
+		}
+		line = strings.SplitN(string(b), "\n", 2)[0]
+	}
+	seed := strings.TrimSpace(line)
+	err = verifySeed(seed)
+	if err != nil {
+		return "", fmt.Errorf("seed file %s: %v", path, err)
+	}
+	return seed, nil
+}
+
+// SetSeedFromFile sets seed read from seed file. It is never written to
+// config file, secrets file or OS keychain when config is saved.
+func (c *Config) SetSeedFromFile(seed string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.Seed = seed
+	c.seedExternal = ErrSeedFromFile
+}
@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ConnTagRule attaches a tag (e.g. work or streaming) to client connections
+// whose target matches, so traffic can be broken down by tag.
+type ConnTagRule struct {
+	Tag     string   `json:"tag"`
+	Targets []string `json:"targets"`         // IP, CIDR or domain, a domain also matches its subdomains
+	Ports   []int    `json:"ports,omitempty"` // only targets on these ports, all ports if empty
+}
+
+// ParseTargets splits targets of rule into CIDRs and lower case domains.
+func (r *ConnTagRule) ParseTargets() ([]*net.IPNet, []string, error) {
+	var ips, domains []string
+	for _, t := range r.Targets {
+		t = strings.TrimSpace(t)
+		if len(t) == 0 {
+			return nil, nil, fmt.Errorf("empty target")
+		}
+		if strings.Contains(t, "/") || net.ParseIP(t) != nil {
+			ips = append(ips, t)
+		} else {
+			domains = append(domains, strings.ToLower(strings.Trim(t, ".")))
+		}
+	}
+	cidrs, err := ParseAllowedIPs(ips)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cidrs, domains, nil
+}
+
+// verifyConnTags checks connection tag rules of client.
+func (c *Config) verifyConnTags() error {
+	for i, r := range c.ConnTags {
+		if len(r.Tag) == 0 || len(r.Targets) == 0 {
+			return fmt.Errorf("connTags[%d]: tag and targets should not be empty", i)
+		}
+		_, _, err := r.ParseTargets()
+		if err != nil {
+			return fmt.Errorf("connTags[%d]: %v", i, err)
+		}
+		for _, p := range r.Ports {
+			if p <= 0 || p > 65535 {
+				return fmt.Errorf("connTags[%d]: invalid port %d", i, p)
+			}
+		}
+	}
+	return nil
+}
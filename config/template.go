@@ -0,0 +1,183 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// templateEntry is one config field of a template with why it is set.
+type templateEntry struct {
+	key     string
+	value   interface{}
+	comment string
+}
+
+// Template is a config pre-filled with recommended options for a deployment
+// scenario.
+type Template struct {
+	Name        string
+	Description string
+	Server      bool
+	Identifier  string // empty to generate on first run
+	Seed        string // empty to generate on first run
+	entries     []templateEntry
+}
+
+var templates = []*Template{
+	{
+		Name:        "home-server",
+		Description: "Server at home that family devices and travel clients connect to",
+		Server:      true,
+		entries: []templateEntry{
+			{"tuna", true, "Relay through tuna service nodes for higher throughput"},
+			{"udp", true, "Proxy UDP as well, e.g. for DNS and games"},
+			{"adminIdentifier", "nConnect", "Admin address prefix clients use to pair with this server"},
+			{"adminHttpAddr", "127.0.0.1:8000", "Admin web GUI, only reachable from this machine"},
+			{"directListenAddr", "0.0.0.0:30080", "Let clients on the same LAN connect directly"},
+			{"loopbackDummyCipher", true, "Skip socks proxy encryption on loopback, NKN tunnel is end to end encrypted"},
+			{"configBackups", 10, "Keep config backups to undo admin changes"},
+			{"acceptAddrs", []string{}, "Client addresses allowed to connect, add with the web GUI or admin RPC"},
+			{"adminAddrs", []string{}, "Client addresses allowed to manage this server"},
+		},
+	},
+	{
+		Name:        "travel-client",
+		Description: "Laptop or phone that routes traffic through home server while traveling",
+		entries: []templateEntry{
+			{"remoteAdminAddr", []string{}, "Admin address of your server(s), the first one is the default"},
+			{"localSocksAddr", "127.0.0.1:1080", "Local socks proxy, only reachable from this device"},
+			{"tuna", true, "Relay through tuna service nodes for higher throughput"},
+			{"udp", true, "Proxy UDP as well, e.g. for DNS and video calls"},
+			{"vpn", true, "Route all traffic through the server, might require root privilege"},
+			{"vpnRoute", []string{"0.0.0.0/0"}, "Send everything through VPN"},
+			{"direct", true, "Connect directly when the server is reachable, e.g. back at home"},
+			{"loopbackDummyCipher", true, "Skip socks proxy encryption on loopback, NKN tunnel is end to end encrypted"},
+		},
+	},
+	{
+		Name:        "router-gateway",
+		Description: "Router that provides the tunnel to all devices of a LAN",
+		entries: []templateEntry{
+			{"remoteAdminAddr", []string{}, "Admin address of your server(s), the first one is the default"},
+			{"localSocksAddr", "0.0.0.0:1080", "Socks proxy for LAN devices"},
+			{"socksAllowedIPs", []string{"192.168.0.0/16", "10.0.0.0/8", "172.16.0.0/12"}, "Only private networks may use the socks proxy"},
+			{"tuna", true, "Relay through tuna service nodes for higher throughput"},
+			{"udp", true, "Proxy UDP as well"},
+			{"vpn", true, "Route traffic of LAN devices through the server"},
+			{"lowMemory", true, "Shrink buffers for routers with little memory"},
+			{"log", "/tmp/nconnect.log", "Log to tmpfs instead of flash storage"},
+			{"logMaxBackups", 1, "Keep only one old log file"},
+		},
+	},
+	{
+		Name:        "headless-iot",
+		Description: "Unattended device that needs to reach a server, e.g. a sensor or camera",
+		entries: []templateEntry{
+			{"remoteAdminAddr", []string{}, "Admin address of your server(s)"},
+			{"localSocksAddr", "127.0.0.1:1080", "Local socks proxy, only reachable from this device"},
+			{"loopbackDummyCipher", true, "Skip socks proxy encryption on loopback to save CPU"},
+			{"lowMemory", true, "Shrink buffers for devices with little memory"},
+			{"disableNATDetection", true, "Skip STUN requests to save traffic"},
+			{"log", "nconnect.log", "Log to file so it can be fetched later"},
+			{"logMaxSize", 1, "Rotate log at 1 MB"},
+			{"logMaxBackups", 1, "Keep only one old log file"},
+		},
+	},
+}
+
+// TemplateNames returns names of all config templates.
+func TemplateNames() []string {
+	names := make([]string, len(templates))
+	for i, t := range templates {
+		names[i] = t.Name
+	}
+	return names
+}
+
+// GetTemplate returns the config template of the given name.
+func GetTemplate(name string) (*Template, error) {
+	for _, t := range templates {
+		if t.Name == name {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown template %q, should be one of %s", name, strings.Join(TemplateNames(), ", "))
+}
+
+// NewTemplate returns an empty config template, e.g. to be filled by answers
+// of setup wizard.
+func NewTemplate(name, description string, server bool) *Template {
+	return &Template{
+		Name:        name,
+		Description: description,
+		Server:      server,
+	}
+}
+
+// Set sets a config field of the template with why it is set. Fields keep
+// the order they are first set.
+func (t *Template) Set(key string, value interface{}, comment string) {
+	for i, e := range t.entries {
+		if e.key == key {
+			t.entries[i] = templateEntry{key, value, comment}
+			return
+		}
+	}
+	t.entries = append(t.entries, templateEntry{key, value, comment})
+}
+
+// Values returns config fields set by the template, which can be verified
+// with Config.WithPartial.
+func (t *Template) Values() map[string]interface{} {
+	values := make(map[string]interface{}, len(t.entries))
+	for _, e := range t.entries {
+		values[e.key] = e.value
+	}
+	return values
+}
+
+// JSON returns the template as a config file, with fields in the order of
+// template so related options stay together.
+func (t *Template) JSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString("{\n")
+	fmt.Fprintf(&buf, "    \"Client\": %v,\n    \"Server\": %v,\n", !t.Server, t.Server)
+	fmt.Fprintf(&buf, "    \"identifier\": %q,\n    \"seed\": %q", t.Identifier, t.Seed)
+	for _, e := range t.entries {
+		b, err := json.Marshal(e.value)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&buf, ",\n    %q: %s", e.key, b)
+	}
+	buf.WriteString("\n}\n")
+	return buf.Bytes(), nil
+}
+
+// Marshal returns the template as a config file in the given format. Field
+// order is only kept for JSON.
+func (t *Template) Marshal(format string) ([]byte, error) {
+	b, err := t.JSON()
+	if err != nil {
+		return nil, err
+	}
+	return convertJSON(b, format)
+}
+
+// Comments returns why each field of the template is set, one per line.
+// JSON config has no comments, so they are shown separately.
+func (t *Template) Comments() string {
+	width := 0
+	for _, e := range t.entries {
+		if len(e.key) > width {
+			width = len(e.key)
+		}
+	}
+	lines := make([]string, len(t.entries))
+	for i, e := range t.entries {
+		lines[i] = fmt.Sprintf("  %-*s  %s", width, e.key, e.comment)
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP as in RFC 6238 with the parameters authenticator apps use by default.
+const (
+	totpPeriod     = 30 // in seconds
+	totpDigits     = 6
+	totpModulo     = 1000000 // 10^totpDigits
+	totpSkew       = 1       // steps accepted before and after the current one
+	totpSecretSize = 20
+
+	numTOTPBackupCodes = 10
+	totpBackupCodeSize = 5 // in bytes
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret returns a random base32 encoded TOTP secret.
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretSize)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI returns the otpauth URI of secret, which authenticator apps can scan
+// as QR code.
+func TOTPURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + v.Encode()
+}
+
+func totpCode(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	h := hmac.New(sha1.New, key)
+	h.Write(msg[:])
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0xf
+	n := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, n%totpModulo)
+}
+
+// verifyTOTP returns the time step code is valid for, and false if code is
+// not valid at now or its step is not after lastStep, so a code can only be
+// used once.
+func verifyTOTP(secret, code string, now time.Time, lastStep int64) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	current := now.Unix() / totpPeriod
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		if step <= lastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, step)), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+func hashTOTPBackupCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// newTOTPBackupCodes returns random one-time backup codes and their hashes.
+func newTOTPBackupCodes() ([]string, []string, error) {
+	codes := make([]string, numTOTPBackupCodes)
+	hashes := make([]string, numTOTPBackupCodes)
+	for i := range codes {
+		b := make([]byte, totpBackupCodeSize)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, nil, err
+		}
+		codes[i] = hex.EncodeToString(b)
+		hashes[i] = hashTOTPBackupCode(codes[i])
+	}
+	return codes, hashes, nil
+}
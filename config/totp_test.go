@@ -0,0 +1,61 @@
+package config
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Secret of the RFC 6238 SHA1 test vectors, whose codes are the last
+// totpDigits digits of the published ones.
+var testTOTPSecret = totpEncoding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTOTPCode(t *testing.T) {
+	tests := []struct {
+		time int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		step, ok := verifyTOTP(testTOTPSecret, tt.code, time.Unix(tt.time, 0), 0)
+		require.True(t, ok, "time %d", tt.time)
+		require.Equal(t, tt.time/totpPeriod, step)
+	}
+}
+
+func TestVerifyTOTP(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+	current := now.Unix() / totpPeriod
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		name     string
+		code     string
+		lastStep int64
+		ok       bool
+	}{
+		{name: "current step", code: totpCode(key, current), ok: true},
+		{name: "previous step", code: totpCode(key, current-1), ok: true},
+		{name: "next step", code: totpCode(key, current+1), ok: true},
+		{name: "two steps before", code: totpCode(key, current-2)},
+		{name: "two steps after", code: totpCode(key, current+2)},
+		{name: "code reused", code: totpCode(key, current), lastStep: current},
+		{name: "earlier code after later one", code: totpCode(key, current-1), lastStep: current},
+		{name: "code after earlier one", code: totpCode(key, current), lastStep: current - 1, ok: true},
+		{name: "wrong length", code: totpCode(key, current)[1:]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ok := verifyTOTP(testTOTPSecret, tt.code, now, tt.lastStep)
+			require.Equal(t, tt.ok, ok)
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConfigProblem is a combination of config fields that do not work together.
+type ConfigProblem struct {
+	Message string
+	Fatal   bool // config does not work as is, otherwise some fields are ignored
+}
+
+// TunSubnet returns the subnet of a TUN device address. Mask is a prefixlen
+// for IPv6 address.
+func TunSubnet(addr, mask string) (*net.IPNet, error) {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid TUN address %s", addr)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		m := net.ParseIP(mask).To4()
+		if m == nil {
+			return nil, fmt.Errorf("invalid TUN mask %s", mask)
+		}
+		return &net.IPNet{IP: ip4.Mask(net.IPMask(m)), Mask: net.IPMask(m)}, nil
+	}
+	prefixlen, err := strconv.Atoi(mask)
+	if err != nil {
+		return nil, fmt.Errorf("parse IPv6 prefixlen failed: %v", err)
+	}
+	m := net.CIDRMask(prefixlen, 8*net.IPv6len)
+	return &net.IPNet{IP: ip.Mask(m), Mask: m}, nil
+}
+
+// CrossFieldProblems returns all combinations of fields that do not work
+// together in client or server mode, so they can be reported at once instead
+// of failing one by one at runtime. Invalid values of single fields are left
+// to VerifyClient and VerifyServer.
+func (c *Config) CrossFieldProblems(client bool) []ConfigProblem {
+	var problems []ConfigProblem
+	fatal := func(format string, a ...interface{}) {
+		problems = append(problems, ConfigProblem{Message: fmt.Sprintf(format, a...), Fatal: true})
+	}
+	ignored := func(format string, a ...interface{}) {
+		problems = append(problems, ConfigProblem{Message: fmt.Sprintf(format, a...)})
+	}
+
+	if c.UDP && !c.Tuna && (!client || c.usesDefaultTuna()) {
+		fatal("udp needs tuna to be enabled, as UDP is only supported over tuna sessions")
+	}
+	if c.UDPIdleTime > 0 && !c.UDP {
+		ignored("udpIdleTime is ignored as udp is not enabled")
+	}
+
+	if client {
+		c.tunProblems(fatal, ignored)
+	} else if !c.Tuna {
+		var fields []string
+		for name, set := range map[string]bool{
+			"tunaCountry":         len(c.TunaCountry) > 0,
+			"tunaAllowIp":         len(c.TunaAllowIp) > 0,
+			"tunaDisallowIp":      len(c.TunaDisallowIp) > 0,
+			"tunaAllowNknAddr":    len(c.TunaAllowNknAddr) > 0,
+			"tunaDisallowNknAddr": len(c.TunaDisallowNknAddr) > 0,
+			"tunaServiceName":     len(c.TunaServiceName) > 0,
+		} {
+			if set {
+				fields = append(fields, name)
+			}
+		}
+		if len(fields) > 0 {
+			ignored("%s ignored as tuna is not enabled", joinFields(fields))
+		}
+	}
+	if !client && c.AdminHTTPMetrics && len(c.AdminHTTPAddr) == 0 {
+		ignored("adminHttpMetrics is ignored as adminHttpAddr is empty")
+	}
+
+	return problems
+}
+
+// usesDefaultTuna returns whether any tunnel of a client uses top level tuna
+// config instead of its own.
+func (c *Config) usesDefaultTuna() bool {
+	if len(c.RemoteTunnelAddr) > 0 || len(c.RemoteAdminAddr) == 0 {
+		return true
+	}
+	for _, s := range c.RemoteAdminAddr {
+		if s.Tuna == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// tunProblems adds problems of TUN and VPN config of a client.
+func (c *Config) tunProblems(fatal, ignored func(string, ...interface{})) {
+	devices := c.TunDevices
+	if len(devices) == 0 {
+		if !c.Tun && !c.VPN {
+			if len(c.VPNRoute) > 0 {
+				ignored("vpnRoute is ignored as neither tun nor vpn is enabled")
+			}
+			return
+		}
+		devices = []TunDeviceConfig{{Addr: c.TunAddr, Gateway: c.TunGateway, Mask: c.TunMask}}
+	}
+
+	switch runtime.GOOS {
+	case "linux", "darwin", "windows":
+	default:
+		fatal("tun and vpn are not supported on %s, use socks proxy instead", runtime.GOOS)
+	}
+
+	subnets := make([]*net.IPNet, len(devices))
+	for i, d := range devices {
+		gatewayField, addrField := "tunGateway", "tunAddr and tunMask"
+		if len(c.TunDevices) > 0 {
+			gatewayField, addrField = fmt.Sprintf("tunDevices[%d].gateway", i), fmt.Sprintf("tunDevices[%d].addr and mask", i)
+		}
+		subnet, err := TunSubnet(d.Addr, d.Mask)
+		if err != nil {
+			continue // reported with hints by check-config
+		}
+		subnets[i] = subnet
+		gateway := net.ParseIP(d.Gateway)
+		if gateway == nil {
+			continue
+		}
+		if gateway.Equal(net.ParseIP(d.Addr)) {
+			fatal("%s %s should differ from the TUN device address", gatewayField, d.Gateway)
+		} else if !subnet.Contains(gateway) {
+			fatal("%s %s is not in subnet %s of %s", gatewayField, d.Gateway, subnet, addrField)
+		}
+		for j := 0; j < i; j++ {
+			if subnets[j] != nil && (subnets[j].Contains(subnet.IP) || subnet.Contains(subnets[j].IP)) {
+				fatal("tunDevices[%d] subnet %s overlaps tunDevices[%d] subnet %s, packets could not be told apart", i, subnet, j, subnets[j])
+			}
+		}
+	}
+}
+
+// joinFields returns field names sorted and joined for a problem message.
+func joinFields(fields []string) string {
+	sort.Strings(fields)
+	if len(fields) == 1 {
+		return fields[0] + " is"
+	}
+	return strings.Join(fields[:len(fields)-1], ", ") + " and " + fields[len(fields)-1] + " are"
+}
+
+// ConfigProblemsError is returned by VerifyClient and VerifyServer with all
+// fatal cross field problems.
+type ConfigProblemsError []ConfigProblem
+
+func (e ConfigProblemsError) Error() string {
+	if len(e) == 1 {
+		return e[0].Message
+	}
+	msgs := make([]string, len(e))
+	for i, p := range e {
+		msgs[i] = p.Message
+	}
+	return fmt.Sprintf("%d config problems: %s", len(e), strings.Join(msgs, "; "))
+}
+
+// verifyCrossFields returns a ConfigProblemsError with all fatal cross field
+// problems, or nil if there is none.
+func (c *Config) verifyCrossFields(client bool) error {
+	var problems ConfigProblemsError
+	for _, p := range c.CrossFieldProblems(client) {
+		if p.Fatal {
+			problems = append(problems, p)
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return problems
+}
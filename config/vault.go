@@ -0,0 +1,66 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+)
+
+var (
+	errVaultNotConfigured = errors.New("VAULT_ADDR and VAULT_TOKEN should be set")
+)
+
+// vaultProvider reads secrets from HashiCorp Vault KV secrets engine, version
+// 1 or 2, with address, token and optional namespace from the standard
+// VAULT_ADDR, VAULT_TOKEN and VAULT_NAMESPACE env. For KV version 2, path
+// includes data, e.g. secret/data/nconnect.
+type vaultProvider struct{}
+
+func (vaultProvider) GetSecret(path, field string) (string, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if len(addr) == 0 || len(token) == 0 {
+		return "", errVaultNotConfigured
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); len(ns) > 0 {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	resp, err := secretProviderClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var res struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	err = json.Unmarshal(b, &res)
+	if err != nil {
+		return "", err
+	}
+	data := res.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok { // KV version 2
+			data = nested
+		}
+	}
+	return mapField(data, field)
+}
@@ -0,0 +1,279 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	WebRoleAdmin  = "admin"
+	WebRoleViewer = "viewer"
+
+	minWebPasswordLength = 8
+)
+
+var (
+	ErrWebUserNotFound    = errors.New("web user not found")
+	ErrWrongWebPassword   = errors.New("wrong user name or password")
+	errInvalidWebRole     = errors.New("web user role should be admin or viewer")
+	errEmptyWebUserName   = errors.New("web user name should not be empty")
+	errShortWebPassword   = errors.New("web user password should have at least 8 characters")
+	errSameWebPassword    = errors.New("new password should be different from the old one")
+	errRemoveLastWebAdmin = errors.New("cannot remove or demote the last web admin")
+
+	ErrWebTOTPRequired   = errors.New("two-factor code required")
+	ErrWrongWebTOTPCode  = errors.New("wrong two-factor code")
+	errWebTOTPNotEnabled = errors.New("two-factor authentication is not enabled")
+)
+
+// WebUser is a named admin web GUI user.
+type WebUser struct {
+	Name               string `json:"name"`
+	Role               string `json:"role"`
+	PasswordHash       string `json:"passwordHash"`
+	LastLogin          int64  `json:"lastLogin,omitempty"`          // unix time
+	MustChangePassword bool   `json:"mustChangePassword,omitempty"` // set when password is set by someone else
+
+	// Two-factor authentication is required at login when TOTPSecret is set.
+	TOTPSecret      string   `json:"totpSecret,omitempty"`      // base32
+	TOTPBackupCodes []string `json:"totpBackupCodes,omitempty"` // sha256 of unused backup codes
+	TOTPLastStep    int64    `json:"totpLastStep,omitempty"`    // time step of the last used code
+}
+
+// HasTOTP returns whether two-factor authentication is enabled for u.
+func (u *WebUser) HasTOTP() bool {
+	return len(u.TOTPSecret) > 0
+}
+
+func hashWebPassword(password string) (string, error) {
+	if len(password) < minWebPasswordLength {
+		return "", errShortWebPassword
+	}
+	b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (c *Config) findWebUser(name string) int {
+	for i, u := range c.WebUsers {
+		if u.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (c *Config) numWebAdmins() int {
+	n := 0
+	for _, u := range c.WebUsers {
+		if u.Role == WebRoleAdmin {
+			n++
+		}
+	}
+	return n
+}
+
+// GetWebUsers returns a copy of web users.
+func (c *Config) GetWebUsers() []WebUser {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	users := make([]WebUser, len(c.WebUsers))
+	copy(users, c.WebUsers)
+	return users
+}
+
+// HasWebUsers returns whether web users are configured, in which case web
+// requests need to log in.
+func (c *Config) HasWebUsers() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.WebUsers) > 0
+}
+
+// SetWebUser adds a web user or replaces the role and password of an existing
+// one. The user has to change password at next login.
+func (c *Config) SetWebUser(name, role, password string) error {
+	if len(name) == 0 {
+		return errEmptyWebUserName
+	}
+	if role != WebRoleAdmin && role != WebRoleViewer {
+		return errInvalidWebRole
+	}
+	hash, err := hashWebPassword(password)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	u := WebUser{Name: name, Role: role, PasswordHash: hash, MustChangePassword: true}
+	if i := c.findWebUser(name); i >= 0 {
+		if c.WebUsers[i].Role == WebRoleAdmin && role != WebRoleAdmin && c.numWebAdmins() == 1 {
+			return errRemoveLastWebAdmin
+		}
+		old := c.WebUsers[i]
+		u.LastLogin = old.LastLogin
+		u.TOTPSecret, u.TOTPBackupCodes, u.TOTPLastStep = old.TOTPSecret, old.TOTPBackupCodes, old.TOTPLastStep
+		c.WebUsers[i] = u
+	} else {
+		c.WebUsers = append(c.WebUsers, u)
+	}
+	return c.save()
+}
+
+// RemoveWebUser removes a web user. The last admin can only be removed when
+// it is the last user, which disables web login.
+func (c *Config) RemoveWebUser(name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	i := c.findWebUser(name)
+	if i < 0 {
+		return ErrWebUserNotFound
+	}
+	if c.WebUsers[i].Role == WebRoleAdmin && c.numWebAdmins() == 1 && len(c.WebUsers) > 1 {
+		return errRemoveLastWebAdmin
+	}
+	c.WebUsers = append(c.WebUsers[:i], c.WebUsers[i+1:]...)
+	return c.save()
+}
+
+// AuthenticateWebUser checks password of a web user, and two-factor code if
+// enabled, which is either a TOTP code or an unused backup code. The login time
+// is recorded on success.
+func (c *Config) AuthenticateWebUser(name, password, code string) (*WebUser, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	i := c.findWebUser(name)
+	if i < 0 {
+		return nil, ErrWrongWebPassword
+	}
+	if bcrypt.CompareHashAndPassword([]byte(c.WebUsers[i].PasswordHash), []byte(password)) != nil {
+		return nil, ErrWrongWebPassword
+	}
+	if c.WebUsers[i].HasTOTP() {
+		if len(code) == 0 {
+			return nil, ErrWebTOTPRequired
+		}
+		if !c.useWebTOTPCode(i, code) {
+			return nil, ErrWrongWebTOTPCode
+		}
+	}
+	c.WebUsers[i].LastLogin = time.Now().Unix()
+	u := c.WebUsers[i]
+	return &u, c.save()
+}
+
+// ChangeWebPassword changes password of a web user after checking the old
+// one, and clears forced password change.
+func (c *Config) ChangeWebPassword(name, oldPassword, newPassword string) error {
+	if oldPassword == newPassword {
+		return errSameWebPassword
+	}
+	hash, err := hashWebPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	i := c.findWebUser(name)
+	if i < 0 {
+		return ErrWrongWebPassword
+	}
+	if bcrypt.CompareHashAndPassword([]byte(c.WebUsers[i].PasswordHash), []byte(oldPassword)) != nil {
+		return ErrWrongWebPassword
+	}
+	c.WebUsers[i].PasswordHash = hash
+	c.WebUsers[i].MustChangePassword = false
+	return c.save()
+}
+
+// useWebTOTPCode checks code against TOTP secret and backup codes of the i-th
+// web user, and marks it used if valid. It should be called with lock held.
+func (c *Config) useWebTOTPCode(i int, code string) bool {
+	u := &c.WebUsers[i]
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if step, ok := verifyTOTP(u.TOTPSecret, code, time.Now(), u.TOTPLastStep); ok {
+		u.TOTPLastStep = step
+		return true
+	}
+	hash := hashTOTPBackupCode(code)
+	for j, h := range u.TOTPBackupCodes {
+		if h == hash {
+			u.TOTPBackupCodes = append(u.TOTPBackupCodes[:j:j], u.TOTPBackupCodes[j+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// EnableWebTOTP enables two-factor authentication of a web user with secret,
+// or replaces the existing one, after checking a code generated from it. The
+// returned backup codes can each be used once instead of a TOTP code.
+func (c *Config) EnableWebTOTP(name, secret, code string) ([]string, error) {
+	step, ok := verifyTOTP(secret, strings.TrimSpace(code), time.Now(), 0)
+	if !ok {
+		return nil, ErrWrongWebTOTPCode
+	}
+	codes, hashes, err := newTOTPBackupCodes()
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	i := c.findWebUser(name)
+	if i < 0 {
+		return nil, ErrWebUserNotFound
+	}
+	c.WebUsers[i].TOTPSecret = secret
+	c.WebUsers[i].TOTPBackupCodes = hashes
+	c.WebUsers[i].TOTPLastStep = step
+	return codes, c.save()
+}
+
+// DisableWebTOTP disables two-factor authentication of a web user after
+// checking a TOTP or backup code.
+func (c *Config) DisableWebTOTP(name, code string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	i := c.findWebUser(name)
+	if i < 0 {
+		return ErrWebUserNotFound
+	}
+	if !c.WebUsers[i].HasTOTP() {
+		return errWebTOTPNotEnabled
+	}
+	if !c.useWebTOTPCode(i, code) {
+		return ErrWrongWebTOTPCode
+	}
+	c.clearWebTOTP(i)
+	return c.save()
+}
+
+// ResetWebTOTP disables two-factor authentication of a web user without a
+// code, for users who lost both their authenticator and backup codes.
+func (c *Config) ResetWebTOTP(name string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	i := c.findWebUser(name)
+	if i < 0 {
+		return ErrWebUserNotFound
+	}
+	if !c.WebUsers[i].HasTOTP() {
+		return errWebTOTPNotEnabled
+	}
+	c.clearWebTOTP(i)
+	return c.save()
+}
+
+func (c *Config) clearWebTOTP(i int) {
+	c.WebUsers[i].TOTPSecret = ""
+	c.WebUsers[i].TOTPBackupCodes = nil
+	c.WebUsers[i].TOTPLastStep = 0
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebTOTP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeTestFile(t, path, `{"acceptAddrs": [], "adminAddrs": []}`)
+	c, err := LoadOrNewConfig(path)
+	require.NoError(t, err)
+	require.NoError(t, c.SetWebUser("alice", WebRoleAdmin, "password"))
+
+	secret, err := NewTOTPSecret()
+	require.NoError(t, err)
+	key, err := totpEncoding.DecodeString(secret)
+	require.NoError(t, err)
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	backupCodes, err := c.EnableWebTOTP("alice", secret, code)
+	require.NoError(t, err)
+	require.Len(t, backupCodes, numTOTPBackupCodes)
+
+	_, err = c.AuthenticateWebUser("alice", "password", "")
+	require.ErrorIs(t, err, ErrWebTOTPRequired)
+	_, err = c.AuthenticateWebUser("alice", "password", code)
+	require.ErrorIs(t, err, ErrWrongWebTOTPCode, "code used to enable reused")
+	_, err = c.AuthenticateWebUser("alice", "wrong password", backupCodes[0])
+	require.ErrorIs(t, err, ErrWrongWebPassword)
+
+	for i, backupCode := range backupCodes {
+		_, err = c.AuthenticateWebUser("alice", "password", backupCode)
+		require.NoError(t, err, "backup code %d", i)
+		_, err = c.AuthenticateWebUser("alice", "password", backupCode)
+		require.ErrorIs(t, err, ErrWrongWebTOTPCode, "backup code %d reused", i)
+		require.Len(t, c.WebUsers[0].TOTPBackupCodes, numTOTPBackupCodes-i-1)
+	}
+
+	// Backup codes are used up, and only TOTP codes are accepted.
+	require.ErrorIs(t, c.DisableWebTOTP("alice", backupCodes[0]), ErrWrongWebTOTPCode)
+	code = totpCode(key, time.Now().Unix()/totpPeriod+1)
+	require.NoError(t, c.DisableWebTOTP("alice", code))
+	require.False(t, c.WebUsers[0].HasTOTP())
+
+	u, err := c.AuthenticateWebUser("alice", "password", "")
+	require.NoError(t, err)
+	require.Equal(t, "alice", u.Name)
+}
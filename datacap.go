@@ -0,0 +1,38 @@
+package nconnect
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/monitor"
+	"github.com/nknorg/nconnect/ss"
+)
+
+// startDataCap counts monthly traffic of this node in state storage, flushed
+// to a write-ahead journal in between, and suspends proxying while monthly
+// data cap (or quota given by server in client mode) is exceeded until ctx is
+// done. bytes returns traffic since start.
+func (nc *nconnect) startDataCap(ctx context.Context, bytes func() int64) error {
+	store, err := nc.persistConf.GetStorage()
+	if err != nil {
+		return err
+	}
+	nc.dataCap = monitor.NewDataCap(store, config.DataCapCheckInterval, bytes, func(suspended bool, reason string) {
+		if suspended {
+			log.Printf("Monthly data cap exceeded (%s), suspend proxying", reason)
+		} else {
+			log.Printf("Monthly data cap not exceeded anymore (%s), resume proxying", reason)
+		}
+		ss.SetSuspended(suspended)
+		admin.BumpRevision()
+	})
+	nc.dataCap.SetLimits(nc.opts.MonthlyDataCapBytes(), nc.opts.DataCapResetDay, nc.opts.DataCapWarnPercent)
+	nc.dataCap.SetJournal(filepath.Join(nc.opts.GetStateDir(), monitor.DataUsageJournalFile), time.Duration(nc.opts.DataUsageFlushInterval)*time.Second)
+	admin.SetDataCap(nc.dataCap)
+	go nc.dataCap.Start(ctx)
+	return nil
+}
@@ -0,0 +1,261 @@
+package direct
+
+import (
+	"context"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/events"
+	"github.com/nknorg/nconnect/monitor"
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const (
+	probeInterval  = 30 * time.Second
+	udpBufSize     = 64 * 1024
+	udpIdleTimeout = 10 * time.Minute
+)
+
+// Forwarder listens on a local address and forwards each TCP connection to
+// the server directly when a direct path is available, or to fallback (the
+// local NKN tunnel listener) otherwise. UDP is always relayed to fallback.
+type Forwarder struct {
+	listenAddr string
+	account    *nkn.Account
+	localAddr  string
+	serverAddr string
+	candidates []string
+	fallback   string
+	udp        bool
+	verbose    bool
+
+	lock    sync.RWMutex
+	active  string
+	reprobe chan struct{}
+}
+
+// NewForwarder creates a forwarder for the server with tunnel address
+// serverAddr. localAddr is the client NKN address used to authenticate and
+// candidates are the server's direct addresses to try in order.
+func NewForwarder(listenAddr string, account *nkn.Account, localAddr, serverAddr string, candidates []string, fallback string, udp, verbose bool) *Forwarder {
+	return &Forwarder{
+		listenAddr: listenAddr,
+		account:    account,
+		localAddr:  localAddr,
+		serverAddr: serverAddr,
+		candidates: candidates,
+		fallback:   fallback,
+		udp:        udp,
+		verbose:    verbose,
+		reprobe:    make(chan struct{}, 1),
+	}
+}
+
+// Reprobe checks direct addresses again at once instead of waiting for the
+// next probe, e.g. after system resumed from suspend and the direct path in
+// use may be gone.
+func (f *Forwarder) Reprobe() {
+	select {
+	case f.reprobe <- struct{}{}:
+	default:
+	}
+}
+
+// Active returns the direct address in use, or empty string if connections
+// currently fall back to the NKN tunnel.
+func (f *Forwarder) Active() string {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+	return f.active
+}
+
+func (f *Forwarder) setActive(addr string) {
+	f.lock.Lock()
+	changed := f.active != addr
+	f.active = addr
+	f.lock.Unlock()
+	if changed {
+		events.Publish(events.RelayChanged{Remote: f.serverAddr, Relay: addr, Direct: len(addr) > 0})
+		if len(addr) > 0 {
+			log.Printf("Using direct connection %s to %s", addr, f.serverAddr)
+		} else {
+			log.Printf("Direct connection to %s unavailable, using NKN tunnel", f.serverAddr)
+		}
+	}
+}
+
+func (f *Forwarder) dialDirect(addr string) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	ec, err := clientHandshake(conn, f.account, f.localAddr, f.serverAddr)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ec, nil
+}
+
+func (f *Forwarder) probe() {
+	for _, addr := range f.candidates {
+		conn, err := f.dialDirect(addr)
+		if err != nil {
+			if f.verbose {
+				log.Printf("Probe direct connection %s error: %v", addr, err)
+			}
+			continue
+		}
+		conn.Close()
+		f.setActive(addr)
+		return
+	}
+	f.setActive("")
+}
+
+// Start serves connections until the listener fails or ctx is done. It
+// returns nil if stopped by ctx.
+func (f *Forwarder) Start(ctx context.Context) error {
+	l, err := net.Listen("tcp", f.listenAddr)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+
+	go func() {
+		for {
+			f.probe()
+			select {
+			case <-time.After(probeInterval):
+			case <-f.reprobe:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	if f.udp {
+		go func() {
+			err := f.relayUDP(ctx)
+			if err != nil {
+				log.Println("Relay UDP error:", err)
+			}
+		}()
+	}
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go f.handleConn(conn)
+	}
+}
+
+func (f *Forwarder) handleConn(conn net.Conn) {
+	if addr := f.Active(); len(addr) > 0 {
+		toConn, err := f.dialDirect(addr)
+		if err == nil {
+			pipe(conn, toConn)
+			return
+		}
+		log.Printf("Dial direct connection %s error: %v", addr, err)
+		f.setActive("")
+	}
+
+	toConn, err := net.DialTimeout("tcp", f.fallback, dialTimeout)
+	if err != nil {
+		log.Println(err)
+		conn.Close()
+		return
+	}
+	pipe(conn, toConn)
+}
+
+// relayUDP relays UDP packets between local sources and fallback, keeping one
+// upstream socket per source so replies reach the right sender, until ctx is
+// done.
+func (f *Forwarder) relayUDP(ctx context.Context) error {
+	pc, err := net.ListenPacket("udp", f.listenAddr)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+	go func() {
+		<-ctx.Done()
+		pc.Close()
+	}()
+
+	fallbackAddr, err := net.ResolveUDPAddr("udp", f.fallback)
+	if err != nil {
+		return err
+	}
+
+	var lock sync.Mutex
+	upstreams := make(map[string]*net.UDPConn)
+
+	buf := make([]byte, udpBufSize)
+	for {
+		n, src, err := pc.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		lock.Lock()
+		upstream, ok := upstreams[src.String()]
+		if !ok {
+			upstream, err = net.DialUDP("udp", nil, fallbackAddr)
+			if err != nil {
+				lock.Unlock()
+				log.Println(err)
+				continue
+			}
+			upstreams[src.String()] = upstream
+			go func(src net.Addr, upstream *net.UDPConn) {
+				defer func() {
+					lock.Lock()
+					delete(upstreams, src.String())
+					lock.Unlock()
+					upstream.Close()
+				}()
+				b := make([]byte, udpBufSize)
+				for {
+					setAt := time.Now()
+					upstream.SetReadDeadline(setAt.Add(udpIdleTimeout))
+					n, err := upstream.Read(b)
+					if err != nil {
+						if monitor.TimedOutLate(err, setAt, udpIdleTimeout) {
+							continue // system was suspended, not idle
+						}
+						return
+					}
+					_, err = pc.WriteTo(b[:n], src)
+					if err != nil {
+						return
+					}
+				}
+			}(src, upstream)
+		}
+		lock.Unlock()
+
+		_, err = upstream.Write(buf[:n])
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
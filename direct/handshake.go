@@ -0,0 +1,200 @@
+package direct
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	stream "github.com/nknorg/encrypted-stream"
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn/v2/crypto/ed25519"
+	"golang.org/x/crypto/nacl/box"
+)
+
+const (
+	handshakeVersion = 1
+	handshakeTimeout = 10 * time.Second
+	nonceSize        = 32
+	maxAddrSize      = 512
+	keyConfirmation  = "nConnect-direct-v1"
+)
+
+var (
+	errInvalidPubkey     = errors.New("invalid public key")
+	errVersionMismatch   = errors.New("direct connection version mismatch")
+	errKeyConfirmation   = errors.New("direct connection key confirmation failed")
+	errAddrNotAccepted   = errors.New("address is not accepted")
+	errInvalidNKNAddress = errors.New("invalid NKN address")
+)
+
+// pubKeyFromAddr returns the public key part of an NKN client address.
+func pubKeyFromAddr(addr string) ([]byte, error) {
+	pubKeyHex := addr
+	if i := strings.LastIndex(addr, "."); i >= 0 {
+		pubKeyHex = addr[i+1:]
+	}
+	pk, err := hex.DecodeString(pubKeyHex)
+	if err != nil || len(pk) != ed25519.PublicKeySize {
+		return nil, errInvalidNKNAddress
+	}
+	return pk, nil
+}
+
+// sessionKey derives a per-connection key from the NKN key pairs of both
+// sides and the nonces they exchanged. Only the holder of the private key of
+// either address can compute it.
+func sessionKey(account *nkn.Account, remotePubKey, clientNonce, serverNonce []byte) (*[32]byte, error) {
+	var pk [ed25519.PublicKeySize]byte
+	copy(pk[:], remotePubKey)
+	curvePubKey, ok := ed25519.PublicKeyToCurve25519PublicKey(&pk)
+	if !ok {
+		return nil, errInvalidPubkey
+	}
+	var sk [ed25519.PrivateKeySize]byte
+	copy(sk[:], account.PrivateKey)
+	curvePrivKey := ed25519.PrivateKeyToCurve25519PrivateKey(&sk)
+
+	var shared [32]byte
+	box.Precompute(&shared, curvePubKey, curvePrivKey)
+
+	h := sha256.New()
+	h.Write(shared[:])
+	h.Write(clientNonce)
+	h.Write(serverNonce)
+	key := new([32]byte)
+	copy(key[:], h.Sum(nil))
+	return key, nil
+}
+
+func encryptedConn(conn net.Conn, key *[32]byte, initiator bool) (net.Conn, error) {
+	return stream.NewEncryptedStream(conn, &stream.Config{
+		Cipher:          stream.NewXSalsa20Poly1305Cipher(key),
+		Initiator:       initiator,
+		SequentialNonce: true,
+	})
+}
+
+// clientHandshake authenticates to the server owning serverAddr and returns an
+// encrypted connection. Both sides send a key confirmation, so the handshake
+// fails unless each peer owns the private key of its claimed address.
+func clientHandshake(conn net.Conn, account *nkn.Account, localAddr, serverAddr string) (net.Conn, error) {
+	serverPubKey, err := pubKeyFromAddr(serverAddr)
+	if err != nil {
+		return nil, err
+	}
+	if len(localAddr) > maxAddrSize {
+		return nil, fmt.Errorf("address length %d exceeds %d", len(localAddr), maxAddrSize)
+	}
+
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	clientNonce := make([]byte, nonceSize)
+	rand.Read(clientNonce)
+
+	req := make([]byte, 0, 3+len(localAddr)+nonceSize)
+	req = append(req, handshakeVersion)
+	req = binary.BigEndian.AppendUint16(req, uint16(len(localAddr)))
+	req = append(req, localAddr...)
+	req = append(req, clientNonce...)
+	_, err = conn.Write(req)
+	if err != nil {
+		return nil, err
+	}
+
+	serverNonce := make([]byte, nonceSize)
+	_, err = io.ReadFull(conn, serverNonce)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := sessionKey(account, serverPubKey, clientNonce, serverNonce)
+	if err != nil {
+		return nil, err
+	}
+	ec, err := encryptedConn(conn, key, true)
+	if err != nil {
+		return nil, err
+	}
+	_, err = ec.Write([]byte(keyConfirmation))
+	if err != nil {
+		return nil, err
+	}
+	confirmation := make([]byte, len(keyConfirmation))
+	_, err = io.ReadFull(ec, confirmation)
+	if err != nil || !bytes.Equal(confirmation, []byte(keyConfirmation)) {
+		return nil, errKeyConfirmation
+	}
+	return ec, nil
+}
+
+// serverHandshake authenticates an incoming connection, returning the
+// encrypted connection and the client NKN address. accept decides whether the
+// claimed client address is allowed; the claim itself is verified by key
+// confirmation, which fails unless the client owns that address' private key.
+func serverHandshake(conn net.Conn, account *nkn.Account, accept func(string) bool) (net.Conn, string, error) {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+
+	header := make([]byte, 3)
+	_, err := io.ReadFull(conn, header)
+	if err != nil {
+		return nil, "", err
+	}
+	if header[0] != handshakeVersion {
+		return nil, "", errVersionMismatch
+	}
+	addrLen := int(binary.BigEndian.Uint16(header[1:3]))
+	if addrLen > maxAddrSize {
+		return nil, "", fmt.Errorf("address length %d exceeds %d", addrLen, maxAddrSize)
+	}
+	b := make([]byte, addrLen+nonceSize)
+	_, err = io.ReadFull(conn, b)
+	if err != nil {
+		return nil, "", err
+	}
+	clientAddr := string(b[:addrLen])
+	clientNonce := b[addrLen:]
+
+	clientPubKey, err := pubKeyFromAddr(clientAddr)
+	if err != nil {
+		return nil, "", err
+	}
+	if !accept(clientAddr) {
+		return nil, "", errAddrNotAccepted
+	}
+
+	serverNonce := make([]byte, nonceSize)
+	rand.Read(serverNonce)
+	_, err = conn.Write(serverNonce)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, err := sessionKey(account, clientPubKey, clientNonce, serverNonce)
+	if err != nil {
+		return nil, "", err
+	}
+	ec, err := encryptedConn(conn, key, false)
+	if err != nil {
+		return nil, "", err
+	}
+	confirmation := make([]byte, len(keyConfirmation))
+	_, err = io.ReadFull(ec, confirmation)
+	if err != nil || !bytes.Equal(confirmation, []byte(keyConfirmation)) {
+		return nil, "", errKeyConfirmation
+	}
+	_, err = ec.Write([]byte(keyConfirmation))
+	if err != nil {
+		return nil, "", err
+	}
+	return ec, clientAddr, nil
+}
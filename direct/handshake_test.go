@@ -0,0 +1,246 @@
+package direct
+
+import (
+	"encoding/hex"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAccount(t *testing.T) (*nkn.Account, string) {
+	account, err := nkn.NewAccount(nil)
+	require.NoError(t, err)
+	return account, "nConnect." + hex.EncodeToString(account.PubKey())
+}
+
+func acceptAll(string) bool { return true }
+
+type handshakeResult struct {
+	conn       net.Conn
+	clientAddr string
+	err        error
+}
+
+// runHandshake runs client and server handshake over conns, closing a side
+// that fails so the other side does not wait for it.
+func runHandshake(clientConn, serverConn net.Conn, clientAccount *nkn.Account, clientAddr, serverAddr string, serverAccount *nkn.Account, accept func(string) bool) (client, server handshakeResult) {
+	serverDone := make(chan handshakeResult, 1)
+	go func() {
+		ec, addr, err := serverHandshake(serverConn, serverAccount, accept)
+		if err != nil {
+			serverConn.Close()
+		}
+		serverDone <- handshakeResult{conn: ec, clientAddr: addr, err: err}
+	}()
+	ec, err := clientHandshake(clientConn, clientAccount, clientAddr, serverAddr)
+	if err != nil {
+		clientConn.Close()
+	}
+	return handshakeResult{conn: ec, err: err}, <-serverDone
+}
+
+func TestHandshake(t *testing.T) {
+	clientAccount, clientAddr := newTestAccount(t)
+	serverAccount, serverAddr := newTestAccount(t)
+
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	client, server := runHandshake(c, s, clientAccount, clientAddr, serverAddr, serverAccount, acceptAll)
+	require.NoError(t, client.err)
+	require.NoError(t, server.err)
+	require.Equal(t, clientAddr, server.clientAddr)
+
+	go client.conn.Write([]byte("ping"))
+	b := make([]byte, 4)
+	_, err := io.ReadFull(server.conn, b)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(b))
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	clientAccount, clientAddr := newTestAccount(t)
+	serverAccount, serverAddr := newTestAccount(t)
+	otherAccount, otherAddr := newTestAccount(t)
+
+	tests := []struct {
+		name          string
+		clientAccount *nkn.Account
+		clientAddr    string
+		serverAddr    string
+		serverAccount *nkn.Account
+		accept        func(string) bool
+		clientErr     error
+		serverErr     error
+	}{
+		{
+			name:          "wrong server static key",
+			clientAccount: clientAccount,
+			clientAddr:    clientAddr,
+			serverAddr:    serverAddr,
+			serverAccount: otherAccount,
+			accept:        acceptAll,
+			clientErr:     errKeyConfirmation,
+			serverErr:     errKeyConfirmation,
+		},
+		{
+			name:          "client impersonating another address",
+			clientAccount: clientAccount,
+			clientAddr:    otherAddr,
+			serverAddr:    serverAddr,
+			serverAccount: serverAccount,
+			accept:        acceptAll,
+			clientErr:     errKeyConfirmation,
+			serverErr:     errKeyConfirmation,
+		},
+		{
+			name:          "client address not accepted",
+			clientAccount: clientAccount,
+			clientAddr:    clientAddr,
+			serverAddr:    serverAddr,
+			serverAccount: serverAccount,
+			accept:        func(addr string) bool { return addr == otherAddr },
+			serverErr:     errAddrNotAccepted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, s := net.Pipe()
+			defer c.Close()
+			defer s.Close()
+			client, server := runHandshake(c, s, tt.clientAccount, tt.clientAddr, tt.serverAddr, tt.serverAccount, tt.accept)
+			require.Error(t, client.err)
+			if tt.clientErr != nil {
+				require.ErrorIs(t, client.err, tt.clientErr)
+			}
+			require.ErrorIs(t, server.err, tt.serverErr)
+		})
+	}
+}
+
+// tamperConn flips a bit of the byte at offset of data read from Conn.
+type tamperConn struct {
+	net.Conn
+	offset int
+	read   int
+}
+
+func (c *tamperConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.offset >= c.read && c.offset < c.read+n {
+		b[c.offset-c.read] ^= 1
+	}
+	c.read += n
+	return n, err
+}
+
+func TestHandshakeTamperedNonce(t *testing.T) {
+	clientAccount, clientAddr := newTestAccount(t)
+	serverAccount, serverAddr := newTestAccount(t)
+
+	t.Run("server nonce", func(t *testing.T) {
+		c, s := net.Pipe()
+		defer c.Close()
+		defer s.Close()
+		client, server := runHandshake(&tamperConn{Conn: c, offset: nonceSize - 1}, s, clientAccount, clientAddr, serverAddr, serverAccount, acceptAll)
+		require.ErrorIs(t, client.err, errKeyConfirmation)
+		require.ErrorIs(t, server.err, errKeyConfirmation)
+	})
+
+	t.Run("client nonce", func(t *testing.T) {
+		c, s := net.Pipe()
+		defer c.Close()
+		defer s.Close()
+		offset := 3 + len(clientAddr)
+		client, server := runHandshake(c, &tamperConn{Conn: s, offset: offset}, clientAccount, clientAddr, serverAddr, serverAccount, acceptAll)
+		require.ErrorIs(t, client.err, errKeyConfirmation)
+		require.ErrorIs(t, server.err, errKeyConfirmation)
+	})
+}
+
+// recordConn keeps data written to Conn.
+type recordConn struct {
+	net.Conn
+	written []byte
+}
+
+func (c *recordConn) Write(b []byte) (int, error) {
+	c.written = append(c.written, b...)
+	return c.Conn.Write(b)
+}
+
+func TestHandshakeReplayedNonce(t *testing.T) {
+	clientAccount, clientAddr := newTestAccount(t)
+	serverAccount, serverAddr := newTestAccount(t)
+
+	c, s := net.Pipe()
+	rc := &recordConn{Conn: c}
+	client, server := runHandshake(rc, s, clientAccount, clientAddr, serverAddr, serverAccount, acceptAll)
+	require.NoError(t, client.err)
+	require.NoError(t, server.err)
+	c.Close()
+	s.Close()
+
+	// Replay the recorded client nonce and key confirmation to a new
+	// connection, which gets a fresh server nonce and so a different key.
+	c, s = net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	serverDone := make(chan error, 1)
+	go func() {
+		_, _, err := serverHandshake(s, serverAccount, acceptAll)
+		s.Close()
+		serverDone <- err
+	}()
+	go func() {
+		io.Copy(io.Discard, c)
+	}()
+	c.Write(rc.written)
+	require.ErrorIs(t, <-serverDone, errKeyConfirmation)
+}
+
+func TestForwarderFallback(t *testing.T) {
+	clientAccount, clientAddr := newTestAccount(t)
+	_, serverAddr := newTestAccount(t)
+	impostorAccount, _ := newTestAccount(t)
+
+	// A direct server whose key does not match serverAddr, so key
+	// confirmation fails.
+	impostor, err := NewServer("127.0.0.1:0", impostorAccount, acceptAll, "127.0.0.1:1", false)
+	require.NoError(t, err)
+	defer impostor.Close()
+	go impostor.Start()
+	impostorAddr := impostor.listener.Addr().String()
+
+	fallback, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer fallback.Close()
+
+	f := NewForwarder("127.0.0.1:0", clientAccount, clientAddr, serverAddr, []string{impostorAddr}, fallback.Addr().String(), false, false)
+
+	f.setActive(impostorAddr)
+	f.probe()
+	require.Empty(t, f.Active())
+
+	// A connection while the direct path is still considered active falls
+	// back to the tunnel when key confirmation fails.
+	f.setActive(impostorAddr)
+	c, s := net.Pipe()
+	defer c.Close()
+	go f.handleConn(s)
+
+	fromConn, err := fallback.Accept()
+	require.NoError(t, err)
+	defer fromConn.Close()
+	require.Empty(t, f.Active())
+
+	go c.Write([]byte("ping"))
+	b := make([]byte, 4)
+	_, err = io.ReadFull(fromConn, b)
+	require.NoError(t, err)
+	require.Equal(t, "ping", string(b))
+}
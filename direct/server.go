@@ -0,0 +1,90 @@
+package direct
+
+import (
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const (
+	dialTimeout = 5 * time.Second
+)
+
+// Server accepts direct connections from clients that can reach it, and pipes
+// them to the same local address the NKN tunnel forwards to.
+type Server struct {
+	listener net.Listener
+	account  *nkn.Account
+	accept   func(string) bool
+	to       string
+	verbose  bool
+}
+
+func NewServer(listenAddr string, account *nkn.Account, accept func(string) bool, to string, verbose bool) (*Server, error) {
+	l, err := net.Listen("tcp", listenAddr)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		listener: l,
+		account:  account,
+		accept:   accept,
+		to:       to,
+		verbose:  verbose,
+	}, nil
+}
+
+// Port returns the port the server is listening on.
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *Server) Start() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return err
+		}
+		go s.handleConn(conn)
+	}
+}
+
+func (s *Server) Close() error {
+	return s.listener.Close()
+}
+
+func (s *Server) handleConn(conn net.Conn) {
+	ec, clientAddr, err := serverHandshake(conn, s.account, s.accept)
+	if err != nil {
+		if s.verbose {
+			log.Printf("Direct connection from %s rejected: %v", conn.RemoteAddr(), err)
+		}
+		conn.Close()
+		return
+	}
+	if s.verbose {
+		log.Printf("Accept direct connection from %s (%s)", clientAddr, conn.RemoteAddr())
+	}
+
+	toConn, err := net.DialTimeout("tcp", s.to, dialTimeout)
+	if err != nil {
+		log.Println(err)
+		ec.Close()
+		return
+	}
+	pipe(ec, toConn)
+}
+
+func pipe(a, b net.Conn) {
+	go func() {
+		io.Copy(a, b)
+		a.Close()
+	}()
+	go func() {
+		io.Copy(b, a)
+		b.Close()
+	}()
+}
@@ -0,0 +1,244 @@
+package nconnect
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/imdario/mergo"
+	"github.com/nknorg/nconnect/config"
+	nkn "github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn/v2/util/address"
+)
+
+// DryRun resolves the effective config of the selected mode from config file
+// and command line options, and writes to w what would be used if started:
+// NKN identifiers, listeners, TUN devices and routes, and tuna services.
+// Nothing is saved, and neither network nor routing table is touched.
+func DryRun(opts *config.Opts, w io.Writer) error {
+	if opts.Client == opts.Server {
+		return errors.New("select exactly one mode to dry run, -c for client or -s for server")
+	}
+
+	err := (&opts.Config).SetPlatformSpecificDefaultValues()
+	if err != nil {
+		return err
+	}
+
+	fileConf, err := config.ReadConfigFile(opts.ConfigFile, opts.ConfigFormat)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("load config file %s error: %v", opts.ConfigFile, err)
+		}
+		fileConf = config.NewConfig()
+		fmt.Fprintf(w, "Config file %s does not exist and would be created\n", opts.ConfigFile)
+	}
+	err = mergo.Merge(&opts.Config, fileConf)
+	if err != nil {
+		return err
+	}
+	if config.LowMemoryBuild || opts.LowMemory {
+		opts.LowMemory = true
+		opts.ApplyLowMemory()
+	}
+
+	if opts.Client {
+		err = opts.VerifyClient()
+	} else {
+		err = opts.VerifyServer()
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+
+	mode := "server"
+	if opts.Client {
+		mode = "client"
+	}
+	fmt.Fprintf(w, "Dry run of %s mode with config %s, nothing is started\n", mode, opts.ConfigFile)
+
+	fmt.Fprintln(w, "\nIdentity:")
+	identifier := opts.Identifier
+	if len(identifier) == 0 {
+		fmt.Fprintln(w, "  identifier: a random one would be generated and saved")
+	} else {
+		fmt.Fprintln(w, "  identifier:", identifier)
+	}
+	if len(opts.SeedFile) > 0 {
+		opts.Seed, err = config.ReadSeedFile(opts.SeedFile)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "  seed: read from seed file", opts.SeedFile)
+	}
+	if len(opts.SeedProvider) > 0 {
+		opts.Seed, err = config.FetchSeed(opts.SeedProvider)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, "  seed: fetched from seed provider", opts.SeedProvider)
+	}
+	var addr string
+	if len(opts.Seed) == 0 {
+		fmt.Fprintln(w, "  seed: a new one would be generated and saved, so address is not known yet")
+	} else {
+		seed, err := hex.DecodeString(opts.Seed)
+		if err != nil {
+			return fmt.Errorf("invalid seed: %v", err)
+		}
+		account, err := nkn.NewAccount(seed)
+		if err != nil {
+			return fmt.Errorf("invalid seed: %v", err)
+		}
+		fmt.Fprintln(w, "  public key:", hex.EncodeToString(account.PubKey()))
+		if len(identifier) > 0 {
+			addr = address.MakeAddressString(account.PubKey(), identifier)
+		}
+	}
+	// withIdentifier prefixes the NKN address of this node with prefix, or
+	// describes it if the address is not known yet.
+	withIdentifier := func(prefix string) string {
+		a := addr
+		if len(a) == 0 {
+			a = "<identifier>.<public key>"
+		}
+		if len(prefix) == 0 {
+			return a
+		}
+		return prefix + "." + a
+	}
+
+	if opts.Client {
+		nc := &nconnect{opts: opts}
+		fmt.Fprintln(w, "  client address:", withIdentifier(""))
+		if len(opts.LocalServices) > 0 {
+			fmt.Fprintln(w, "  local services address:", withIdentifier(config.PublishIdentifierPrefix))
+		}
+
+		fmt.Fprintln(w, "\nRemote servers:")
+		if len(opts.RemoteTunnelAddr) > 0 {
+			for _, a := range opts.RemoteTunnelAddr {
+				fmt.Fprintln(w, "  tunnel:", a)
+			}
+		} else {
+			for _, s := range opts.RemoteAdminAddr {
+				fmt.Fprintf(w, "  admin: %s (tunnel address would be fetched from it)\n", s.Addr)
+				var options []string
+				if s.DialTimeout > 0 {
+					options = append(options, fmt.Sprintf("dial timeout %dms", s.DialTimeout))
+				}
+				if s.SessionWindowSize > 0 {
+					options = append(options, fmt.Sprintf("session window size %d", s.SessionWindowSize))
+				}
+				if s.Tuna != nil {
+					options = append(options, fmt.Sprintf("tuna %v", *s.Tuna))
+				}
+				if len(options) > 0 {
+					fmt.Fprintln(w, "    tunnel options:", strings.Join(options, ", "))
+				}
+				if len(s.Labels) > 0 {
+					fmt.Fprintln(w, "    labels:", strings.Join(s.Labels, ", "))
+				}
+			}
+		}
+
+		fmt.Fprintln(w, "\nListeners:")
+		fmt.Fprintln(w, "  socks proxy:", opts.LocalSocksAddr)
+		if opts.AdvertiseProxy {
+			fmt.Fprintln(w, "  mDNS: advertise socks proxy as", proxyServiceType)
+		}
+		for _, s := range opts.LocalServices {
+			fmt.Fprintf(w, "  local service %s: %s\n", s.Name, s.Addr)
+		}
+
+		devices := nc.getTunDeviceConfigs()
+		if len(devices) > 0 {
+			fmt.Fprintln(w, "\nTUN devices:")
+		}
+		for _, d := range devices {
+			name := d.Name
+			if len(name) == 0 {
+				name = opts.TunName
+			}
+			mask := d.Mask
+			if len(mask) == 0 {
+				mask = opts.TunMask
+			}
+			fmt.Fprintf(w, "  %s: address %s/%s, gateway %s\n", name, d.Addr, mask, d.Gateway)
+			if len(d.DNS) > 0 {
+				fmt.Fprintln(w, "    DNS:", strings.Join(d.DNS, ", "))
+			}
+			if !d.VPN {
+				continue
+			}
+			if len(d.VPNRoute) > 0 {
+				fmt.Fprintln(w, "    VPN routes:", strings.Join(d.VPNRoute, ", "))
+			} else {
+				fmt.Fprintln(w, "    VPN routes: local IPs of remote servers, fetched at start")
+			}
+			policy := d.VPNIPv6Policy
+			if len(policy) == 0 {
+				policy = opts.VPNIPv6Policy
+			}
+			fmt.Fprintln(w, "    IPv6 policy:", policy)
+		}
+	} else {
+		fmt.Fprintln(w, "  tunnel address:", withIdentifier(""))
+		if len(opts.AdminIdentifier) > 0 {
+			fmt.Fprintln(w, "  admin address:", withIdentifier(opts.AdminIdentifier))
+		}
+		fmt.Fprintf(w, "  accept addresses: %d, admin addresses: %d\n", len(opts.AcceptAddrs), len(opts.AdminAddrs))
+
+		fmt.Fprintln(w, "\nListeners:")
+		listening := false
+		if len(opts.AdminHTTPAddr) > 0 {
+			scheme := "http"
+			if opts.AdminHTTPTLS {
+				scheme = "https"
+			}
+			fmt.Fprintf(w, "  admin web GUI: %s://%s\n", scheme, opts.AdminHTTPAddr)
+			if opts.AdminHTTPMetrics {
+				fmt.Fprintf(w, "  metrics: %s://%s/metrics\n", scheme, opts.AdminHTTPAddr)
+			}
+			listening = true
+		}
+		if len(opts.PublishedServices) > 0 {
+			publishAddr := opts.PublishAddr
+			if len(publishAddr) == 0 {
+				publishAddr = opts.AdminHTTPAddr
+			}
+			fmt.Fprintf(w, "  published services: %s (%d services)\n", publishAddr, len(opts.PublishedServices))
+			listening = true
+		}
+		if len(opts.DirectListenAddr) > 0 {
+			fmt.Fprintln(w, "  direct connection:", opts.DirectListenAddr)
+			listening = true
+		}
+		if !listening {
+			fmt.Fprintln(w, "  none besides NKN tunnel")
+		}
+	}
+
+	fmt.Fprintln(w, "\nTuna:")
+	if !opts.Tuna {
+		fmt.Fprintln(w, "  disabled")
+	} else if opts.Client {
+		fmt.Fprintln(w, "  enabled, service nodes are chosen by remote servers")
+	} else {
+		serviceName := opts.TunaServiceName
+		if len(serviceName) == 0 {
+			serviceName = "default"
+		}
+		fmt.Fprintln(w, "  service:", serviceName)
+		if len(opts.TunaCountry) > 0 {
+			fmt.Fprintln(w, "  countries:", strings.Join(opts.TunaCountry, ", "))
+		}
+		fmt.Fprintf(w, "  max price: %s NKN/MB, min balance: %s NKN\n", opts.TunaMaxPrice, opts.TunaMinBalance)
+	}
+
+	fmt.Fprintln(w, "\nUDP:", opts.UDP)
+	return nil
+}
@@ -0,0 +1,130 @@
+package nconnect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/imdario/mergo"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/util"
+)
+
+// Sources of effective config values.
+const (
+	ConfigSourceFlag      = "flag"      // command line
+	ConfigSourceFile      = "file"      // config file or its included files
+	ConfigSourceDefault   = "default"   // flag default, platform default or unset
+	ConfigSourceLowMemory = "lowMemory" // low memory mode
+)
+
+// effectiveValue is a config value in effect and where it came from.
+type effectiveValue struct {
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// PrintEffectiveConfig merges config file into opts the same way as on start,
+// and writes to w each config field in effect as JSON, with the source it came
+// from: flag, file, default or lowMemory. flagFields are names of Config
+// struct fields set on command line. Secrets of config.Secrets are redacted,
+// and nothing is saved.
+//
+// Note that flag defaults take precedence over config file, as a value given
+// in opts is only replaced by config file when it is empty.
+func PrintEffectiveConfig(opts *config.Opts, flagFields map[string]bool, w io.Writer) error {
+	parsed := configFieldValues(&opts.Config)
+
+	err := (&opts.Config).SetPlatformSpecificDefaultValues()
+	if err != nil {
+		return err
+	}
+
+	fileConf, err := config.ReadConfigFile(opts.ConfigFile, opts.ConfigFormat)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			return fmt.Errorf("load config file %s error: %v", opts.ConfigFile, err)
+		}
+		fileConf = config.NewConfig()
+	}
+	err = mergo.Merge(&opts.Config, fileConf)
+	if err != nil {
+		return err
+	}
+	fromFile := configFieldValues(fileConf)
+
+	merged := configFieldValues(&opts.Config)
+	if config.LowMemoryBuild || opts.LowMemory {
+		opts.LowMemory = true
+		opts.ApplyLowMemory()
+	}
+
+	effective := make(map[string]effectiveValue)
+	v := reflect.ValueOf(&opts.Config).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := configFieldJSONName(f)
+		if len(name) == 0 {
+			continue
+		}
+		value := v.Field(i).Interface()
+
+		source := ConfigSourceDefault
+		switch {
+		case !reflect.DeepEqual(value, merged[f.Name]):
+			source = ConfigSourceLowMemory
+		case flagFields[f.Name]:
+			source = ConfigSourceFlag
+		case !reflect.ValueOf(parsed[f.Name]).IsZero():
+			// flag default, kept over config file
+		case !reflect.ValueOf(fromFile[f.Name]).IsZero():
+			source = ConfigSourceFile
+		}
+
+		effective[name] = effectiveValue{Value: value, Source: source}
+	}
+
+	b, err := json.MarshalIndent(effective, "", "  ")
+	if err != nil {
+		return err
+	}
+	redactor := util.NewRedactor()
+	redactor.AddSecrets(opts.Config.Secrets()...)
+	redactor.AddSecrets(fileConf.Secrets()...)
+	_, err = fmt.Fprintln(w, string(redactor.RedactSecrets(b)))
+	return err
+}
+
+// configFieldValues returns exported field values of c by field name.
+func configFieldValues(c *config.Config) map[string]interface{} {
+	values := make(map[string]interface{})
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if len(t.Field(i).PkgPath) > 0 {
+			continue
+		}
+		values[t.Field(i).Name] = v.Field(i).Interface()
+	}
+	return values
+}
+
+// configFieldJSONName returns the name of config field f in config file, or
+// empty if it is not kept in config file.
+func configFieldJSONName(f reflect.StructField) string {
+	if len(f.PkgPath) > 0 {
+		return ""
+	}
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if name == "-" {
+		return ""
+	}
+	if len(name) == 0 {
+		return f.Name
+	}
+	return name
+}
@@ -0,0 +1,80 @@
+package nconnect
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/events"
+	"github.com/nknorg/nconnect/monitor"
+	tunnel "github.com/nknorg/nkn-tunnel"
+	"github.com/nknorg/nkn/v2/common"
+)
+
+const (
+	relayCheckInterval   = 30 * time.Second
+	balanceCheckInterval = 10 * time.Minute
+)
+
+// SubscribeEvents returns a channel receiving events of this node from now
+// on, and a function to unsubscribe. It should be called before StartClient
+// or StartServer to receive events of starting up.
+func (nc *nconnect) SubscribeEvents() (<-chan events.Event, func()) {
+	return events.Subscribe()
+}
+
+// runTunnel starts t and publishes events when it goes up and down.
+func runTunnel(t *tunnel.Tunnel) error {
+	events.Publish(events.TunnelUp{From: t.FromAddr(), To: t.ToAddr()})
+	err := t.Start()
+	events.Publish(events.TunnelDown{From: t.FromAddr(), To: t.ToAddr(), Err: err})
+	return err
+}
+
+// watchRelay publishes RelayChanged when the tuna relay used by server tunnel
+// t changes, until ctx is done.
+func watchRelay(ctx context.Context, t *tunnel.Tunnel) {
+	var relay string
+	for monitor.Sleep(ctx, relayCheckInterval) {
+		ip := admin.TunaRelayIP(t)
+		if ip == relay {
+			continue
+		}
+		relay = ip
+		if len(ip) > 0 {
+			log.Println("Using tuna relay", ip)
+		} else {
+			log.Println("No tuna relay is used")
+		}
+		events.Publish(events.RelayChanged{Relay: ip})
+	}
+}
+
+// watchBalance publishes BalanceUpdated when wallet balance of server tunnel
+// t changes, and LowBalance when it drops below minBalance if minBalance is
+// positive, until ctx is done.
+func (nc *nconnect) watchBalance(ctx context.Context, t *tunnel.Tunnel, minBalance common.Fixed64) {
+	low := false
+	last := ""
+	for interval := time.Duration(0); monitor.Sleep(ctx, interval); interval = balanceCheckInterval {
+		balance, err := t.MultiClient().Balance()
+		if err != nil {
+			log.Println("Fetch balance error:", err)
+			continue
+		}
+		if balance.String() != last {
+			last = balance.String()
+			events.Publish(events.BalanceUpdated{Balance: last})
+		}
+		if minBalance <= 0 {
+			continue
+		}
+		wasLow := low
+		low = balance.ToFixed64() < minBalance
+		if low && !wasLow {
+			log.Printf("WARNING: wallet balance %s is less than minimal balance of tuna %s", balance.String(), nc.opts.TunaMinBalance)
+			events.Publish(events.LowBalance{Balance: balance.String(), MinBalance: nc.opts.TunaMinBalance})
+		}
+	}
+}
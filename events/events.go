@@ -0,0 +1,170 @@
+// Package events delivers state changes of nConnect to embedding applications
+// and GUIs, so they do not have to parse logs to react to them.
+package events
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+const (
+	TypeTunnelUp         = "tunnelUp"
+	TypeTunnelDown       = "tunnelDown"
+	TypeRelayChanged     = "relayChanged"
+	TypeClientConnected  = "clientConnected"
+	TypeClientAccepted   = "clientAccepted"
+	TypeClientRejected   = "clientRejected"
+	TypeConnectionOpened = "connectionOpened"
+	TypeBalanceUpdated   = "balanceUpdated"
+	TypeLowBalance       = "lowBalance"
+	TypeConfigChanged    = "configChanged"
+
+	ViaAdmin  = "admin"  // admin request over NKN
+	ViaDirect = "direct" // direct connection
+	ViaPair   = "pair"   // pairing with QR code
+
+	// Events are dropped for a subscriber that does not receive them fast
+	// enough to keep this many buffered.
+	subscriberBufferSize = 64
+)
+
+var (
+	lock        sync.RWMutex
+	subscribers = make(map[chan Event]struct{})
+)
+
+// Event is one of the event types in this package. Use a type switch to get
+// its fields.
+type Event interface {
+	Type() string
+}
+
+// TunnelUp is sent when a tunnel starts serving. In client mode From is the
+// local address and To is the remote tunnel address. In server mode From is
+// the tunnel NKN address and To is the local proxy address.
+type TunnelUp struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// TunnelDown is sent when a tunnel stops, with the error it stopped with, or
+// nil if it was closed.
+type TunnelDown struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Err  error  `json:"-"`
+}
+
+// RelayChanged is sent when traffic is relayed through another path. Relay is
+// the tuna relay IP of a server, or the direct address to remote server Remote
+// of a client if Direct is true. It is empty if no relay or direct path is
+// used anymore.
+type RelayChanged struct {
+	Remote string `json:"remote,omitempty"`
+	Relay  string `json:"relay"`
+	Direct bool   `json:"direct"`
+}
+
+// ClientConnected is sent by server when a client talks to admin server for
+// the first time, or again after it has not been seen for a while.
+type ClientConnected struct {
+	Addr      string `json:"addr"`
+	Name      string `json:"name,omitempty"`
+	FirstSeen bool   `json:"firstSeen"`
+}
+
+// ClientAccepted is sent by server when a client is accepted Via direct
+// connection or pairing.
+type ClientAccepted struct {
+	Addr string `json:"addr"`
+	Via  string `json:"via"`
+}
+
+// ClientRejected is sent by server when a client not in accept addresses is
+// rejected Via admin request or direct connection.
+type ClientRejected struct {
+	Addr string `json:"addr"`
+	Via  string `json:"via"`
+}
+
+// ConnectionOpened is sent by server when a proxied connection to a target is
+// opened, with the number of active ones.
+type ConnectionOpened struct {
+	Active int64 `json:"active"`
+}
+
+// BalanceUpdated is sent by server when its wallet balance in NKN is fetched
+// and has changed.
+type BalanceUpdated struct {
+	Balance string `json:"balance"`
+}
+
+// LowBalance is sent by server when wallet balance drops below tuna minimal
+// balance. Amounts are in NKN.
+type LowBalance struct {
+	Balance    string `json:"balance"`
+	MinBalance string `json:"minBalance"`
+}
+
+// ConfigChanged is sent when config or state is changed by admin rpc Method,
+// or by reloading config file with Method "reload" and the changed Fields.
+type ConfigChanged struct {
+	Method string   `json:"method"`
+	Fields []string `json:"fields,omitempty"`
+}
+
+func (TunnelUp) Type() string         { return TypeTunnelUp }
+func (TunnelDown) Type() string       { return TypeTunnelDown }
+func (RelayChanged) Type() string     { return TypeRelayChanged }
+func (ClientConnected) Type() string  { return TypeClientConnected }
+func (ClientAccepted) Type() string   { return TypeClientAccepted }
+func (ClientRejected) Type() string   { return TypeClientRejected }
+func (ConnectionOpened) Type() string { return TypeConnectionOpened }
+func (BalanceUpdated) Type() string   { return TypeBalanceUpdated }
+func (LowBalance) Type() string       { return TypeLowBalance }
+func (ConfigChanged) Type() string    { return TypeConfigChanged }
+
+// MarshalJSON encodes Err as its message.
+func (e TunnelDown) MarshalJSON() ([]byte, error) {
+	type tunnelDown TunnelDown
+	var errMsg string
+	if e.Err != nil {
+		errMsg = e.Err.Error()
+	}
+	return json.Marshal(struct {
+		tunnelDown
+		Error string `json:"error,omitempty"`
+	}{tunnelDown(e), errMsg})
+}
+
+// Subscribe returns a channel receiving events published from now on, and a
+// function to unsubscribe, which closes the channel. Events are dropped if
+// the channel is full, so it should be drained without blocking for long.
+func Subscribe() (<-chan Event, func()) {
+	c := make(chan Event, subscriberBufferSize)
+	lock.Lock()
+	subscribers[c] = struct{}{}
+	lock.Unlock()
+
+	var once sync.Once
+	return c, func() {
+		once.Do(func() {
+			lock.Lock()
+			delete(subscribers, c)
+			lock.Unlock()
+			close(c)
+		})
+	}
+}
+
+// Publish sends e to all subscribers without blocking.
+func Publish(e Event) {
+	lock.RLock()
+	defer lock.RUnlock()
+	for c := range subscribers {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
@@ -0,0 +1,339 @@
+package nconnect
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nknorg/nconnect/monitor"
+	"github.com/nknorg/tuna/geo"
+	"github.com/oschwald/geoip2-golang"
+)
+
+const (
+	geoDBChecksumSuffix = ".sha256"
+	geoDBLockSuffix     = ".lock"
+
+	// Geo db is downloaded again at this age, well before Tuna considers it
+	// expired and downloads it itself without checks.
+	geoDBRefreshAge      = geo.MaxMindExpired / 2
+	geoDBCheckInterval   = 6 * time.Hour
+	geoDBDownloadTimeout = 2 * time.Minute
+	geoDBMaxSize         = 64 << 20
+
+	// A lock older than this is left by a crashed instance and is removed.
+	geoDBLockTimeout      = 5 * time.Minute
+	geoDBLockPollInterval = time.Second
+)
+
+var errGeoDBChecksumMismatch = errors.New("geo db checksum mismatch")
+
+// watchGeoDB updates Tuna geo db periodically until ctx is done.
+func (nc *nconnect) watchGeoDB(ctx context.Context) {
+	for monitor.Sleep(ctx, geoDBCheckInterval) {
+		err := nc.updateGeoDB(ctx)
+		if err != nil {
+			log.Println("Update geo db error:", err)
+		}
+	}
+}
+
+// updateGeoDB makes sure Tuna geo db in geo db path is valid and fresh. Geo
+// db is downloaded from mirror or default URL and checked against its
+// published checksum, falling back to the cached one or the bundled snapshot.
+// Instances sharing geo db path take turns with a lock file. The cached geo db
+// is kept fresh for Tuna, so it does not download one itself without checks.
+func (nc *nconnect) updateGeoDB(ctx context.Context) error {
+	dir := nc.opts.TunaGeoDBPath
+	if len(dir) == 0 {
+		dir = "."
+	}
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(dir, geo.MaxMindFile)
+
+	unlock, err := lockGeoDB(ctx, file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	checkedAt, err := verifyGeoDB(file)
+	cached := err == nil
+	if err != nil && !os.IsNotExist(err) {
+		log.Println("Cached geo db is invalid:", err)
+	}
+	if cached && time.Since(checkedAt) < geoDBRefreshAge {
+		return touchGeoDB(file)
+	}
+
+	url := nc.opts.TunaGeoDBMirror
+	if len(url) == 0 {
+		url = geo.Geolite2Url
+	}
+	err = downloadGeoDB(ctx, url, file)
+	if err == nil {
+		log.Println("Downloaded geo db from", url)
+		return nil
+	}
+	log.Println("Download geo db error:", err)
+
+	if cached {
+		log.Println("Using cached geo db")
+		return touchGeoDB(file)
+	}
+	if len(nc.opts.TunaGeoDBSnapshot) > 0 {
+		err = installGeoDBSnapshot(nc.opts.TunaGeoDBSnapshot, file)
+		if err != nil {
+			return fmt.Errorf("install geo db snapshot: %v", err)
+		}
+		log.Println("Using geo db snapshot", nc.opts.TunaGeoDBSnapshot)
+		return nil
+	}
+	return err
+}
+
+// lockGeoDB creates the lock file of geo db file, waiting for other instances
+// to remove it, and returns a function to remove it.
+func lockGeoDB(ctx context.Context, file string) (func(), error) {
+	lockFile := file + geoDBLockSuffix
+	for {
+		f, err := os.OpenFile(lockFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			f.WriteString(strconv.Itoa(os.Getpid()))
+			f.Close()
+			return func() { os.Remove(lockFile) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		if fi, err := os.Stat(lockFile); err == nil && time.Since(fi.ModTime()) > geoDBLockTimeout {
+			log.Println("Removing stale geo db lock", lockFile)
+			os.Remove(lockFile)
+			continue
+		}
+		if !monitor.Sleep(ctx, geoDBLockPollInterval) {
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// verifyGeoDB checks geo db file against its checksum file and that it can be
+// opened, and returns when it was last checked against its source.
+func verifyGeoDB(file string) (time.Time, error) {
+	fi, err := os.Stat(file + geoDBChecksumSuffix)
+	if err != nil {
+		return time.Time{}, err
+	}
+	want, err := readChecksum(file + geoDBChecksumSuffix)
+	if err != nil {
+		return time.Time{}, err
+	}
+	got, err := fileChecksum(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if got != want {
+		return time.Time{}, errGeoDBChecksumMismatch
+	}
+	err = openGeoDB(file)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// downloadGeoDB downloads geo db from url to file, checking it against the
+// checksum at url with .sha256 suffix if there is one.
+func downloadGeoDB(ctx context.Context, url, file string) error {
+	ctx, cancel := context.WithTimeout(ctx, geoDBDownloadTimeout)
+	defer cancel()
+
+	want, err := fetchChecksum(ctx, url+geoDBChecksumSuffix)
+	if err != nil {
+		return err
+	}
+	if len(want) == 0 {
+		log.Println("No checksum is published for geo db, only checking its format")
+	}
+
+	body, err := httpGet(ctx, url)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmpFile, h), io.LimitReader(body, geoDBMaxSize))
+	tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	got := hex.EncodeToString(h.Sum(nil))
+	if len(want) > 0 && got != want {
+		return errGeoDBChecksumMismatch
+	}
+
+	return installGeoDB(tmpFile.Name(), file, got, time.Now())
+}
+
+// installGeoDBSnapshot copies bundled geo db snapshot to file. It is checked
+// against its source again at next check, as if it was downloaded when the
+// snapshot was made.
+func installGeoDBSnapshot(snapshot, file string) error {
+	fi, err := os.Stat(snapshot)
+	if err != nil {
+		return err
+	}
+	src, err := os.Open(snapshot)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+"-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmpFile, h), src)
+	tmpFile.Close()
+	if err != nil {
+		return err
+	}
+	return installGeoDB(tmpFile.Name(), file, hex.EncodeToString(h.Sum(nil)), fi.ModTime())
+}
+
+// installGeoDB checks that geo db tmpFile can be opened, then moves it to
+// file and writes its checksum file with checkedAt as modification time.
+func installGeoDB(tmpFile, file, checksum string, checkedAt time.Time) error {
+	err := openGeoDB(tmpFile)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpFile, file)
+	if err != nil {
+		return err
+	}
+	checksumFile := file + geoDBChecksumSuffix
+	err = os.WriteFile(checksumFile, []byte(checksum+"  "+filepath.Base(file)+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	err = os.Chtimes(checksumFile, checkedAt, checkedAt)
+	if err != nil {
+		return err
+	}
+	return touchGeoDB(file)
+}
+
+// touchGeoDB updates modification time of geo db file, which Tuna uses to
+// tell if it has expired.
+func touchGeoDB(file string) error {
+	now := time.Now()
+	return os.Chtimes(file, now, now)
+}
+
+func openGeoDB(file string) error {
+	db, err := geoip2.Open(file)
+	if err != nil {
+		return fmt.Errorf("invalid geo db: %v", err)
+	}
+	return db.Close()
+}
+
+func fileChecksum(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func readChecksum(file string) (string, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return parseChecksum(string(b))
+}
+
+// fetchChecksum returns the checksum at url, or empty string if there is
+// none.
+func fetchChecksum(ctx context.Context, url string) (string, error) {
+	body, err := httpGet(ctx, url)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	b, err := io.ReadAll(io.LimitReader(body, 1024))
+	if err != nil {
+		return "", err
+	}
+	return parseChecksum(string(b))
+}
+
+// parseChecksum parses a sha256 checksum in sha256sum output format.
+func parseChecksum(s string) (string, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return "", errors.New("empty checksum")
+	}
+	b, err := hex.DecodeString(fields[0])
+	if err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid checksum %q", fields[0])
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// httpGet returns the response body of url, or an error wrapping
+// os.ErrNotExist if it is not found.
+func httpGet(ctx context.Context, url string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, fmt.Errorf("get %s: %w", url, os.ErrNotExist)
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("get %s: %s", url, resp.Status)
+	}
+}
@@ -0,0 +1,204 @@
+package nconnect
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn/v2/util/address"
+)
+
+const (
+	identifierProbeTimeout = 5 * time.Second
+)
+
+var (
+	errIdentityInUse  = errors.New("another live node uses the same seed and identifier, e.g. from a cloned disk image")
+	errIdentityCopied = errors.New("config with a generated identifier was copied from another machine, e.g. a cloned VM")
+
+	identifierProbeMsg   = []byte("nConnect-identifier-probe")
+	identifierProbeReply = []byte("nConnect-identifier-in-use")
+)
+
+// identifierInUse returns whether another running node receives messages sent
+// to the NKN address of identifier, e.g. a duplicate deployment from a cloned
+// disk image. It should be called before this node goes online.
+func (nc *nconnect) identifierInUse(identifier string) (bool, error) {
+	c, err := nc.getAdminClient()
+	if err != nil {
+		return false, err
+	}
+	if nc.opts.Server {
+		defer func() {
+			c.Close()
+			nc.adminClientCache = nil
+		}()
+	}
+
+	addr := address.MakeAddressString(nc.account.PubKey(), identifier)
+	onReply, err := c.Send(nkn.NewStringArray(addr), identifierProbeMsg, nil)
+	if err != nil {
+		return false, err
+	}
+
+	select {
+	case reply := <-onReply.C:
+		return bytes.Equal(reply.Data, identifierProbeReply), nil
+	case <-time.After(identifierProbeTimeout):
+		return false, nil
+	}
+}
+
+// checkIdentifier detects identifier conflict and handles it according to
+// config. With suffix, a random suffix is appended to identifier and saved to
+// config, so this node gets a new address.
+func (nc *nconnect) checkIdentifier() error {
+	if nc.opts.IdentifierConflict == config.IdentifierConflictIgnore {
+		return nil
+	}
+
+	err := nc.checkMachineBinding()
+	if err != nil {
+		return err
+	}
+
+	inUse, err := nc.identifierInUse(nc.opts.Identifier)
+	if err != nil {
+		log.Println("Check identifier conflict error:", err)
+		return nil
+	}
+	if !inUse {
+		return nil
+	}
+
+	if nc.opts.IdentifierConflict != config.IdentifierConflictSuffix {
+		admin.SetIdentityConflict(errIdentityInUse.Error())
+		log.Printf("WARNING: identifier %s is already used by another running node with the same seed, e.g. a cloned disk image. Both nodes will fight over messages. Change identifier, or set --identifier-conflict suffix to append a random suffix automatically.", nc.opts.Identifier)
+		return nil
+	}
+
+	identifier := nc.opts.Identifier + "-" + config.RandomIdentifier()
+	log.Printf("Identifier %s is already used by another running node, switching to %s. Paired peers need the new address.", nc.opts.Identifier, identifier)
+	nc.persistConf.Identifier = identifier
+	nc.opts.Identifier = identifier
+	return nc.persistConf.Save()
+}
+
+// checkMachineBinding detects config with a generated identifier copied to
+// another machine, which catches cloned VMs even if the original one is
+// offline. It is handled the same way as identifier conflict.
+func (nc *nconnect) checkMachineBinding() error {
+	if len(nc.persistConf.MachineBinding) == 0 || nc.opts.Identifier != nc.persistConf.Identifier {
+		return nil
+	}
+	binding, err := config.MachineBinding()
+	if err != nil {
+		log.Println("Get machine ID error:", err)
+		return nil
+	}
+	if binding == nc.persistConf.MachineBinding {
+		return nil
+	}
+
+	if nc.opts.IdentifierConflict != config.IdentifierConflictSuffix {
+		admin.SetIdentityConflict(errIdentityCopied.Error())
+		log.Printf("WARNING: identifier %s was generated on another machine, config seems to be copied, e.g. from a cloned VM. Both nodes will fight over messages if running with the same seed. Run regen-identity on this machine, or remove machineBinding from config if it was moved on purpose.", nc.opts.Identifier)
+		return nil
+	}
+
+	identifier := nc.opts.Identifier + "-" + config.RandomIdentifier()
+	log.Printf("Identifier %s was generated on another machine, switching to %s. Paired peers need the new address.", nc.opts.Identifier, identifier)
+	nc.persistConf.Identifier = identifier
+	nc.persistConf.MachineBinding = binding
+	nc.opts.Identifier = identifier
+	return nc.persistConf.Save()
+}
+
+// respondIdentifierProbes replies to identifier probes of other nodes sent to
+// m, so they can detect they are using the same identifier.
+func respondIdentifierProbes(m *nkn.MultiClient) {
+	for {
+		msg, ok := <-m.OnMessage.C
+		if !ok {
+			return
+		}
+		if !bytes.Equal(msg.Data, identifierProbeMsg) {
+			continue
+		}
+		log.Println("WARNING: received identifier probe, another node is starting with the same seed and identifier. Run regen-identity on one of them if it is a cloned disk image.")
+		admin.SetIdentityConflict(errIdentityInUse.Error())
+		err := msg.Reply(identifierProbeReply)
+		if err != nil {
+			log.Println("Reply identifier probe error:", err)
+		}
+	}
+}
+
+// RegenIdentity generates a new seed and identifier and saves them to config
+// after backing it up. In client mode, addresses of this client on remote
+// servers it has admin access to are updated to the new public key first.
+// Other paired peers need to pair with the printed new address.
+func RegenIdentity(opts *config.Opts) error {
+	nc, err := NewNconnect(opts)
+	if err != nil {
+		return err
+	}
+	if len(opts.SeedFile) > 0 {
+		return config.ErrSeedFromFile
+	}
+	if len(opts.SeedProvider) > 0 {
+		return config.ErrSeedFromProvider
+	}
+
+	account, err := nkn.NewAccount(nil)
+	if err != nil {
+		return err
+	}
+	oldPubKey := hex.EncodeToString(nc.account.PubKey())
+	newPubKey := hex.EncodeToString(account.PubKey())
+	identifier := config.RandomIdentifier()
+
+	if opts.Client && len(opts.RemoteAdminAddr) > 0 {
+		c, err := nc.getAdminClient()
+		if err != nil {
+			return err
+		}
+		for _, remoteAdminAddr := range opts.RemoteAdminAddrs() {
+			err = c.ReplacePubKey(remoteAdminAddr, oldPubKey, newPubKey)
+			if err != nil {
+				log.Printf("Update addresses on %s error: %v, pair the new address with it manually", remoteAdminAddr, err)
+				continue
+			}
+			log.Printf("Updated addresses on %s to the new public key", remoteAdminAddr)
+		}
+	}
+
+	backup, err := nc.persistConf.Backup()
+	if err != nil {
+		return err
+	}
+	log.Println("Config backed up to", backup)
+
+	nc.persistConf.Identifier = identifier
+	if binding, err := config.MachineBinding(); err == nil {
+		nc.persistConf.MachineBinding = binding
+	}
+	err = nc.persistConf.SetSeed(hex.EncodeToString(account.Seed()))
+	if err != nil {
+		return err
+	}
+
+	addr := address.MakeAddressString(account.PubKey(), identifier)
+	if opts.Server && len(opts.AdminIdentifier) > 0 {
+		addr = opts.AdminIdentifier + "." + addr
+	}
+	fmt.Println("New address:", addr)
+
+	return nil
+}
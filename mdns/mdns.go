@@ -0,0 +1,287 @@
+// Package mdns advertises a local service on the LAN with multicast DNS and
+// DNS service discovery (RFC 6762 and RFC 6763), so other devices can find it
+// without entering its IP address.
+package mdns
+
+import (
+	"errors"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	domain          = "local."
+	servicesName    = "_services._dns-sd._udp." + domain
+	recordTTL       = 120 // seconds
+	announceCount   = 2
+	announceDelay   = time.Second
+	maxPacketSize   = 9000
+	multicastPort   = 5353
+	unicastResponse = 1 << 15 // QU bit of question class
+)
+
+var (
+	multicastAddr = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: multicastPort}
+
+	errInvalidService = errors.New("service should be like _name._tcp or _name._udp")
+)
+
+// Service is a service to advertise.
+type Service struct {
+	Instance string   // instance name shown to users, e.g. "nConnect on Anna's laptop"
+	Service  string   // service type, e.g. "_socks._tcp"
+	Port     int      // port the service listens on
+	IP       net.IP   // IPv4 address the service listens on, all addresses of this host if unspecified
+	Text     []string // key=value pairs in TXT record
+}
+
+// Advertiser answers mDNS queries for a service until closed. Addresses are
+// looked up on each answer, so it follows network changes.
+type Advertiser struct {
+	service  Service
+	host     string // host name in local domain
+	instance string // full instance name
+	typ      string // full service type name
+	conn     *net.UDPConn
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// Advertise starts advertising service s, and announces it on the LAN.
+func Advertise(s Service) (*Advertiser, error) {
+	parts := strings.Split(s.Service, ".")
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "_") || (parts[1] != "_tcp" && parts[1] != "_udp") {
+		return nil, errInvalidService
+	}
+
+	conn, err := net.ListenMulticastUDP("udp4", nil, multicastAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	typ := s.Service + "." + domain
+	a := &Advertiser{
+		service:  s,
+		host:     normalizeName(hostName() + "." + domain),
+		instance: normalizeName(escapeLabel(s.Instance) + "." + typ),
+		typ:      typ,
+		conn:     conn,
+		closed:   make(chan struct{}),
+	}
+
+	go a.serve()
+	go a.announce()
+
+	return a, nil
+}
+
+// Close sends goodbye packets so cached records expire at once, and stops
+// answering queries.
+func (a *Advertiser) Close() error {
+	var err error
+	a.closeOnce.Do(func() {
+		close(a.closed)
+		a.send(a.records(0), multicastAddr, 0)
+		err = a.conn.Close()
+	})
+	return err
+}
+
+func (a *Advertiser) announce() {
+	for i := 0; i < announceCount; i++ {
+		a.send(a.records(recordTTL), multicastAddr, 0)
+		select {
+		case <-time.After(announceDelay):
+		case <-a.closed:
+			return
+		}
+	}
+}
+
+func (a *Advertiser) serve() {
+	b := make([]byte, maxPacketSize)
+	for {
+		n, src, err := a.conn.ReadFromUDP(b)
+		if err != nil {
+			select {
+			case <-a.closed:
+			default:
+				log.Printf("mDNS read error: %v", err)
+			}
+			return
+		}
+
+		msg := &dns.Msg{}
+		if msg.Unpack(b[:n]) != nil || msg.Response || msg.Opcode != dns.OpcodeQuery {
+			continue
+		}
+
+		answers, unicast := a.answer(msg.Question)
+		if len(answers) == 0 {
+			continue
+		}
+		if src.Port != multicastPort {
+			// legacy unicast query, reply directly with its id
+			a.send(answers, src, msg.Id)
+		} else if unicast {
+			a.send(answers, src, 0)
+		} else {
+			a.send(answers, multicastAddr, 0)
+		}
+	}
+}
+
+// answer returns records answering questions, and whether any question asked
+// for a unicast response.
+func (a *Advertiser) answer(questions []dns.Question) ([]dns.RR, bool) {
+	var answers []dns.RR
+	unicast := false
+	for _, q := range questions {
+		name := q.Name
+		var rrs []dns.RR
+		switch {
+		case strings.EqualFold(name, servicesName) && (q.Qtype == dns.TypePTR || q.Qtype == dns.TypeANY):
+			rrs = []dns.RR{a.ptr(servicesName, a.typ, recordTTL)}
+		case strings.EqualFold(name, a.typ) && (q.Qtype == dns.TypePTR || q.Qtype == dns.TypeANY):
+			rrs = a.records(recordTTL)
+		case strings.EqualFold(name, a.instance):
+			for _, rr := range a.records(recordTTL)[1:] {
+				if q.Qtype == dns.TypeANY || rr.Header().Rrtype == q.Qtype {
+					rrs = append(rrs, rr)
+				}
+			}
+		case strings.EqualFold(name, a.host) && (q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY):
+			rrs = a.addresses(recordTTL)
+		}
+		if len(rrs) > 0 && q.Qclass&unicastResponse != 0 {
+			unicast = true
+		}
+		answers = append(answers, rrs...)
+	}
+	return answers, unicast
+}
+
+// records returns PTR, SRV, TXT and A records of the service.
+func (a *Advertiser) records(ttl uint32) []dns.RR {
+	rrs := []dns.RR{
+		a.ptr(a.typ, a.instance, ttl),
+		&dns.SRV{
+			Hdr:    a.header(a.instance, dns.TypeSRV, ttl),
+			Port:   uint16(a.service.Port),
+			Target: a.host,
+		},
+		&dns.TXT{
+			Hdr: a.header(a.instance, dns.TypeTXT, ttl),
+			Txt: append([]string{}, a.service.Text...),
+		},
+	}
+	return append(rrs, a.addresses(ttl)...)
+}
+
+func (a *Advertiser) ptr(name, target string, ttl uint32) dns.RR {
+	return &dns.PTR{
+		Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl},
+		Ptr: target,
+	}
+}
+
+// header returns header of a unique record, with cache flush bit set so
+// other hosts replace cached records of it.
+func (a *Advertiser) header(name string, rrtype uint16, ttl uint32) dns.RR_Header {
+	return dns.RR_Header{Name: name, Rrtype: rrtype, Class: dns.ClassINET | unicastResponse, Ttl: ttl}
+}
+
+func (a *Advertiser) addresses(ttl uint32) []dns.RR {
+	var rrs []dns.RR
+	for _, ip := range a.ips() {
+		rrs = append(rrs, &dns.A{Hdr: a.header(a.host, dns.TypeA, ttl), A: ip})
+	}
+	return rrs
+}
+
+// ips returns IPv4 addresses the service can be reached at.
+func (a *Advertiser) ips() []net.IP {
+	if ip := a.service.IP.To4(); ip != nil && !ip.IsUnspecified() {
+		return []net.IP{ip}
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+	var ips []net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		ip := ipNet.IP.To4()
+		if ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() {
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	return ips
+}
+
+func (a *Advertiser) send(rrs []dns.RR, dest *net.UDPAddr, id uint16) {
+	msg := &dns.Msg{}
+	msg.Id = id
+	msg.Response = true
+	msg.Authoritative = true
+	msg.Answer = rrs
+	b, err := msg.Pack()
+	if err != nil {
+		log.Printf("mDNS pack error: %v", err)
+		return
+	}
+	_, err = a.conn.WriteToUDP(b, dest)
+	if err != nil {
+		select {
+		case <-a.closed:
+		default:
+			log.Printf("mDNS send error: %v", err)
+		}
+	}
+}
+
+// hostName returns host name as a single DNS label.
+func hostName() string {
+	name, err := os.Hostname()
+	if err != nil || len(name) == 0 {
+		return "nconnect"
+	}
+	name = strings.TrimSuffix(strings.Split(name, ".")[0], "-")
+	return escapeLabel(name)
+}
+
+// normalizeName returns name escaped the same way as names in received
+// messages, so they can be compared.
+func normalizeName(name string) string {
+	b := make([]byte, 256)
+	n, err := dns.PackDomainName(name, b, 0, nil, false)
+	if err != nil {
+		return name
+	}
+	name, _, err = dns.UnpackDomainName(b[:n], 0)
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+// escapeLabel escapes characters with special meaning in domain names, so s
+// is a single label.
+func escapeLabel(s string) string {
+	r := strings.NewReplacer(".", "\\.", " ", "\\ ", "\\", "\\\\")
+	if len(s) > 63 {
+		s = s[:63]
+	}
+	return r.Replace(s)
+}
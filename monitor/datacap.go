@@ -0,0 +1,352 @@
+package monitor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nknorg/nconnect/storage"
+)
+
+const (
+	dataUsageKey = "data-usage.json"
+
+	// DataUsageJournalFile is the write-ahead journal of data usage, kept in
+	// state directory.
+	DataUsageJournalFile = "data-usage.journal"
+
+	// DefaultDataUsageFlushInterval limits how often changed usage alone is
+	// saved, so at most this much usage is lost on crash. Period resets and
+	// warnings are saved at once.
+	DefaultDataUsageFlushInterval = time.Minute
+
+	// dataUsageSnapshotInterval limits how often changed usage alone is saved
+	// to state storage when it is flushed to journal in between.
+	dataUsageSnapshotInterval = time.Hour
+)
+
+// DataUsage is the traffic of the current data cap period.
+type DataUsage struct {
+	PeriodStart time.Time `json:"periodStart"`
+	NextReset   time.Time `json:"nextReset"`
+	Bytes       int64     `json:"bytes"`
+	CapBytes    int64     `json:"capBytes,omitempty"` // 0 is for no cap
+	Percent     float64   `json:"percent,omitempty"`  // percent of cap used
+	Suspended   bool      `json:"suspended"`          // proxying is suspended until next reset
+	Warning     string    `json:"warning,omitempty"`  // latest warning of this period
+}
+
+// dataUsageState is what is kept in state storage across restarts.
+type dataUsageState struct {
+	PeriodStart time.Time `json:"periodStart"`
+	Bytes       int64     `json:"bytes"`
+	Warned      int       `json:"warned,omitempty"` // highest warn percent reached
+	Warning     string    `json:"warning,omitempty"`
+}
+
+// DataCap counts traffic of each month in state storage, warns when usage
+// reaches warn percents of cap and calls OnSuspend when cap is exceeded or
+// usage is reset, e.g. for metered VPS or residential plans. The effective
+// cap is the lower one of local cap and quota given by server.
+type DataCap struct {
+	Interval  time.Duration
+	Bytes     func() int64 // traffic since start
+	Store     storage.Storage
+	OnSuspend func(suspended bool, reason string)
+
+	lock         sync.Mutex
+	capBytes     int64
+	quotaBytes   int64
+	resetDay     int
+	warnPercents []int
+	state        dataUsageState
+	loaded       bool
+	suspended    bool
+	lastBytes    int64
+	lastSave     time.Time
+	savedBytes   int64
+
+	journal        *usageJournal
+	flushInterval  time.Duration
+	lastFlush      time.Time
+	journaledBytes int64
+}
+
+func NewDataCap(store storage.Storage, interval time.Duration, bytes func() int64, onSuspend func(bool, string)) *DataCap {
+	return &DataCap{
+		Interval:      interval,
+		Bytes:         bytes,
+		Store:         store,
+		OnSuspend:     onSuspend,
+		resetDay:      1,
+		flushInterval: DefaultDataUsageFlushInterval,
+	}
+}
+
+// SetJournal flushes changed usage to write-ahead journal at path every
+// flushInterval, and saves it to state storage only hourly, which saves flash
+// wear on routers. Usage in journal is recovered on load. It should be called
+// before Start.
+func (d *DataCap) SetJournal(path string, flushInterval time.Duration) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.journal = &usageJournal{path: path}
+	if flushInterval > 0 {
+		d.flushInterval = flushInterval
+	}
+}
+
+// SetLimits sets local cap in bytes (0 is for no cap), day of month (1-28)
+// usage is reset, and percents of cap to warn at. Warnings are given again
+// if they are reached under a new cap.
+func (d *DataCap) SetLimits(capBytes int64, resetDay int, warnPercents []int) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if resetDay < 1 || resetDay > 28 {
+		resetDay = 1
+	}
+	limit := d.limit()
+	d.capBytes = capBytes
+	d.resetDay = resetDay
+	d.warnPercents = append([]int(nil), warnPercents...)
+	sort.Ints(d.warnPercents)
+	if d.limit() != limit {
+		d.state.Warned = 0
+	}
+}
+
+// SetQuota sets the monthly quota in bytes given by server, 0 is for no quota.
+func (d *DataCap) SetQuota(quotaBytes int64) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	limit := d.limit()
+	d.quotaBytes = quotaBytes
+	if d.limit() != limit {
+		d.state.Warned = 0
+	}
+}
+
+func (d *DataCap) Start(ctx context.Context) {
+	for {
+		err := d.check()
+		if err != nil {
+			log.Println("Check data usage error:", err)
+		}
+		if !Sleep(ctx, d.Interval) {
+			d.lock.Lock()
+			if d.loaded && d.state.Bytes != d.savedBytes {
+				err = d.save()
+				if err != nil {
+					log.Println("Save data usage error:", err)
+				}
+			}
+			d.lock.Unlock()
+			return
+		}
+	}
+}
+
+// Usage returns traffic of the current period.
+func (d *DataCap) Usage() DataUsage {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	limit := d.limit()
+	usage := DataUsage{
+		PeriodStart: d.state.PeriodStart,
+		NextReset:   d.nextReset(d.state.PeriodStart),
+		Bytes:       d.state.Bytes,
+		CapBytes:    limit,
+		Suspended:   d.suspended,
+		Warning:     d.state.Warning,
+	}
+	if limit > 0 {
+		usage.Percent = 100 * float64(d.state.Bytes) / float64(limit)
+	}
+	return usage
+}
+
+// limit should be called with lock held.
+func (d *DataCap) limit() int64 {
+	if d.quotaBytes > 0 && (d.capBytes == 0 || d.quotaBytes < d.capBytes) {
+		return d.quotaBytes
+	}
+	return d.capBytes
+}
+
+// periodStart returns the start of the period now is in, which is the
+// midnight of reset day in local time.
+func (d *DataCap) periodStart(now time.Time) time.Time {
+	start := time.Date(now.Year(), now.Month(), d.resetDay, 0, 0, 0, 0, now.Location())
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+func (d *DataCap) nextReset(start time.Time) time.Time {
+	if start.IsZero() {
+		return start
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+// load should be called with lock held.
+func (d *DataCap) load() error {
+	if d.loaded {
+		return nil
+	}
+	b, err := d.Store.Get(dataUsageKey)
+	if err == nil {
+		err = json.Unmarshal(b, &d.state)
+		if err != nil {
+			return err
+		}
+	} else if err != storage.ErrNotFound {
+		return err
+	}
+	if d.journal != nil {
+		r, ok, err := d.journal.recover()
+		if err != nil {
+			return err
+		}
+		if ok && (r.periodStart.After(d.state.PeriodStart) || r.periodStart.Equal(d.state.PeriodStart) && r.bytes > d.state.Bytes) {
+			log.Printf("Recovered data usage %s from journal", formatBytes(r.bytes))
+			if r.periodStart.After(d.state.PeriodStart) {
+				d.state = dataUsageState{PeriodStart: r.periodStart}
+			}
+			d.state.Bytes = r.bytes
+		}
+	}
+	d.savedBytes = d.state.Bytes
+	d.journaledBytes = d.state.Bytes
+	d.loaded = true
+	return nil
+}
+
+// save should be called with lock held.
+func (d *DataCap) save() error {
+	b, err := json.Marshal(d.state)
+	if err != nil {
+		return err
+	}
+	err = d.Store.Put(dataUsageKey, b)
+	if err != nil {
+		return err
+	}
+	d.lastSave = time.Now()
+	d.savedBytes = d.state.Bytes
+	if d.journal != nil {
+		err = d.journal.reset(usageRecord{periodStart: d.state.PeriodStart, bytes: d.state.Bytes})
+		if err != nil {
+			return err
+		}
+		d.lastFlush = d.lastSave
+		d.journaledBytes = d.state.Bytes
+	}
+	return nil
+}
+
+// flush appends usage to journal. It should be called with lock held.
+func (d *DataCap) flush() error {
+	err := d.journal.append(usageRecord{periodStart: d.state.PeriodStart, bytes: d.state.Bytes})
+	if err != nil {
+		return err
+	}
+	d.lastFlush = time.Now()
+	d.journaledBytes = d.state.Bytes
+	return nil
+}
+
+func (d *DataCap) check() error {
+	d.lock.Lock()
+	err := d.load()
+	if err != nil {
+		d.lock.Unlock()
+		return err
+	}
+
+	now := time.Now()
+	changed := false
+	if start := d.periodStart(now); !start.Equal(d.state.PeriodStart) {
+		if start.After(d.state.PeriodStart) {
+			if !d.state.PeriodStart.IsZero() {
+				log.Printf("Data usage of period since %s is %s, reset for new period", d.state.PeriodStart.Format("2006-01-02"), formatBytes(d.state.Bytes))
+			}
+			d.state = dataUsageState{}
+		}
+		d.state.PeriodStart = start
+		changed = true
+	}
+
+	bytes := d.Bytes()
+	if bytes > d.lastBytes {
+		d.state.Bytes += bytes - d.lastBytes
+	}
+	d.lastBytes = bytes
+
+	limit := d.limit()
+	next := d.nextReset(d.state.PeriodStart).Format("2006-01-02")
+	if limit > 0 {
+		percent := 100 * float64(d.state.Bytes) / float64(limit)
+		for i := len(d.warnPercents) - 1; i >= 0; i-- {
+			p := d.warnPercents[i]
+			if percent < float64(p) {
+				continue
+			}
+			if p > d.state.Warned {
+				d.state.Warned = p
+				d.state.Warning = fmt.Sprintf("data usage %s reached %d%% of monthly cap %s, resets at %s", formatBytes(d.state.Bytes), p, formatBytes(limit), next)
+				log.Printf("WARNING: %s", d.state.Warning)
+				changed = true
+			}
+			break
+		}
+	}
+
+	wasSuspended := d.suspended
+	d.suspended = limit > 0 && d.state.Bytes >= limit
+	suspended := d.suspended
+	var reason string
+	if suspended {
+		reason = fmt.Sprintf("data usage %s exceeds monthly cap %s until %s", formatBytes(d.state.Bytes), formatBytes(limit), next)
+	} else if limit > 0 {
+		reason = fmt.Sprintf("data usage %s, monthly cap %s", formatBytes(d.state.Bytes), formatBytes(limit))
+	} else {
+		reason = fmt.Sprintf("data usage %s, no monthly cap", formatBytes(d.state.Bytes))
+	}
+
+	if d.journal == nil {
+		if changed || (d.state.Bytes != d.savedBytes && now.Sub(d.lastSave) >= d.flushInterval) {
+			err = d.save()
+		}
+	} else if changed || (d.state.Bytes != d.savedBytes && now.Sub(d.lastSave) >= dataUsageSnapshotInterval) {
+		err = d.save()
+	} else if d.state.Bytes != d.journaledBytes && now.Sub(d.lastFlush) >= d.flushInterval {
+		err = d.flush()
+	}
+	d.lock.Unlock()
+
+	if suspended != wasSuspended && d.OnSuspend != nil {
+		d.OnSuspend(suspended, reason)
+	}
+
+	return err
+}
+
+// formatBytes formats n bytes in decimal units, as data plans do.
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f%cB", float64(n)/float64(div), "kMGTPE"[exp])
+}
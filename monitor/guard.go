@@ -0,0 +1,135 @@
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// Usage has to drop below this fraction of the limit before the guard
+	// recovers, so it does not flap around the limit.
+	recoverRatio = 0.9
+)
+
+type Usage struct {
+	CPUPercent float64 `json:"cpuPercent"` // percent of all cores
+	MemoryMB   float64 `json:"memoryMB"`   // memory obtained from OS and not released back to it
+}
+
+// Guard samples process CPU and memory usage and calls OnChange when usage
+// crosses the soft limits, so callers can shed load until it recovers.
+type Guard struct {
+	MaxCPUPercent float64
+	MaxMemoryMB   float64
+	Interval      time.Duration
+	OnChange      func(overloaded bool, reason string)
+
+	lock       sync.RWMutex
+	usage      Usage
+	overloaded bool
+	lastCPU    time.Duration
+	lastTime   time.Time
+
+	// sampleUsage samples usage, g.sample if nil. It is replaced in tests.
+	sampleUsage func() (Usage, error)
+}
+
+func NewGuard(maxCPUPercent, maxMemoryMB float64, interval time.Duration, onChange func(bool, string)) *Guard {
+	return &Guard{
+		MaxCPUPercent: maxCPUPercent,
+		MaxMemoryMB:   maxMemoryMB,
+		Interval:      interval,
+		OnChange:      onChange,
+	}
+}
+
+func (g *Guard) Start(ctx context.Context) {
+	for {
+		err := g.check()
+		if err != nil {
+			log.Println("Check resource usage error:", err)
+		}
+		if !Sleep(ctx, g.Interval) {
+			return
+		}
+	}
+}
+
+func (g *Guard) Usage() Usage {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.usage
+}
+
+func (g *Guard) Overloaded() bool {
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+	return g.overloaded
+}
+
+func (g *Guard) sample() (Usage, error) {
+	var usage Usage
+
+	cpu, err := processCPUTime()
+	if err != nil {
+		return usage, err
+	}
+	now := time.Now()
+	if !g.lastTime.IsZero() {
+		elapsed := now.Sub(g.lastTime) * time.Duration(runtime.NumCPU())
+		if elapsed > 0 {
+			usage.CPUPercent = 100 * float64(cpu-g.lastCPU) / float64(elapsed)
+		}
+	}
+	g.lastCPU = cpu
+	g.lastTime = now
+
+	// Sys never decreases, so memory released back to OS is subtracted, or
+	// usage could never drop below the limit again to recover.
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	usage.MemoryMB = float64(m.Sys-m.HeapReleased) / (1 << 20)
+
+	return usage, nil
+}
+
+func (g *Guard) check() error {
+	sample := g.sampleUsage
+	if sample == nil {
+		sample = g.sample
+	}
+	usage, err := sample()
+	if err != nil {
+		return err
+	}
+
+	g.lock.Lock()
+	g.usage = usage
+	wasOverloaded := g.overloaded
+	ratio := 1.0
+	if wasOverloaded {
+		ratio = recoverRatio
+	}
+	var reason string
+	if g.MaxCPUPercent > 0 && usage.CPUPercent > g.MaxCPUPercent*ratio {
+		reason = fmt.Sprintf("CPU usage %.1f%% exceeds limit %.1f%%", usage.CPUPercent, g.MaxCPUPercent)
+	} else if g.MaxMemoryMB > 0 && usage.MemoryMB > g.MaxMemoryMB*ratio {
+		reason = fmt.Sprintf("memory usage %.1fMB exceeds limit %.1fMB", usage.MemoryMB, g.MaxMemoryMB)
+	}
+	g.overloaded = len(reason) > 0
+	overloaded := g.overloaded
+	g.lock.Unlock()
+
+	if overloaded != wasOverloaded && g.OnChange != nil {
+		if !overloaded {
+			reason = fmt.Sprintf("CPU usage %.1f%%, memory usage %.1fMB", usage.CPUPercent, usage.MemoryMB)
+		}
+		g.OnChange(overloaded, reason)
+	}
+
+	return nil
+}
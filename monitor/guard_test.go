@@ -0,0 +1,43 @@
+package monitor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGuardCheck(t *testing.T) {
+	var changes []bool
+	g := NewGuard(80, 100, 0, func(overloaded bool, reason string) {
+		changes = append(changes, overloaded)
+	})
+
+	samples := []struct {
+		usage      Usage
+		overloaded bool
+	}{
+		{Usage{CPUPercent: 10, MemoryMB: 50}, false},
+		{Usage{CPUPercent: 10, MemoryMB: 120}, true},
+		{Usage{CPUPercent: 10, MemoryMB: 95}, true}, // below limit but above recover ratio
+		{Usage{CPUPercent: 10, MemoryMB: 80}, false},
+		{Usage{CPUPercent: 90, MemoryMB: 80}, true},
+		{Usage{CPUPercent: 75, MemoryMB: 80}, true},
+		{Usage{CPUPercent: 20, MemoryMB: 80}, false},
+	}
+	for i, s := range samples {
+		g.sampleUsage = func() (Usage, error) { return s.usage, nil }
+		require.NoError(t, g.check())
+		require.Equal(t, s.overloaded, g.Overloaded(), "sample %d", i)
+		require.Equal(t, s.usage, g.Usage())
+	}
+	require.Equal(t, []bool{true, false, true, false}, changes)
+}
+
+func TestGuardSampleMemory(t *testing.T) {
+	g := NewGuard(0, 0, 0, nil)
+	usage, err := g.sample()
+	if err != nil {
+		t.Skip("process CPU time is not available:", err)
+	}
+	require.Greater(t, usage.MemoryMB, 0.0)
+}
@@ -0,0 +1,30 @@
+package monitor
+
+import (
+	"runtime"
+)
+
+// HostStats describes the machine nConnect is running on. Fields that are not
+// available on the current platform are left empty.
+type HostStats struct {
+	NumCPU         int       `json:"numCPU"`
+	LoadAverage    []float64 `json:"loadAverage,omitempty"` // 1, 5 and 15 minutes
+	MemTotalMB     uint64    `json:"memTotalMB,omitempty"`
+	MemAvailableMB uint64    `json:"memAvailableMB,omitempty"`
+	DiskTotalMB    uint64    `json:"diskTotalMB,omitempty"`
+	DiskFreeMB     uint64    `json:"diskFreeMB,omitempty"`
+	Uptime         int64     `json:"uptime,omitempty"` // seconds
+}
+
+// GetHostStats collects host stats, with disk usage measured on the partition
+// containing diskPath.
+func GetHostStats(diskPath string) (*HostStats, error) {
+	stats := &HostStats{
+		NumCPU: runtime.NumCPU(),
+	}
+	err := getPlatformHostStats(stats, diskPath)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
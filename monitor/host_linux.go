@@ -0,0 +1,66 @@
+package monitor
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+func getPlatformHostStats(stats *HostStats, diskPath string) error {
+	b, err := os.ReadFile("/proc/loadavg")
+	if err == nil {
+		fields := strings.Fields(string(b))
+		for i := 0; i < 3 && i < len(fields); i++ {
+			load, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				break
+			}
+			stats.LoadAverage = append(stats.LoadAverage, load)
+		}
+	}
+
+	b, err = os.ReadFile("/proc/uptime")
+	if err == nil {
+		fields := strings.Fields(string(b))
+		if len(fields) > 0 {
+			uptime, err := strconv.ParseFloat(fields[0], 64)
+			if err == nil {
+				stats.Uptime = int64(uptime)
+			}
+		}
+	}
+
+	f, err := os.Open("/proc/meminfo")
+	if err == nil {
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 2 {
+				continue
+			}
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				continue
+			}
+			switch fields[0] {
+			case "MemTotal:":
+				stats.MemTotalMB = kb / 1024
+			case "MemAvailable:":
+				stats.MemAvailableMB = kb / 1024
+			}
+		}
+	}
+
+	var fs syscall.Statfs_t
+	err = syscall.Statfs(diskPath, &fs)
+	if err != nil {
+		return err
+	}
+	stats.DiskTotalMB = fs.Blocks * uint64(fs.Bsize) / (1 << 20)
+	stats.DiskFreeMB = fs.Bavail * uint64(fs.Bsize) / (1 << 20)
+
+	return nil
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package monitor
+
+func getPlatformHostStats(stats *HostStats, diskPath string) error {
+	return nil
+}
@@ -0,0 +1,116 @@
+package monitor
+
+import (
+	"bufio"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// usageJournal is a write-ahead journal of data usage. Each flush appends a
+// small record with the usage of a period and syncs it, which costs far less
+// flash wear than rewriting the whole usage state. Records are checksummed,
+// so a record torn by power failure is skipped on recovery.
+type usageJournal struct {
+	path string
+}
+
+type usageRecord struct {
+	periodStart time.Time
+	bytes       int64
+}
+
+func (r usageRecord) String() string {
+	s := fmt.Sprintf("%d %d", r.periodStart.Unix(), r.bytes)
+	return fmt.Sprintf("%s %08x\n", s, crc32.ChecksumIEEE([]byte(s)))
+}
+
+func parseUsageRecord(line string) (usageRecord, bool) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return usageRecord{}, false
+	}
+	s := fields[0] + " " + fields[1]
+	if fmt.Sprintf("%08x", crc32.ChecksumIEEE([]byte(s))) != fields[2] {
+		return usageRecord{}, false
+	}
+	start, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return usageRecord{}, false
+	}
+	bytes, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return usageRecord{}, false
+	}
+	return usageRecord{periodStart: time.Unix(start, 0), bytes: bytes}, true
+}
+
+// append appends r to journal and syncs it to disk.
+func (j *usageJournal) append(r usageRecord) error {
+	f, err := os.OpenFile(j.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(r.String())
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	return err
+}
+
+// reset replaces journal with r only, after usage state is saved. Keeping
+// the last record instead of emptying it still recovers usage if the saved
+// state is lost.
+func (j *usageJournal) reset(r usageRecord) error {
+	f, err := os.CreateTemp(filepath.Dir(j.path), "."+filepath.Base(j.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.WriteString(r.String())
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), j.path)
+}
+
+// recover returns the latest valid record of journal, and false if there is
+// none.
+func (j *usageJournal) recover() (usageRecord, bool, error) {
+	f, err := os.Open(j.path)
+	if os.IsNotExist(err) {
+		return usageRecord{}, false, nil
+	}
+	if err != nil {
+		return usageRecord{}, false, err
+	}
+	defer f.Close()
+
+	var latest usageRecord
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		r, ok := parseUsageRecord(scanner.Text())
+		if !ok {
+			continue
+		}
+		if !found || r.periodStart.After(latest.periodStart) || r.periodStart.Equal(latest.periodStart) && r.bytes >= latest.bytes {
+			latest, found = r, true
+		}
+	}
+	return latest, found, scanner.Err()
+}
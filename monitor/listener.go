@@ -0,0 +1,114 @@
+package monitor
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Names of local listeners in listener stats.
+const (
+	ListenerSocks     = "socks"      // local socks proxy of client
+	ListenerTCPTun    = "tcp_tun"    // local TCP tunnel of client
+	ListenerRedir     = "redir"      // netfilter or pf redirected TCP connections of client
+	ListenerRedir6    = "redir6"     // netfilter redirected TCP IPv6 connections of client
+	ListenerProxy     = "proxy"      // local proxy of server, which tunnels forward clients to
+	ListenerAdminHTTP = "admin_http" // admin web GUI and API
+)
+
+var listeners struct {
+	sync.Mutex
+	byName map[string]*Listener
+}
+
+// ListenerStats is the connection counters of a local listener, so capacity
+// issues on the listener side can be told apart from tunnel issues.
+type ListenerStats struct {
+	Accepts           int64 `json:"accepts"`
+	AcceptErrors      int64 `json:"acceptErrors"`      // e.g. too many open files
+	HandshakeFailures int64 `json:"handshakeFailures"` // closed before a request is read, e.g. failed socks or TLS handshake
+	AuthFailures      int64 `json:"authFailures"`      // source not allowed, wrong cipher key or permission denied
+	Drops             int64 `json:"drops"`             // closed right after accept by connection limit
+
+	// Connections waiting in accept queue of the listen socket, only known on
+	// Linux.
+	QueueLength int64 `json:"queueLength,omitempty"`
+}
+
+// Listener counts connections of a local listener.
+type Listener struct {
+	addr net.Addr
+
+	accepts           atomic.Int64
+	acceptErrors      atomic.Int64
+	handshakeFailures atomic.Int64
+	authFailures      atomic.Int64
+	drops             atomic.Int64
+}
+
+// NewListener returns the counters of local listener name listening on addr.
+// Counters are kept if the listener is started again with the same name.
+func NewListener(name string, addr net.Addr) *Listener {
+	listeners.Lock()
+	defer listeners.Unlock()
+	if listeners.byName == nil {
+		listeners.byName = make(map[string]*Listener)
+	}
+	l, ok := listeners.byName[name]
+	if !ok {
+		l = &Listener{}
+		listeners.byName[name] = l
+	}
+	l.addr = addr
+	return l
+}
+
+func (l *Listener) Accepted()        { l.accepts.Add(1) }
+func (l *Listener) AcceptFailed()    { l.acceptErrors.Add(1) }
+func (l *Listener) HandshakeFailed() { l.handshakeFailures.Add(1) }
+func (l *Listener) AuthFailed()      { l.authFailures.Add(1) }
+func (l *Listener) Dropped()         { l.drops.Add(1) }
+
+// GetListenerStats returns a copy of connection counters of local listeners
+// by name.
+func GetListenerStats() map[string]ListenerStats {
+	listeners.Lock()
+	defer listeners.Unlock()
+	stats := make(map[string]ListenerStats, len(listeners.byName))
+	for name, l := range listeners.byName {
+		s := ListenerStats{
+			Accepts:           l.accepts.Load(),
+			AcceptErrors:      l.acceptErrors.Load(),
+			HandshakeFailures: l.handshakeFailures.Load(),
+			AuthFailures:      l.authFailures.Load(),
+			Drops:             l.drops.Load(),
+		}
+		if a, ok := l.addr.(*net.TCPAddr); ok {
+			s.QueueLength = getListenQueue(a.Port)
+		}
+		stats[name] = s
+	}
+	return stats
+}
+
+// Count returns ln that counts its accepted connections and accept errors in
+// l.
+func (l *Listener) Count(ln net.Listener) net.Listener {
+	return &countedListener{Listener: ln, stats: l}
+}
+
+type countedListener struct {
+	net.Listener
+	stats *Listener
+}
+
+func (l *countedListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err == nil {
+		l.stats.Accepted()
+	} else if !errors.Is(err, net.ErrClosed) {
+		l.stats.AcceptFailed()
+	}
+	return c, err
+}
@@ -0,0 +1,57 @@
+package monitor
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tcpStateListen is the state of listen sockets in /proc/net/tcp.
+const tcpStateListen = "0A"
+
+// getListenQueue returns the number of connections waiting in accept queue of
+// the TCP listen socket on port, which is the receive queue of listen sockets
+// in /proc/net/tcp, or zero if not found.
+func getListenQueue(port int) int64 {
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		queued, ok := findListenQueue(f, port)
+		f.Close()
+		if ok {
+			return queued
+		}
+	}
+	return 0
+}
+
+func findListenQueue(r io.Reader, port int) (int64, bool) {
+	scanner := bufio.NewScanner(r)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		// sl local_address rem_address st tx_queue:rx_queue ...
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[3] != tcpStateListen {
+			continue
+		}
+		i := strings.LastIndexByte(fields[1], ':')
+		p, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+		if err != nil || int(p) != port {
+			continue
+		}
+		_, rx, ok := strings.Cut(fields[4], ":")
+		if !ok {
+			continue
+		}
+		queued, err := strconv.ParseInt(rx, 16, 64)
+		if err != nil {
+			continue
+		}
+		return queued, true
+	}
+	return 0, false
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package monitor
+
+func getListenQueue(port int) int64 {
+	return 0
+}
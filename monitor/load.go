@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"context"
+	"log"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+)
+
+const (
+	// Time constant of load moving averages. A change in load is mostly
+	// reflected after this time, so short bursts don't flip server selection.
+	loadTimeConstant = time.Minute
+)
+
+// Load is the load of a server as exponential moving averages, published so
+// clients and dashboards can prefer underloaded servers.
+type Load struct {
+	Score             float64 `json:"score"`                       // 0 (idle) to 1 (fully loaded), the highest load of CPU, sessions and bandwidth
+	CPUPercent        float64 `json:"cpuPercent"`                  // percent of all cores
+	Sessions          float64 `json:"sessions"`                    // proxied connections
+	BandwidthMbps     float64 `json:"bandwidthMbps"`               // traffic in both directions
+	BandwidthHeadroom float64 `json:"bandwidthHeadroom,omitempty"` // fraction of bandwidth capacity left, only if capacity is known
+}
+
+// LoadSample is what LoadMeter samples from the server at each interval.
+type LoadSample struct {
+	Sessions int64 // current proxied connections
+	Bytes    int64 // total traffic since start
+}
+
+// LoadMeter samples CPU usage, sessions and traffic at interval, and keeps
+// their exponential moving averages. Sessions and bandwidth count in score
+// only when their capacity is given.
+type LoadMeter struct {
+	Interval      time.Duration
+	MaxSessions   int
+	BandwidthMbps float64 // bandwidth capacity
+	Sample        func() LoadSample
+
+	lock      sync.RWMutex
+	load      Load
+	sampled   bool
+	lastCPU   time.Duration
+	lastBytes int64
+	lastTime  time.Time
+}
+
+func NewLoadMeter(interval time.Duration, maxSessions int, bandwidthMbps float64, sample func() LoadSample) *LoadMeter {
+	return &LoadMeter{
+		Interval:      interval,
+		MaxSessions:   maxSessions,
+		BandwidthMbps: bandwidthMbps,
+		Sample:        sample,
+	}
+}
+
+func (m *LoadMeter) Start(ctx context.Context) {
+	for {
+		err := m.update()
+		if err != nil {
+			log.Println("Update load error:", err)
+		}
+		if !Sleep(ctx, m.Interval) {
+			return
+		}
+	}
+}
+
+// Load returns current load, or nil before there are two samples.
+func (m *LoadMeter) Load() *Load {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if !m.sampled {
+		return nil
+	}
+	load := m.load
+	return &load
+}
+
+func (m *LoadMeter) update() error {
+	cpu, err := processCPUTime()
+	if err != nil {
+		return err
+	}
+	sample := m.Sample()
+	now := time.Now()
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.lastTime.IsZero() {
+		m.lastCPU, m.lastBytes, m.lastTime = cpu, sample.Bytes, now
+		return nil
+	}
+	elapsed := now.Sub(m.lastTime)
+	if elapsed <= 0 {
+		return nil
+	}
+	cpuPercent := 100 * float64(cpu-m.lastCPU) / float64(elapsed*time.Duration(runtime.NumCPU()))
+	mbps := float64(sample.Bytes-m.lastBytes) * 8 / elapsed.Seconds() / 1e6
+	m.lastCPU, m.lastBytes, m.lastTime = cpu, sample.Bytes, now
+
+	alpha := 1.0
+	if m.sampled {
+		alpha = 1 - math.Exp(-float64(elapsed)/float64(loadTimeConstant))
+	}
+	m.load.CPUPercent += alpha * (cpuPercent - m.load.CPUPercent)
+	m.load.Sessions += alpha * (float64(sample.Sessions) - m.load.Sessions)
+	m.load.BandwidthMbps += alpha * (mbps - m.load.BandwidthMbps)
+	m.sampled = true
+
+	score := m.load.CPUPercent / 100
+	if m.MaxSessions > 0 {
+		score = math.Max(score, m.load.Sessions/float64(m.MaxSessions))
+	}
+	if m.BandwidthMbps > 0 {
+		used := m.load.BandwidthMbps / m.BandwidthMbps
+		m.load.BandwidthHeadroom = math.Max(0, 1-used)
+		score = math.Max(score, used)
+	}
+	m.load.Score = math.Min(1, score)
+
+	return nil
+}
@@ -0,0 +1,62 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"time"
+)
+
+const (
+	// A timer firing this much later than due was delayed by system suspend
+	// rather than by scheduling.
+	suspendSlack = 5 * time.Second
+)
+
+// WatchSuspend checks at interval whether the system has been suspended
+// (e.g. laptop sleep) for more than threshold, and calls onResume with the
+// time slept after it resumes. It returns when ctx is done.
+//
+// Wall clock keeps running while suspended on all platforms, but monotonic
+// clock stops on some of them, so the larger gap of the two is taken.
+func WatchSuspend(ctx context.Context, interval, threshold time.Duration, onResume func(slept time.Duration)) {
+	last := time.Now()
+	for {
+		if !Sleep(ctx, interval) {
+			return
+		}
+		now := time.Now()
+		gap := now.Sub(last)
+		if wall := now.Round(0).Sub(last.Round(0)); wall > gap {
+			gap = wall
+		}
+		last = now
+		if slept := gap - interval; slept > threshold {
+			onResume(slept)
+		}
+	}
+}
+
+// TimedOutLate returns whether err is a timeout of a deadline set at setAt
+// after timeout, which fired much later than due. It happens when system was
+// suspended and monotonic clock counts time slept, so the connection was not
+// really idle that long and should be kept.
+func TimedOutLate(err error, setAt time.Time, timeout time.Duration) bool {
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		return false
+	}
+	return time.Since(setAt) > timeout+suspendSlack
+}
+
+// Sleep waits for d, and returns false without waiting longer if ctx is done
+// before that.
+func Sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,114 @@
+package nat
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync"
+	"time"
+)
+
+type Type string
+
+const (
+	TypeUnknown   Type = "unknown"
+	TypeOpen      Type = "open"      // public IP, no NAT
+	TypeCone      Type = "cone"      // same mapping regardless of destination
+	TypeSymmetric Type = "symmetric" // mapping changes per destination
+	TypeBlocked   Type = "blocked"   // no STUN response over UDP
+)
+
+const (
+	stunTimeout = 3 * time.Second
+)
+
+var (
+	lock     sync.RWMutex
+	detected = TypeUnknown
+)
+
+// DirectPathPossible returns whether a peer behind this NAT type can be
+// reached directly (possibly with hole punching), so callers can skip direct
+// connection attempts that are bound to fail.
+func (t Type) DirectPathPossible() bool {
+	return t == TypeOpen || t == TypeCone
+}
+
+// Detect determines NAT type by sending STUN binding requests from a single
+// UDP socket to (at least two) different servers: if the public mapping
+// equals a local address there is no NAT, if the mappings differ the NAT is
+// symmetric, otherwise it is cone.
+func Detect(servers []string) (Type, error) {
+	if len(servers) < 2 {
+		return TypeUnknown, fmt.Errorf("at least 2 STUN servers are needed, got %d", len(servers))
+	}
+
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return TypeUnknown, err
+	}
+	defer conn.Close()
+	localPort := conn.LocalAddr().(*net.UDPAddr).Port
+
+	mapped := make([]*net.UDPAddr, 0, len(servers))
+	for _, server := range servers {
+		addr, err := stunBinding(conn, server, stunTimeout)
+		if err != nil {
+			log.Printf("STUN binding with %s error: %v", server, err)
+			continue
+		}
+		mapped = append(mapped, addr)
+		if len(mapped) == 2 {
+			break
+		}
+	}
+
+	switch len(mapped) {
+	case 0:
+		return TypeBlocked, nil
+	case 1:
+		return TypeUnknown, nil
+	}
+
+	if !mapped[0].IP.Equal(mapped[1].IP) || mapped[0].Port != mapped[1].Port {
+		return TypeSymmetric, nil
+	}
+	if mapped[0].Port == localPort && isLocalIP(mapped[0].IP) {
+		return TypeOpen, nil
+	}
+	return TypeCone, nil
+}
+
+// DetectAndStore runs Detect and stores the result to be returned by
+// Detected.
+func DetectAndStore(servers []string) (Type, error) {
+	t, err := Detect(servers)
+	if err != nil {
+		return t, err
+	}
+	lock.Lock()
+	detected = t
+	lock.Unlock()
+	return t, nil
+}
+
+// Detected returns the last stored NAT type, or TypeUnknown if detection has
+// not finished.
+func Detected() Type {
+	lock.RLock()
+	defer lock.RUnlock()
+	return detected
+}
+
+func isLocalIP(ip net.IP) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package nat
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"time"
+)
+
+const (
+	stunHeaderSize      = 20
+	stunMagicCookie     = 0x2112A442
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunAttrMappedAddr  = 0x0001
+	stunAttrXorMapped   = 0x0020
+	stunFamilyIPv4      = 0x01
+	stunFamilyIPv6      = 0x02
+)
+
+var (
+	errInvalidStunResponse = errors.New("invalid STUN response")
+	errNoMappedAddress     = errors.New("no mapped address in STUN response")
+)
+
+// stunBinding sends a binding request to server from conn and returns the
+// mapped (public) address the server sees.
+func stunBinding(conn net.PacketConn, server string, timeout time.Duration) (*net.UDPAddr, error) {
+	addr, err := net.ResolveUDPAddr("udp4", server)
+	if err != nil {
+		return nil, err
+	}
+
+	req := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(req[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint16(req[2:4], 0)
+	binary.BigEndian.PutUint32(req[4:8], stunMagicCookie)
+	txID := req[8:20]
+	rand.Read(txID)
+
+	_, err = conn.WriteTo(req, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 1024)
+	deadline := time.Now().Add(timeout)
+	for {
+		err = conn.SetReadDeadline(deadline)
+		if err != nil {
+			return nil, err
+		}
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return nil, err
+		}
+		if n < stunHeaderSize || binary.BigEndian.Uint16(buf[0:2]) != stunBindingResponse || string(buf[8:20]) != string(txID) {
+			continue // not the response to this request
+		}
+		return parseStunResponse(buf[:n])
+	}
+}
+
+func parseStunResponse(b []byte) (*net.UDPAddr, error) {
+	length := int(binary.BigEndian.Uint16(b[2:4]))
+	if stunHeaderSize+length > len(b) {
+		return nil, errInvalidStunResponse
+	}
+	attrs := b[stunHeaderSize : stunHeaderSize+length]
+
+	var mapped *net.UDPAddr
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+attrLen > len(attrs) {
+			return nil, errInvalidStunResponse
+		}
+		value := attrs[4 : 4+attrLen]
+
+		switch attrType {
+		case stunAttrXorMapped:
+			addr, err := parseStunAddr(value, b[4:20])
+			if err != nil {
+				return nil, err
+			}
+			return addr, nil
+		case stunAttrMappedAddr:
+			addr, err := parseStunAddr(value, nil)
+			if err != nil {
+				return nil, err
+			}
+			mapped = addr
+		}
+
+		// attributes are padded to 4 bytes
+		next := 4 + (attrLen+3)/4*4
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+
+	if mapped == nil {
+		return nil, errNoMappedAddress
+	}
+	return mapped, nil
+}
+
+// parseStunAddr parses a (XOR-)MAPPED-ADDRESS value. xorKey is the magic
+// cookie followed by transaction ID, or nil if the address is not xored.
+func parseStunAddr(value, xorKey []byte) (*net.UDPAddr, error) {
+	if len(value) < 4 {
+		return nil, errInvalidStunResponse
+	}
+	var ipLen int
+	switch value[1] {
+	case stunFamilyIPv4:
+		ipLen = net.IPv4len
+	case stunFamilyIPv6:
+		ipLen = net.IPv6len
+	default:
+		return nil, errInvalidStunResponse
+	}
+	if len(value) < 4+ipLen {
+		return nil, errInvalidStunResponse
+	}
+
+	port := binary.BigEndian.Uint16(value[2:4])
+	ip := make(net.IP, ipLen)
+	copy(ip, value[4:4+ipLen])
+	if xorKey != nil {
+		port ^= uint16(stunMagicCookie >> 16)
+		for i := range ip {
+			ip[i] ^= xorKey[i]
+		}
+	}
+
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
@@ -1,25 +1,33 @@
 package nconnect
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime/debug"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
-	"github.com/eycorsican/go-tun2socks/core"
-	"github.com/eycorsican/go-tun2socks/proxy/socks"
 	"github.com/imdario/mergo"
 	"github.com/nknorg/nconnect/admin"
-	"github.com/nknorg/nconnect/arch"
 	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/direct"
+	"github.com/nknorg/nconnect/events"
+	"github.com/nknorg/nconnect/monitor"
+	"github.com/nknorg/nconnect/nat"
 	"github.com/nknorg/nconnect/ss"
+	"github.com/nknorg/nconnect/storage"
 	"github.com/nknorg/nconnect/util"
 	"github.com/nknorg/ncp-go"
 	"github.com/nknorg/nkn-sdk-go"
@@ -52,36 +60,108 @@ type nconnect struct {
 	remoteInfoCache    map[string]*admin.GetInfoJSON // map remote admin address to remote info
 	remoteInfoByTunnel map[string]*admin.GetInfoJSON // map tunnel address to remote info
 
-	tunnels  []*tunnel.Tunnel
-	tunaNode *types.Node // It is used to connect specified tuna node, mainly is for testing.
+	tunnels    []*tunnel.Tunnel
+	forwarders []*direct.Forwarder
+	tunaNode   *types.Node // It is used to connect specified tuna node, mainly is for testing.
+
+	logFile *lumberjack.Logger // nil if logging to stderr
+
+	loadMeter *monitor.LoadMeter // nil in client mode
+	dataCap   *monitor.DataCap
+
+	tunLock        sync.Mutex
+	tunDevices     []*tunDevice
+	tunNetworkSet  bool              // routes and DNS of TUN devices are set
+	ssAddrByRemote map[string]string // map remote tunnel address to local tunnel port
 }
 
-func NewNconnect(opts *config.Opts) (*nconnect, error) {
+// loadConfig loads the persisted config and merges it into opts, with values
+// given in opts taking precedence.
+func loadConfig(opts *config.Opts) (*config.Config, error) {
 	err := (&opts.Config).SetPlatformSpecificDefaultValues()
 	if err != nil {
 		return nil, err
 	}
 
-	if opts.Client == opts.Server {
-		log.Fatal("Exactly one mode (client or server) should be selected.")
+	persistConf, err := config.LoadOrNewConfigFormat(opts.ConfigFile, opts.ConfigFormat)
+	if err != nil {
+		return nil, err
 	}
 
-	persistConf, err := config.LoadOrNewConfig(opts.ConfigFile)
+	err = mergo.Merge(&opts.Config, persistConf)
 	if err != nil {
 		return nil, err
 	}
 
-	err = mergo.Merge(&opts.Config, persistConf)
+	if len(opts.SeedFile) > 0 && len(opts.SeedProvider) > 0 {
+		return nil, errors.New("seedFile and seedProvider should not be both set")
+	}
+	if len(opts.SeedFile) > 0 {
+		seed, err := config.ReadSeedFile(opts.SeedFile)
+		if err != nil {
+			return nil, err
+		}
+		persistConf.SetSeedFromFile(seed)
+		opts.Seed = seed
+	}
+	if len(opts.SeedProvider) > 0 {
+		seed, err := config.FetchSeed(opts.SeedProvider)
+		if err != nil {
+			return nil, err
+		}
+		persistConf.SetSeedFromProvider(seed)
+		opts.Seed = seed
+	}
+
+	if len(opts.SecretsFile) > 0 && opts.SecretsFile != persistConf.SecretsFile {
+		err = persistConf.SetSecretsFile(opts.SecretsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range persistConf.CheckFilePerms() {
+		log.Printf("WARNING: %s, run with --fix-perms to fix it", p)
+	}
+
+	persistConf.SetMaxBackups(opts.ConfigBackups)
+
+	if len(opts.SeedStorage) > 0 && opts.SeedStorage != persistConf.SeedStorage {
+		err = persistConf.SetSeedStorage(opts.SeedStorage)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.StateStorage == storage.TypeFile && len(persistConf.StateStorage) > 0 {
+		opts.StateStorage = persistConf.StateStorage
+	}
+	store, err := storage.New(opts.StateStorage, opts.GetStateDir())
+	if err != nil {
+		return nil, fmt.Errorf("open %s state storage error: %v", opts.StateStorage, err)
+	}
+	persistConf.SetStorage(store)
+
+	return persistConf, nil
+}
+
+func NewNconnect(opts *config.Opts) (*nconnect, error) {
+	if opts.Client == opts.Server {
+		log.Fatal("Exactly one mode (client or server) should be selected.")
+	}
+
+	persistConf, err := loadConfig(opts)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(opts.LogFileName) > 0 {
-		log.SetOutput(&lumberjack.Logger{
-			Filename:   opts.LogFileName,
-			MaxSize:    opts.LogMaxSize,
-			MaxBackups: opts.LogMaxBackups,
-		})
+	logFile := setupLog(&opts.Config)
+
+	if config.LowMemoryBuild || opts.LowMemory {
+		opts.LowMemory = true
+		opts.ApplyLowMemory()
+		debug.SetGCPercent(config.LowMemoryGCPercent)
+		log.Println("Low memory mode enabled")
 	}
 
 	seed, err := hex.DecodeString(opts.Seed)
@@ -95,16 +175,43 @@ func NewNconnect(opts *config.Opts) (*nconnect, error) {
 	}
 
 	shouldSave := false
-	if len(opts.Seed) == 0 {
+	firstRun := len(opts.Seed) == 0
+	if firstRun {
 		persistConf.Seed = hex.EncodeToString(account.Seed())
 		opts.Seed = persistConf.Seed
 		shouldSave = true
 	}
 
+	util.DefaultRedactor.AddSecrets(opts.Secrets()...)
+
 	if len(opts.Identifier) == 0 {
 		persistConf.Identifier = config.RandomIdentifier()
 		opts.Identifier = persistConf.Identifier
 		shouldSave = true
+		if binding, err := config.MachineBinding(); err == nil {
+			persistConf.MachineBinding = binding
+		} else {
+			log.Println("Get machine ID error:", err)
+		}
+	}
+
+	if opts.Cipher == config.CipherAuto {
+		if opts.Server && opts.LoopbackDummyCipher {
+			opts.Cipher = config.CipherDummy // server socks proxy always listens on loopback
+		} else if len(persistConf.Cipher) > 0 && persistConf.Cipher != config.CipherAuto {
+			opts.Cipher = persistConf.Cipher
+		} else if opts.Server {
+			opts.Cipher = config.DefaultCipher
+			if firstRun {
+				opts.Cipher, err = ss.FastestCipher()
+				if err != nil {
+					return nil, err
+				}
+				log.Println("Selected fastest cipher on this CPU:", opts.Cipher)
+			}
+			persistConf.Cipher = opts.Cipher
+			shouldSave = true
+		}
 	}
 
 	if shouldSave {
@@ -190,6 +297,8 @@ func NewNconnect(opts *config.Opts) (*nconnect, error) {
 
 	clientConfig := &nkn.ClientConfig{
 		SeedRPCServerAddr: seedRPCServerAddr,
+		MsgChanLen:        opts.MsgChanLen,
+		RPCConcurrency:    opts.RPCConcurrency,
 	}
 	walletConfig := &nkn.WalletConfig{
 		SeedRPCServerAddr: seedRPCServerAddr,
@@ -209,6 +318,7 @@ func NewNconnect(opts *config.Opts) (*nconnect, error) {
 	}
 
 	tsConfig := &ts.Config{
+		NumTunaListeners:             opts.NumTunaListeners,
 		TunaMaxPrice:                 opts.TunaMaxPrice,
 		TunaMinNanoPayFee:            opts.TunaMinFee,
 		TunaNanoPayFeeRatio:          opts.TunaFeeRatio,
@@ -218,18 +328,37 @@ func NewNconnect(opts *config.Opts) (*nconnect, error) {
 		TunaDownloadGeoDB:            !opts.TunaDisableDownloadGeoDB,
 		TunaGeoDBPath:                opts.TunaGeoDBPath,
 		TunaMeasureBandwidth:         !opts.TunaDisableMeasureBandwidth,
-		TunaMeasureStoragePath:       opts.TunaMeasureStoragePath,
+		TunaMeasureStoragePath:       opts.GetTunaMeasureStoragePath(),
 		TunaMeasurementBytesDownLink: opts.TunaMeasureBandwidthBytes,
 		TunaMinBalance:               opts.TunaMinBalance,
 	}
+	if opts.Client && !opts.DisableRoaming {
+		tsConfig.ReconnectRetries = roamingTunaReconnectRetries
+	}
+	if opts.LowMemory {
+		tsConfig.UDPRecvBufferSize = config.LowMemoryUDPRecvBufferSize
+		tsConfig.MaxUdpDatagramBuffered = config.LowMemoryMaxUDPDatagrams
+	}
 
-	if opts.SessionWindowSize > 0 {
-		clientConfig.SessionConfig = &ncp.Config{SessionWindowSize: opts.SessionWindowSize}
-		tsConfig.SessionConfig = &ncp.Config{SessionWindowSize: opts.SessionWindowSize}
+	sessionConfig := ncp.Config{
+		SessionWindowSize:            opts.SessionWindowSize,
+		MTU:                          opts.SessionMTU,
+		MinConnectionWindowSize:      opts.SessionMinConnectionWindowSize,
+		FlushInterval:                opts.SessionFlushInterval,
+		Linger:                       opts.SessionLinger,
+		InitialRetransmissionTimeout: opts.SessionInitialRetransmissionTimeout,
+		MaxRetransmissionTimeout:     opts.SessionMaxRetransmissionTimeout,
+		SendAckInterval:              opts.SessionSendAckInterval,
+	}
+	if sessionConfig != (ncp.Config{}) {
+		clientSessionConfig, tsSessionConfig := sessionConfig, sessionConfig
+		clientConfig.SessionConfig = &clientSessionConfig
+		tsConfig.SessionConfig = &tsSessionConfig
 	}
 
 	tunnelConfig := &tunnel.Config{
-		AcceptAddrs:       nkn.NewStringArray(persistConf.AcceptAddrs...),
+		NumSubClients:     opts.NumSubClients,
+		AcceptAddrs:       nkn.NewStringArray(persistConf.GetAcceptAddrs()...),
 		ClientConfig:      clientConfig,
 		WalletConfig:      walletConfig,
 		DialConfig:        dialConfig,
@@ -244,9 +373,20 @@ func NewNconnect(opts *config.Opts) (*nconnect, error) {
 		Cipher:   opts.Cipher,
 		Password: opts.Password,
 
-		Verbose:    opts.Verbose,
-		UDPTimeout: config.DefaultUDPTimeout,
-		UDP:        opts.UDP,
+		Verbose:     opts.Verbose,
+		UDPTimeout:  config.DefaultUDPTimeout,
+		UDP:         opts.UDP,
+		StrictAudit: opts.StrictAudit,
+		MaxConns:    opts.MaxProxyConns,
+		LowMemory:   opts.LowMemory,
+
+		MaxUploadMbps:   opts.MaxUploadMbps,
+		MaxDownloadMbps: opts.MaxDownloadMbps,
+
+		DNSCache:            !opts.DisableDNSCache,
+		DNSCacheMinTTL:      time.Duration(opts.DNSCacheMinTTL) * time.Second,
+		DNSCacheMaxTTL:      time.Duration(opts.DNSCacheMaxTTL) * time.Second,
+		DNSCacheNegativeTTL: time.Duration(opts.DNSCacheNegativeTTL) * time.Second,
 
 		TargetToClient: make(map[string]string),
 	}
@@ -255,6 +395,20 @@ func NewNconnect(opts *config.Opts) (*nconnect, error) {
 		ssConfig.UDPSocks = true
 	}
 
+	if opts.Client {
+		ssConfig.SocksAllowedIPs, err = config.ParseAllowedIPs(opts.SocksAllowedIPs)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range opts.ConnTags {
+			cidrs, domains, err := r.ParseTargets()
+			if err != nil {
+				return nil, err
+			}
+			ssConfig.TagRules = append(ssConfig.TagRules, ss.TagRule{Tag: r.Tag, CIDRs: cidrs, Domains: domains, Ports: r.Ports})
+		}
+	}
+
 	nc := &nconnect{
 		opts:         opts,
 		account:      account,
@@ -263,6 +417,7 @@ func NewNconnect(opts *config.Opts) (*nconnect, error) {
 		ssConfig:     ssConfig,
 		walletConfig: walletConfig,
 		persistConf:  persistConf,
+		logFile:      logFile,
 
 		remoteInfoCache:    make(map[string]*admin.GetInfoJSON),
 		remoteInfoByTunnel: make(map[string]*admin.GetInfoJSON),
@@ -276,10 +431,20 @@ func (nc *nconnect) getAdminClient() (*admin.Client, error) {
 	if nc.adminClientCache != nil {
 		return nc.adminClientCache, nil
 	}
-	c, err := admin.NewClient(nc.account, nc.clientConfig)
+	c, err := admin.NewClient(nc.account, nc.clientConfig, nc.opts.GetAdminNumSubClients())
 	if err != nil {
 		return nil, err
 	}
+	if nc.opts.Client {
+		name := nc.opts.Name
+		if len(name) == 0 {
+			name, _ = os.Hostname()
+		}
+		c.SetName(name)
+		if devices := nc.getTunDeviceConfigs(); len(devices) > 0 {
+			c.SetTunAddr(devices[0].Addr)
+		}
+	}
 	// Wait for more sub-clients to connect
 	time.Sleep(time.Second)
 	nc.adminClientCache = c
@@ -309,60 +474,127 @@ func (nc *nconnect) getRemoteInfo(remoteAdminAddr string) (*admin.GetInfoJSON, e
 	return remoteInfoCache, nil
 }
 
-func (nc *nconnect) StartClient() error {
+func (nc *nconnect) detectNAT() {
+	if nc.opts.DisableNATDetection {
+		return
+	}
+	natType, err := nat.DetectAndStore(nc.opts.STUNServers)
+	if err != nil {
+		log.Println("Detect NAT type error:", err)
+		return
+	}
+	log.Println("Detected NAT type:", natType)
+}
+
+// remoteCipher returns the cipher of the first remote server that has it in
+// remote info. If none has, dummy cipher is used if enabled for loopback and
+// default cipher otherwise. All remote servers should
+// use the same cipher as there is only one local socks proxy.
+func (nc *nconnect) remoteCipher(remoteTunnelAddr []string) string {
+	var cipher string
+	for _, remote := range remoteTunnelAddr {
+		remoteInfo, ok := nc.remoteInfoByTunnel[remote]
+		if !ok || len(remoteInfo.Cipher) == 0 {
+			continue
+		}
+		if len(cipher) == 0 {
+			cipher = remoteInfo.Cipher
+		} else if remoteInfo.Cipher != cipher {
+			log.Printf("Remote server %s uses cipher %s instead of %s, set --cipher on all servers to use the same one", remote, remoteInfo.Cipher, cipher)
+		}
+	}
+	if len(cipher) == 0 {
+		if nc.opts.LoopbackDummyCipher && config.IsLoopbackAddr(nc.opts.LocalSocksAddr) {
+			return config.CipherDummy
+		}
+		return config.DefaultCipher
+	}
+	return cipher
+}
+
+// StartClient starts client and blocks until SIGINT or SIGTERM is received,
+// ctx is done or a subsystem stops. All subsystems are stopped before it
+// returns.
+func (nc *nconnect) StartClient(ctx context.Context) error {
 	err := nc.opts.VerifyClient()
 	if err != nil {
 		return err
 	}
+	warnIgnoredConfig(nc.opts)
 
-	remoteTunnelAddr := nc.opts.RemoteTunnelAddr
-	if len(remoteTunnelAddr) == 0 {
-		for _, remoteAdminAddr := range nc.opts.RemoteAdminAddr {
-			remoteInfo, err := nc.getRemoteInfo(remoteAdminAddr)
+	subs := newSubsystems(ctx)
+	defer nc.stop(subs)
+
+	err = nc.checkSecurity()
+	if err != nil {
+		return err
+	}
+
+	err = nc.checkIdentifier()
+	if err != nil {
+		return err
+	}
+
+	nc.opts.LocalSocksAddr, err = preflightListenAddr("localSocksAddr", "--local-socks-addr", nc.opts.LocalSocksAddr, nc.opts.AutoPort)
+	if err != nil {
+		return err
+	}
+
+	go nc.detectNAT()
+	go nc.checkClockSkew()
+
+	remoteTunnelAddr := nc.getRemoteTunnelAddrs(nc.opts.RemoteAdminAddrs(), nc.opts.RemoteTunnelAddr)
+
+	seen := make(map[string]bool, len(remoteTunnelAddr))
+	for _, remote := range remoteTunnelAddr {
+		seen[remote] = true
+	}
+
+	var devices []*tunDevice
+	for _, conf := range nc.getTunDeviceConfigs() {
+		d := &tunDevice{TunDeviceConfig: conf}
+		remoteAdminAddr := d.RemoteAdminAddr
+		if len(d.RemoteAdminAddr) > 0 || len(d.RemoteTunnelAddr) > 0 {
+			d.remotes = nc.getRemoteTunnelAddrs(d.RemoteAdminAddr, d.RemoteTunnelAddr)
+			if len(d.remotes) == 0 {
+				return fmt.Errorf("no remote tunnel address for TUN device %s, start client fail", d.Name)
+			}
+		} else {
+			remoteAdminAddr = nc.opts.RemoteAdminAddrs()
+			d.remotes = remoteTunnelAddr
+		}
+		if d.VPN {
+			d.routes, err = nc.getVPNRoutes(&d.TunDeviceConfig, remoteAdminAddr)
 			if err != nil {
-				log.Printf("getRemoteInfo %v err: %v", remoteAdminAddr, err)
-				continue
+				return err
+			}
+			d.routes = nc.applyIPv6Policy(d, d.routes)
+			d.DNSSearch = nc.getDNSSearch(&d.TunDeviceConfig, remoteAdminAddr)
+		}
+		for _, remote := range d.remotes {
+			if !seen[remote] {
+				seen[remote] = true
+				remoteTunnelAddr = append(remoteTunnelAddr, remote)
 			}
-			remoteTunnelAddr = append(remoteTunnelAddr, remoteInfo.Addr)
 		}
+		devices = append(devices, d)
 	}
 	if len(remoteTunnelAddr) == 0 {
 		return fmt.Errorf("no remote tunnel address, start client fail")
 	}
 
-	var vpnCIDR []*net.IPNet
-	if nc.opts.VPN {
-		vpnRoutes := nc.opts.VPNRoute
-		if len(vpnRoutes) == 0 {
-			for _, remoteAdminAddr := range nc.opts.RemoteAdminAddr {
-				remoteInfo, err := nc.getRemoteInfo(remoteAdminAddr)
-				if err != nil {
-					log.Printf("getRemoteInfo %v err: %v", remoteAdminAddr, err)
-					continue
-				}
-				if len(remoteInfo.LocalIP.Ipv4) > 0 {
-					vpnRoutes = make([]string, 0, len(remoteInfo.LocalIP.Ipv4))
-					for _, ip := range remoteInfo.LocalIP.Ipv4 {
-						if ip == nc.opts.TunAddr || ip == nc.opts.TunGateway {
-							log.Printf("Skipping server's local IP %s in routes", ip)
-							continue
-						}
-						vpnRoutes = append(vpnRoutes, fmt.Sprintf("%s/32", ip))
-					}
-				}
-			}
-		}
-		if len(vpnRoutes) > 0 {
-			vpnCIDR = make([]*net.IPNet, len(vpnRoutes))
-			for i, cidr := range vpnRoutes {
-				_, cidr, err := net.ParseCIDR(cidr)
-				if err != nil {
-					return fmt.Errorf("parse CIDR %s error: %v", cidr, err)
-				}
-				vpnCIDR[i] = cidr
-			}
+	if nc.opts.Cipher == config.CipherAuto {
+		nc.opts.Cipher = nc.remoteCipher(remoteTunnelAddr)
+		nc.ssConfig.Cipher = nc.opts.Cipher
+		log.Println("Using cipher:", nc.opts.Cipher)
+		err = nc.opts.VerifyCipher()
+		if err != nil {
+			return err
 		}
 	}
+	if nc.opts.Cipher == config.CipherDummy {
+		log.Println("Using dummy cipher.", config.DummyCipherNote)
+	}
 
 	proxyAddr, err := net.ResolveTCPAddr("tcp", nc.opts.LocalSocksAddr)
 	if err != nil {
@@ -371,7 +603,8 @@ func (nc *nconnect) StartClient() error {
 	proxyHost := proxyAddr.IP.String()
 	proxyPort := uint16(proxyAddr.Port)
 
-	var from, to []string
+	var ssAddrs, from, to []string
+	nc.ssAddrByRemote = make(map[string]string, len(remoteTunnelAddr))
 	for _, remote := range remoteTunnelAddr {
 		port, err := util.GetFreePort()
 		if err != nil {
@@ -379,85 +612,126 @@ func (nc *nconnect) StartClient() error {
 		}
 
 		ssAddr := "127.0.0.1:" + strconv.Itoa(port)
-		from = append(from, ssAddr)
-		to = append(to, remote)
+		ssAddrs = append(ssAddrs, ssAddr)
+		nc.ssAddrByRemote[remote] = ssAddr
+		tunnelAddr := ssAddr
 
 		if remoteInfo, ok := nc.remoteInfoByTunnel[remote]; ok {
 			for _, addr := range remoteInfo.LocalIP.Ipv4 {
 				nc.ssConfig.TargetToClient[addr] = ssAddr
 			}
+
+			if nc.opts.Direct && len(remoteInfo.DirectAddrs) > 0 {
+				port, err := util.GetFreePort()
+				if err != nil {
+					return err
+				}
+				tunnelAddr = "127.0.0.1:" + strconv.Itoa(port)
+				localAddr := address.MakeAddressString(nc.account.PubKey(), nc.opts.Identifier)
+				f := direct.NewForwarder(ssAddr, nc.account, localAddr, remote, remoteInfo.DirectAddrs, tunnelAddr, nc.opts.UDP, nc.opts.Verbose)
+				nc.forwarders = append(nc.forwarders, f)
+			}
 		}
+
+		from = append(from, tunnelAddr)
+		to = append(to, remote)
 	}
-	tunnels, err := tunnel.NewTunnels(nc.account, nc.opts.Identifier, from, to, nc.opts.Tuna, nc.tunnelConfig, nil)
+	tunnels, err := nc.newClientTunnels(from, to)
 	if err != nil {
 		return err
 	}
 	nc.tunnels = tunnels
 
 	nc.ssConfig.Socks = nc.opts.LocalSocksAddr
-	nc.ssConfig.Client = from[0]
-	nc.ssConfig.DefaultClient = from[0] // the first config is the default client
+	nc.ssConfig.Client = ssAddrs[0]
+	nc.ssConfig.DefaultClient = ssAddrs[0] // the first config is the default client
 
 	log.Println("Client socks proxy listen address:", nc.opts.LocalSocksAddr)
 
-	if nc.opts.Tun || nc.opts.VPN {
-		tunDevice, err := arch.OpenTunDevice(nc.opts.TunName, nc.opts.TunAddr, nc.opts.TunGateway, nc.opts.TunMask, nc.opts.TunDNS, true)
+	if nc.opts.AdvertiseProxy {
+		a, err := nc.advertiseProxy(proxyAddr)
 		if err != nil {
-			return fmt.Errorf("failed to open TUN device: %v", err)
+			log.Printf("Advertise socks proxy error: %v", err)
+		} else {
+			defer a.Close()
 		}
+	}
 
-		core.RegisterOutputFn(tunDevice.Write)
-
-		core.RegisterTCPConnHandler(socks.NewTCPHandler(proxyHost, proxyPort))
-		core.RegisterUDPConnHandler(socks.NewUDPHandler(proxyHost, proxyPort, 30*time.Second))
-
-		lwipWriter := core.NewLWIPStack()
+	if len(devices) > 0 {
+		err = nc.startTunDevices(devices, proxyHost, proxyPort)
+		if err != nil {
+			return err
+		}
+		nc.ssConfig.CIDRToClient = nc.cidrRoutes()
+	}
 
-		go func() {
-			_, err := io.CopyBuffer(lwipWriter, tunDevice, make([]byte, mtu))
-			if err != nil {
-				log.Fatalf("Failed to write data to network stack: %v", err)
-			}
-		}()
+	if len(nc.opts.LocalServices) > 0 {
+		err = nc.startLocalServices(subs.ctx, remoteTunnelAddr)
+		if err != nil {
+			return err
+		}
+	}
 
-		log.Println("Started tun2socks")
+	err = nc.startDataCap(subs.ctx, ss.GetClientBytes)
+	if err != nil {
+		return err
+	}
 
-		if nc.opts.VPN {
-			for _, dest := range vpnCIDR {
-				log.Printf("Adding route %s", dest)
-				out, err := arch.AddRouteCmd(dest, nc.opts.TunGateway, nc.opts.TunName)
-				if len(out) > 0 {
-					os.Stdout.Write(out)
-				}
-				if err != nil {
-					os.Stdout.Write([]byte(util.ParseExecError(err)))
-					os.Exit(1)
-				}
-				defer func(dest *net.IPNet) {
-					log.Printf("Deleting route %s", dest)
-					out, err := arch.DeleteRouteCmd(dest, nc.opts.TunGateway, nc.opts.TunName)
-					if len(out) > 0 {
-						os.Stdout.Write(out)
-					}
-					if err != nil {
-						os.Stdout.Write([]byte(util.ParseExecError(err)))
-					}
-				}(dest)
-			}
+	nc.startSSAndTunnel(subs)
+	if len(devices) > 0 {
+		err = nc.startTunNetwork(subs, localSocksAddr(proxyAddr))
+		if err != nil {
+			return err
 		}
 	}
+	if len(nc.opts.UpdateCommand) > 0 {
+		go nc.checkUpdates(subs.ctx)
+	}
+	if nc.opts.AcceptServerSettings {
+		go nc.syncClientSettings(subs.ctx)
+	}
+	if !nc.opts.DisableRoaming {
+		go nc.watchNetwork(subs.ctx)
+		go nc.watchSuspend(subs.ctx)
+	}
 
-	nc.startSSAndTunnel()
-	nc.waitForSignal()
-
-	return nil
+	return nc.waitForSignal(subs)
 }
 
-func (nc *nconnect) StartServer() error {
+// StartServer starts server and blocks until SIGINT or SIGTERM is received,
+// ctx is done or a subsystem stops. All subsystems are stopped before it
+// returns.
+func (nc *nconnect) StartServer(ctx context.Context) error {
 	err := nc.opts.VerifyServer()
 	if err != nil {
 		return err
 	}
+	warnIgnoredConfig(nc.opts)
+
+	subs := newSubsystems(ctx)
+	defer nc.stop(subs)
+
+	err = nc.checkSecurity()
+	if err != nil {
+		return err
+	}
+
+	if nc.opts.Cipher == config.CipherDummy {
+		log.Println("Using dummy cipher.", config.DummyCipherNote)
+	}
+
+	err = nc.checkIdentifier()
+	if err != nil {
+		return err
+	}
+
+	nc.opts.AdminHTTPAddr, err = preflightListenAddr("adminHttpAddr", "--admin-http", nc.opts.AdminHTTPAddr, nc.opts.AutoPort)
+	if err != nil {
+		return err
+	}
+
+	go nc.detectNAT()
+	go nc.checkClockSkew()
 
 	port, err := util.GetFreePort()
 	if err != nil {
@@ -466,8 +740,9 @@ func (nc *nconnect) StartServer() error {
 	ssAddr := "127.0.0.1:" + strconv.Itoa(port)
 	nc.ssConfig.Server = ssAddr
 
+	var minBalance common.Fixed64
 	if nc.opts.Tuna {
-		minBalance, err := common.StringToFixed64(nc.opts.TunaMinBalance)
+		minBalance, err = common.StringToFixed64(nc.opts.TunaMinBalance)
 		if err != nil {
 			return err
 		}
@@ -484,11 +759,20 @@ func (nc *nconnect) StartServer() error {
 			} else if balance.ToFixed64() < minBalance {
 				log.Printf("Wallet balance %s is less than minimal balance to enable tuna %s, tuna will not be enabled",
 					balance.String(), nc.opts.TunaMinBalance)
+				events.Publish(events.LowBalance{Balance: balance.String(), MinBalance: nc.opts.TunaMinBalance})
 				nc.opts.Tuna = false
 			}
 		}
 	}
 
+	if nc.opts.Tuna && !nc.opts.TunaDisableDownloadGeoDB {
+		err = nc.updateGeoDB(subs.ctx)
+		if err != nil {
+			log.Println("Update geo db error:", err)
+		}
+		go nc.watchGeoDB(subs.ctx)
+	}
+
 	if nc.tunaNode != nil {
 		nc.tunnelConfig.TunaNode = nc.tunaNode
 	}
@@ -499,62 +783,362 @@ func (nc *nconnect) StartServer() error {
 	nc.tunnels = append(nc.tunnels, t)
 	log.Println("Tunnel listen address:", t.FromAddr())
 
-	if len(nc.opts.AdminIdentifier) > 0 {
-		go func() {
-			identifier := nc.opts.AdminIdentifier
-			if len(nc.opts.Identifier) > 0 {
-				identifier += "." + nc.opts.Identifier
+	if len(nc.opts.StatsTopic) > 0 {
+		go nc.publishStats(subs.ctx, t)
+		log.Println("Publishing stats to topic:", nc.opts.StatsTopic)
+	}
+
+	if nc.opts.Tuna {
+		go watchRelay(subs.ctx, t)
+	} else {
+		minBalance = 0
+	}
+	go nc.watchBalance(subs.ctx, t, minBalance)
+
+	if len(nc.opts.DirectListenAddr) > 0 {
+		accept := func(addr string) bool {
+			ok := !admin.IsAcceptPaused() && admin.IsSubsystemEnabled(admin.SubsystemDirect) && util.MatchRegex(nc.persistConf.GetAcceptAddrs(), addr)
+			if ok {
+				events.Publish(events.ClientAccepted{Addr: addr, Via: events.ViaDirect})
+			} else {
+				events.Publish(events.ClientRejected{Addr: addr, Via: events.ViaDirect})
+			}
+			return ok
+		}
+		s, err := direct.NewServer(nc.opts.DirectListenAddr, nc.account, accept, ssAddr, nc.opts.Verbose)
+		if err != nil {
+			return err
+		}
+		admin.SetDirectPort(s.Port())
+		subs.run(s.Start, s.Close)
+		log.Println("Direct connection listen address:", nc.opts.DirectListenAddr)
+	}
+
+	interval := time.Duration(nc.opts.ResourceCheckInterval) * time.Second
+	if interval <= 0 {
+		interval = config.DefaultResourceCheckInterval
+	}
+	nc.loadMeter = monitor.NewLoadMeter(interval, nc.opts.MaxProxyConns, nc.opts.BandwidthMbps, func() monitor.LoadSample {
+		stats := ss.GetServerStats()
+		return monitor.LoadSample{Sessions: stats.Active, Bytes: stats.Bytes}
+	})
+	admin.SetLoadMeter(nc.loadMeter)
+	go nc.loadMeter.Start(subs.ctx)
+
+	err = nc.startDataCap(subs.ctx, func() int64 {
+		return ss.GetServerStats().Bytes
+	})
+	if err != nil {
+		return err
+	}
+
+	if nc.opts.MaxCPUPercent > 0 || nc.opts.MaxMemoryMB > 0 {
+		guard := monitor.NewGuard(nc.opts.MaxCPUPercent, nc.opts.MaxMemoryMB, interval, func(overloaded bool, reason string) {
+			if overloaded {
+				log.Printf("Resource limit exceeded (%s), pause accepting new connections", reason)
+			} else {
+				log.Printf("Resource usage recovered (%s), resume accepting new connections", reason)
 			}
-			err := admin.StartNKNServer(nc.account, identifier, nc.clientConfig, t, nc.persistConf, &nc.opts.Config)
+			err := admin.SetAcceptPaused(nc.persistConf, t, overloaded)
 			if err != nil {
-				log.Fatal(err)
+				log.Println("Set accept paused error:", err)
 			}
-			os.Exit(0)
-		}()
+		})
+		go guard.Start(subs.ctx)
+	}
+
+	admin.SetConfigReloader(nc.reloadConfig)
+	admin.ScheduleAddrExpiry(nc.persistConf, t)
+
+	if len(nc.opts.AdminIdentifier) > 0 {
+		identifier := nc.opts.AdminIdentifier
+		if len(nc.opts.Identifier) > 0 {
+			identifier += "." + nc.opts.Identifier
+		}
+		subs.run(func() error {
+			return admin.StartNKNServer(subs.ctx, nc.account, identifier, nc.clientConfig, t, nc.persistConf, &nc.opts.Config)
+		}, nil)
 		log.Println("Admin listening address:", nc.opts.AdminIdentifier+"."+t.FromAddr())
 	}
 
 	if len(nc.opts.AdminHTTPAddr) > 0 {
-		go func() {
-			err := admin.StartWebServer(nc.opts.AdminHTTPAddr, t, nc.persistConf, &nc.opts.Config)
-			if err != nil {
-				log.Fatal(err)
-			}
-			os.Exit(0)
-		}()
+		subs.run(func() error {
+			return admin.StartWebServer(subs.ctx, nc.opts.AdminHTTPAddr, t, nc.persistConf, &nc.opts.Config)
+		}, nil)
 		log.Println("Admin web dashboard listening address:", nc.opts.AdminHTTPAddr)
 	}
 
-	nc.startSSAndTunnel()
-	nc.waitForSignal()
+	if len(nc.opts.PublishedServices) > 0 && len(nc.opts.PublishAddr) > 0 {
+		subs.run(func() error {
+			return admin.StartPublishServer(subs.ctx, nc.opts.PublishAddr, t, &nc.opts.Config)
+		}, nil)
+		log.Println("Published services listening address:", nc.opts.PublishAddr)
+	}
 
-	return nil
+	nc.startSSAndTunnel(subs)
+
+	return nc.waitForSignal(subs)
 }
 
-func (nc *nconnect) startSSAndTunnel() {
-	go func() {
-		err := ss.Start(nc.ssConfig)
-		if err != nil {
-			log.Fatal(err)
+func (nc *nconnect) startSSAndTunnel(subs *subsystems) {
+	subs.run(func() error {
+		return ss.Start(subs.ctx, nc.ssConfig)
+	}, nil)
+	admin.SetConfigApplier(nc.applyConfigField)
+
+	probed := make(map[*nkn.MultiClient]bool)
+	for _, t := range nc.tunnels {
+		if m := t.MultiClient(); m != nil && !probed[m] {
+			probed[m] = true
+			go respondIdentifierProbes(m)
 		}
-		os.Exit(0)
-	}()
+	}
 
 	for _, t := range nc.tunnels {
-		go func(t *tunnel.Tunnel) {
-			err := t.Start()
+		t := t
+		subs.run(func() error {
+			return runTunnel(t)
+		}, t.Close)
+	}
+
+	for _, f := range nc.forwarders {
+		f := f
+		subs.run(func() error {
+			return f.Start(subs.ctx)
+		}, nil)
+	}
+
+	if len(nc.opts.StatusFile) > 0 {
+		go nc.startStatusFile(subs.ctx)
+		log.Println("Status file:", nc.opts.StatusFile)
+	}
+}
+
+// waitForSignal reloads config on SIGHUP until SIGINT or SIGTERM is received,
+// or subsystems are stopped by ctx or by one of them. It returns the error of
+// the subsystem that stopped, if any.
+func (nc *nconnect) waitForSignal(subs *subsystems) error {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(sigs)
+	for {
+		select {
+		case sig := <-sigs:
+			if sig != syscall.SIGHUP {
+				return nil
+			}
+			_, _, err := nc.reloadConfig()
 			if err != nil {
-				log.Fatal(err)
+				log.Println("Reload config error:", err)
 			}
-			os.Exit(0)
-		}(t)
+		case <-subs.ctx.Done():
+			return subs.err()
+		}
 	}
 }
 
-func (nc *nconnect) waitForSignal() {
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	<-sigs
+// stop stops all subsystems, then closes NKN clients not owned by any of
+// them, so nothing keeps running after StartClient or StartServer returns.
+func (nc *nconnect) stop(subs *subsystems) {
+	subs.stopAll()
+	if nc.adminClientCache != nil {
+		nc.adminClientCache.Close()
+		nc.adminClientCache = nil
+	}
+}
+
+// WriteSupportBundle writes a support bundle of local config and state to
+// path. In client mode with remote admin addresses, bundles are fetched from
+// each remote server instead and saved next to path with the server address
+// prefix appended.
+func WriteSupportBundle(opts *config.Opts, path string) error {
+	if !opts.Client || len(opts.RemoteAdminAddr) == 0 {
+		persistConf, err := loadConfig(opts)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		err = admin.WriteSupportBundle(f, persistConf, &opts.Config)
+		if err != nil {
+			return err
+		}
+		log.Println("Support bundle written to", path)
+		return nil
+	}
+
+	nc, err := NewNconnect(opts)
+	if err != nil {
+		return err
+	}
+
+	c, err := nc.getAdminClient()
+	if err != nil {
+		return err
+	}
+
+	for _, remoteAdminAddr := range opts.RemoteAdminAddrs() {
+		b, err := c.GetSupportBundle(remoteAdminAddr)
+		if err != nil {
+			return fmt.Errorf("get support bundle from %s error: %v", remoteAdminAddr, err)
+		}
+
+		p := remoteOutputPath(path, ".tar.gz", remoteAdminAddr)
+		err = os.WriteFile(p, b, 0600)
+		if err != nil {
+			return err
+		}
+		log.Printf("Support bundle of %s written to %s", remoteAdminAddr, p)
+	}
+
+	return nil
+}
+
+// remoteOutputPath returns path with the remote admin address without public
+// key appended before ext, so outputs of multiple remotes do not collide.
+func remoteOutputPath(path, ext, remoteAdminAddr string) string {
+	name := remoteAdminAddr
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[:i]
+	}
+	return strings.TrimSuffix(path, ext) + "-" + name + ext
+}
+
+// clientsFormat returns clients file format from path extension.
+func clientsFormat(path string) string {
+	if strings.EqualFold(filepath.Ext(path), ".csv") {
+		return admin.ClientsFormatCSV
+	}
+	return admin.ClientsFormatJSON
+}
+
+// ImportClients imports clients from a json or csv file at path. In client
+// mode with remote admin addresses, clients are imported to each remote
+// server, otherwise to local config.
+func ImportClients(opts *config.Opts, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	format := clientsFormat(path)
+
+	if !opts.Client || len(opts.RemoteAdminAddr) == 0 {
+		persistConf, err := loadConfig(opts)
+		if err != nil {
+			return err
+		}
+		clients, err := admin.ParseClients(format, data)
+		if err != nil {
+			return err
+		}
+		err = admin.ImportClients(persistConf, nil, clients)
+		if err != nil {
+			return err
+		}
+		log.Printf("Imported %d clients to %s", len(clients), opts.ConfigFile)
+		return nil
+	}
+
+	nc, err := NewNconnect(opts)
+	if err != nil {
+		return err
+	}
+	c, err := nc.getAdminClient()
+	if err != nil {
+		return err
+	}
+	for _, remoteAdminAddr := range opts.RemoteAdminAddrs() {
+		err = c.ImportClients(remoteAdminAddr, format, data)
+		if err != nil {
+			return fmt.Errorf("import clients to %s error: %v", remoteAdminAddr, err)
+		}
+		log.Printf("Imported clients to %s", remoteAdminAddr)
+	}
+	return nil
+}
+
+// ExportClients exports clients to a json or csv file at path, or prints json
+// to stdout if path is empty. In client mode with remote admin addresses,
+// clients of each remote server are exported next to path with the server
+// address prefix appended.
+func ExportClients(opts *config.Opts, path string) error {
+	format := clientsFormat(path)
+
+	if !opts.Client || len(opts.RemoteAdminAddr) == 0 {
+		persistConf, err := loadConfig(opts)
+		if err != nil {
+			return err
+		}
+		b, err := admin.FormatClients(format, admin.ExportClients(persistConf))
+		if err != nil {
+			return err
+		}
+		if len(path) == 0 {
+			_, err = os.Stdout.Write(append(b, '\n'))
+			return err
+		}
+		return os.WriteFile(path, b, 0600)
+	}
+
+	if len(path) == 0 {
+		return errors.New("output path is required when exporting clients of remote servers")
+	}
+
+	nc, err := NewNconnect(opts)
+	if err != nil {
+		return err
+	}
+	c, err := nc.getAdminClient()
+	if err != nil {
+		return err
+	}
+	for _, remoteAdminAddr := range opts.RemoteAdminAddrs() {
+		b, err := c.ExportClients(remoteAdminAddr, format)
+		if err != nil {
+			return fmt.Errorf("export clients from %s error: %v", remoteAdminAddr, err)
+		}
+		p := remoteOutputPath(path, filepath.Ext(path), remoteAdminAddr)
+		err = os.WriteFile(p, b, 0600)
+		if err != nil {
+			return err
+		}
+		log.Printf("Clients of %s written to %s", remoteAdminAddr, p)
+	}
+	return nil
+}
+
+// AddWebUser adds or resets a web GUI user in local config with a random
+// password, which has to be changed at first login.
+func AddWebUser(opts *config.Opts, name, role string) (string, error) {
+	persistConf, err := loadConfig(opts)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, 12)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	password := base64.RawURLEncoding.EncodeToString(b)
+	err = persistConf.SetWebUser(name, role, password)
+	if err != nil {
+		return "", err
+	}
+	return password, nil
+}
+
+// RemoveWebUser removes a web GUI user from local config.
+func RemoveWebUser(opts *config.Opts, name string) error {
+	persistConf, err := loadConfig(opts)
+	if err != nil {
+		return err
+	}
+	return persistConf.RemoveWebUser(name)
 }
 
 func (nc *nconnect) SetTunaNode(node *types.Node) {
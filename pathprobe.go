@@ -0,0 +1,97 @@
+package nconnect
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/config"
+)
+
+// PathProbeResult is the hop by hop probe run by one remote server of a
+// client.
+type PathProbeResult struct {
+	Addr  string               `json:"addr"`
+	Error string               `json:"error,omitempty"`
+	Probe *admin.PathProbeJSON `json:"probe,omitempty"`
+}
+
+// PathProbe asks each remote server of a client to probe latency and loss of
+// each hop toward target, or toward the tuna relay it uses if target is empty,
+// so slowness can be located between server and relay or server and
+// destination.
+func PathProbe(opts *config.Opts, target string) ([]PathProbeResult, error) {
+	if !opts.Client || len(opts.RemoteAdminAddr) == 0 {
+		return nil, errNoRemoteAdminAddr
+	}
+
+	nc, err := NewNconnect(opts)
+	if err != nil {
+		return nil, err
+	}
+	c, err := nc.getAdminClient()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]PathProbeResult, len(opts.RemoteAdminAddr))
+	for i, addr := range opts.RemoteAdminAddrs() {
+		results[i].Addr = addr
+		probe, err := c.RunPathProbe(addr, target, 0, 0)
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].Probe = probe
+	}
+	return results, nil
+}
+
+// WritePathProbe writes path probe results to w as a table of hops for each
+// server, or as JSON if asJSON is true.
+func WritePathProbe(w io.Writer, results []PathProbeResult, asJSON bool) error {
+	if asJSON {
+		b, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+
+	for i, r := range results {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		if len(r.Error) > 0 {
+			fmt.Fprintf(w, "%s: %s\n", r.Addr, r.Error)
+			continue
+		}
+		target := r.Probe.Target
+		if r.Probe.Relay {
+			target = "tuna relay " + target
+		}
+		fmt.Fprintf(w, "%s -> %s (%s)", r.Addr, target, r.Probe.IP)
+		if !r.Probe.Reached {
+			fmt.Fprint(w, ", not reached")
+		}
+		fmt.Fprintln(w)
+
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "HOP\tHOST\tLOSS\tSENT\tAVG\tMIN\tMAX")
+		for _, hop := range r.Probe.Hops {
+			if hop.Received == 0 {
+				fmt.Fprintf(tw, "%d\t???\t%.0f%%\t%d\t-\t-\t-\n", hop.TTL, hop.Loss, hop.Sent)
+				continue
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%.0f%%\t%d\t%.1fms\t%.1fms\t%.1fms\n", hop.TTL, hop.Addr, hop.Loss, hop.Sent, hop.AvgMs, hop.MinMs, hop.MaxMs)
+		}
+		err := tw.Flush()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package nconnect
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"runtime"
+	"strconv"
+	"syscall"
+)
+
+const (
+	// maxAutoPortTries is the number of ports after a port in use tried when
+	// autoPort is enabled.
+	maxAutoPortTries = 100
+
+	// wsaeAddrInUse is the winsock error of listening on an address in use,
+	// which is not syscall.EADDRINUSE on Windows.
+	wsaeAddrInUse = 10048
+)
+
+// portOwner is a process listening on a port.
+type portOwner struct {
+	PID  int
+	Name string
+}
+
+func (o *portOwner) String() string {
+	return fmt.Sprintf("%s (pid %d)", o.Name, o.PID)
+}
+
+// isAddrInUse returns if err is a listen error because address is in use.
+func isAddrInUse(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	return errno == syscall.EADDRINUSE || runtime.GOOS == "windows" && errno == wsaeAddrInUse
+}
+
+func tryListen(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return l.Close()
+}
+
+// portInUseMessage returns why TCP address addr of config field name can't be
+// listened on and how to fix it, with the program using it if known.
+func portInUseMessage(name, flag, addr string) string {
+	msg := fmt.Sprintf("%s %s is already in use", name, addr)
+	_, portStr, err := net.SplitHostPort(addr)
+	if err == nil {
+		port, err := strconv.Atoi(portStr)
+		if err == nil {
+			if owner := listenPortOwner(port); owner != nil {
+				msg += " by " + owner.String()
+			}
+		}
+	}
+	return msg + fmt.Sprintf(", stop the program using it (e.g. another nConnect), choose another address with %s, or enable autoPort to listen on the next free port", flag)
+}
+
+// nextFreeAddr returns the first address on the same host as addr whose port
+// after the port of addr can be listened on.
+func nextFreeAddr(addr string) (string, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return "", err
+	}
+	for p := port + 1; p <= port+maxAutoPortTries && p <= 65535; p++ {
+		a := net.JoinHostPort(host, strconv.Itoa(p))
+		if tryListen(a) == nil {
+			return a, nil
+		}
+	}
+	return "", fmt.Errorf("no free port in %d ports after %s", maxAutoPortTries, addr)
+}
+
+// preflightListenAddr checks that TCP address addr of config field name can be
+// listened on before anything is started, so a port in use fails with the
+// program using it and how to fix it instead of a raw bind error later. If
+// autoPort is true and the port is in use, the next free port on the same host
+// is returned instead of addr.
+func preflightListenAddr(name, flag, addr string, autoPort bool) (string, error) {
+	if len(addr) == 0 {
+		return addr, nil
+	}
+	err := tryListen(addr)
+	if err == nil {
+		return addr, nil
+	}
+	if !isAddrInUse(err) {
+		return "", fmt.Errorf("%s %s can't be listened on: %v, choose another address with %s", name, addr, err, flag)
+	}
+	if autoPort {
+		newAddr, err := nextFreeAddr(addr)
+		if err == nil {
+			log.Printf("WARNING: %s %s is already in use, listening on %s instead", name, addr, newAddr)
+			return newAddr, nil
+		}
+		log.Println("Find free port error:", err)
+	}
+	return "", errors.New(portInUseMessage(name, flag, addr))
+}
@@ -0,0 +1,75 @@
+package nconnect
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// tcpStateListen is the state of listen sockets in /proc/net/tcp.
+const tcpStateListen = "0A"
+
+// listenPortOwner returns the process listening on TCP port, or nil if not
+// found, e.g. it belongs to another user and we are not root.
+func listenPortOwner(port int) *portOwner {
+	inodes := listenSocketInodes(port)
+	if len(inodes) == 0 {
+		return nil
+	}
+
+	procs, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	for _, proc := range procs {
+		pid, err := strconv.Atoi(proc.Name())
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join("/proc", proc.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil || !inodes[link] {
+				continue
+			}
+			comm, _ := os.ReadFile(filepath.Join("/proc", proc.Name(), "comm"))
+			return &portOwner{PID: pid, Name: strings.TrimSpace(string(comm))}
+		}
+	}
+	return nil
+}
+
+// listenSocketInodes returns the fd links (socket:[inode]) of TCP listen
+// sockets on port in /proc/net/tcp and /proc/net/tcp6.
+func listenSocketInodes(port int) map[string]bool {
+	inodes := make(map[string]bool)
+	for _, file := range []string{"/proc/net/tcp", "/proc/net/tcp6"} {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			// sl local_address rem_address st tx_queue:rx_queue tr:tm->when retrnsmt uid timeout inode ...
+			fields := strings.Fields(scanner.Text())
+			if len(fields) < 10 || fields[3] != tcpStateListen {
+				continue
+			}
+			i := strings.LastIndexByte(fields[1], ':')
+			p, err := strconv.ParseUint(fields[1][i+1:], 16, 16)
+			if err != nil || int(p) != port || fields[9] == "0" {
+				continue
+			}
+			inodes["socket:["+fields[9]+"]"] = true
+		}
+		f.Close()
+	}
+	return inodes
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+// +build !linux
+
+package nconnect
+
+func listenPortOwner(port int) *portOwner {
+	return nil
+}
@@ -0,0 +1,101 @@
+package nconnect
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const (
+	maxServiceNameLength = 256
+)
+
+// startLocalServices lets remote servers publish local services. Sessions
+// from remote tunnel addresses start with the name of a local service in a
+// line, and are relayed to it. The NKN client is closed when ctx is done.
+func (nc *nconnect) startLocalServices(ctx context.Context, remoteTunnelAddr []string) error {
+	identifier := config.PublishIdentifierPrefix
+	if len(nc.opts.Identifier) > 0 {
+		identifier += "." + nc.opts.Identifier
+	}
+	m, err := nkn.NewMultiClient(nc.account, identifier, nc.opts.GetAdminNumSubClients(), false, nc.clientConfig)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		m.Close()
+	}()
+	<-m.OnConnect.C
+
+	addrsRe := make([]string, len(remoteTunnelAddr))
+	for i, addr := range remoteTunnelAddr {
+		addrsRe[i] = "^" + regexp.QuoteMeta(addr) + "$"
+	}
+	err = m.Listen(nkn.NewStringArray(addrsRe...))
+	if err != nil {
+		return err
+	}
+
+	services := make(map[string]string, len(nc.opts.LocalServices))
+	for _, s := range nc.opts.LocalServices {
+		services[s.Name] = s.Addr
+	}
+
+	go func() {
+		for {
+			conn, err := m.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("Accept local service session error:", err)
+				}
+				return
+			}
+			go serveLocalService(conn, services)
+		}
+	}()
+
+	log.Println("Local services publish address:", m.Address())
+	return nil
+}
+
+func serveLocalService(conn net.Conn, services map[string]string) {
+	defer conn.Close()
+
+	r := bufio.NewReaderSize(conn, maxServiceNameLength)
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		log.Println("Read local service name error:", err)
+		return
+	}
+	name := strings.TrimSpace(string(line))
+	addr, ok := services[name]
+	if !ok {
+		log.Printf("Unknown local service %q requested by %s", name, conn.RemoteAddr())
+		return
+	}
+
+	local, err := net.Dial("tcp", addr)
+	if err != nil {
+		log.Printf("Connect to local service %s error: %v", name, err)
+		return
+	}
+	defer local.Close()
+
+	done := make(chan struct{})
+	go func() {
+		io.Copy(local, r)
+		local.Close()
+		close(done)
+	}()
+	io.Copy(conn, local)
+	conn.Close()
+	<-done
+}
@@ -0,0 +1,158 @@
+package nconnect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nkn/v2/util/address"
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	pairingScheme = "nconnect"
+	pairingHost   = "pair"
+	qrPNGSize     = 512
+)
+
+var (
+	errPairingServerOnly = errors.New("pairing QR code needs server mode")
+	errPairingClientOnly = errors.New("pairing import needs client mode")
+	errNoAdminIdentifier = errors.New("adminIdentifier is empty, clients cannot reach server admin")
+	errInvalidPairing    = errors.New("invalid pairing info, should be like nconnect://pair?addr=...")
+)
+
+// PairingInfo is what a client needs to pair with a server, encoded as an
+// nconnect://pair URI in QR code.
+type PairingInfo struct {
+	AdminAddr string // server admin address
+	Token     string // optional one-time pairing token
+}
+
+func (p *PairingInfo) String() string {
+	q := url.Values{}
+	q.Set("addr", p.AdminAddr)
+	if len(p.Token) > 0 {
+		q.Set("token", p.Token)
+	}
+	u := url.URL{Scheme: pairingScheme, Host: pairingHost, RawQuery: q.Encode()}
+	return u.String()
+}
+
+// ParsePairingInfo parses pairing info from an nconnect://pair URI.
+func ParsePairingInfo(s string) (*PairingInfo, error) {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil || u.Scheme != pairingScheme || u.Host != pairingHost {
+		return nil, errInvalidPairing
+	}
+	q := u.Query()
+	p := &PairingInfo{AdminAddr: q.Get("addr"), Token: q.Get("token")}
+	if len(p.AdminAddr) == 0 {
+		return nil, errInvalidPairing
+	}
+	return p, nil
+}
+
+// GetPairingInfo returns pairing info of the server. If withToken is true, a
+// one-time pairing token is created, so a client scanning the QR code is added
+// to accept addresses on its first connection without being added by hand.
+func GetPairingInfo(opts *config.Opts, withToken bool) (*PairingInfo, error) {
+	if !opts.Server {
+		return nil, errPairingServerOnly
+	}
+	nc, err := NewNconnect(opts)
+	if err != nil {
+		return nil, err
+	}
+	if len(opts.AdminIdentifier) == 0 {
+		return nil, errNoAdminIdentifier
+	}
+
+	p := &PairingInfo{
+		AdminAddr: opts.AdminIdentifier + "." + address.MakeAddressString(nc.account.PubKey(), opts.Identifier),
+	}
+	if withToken {
+		p.Token, err = admin.NewPairingToken(nc.persistConf, admin.PairingTokenExpiration)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// WritePairingQR writes pairing info as QR code to a PNG file at pngPath, or
+// prints it to w as text if pngPath is empty.
+func WritePairingQR(w io.Writer, p *PairingInfo, pngPath string) error {
+	payload := p.String()
+	if len(pngPath) > 0 {
+		err := qrcode.WriteFile(payload, qrcode.Medium, qrPNGSize, pngPath)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, "Pairing QR code written to", pngPath)
+		return err
+	}
+
+	q, err := qrcode.New(payload, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprint(w, q.ToSmallString(false))
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, payload)
+	if err != nil {
+		return err
+	}
+	if len(p.Token) > 0 {
+		_, err = fmt.Fprintf(w, "Pairing token can be used once in %v\n", admin.PairingTokenExpiration)
+	}
+	return err
+}
+
+// ImportPairing adds the server admin address in pairing info s to
+// remoteAdminAddr of client config. If it has a pairing token, the client is
+// paired with the server first, so it is in accept addresses of the server.
+func ImportPairing(opts *config.Opts, s string) error {
+	if !opts.Client {
+		return errPairingClientOnly
+	}
+	p, err := ParsePairingInfo(s)
+	if err != nil {
+		return err
+	}
+
+	nc, err := NewNconnect(opts)
+	if err != nil {
+		return err
+	}
+
+	if len(p.Token) > 0 {
+		c, err := nc.getAdminClient()
+		if err != nil {
+			return err
+		}
+		err = c.Pair(p.AdminAddr, p.Token)
+		if err != nil {
+			return fmt.Errorf("pair with %s error: %v", p.AdminAddr, err)
+		}
+		log.Printf("Paired with %s", p.AdminAddr)
+	}
+
+	added, err := nc.persistConf.AddRemoteAdminAddr(p.AdminAddr)
+	if err != nil {
+		return err
+	}
+	if added {
+		log.Printf("Added %s to remoteAdminAddr in %s", p.AdminAddr, opts.ConfigFile)
+	} else {
+		log.Printf("%s is already in remoteAdminAddr", p.AdminAddr)
+	}
+	return nil
+}
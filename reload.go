@@ -0,0 +1,117 @@
+package nconnect
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/events"
+	"github.com/nknorg/nconnect/ss"
+	"github.com/nknorg/nconnect/util"
+	tunnel "github.com/nknorg/nkn-tunnel"
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// setupLog sends log to log file in conf, or stderr if not set. It returns
+// the log file, or nil if logging to stderr.
+func setupLog(conf *config.Config) *lumberjack.Logger {
+	var logWriter io.Writer = os.Stderr
+	var logFile *lumberjack.Logger
+	if len(conf.LogFileName) > 0 {
+		logFile = &lumberjack.Logger{
+			Filename:   conf.LogFileName,
+			MaxSize:    conf.LogMaxSize,
+			MaxBackups: conf.LogMaxBackups,
+		}
+		logWriter = logFile
+	}
+	log.SetOutput(util.DefaultRedactor.Writer(logWriter))
+	return logFile
+}
+
+var (
+	errAutoCipher   = errors.New("auto cipher is resolved at start")
+	errUDPNotActive = errors.New("UDP proxy was not started")
+)
+
+// applyConfigField applies a changed config field of admin live config fields
+// to the running proxy. It returns an error if the field takes effect only
+// after restart.
+func (nc *nconnect) applyConfigField(name string) error {
+	switch name {
+	case "cipher", "password":
+		if nc.opts.Cipher == config.CipherAuto {
+			return errAutoCipher
+		}
+		err := nc.opts.VerifyCipher()
+		if err != nil {
+			return err
+		}
+		err = ss.SetCipher(nc.opts.Cipher, nc.opts.Password)
+		if err != nil {
+			return err
+		}
+		nc.ssConfig.Cipher, nc.ssConfig.Password = nc.opts.Cipher, nc.opts.Password
+		util.DefaultRedactor.AddSecrets(nc.opts.Password)
+	case "udp":
+		if !nc.opts.Server || !nc.ssConfig.UDP {
+			return errUDPNotActive
+		}
+		ss.SetUDPEnabled(nc.opts.UDP && admin.IsSubsystemEnabled(admin.SubsystemUDP))
+	case "verbose":
+		nc.ssConfig.Verbose = nc.opts.Verbose
+		ss.SetVerbose(nc.opts.Verbose)
+	case "socksAllowedIPs":
+		allowed, err := config.ParseAllowedIPs(nc.opts.SocksAllowedIPs)
+		if err != nil {
+			return err
+		}
+		ss.SetSocksAllowedIPs(allowed)
+	default:
+		return fmt.Errorf("config field %s cannot be applied at runtime", name)
+	}
+	return nil
+}
+
+// reloadConfig reads config file again and applies changes to the running
+// client or server, e.g. on SIGHUP. It returns json names of fields that have
+// taken effect and fields that take effect after restart.
+func (nc *nconnect) reloadConfig() ([]string, []string, error) {
+	var tun *tunnel.Tunnel
+	if nc.opts.Server && len(nc.tunnels) > 0 {
+		tun = nc.tunnels[0]
+	}
+
+	applied, deferred, err := admin.ReloadConfig(nc.persistConf, &nc.opts.Config, tun)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	logChanged := false
+	for _, name := range applied {
+		switch name {
+		case "log", "logMaxSize", "logMaxBackups":
+			logChanged = true
+		}
+	}
+	if logChanged {
+		oldLogFile := nc.logFile
+		nc.logFile = setupLog(&nc.opts.Config)
+		if oldLogFile != nil {
+			oldLogFile.Close()
+		}
+	}
+
+	if len(applied) == 0 && len(deferred) == 0 {
+		log.Println("Config reloaded, nothing changed")
+	} else {
+		log.Printf("Config reloaded, applied: %v, take effect after restart: %v", applied, deferred)
+		events.Publish(events.ConfigChanged{Method: "reload", Fields: append(append([]string(nil), applied...), deferred...)})
+	}
+
+	return applied, deferred, nil
+}
@@ -0,0 +1,103 @@
+package nconnect
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/ncp-go"
+	"github.com/nknorg/nkn-sdk-go"
+	tunnel "github.com/nknorg/nkn-tunnel"
+)
+
+// remoteServersByTunnel returns remote servers with tunnel options by their
+// tunnel address.
+func (nc *nconnect) remoteServersByTunnel() map[string]config.RemoteServer {
+	servers := make(map[string]config.RemoteServer)
+	for _, s := range nc.opts.RemoteAdminAddr {
+		if !s.HasTunnelOptions() {
+			continue
+		}
+		remoteInfo, err := nc.getRemoteInfo(s.Addr)
+		if err != nil {
+			log.Printf("getRemoteInfo %v err: %v", s.Addr, err)
+			continue
+		}
+		servers[remoteInfo.Addr] = s
+		log.Printf("Using tunnel options of remote server %s", s.Addr)
+	}
+	return servers
+}
+
+// remoteTunnelConfig returns tunnel config and whether to use tuna for tunnels
+// to remote server s, with its options overriding client config.
+func (nc *nconnect) remoteTunnelConfig(s config.RemoteServer) (*tunnel.Config, bool) {
+	tuna := nc.opts.Tuna
+	if s.Tuna != nil {
+		tuna = *s.Tuna
+	}
+	if s.DialTimeout == 0 && s.SessionWindowSize == 0 {
+		return nc.tunnelConfig, tuna
+	}
+
+	conf := *nc.tunnelConfig
+	dialConfig := &nkn.DialConfig{}
+	if conf.DialConfig != nil {
+		*dialConfig = *conf.DialConfig
+	}
+	if s.DialTimeout > 0 {
+		dialConfig.DialTimeout = s.DialTimeout
+	}
+	if s.SessionWindowSize > 0 {
+		sessionConfig := &ncp.Config{}
+		if dialConfig.SessionConfig != nil {
+			*sessionConfig = *dialConfig.SessionConfig
+		}
+		sessionConfig.SessionWindowSize = s.SessionWindowSize
+		dialConfig.SessionConfig = sessionConfig
+	}
+	conf.DialConfig = dialConfig
+	return &conf, tuna
+}
+
+// newClientTunnels creates tunnels from local addresses to remote tunnel
+// addresses. Tunnels to remote servers with the same options share tunnel
+// config, and all of them share one multiclient.
+func (nc *nconnect) newClientTunnels(from, to []string) ([]*tunnel.Tunnel, error) {
+	servers := nc.remoteServersByTunnel()
+
+	type tunnelGroup struct {
+		server   config.RemoteServer
+		from, to []string
+	}
+	var groups []*tunnelGroup
+	groupByKey := make(map[string]*tunnelGroup)
+	for i, remote := range to {
+		s := servers[remote]
+		key := fmt.Sprintf("%d/%d", s.DialTimeout, s.SessionWindowSize)
+		if s.Tuna != nil {
+			key += fmt.Sprintf("/%v", *s.Tuna)
+		}
+		g, ok := groupByKey[key]
+		if !ok {
+			g = &tunnelGroup{server: s}
+			groupByKey[key] = g
+			groups = append(groups, g)
+		}
+		g.from = append(g.from, from[i])
+		g.to = append(g.to, remote)
+	}
+
+	var tunnels []*tunnel.Tunnel
+	var mc *nkn.MultiClient
+	for _, g := range groups {
+		conf, tuna := nc.remoteTunnelConfig(g.server)
+		t, err := tunnel.NewTunnels(nc.account, nc.opts.Identifier, g.from, g.to, tuna, conf, mc)
+		if err != nil {
+			return nil, err
+		}
+		mc = t[0].MultiClient()
+		tunnels = append(tunnels, t...)
+	}
+	return tunnels, nil
+}
@@ -0,0 +1,129 @@
+package nconnect
+
+import (
+	"context"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/monitor"
+	"github.com/nknorg/nkn-sdk-go"
+)
+
+const (
+	networkCheckInterval = 2 * time.Second
+
+	// System is considered suspended if the network check loop is delayed by
+	// more than this time.
+	suspendThreshold = 10 * time.Second
+
+	// Broken tuna connections of client sessions are redialed this many times,
+	// 2s apart, so sessions survive network change.
+	roamingTunaReconnectRetries = 30
+)
+
+// localIPs returns sorted non-loopback unicast IPs of local interfaces,
+// excluding IPs of TUN devices created by nConnect itself.
+func (nc *nconnect) localIPs() ([]string, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	ignore := map[string]bool{nc.opts.TunAddr: true}
+	for _, d := range nc.opts.TunDevices {
+		ignore[d.Addr] = true
+	}
+
+	ips := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLoopback() || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		ip := ipNet.IP.String()
+		if !ignore[ip] {
+			ips = append(ips, ip)
+		}
+	}
+	sort.Strings(ips)
+	return ips, nil
+}
+
+// watchNetwork reconnects NKN clients when local IPs change, e.g. when
+// switching from Wi-Fi to LTE tethering, instead of waiting for connections
+// bound to the old network to time out. Sessions are kept as their data is
+// retransmitted over the reconnected clients. It returns when ctx is done.
+func (nc *nconnect) watchNetwork(ctx context.Context) {
+	lastIPs, err := nc.localIPs()
+	if err != nil {
+		log.Println("Get local IPs error:", err)
+	}
+
+	for monitor.Sleep(ctx, networkCheckInterval) {
+		ips, err := nc.localIPs()
+		if err != nil {
+			log.Println("Get local IPs error:", err)
+			continue
+		}
+		if strings.Join(ips, ",") == strings.Join(lastIPs, ",") {
+			continue
+		}
+
+		if len(ips) == 0 {
+			log.Println("Network is down, all local IPs are gone")
+			admin.AddNetworkEvent(admin.NetworkEventDown, lastIPs, ips)
+		} else {
+			log.Printf("Local IPs changed from %v to %v, reconnecting", lastIPs, ips)
+			admin.AddNetworkEvent(admin.NetworkEventChanged, lastIPs, ips)
+			nc.reconnectClients()
+		}
+		lastIPs = ips
+	}
+}
+
+// watchSuspend reconnects NKN clients and probes direct connections again
+// when system resumes from suspend, e.g. laptop sleep. Connections to NKN
+// nodes are usually dead after a long sleep, and waiting for keepalive to
+// find it out makes recovery slow.
+func (nc *nconnect) watchSuspend(ctx context.Context) {
+	monitor.WatchSuspend(ctx, networkCheckInterval, suspendThreshold, func(slept time.Duration) {
+		log.Printf("System resumed after suspended for %v, reconnecting", slept.Round(time.Second))
+		ips, err := nc.localIPs()
+		if err != nil {
+			log.Println("Get local IPs error:", err)
+		}
+		admin.AddNetworkEvent(admin.NetworkEventResumed, ips, ips)
+		nc.reconnectClients()
+		for _, f := range nc.forwarders {
+			f.Reprobe()
+		}
+	})
+}
+
+// reconnectClients forces all NKN sub-clients of tunnels and admin client to
+// find node and connect again over the current network.
+func (nc *nconnect) reconnectClients() {
+	reconnected := make(map[*nkn.MultiClient]bool)
+	reconnect := func(m *nkn.MultiClient) {
+		if m == nil || m.IsClosed() || reconnected[m] {
+			return
+		}
+		reconnected[m] = true
+		clients := m.GetClients()
+		for _, c := range clients {
+			c.Reconnect()
+		}
+		admin.AddNKNReconnects(len(clients))
+	}
+
+	for _, t := range nc.tunnels {
+		reconnect(t.MultiClient())
+	}
+	if nc.adminClientCache != nil {
+		reconnect(nc.adminClientCache.MultiClient)
+	}
+}
@@ -0,0 +1,111 @@
+package nconnect
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/monitor"
+)
+
+const (
+	maxUpdateOutputReported = 512
+)
+
+// checkUpdates asks the default remote server whether a staged rollout
+// targets this client, and runs update command if so. It returns when ctx is
+// done.
+func (nc *nconnect) checkUpdates(ctx context.Context) {
+	interval := time.Duration(nc.opts.UpdateCheckInterval) * time.Second
+	if interval <= 0 {
+		interval = config.DefaultUpdateCheckInterval
+	}
+	for {
+		err := nc.checkUpdate(ctx)
+		if err != nil {
+			log.Println("Check update error:", err)
+		}
+		if !monitor.Sleep(ctx, interval) {
+			return
+		}
+	}
+}
+
+// checkUpdate updates and restarts if a rollout targets this client, after
+// the rollout delay unless ctx is done first.
+func (nc *nconnect) checkUpdate(ctx context.Context) error {
+	if len(nc.opts.RemoteAdminAddr) == 0 {
+		return nil
+	}
+	addr := nc.opts.RemoteAdminAddr[0].Addr
+
+	c, err := nc.getAdminClient()
+	if err != nil {
+		return err
+	}
+	update, err := c.GetUpdate(addr, config.Version, nc.opts.UpdateChannel, nc.opts.FleetGroups)
+	if err != nil {
+		return err
+	}
+	if len(update.Version) == 0 || update.Version == config.Version {
+		return nil
+	}
+
+	log.Printf("Rollout targets version %s, updating in %d seconds", update.Version, update.Delay)
+	if !monitor.Sleep(ctx, time.Duration(update.Delay)*time.Second) {
+		return nil
+	}
+
+	err = c.ReportUpdate(addr, update.Version, admin.RolloutStatusUpdating, "")
+	if err != nil {
+		log.Println("Report update status error:", err)
+	}
+
+	out, err := runUpdateCommand(nc.opts.UpdateCommand, update.Version)
+	if err != nil {
+		msg := err.Error()
+		if len(out) > 0 {
+			msg = fmt.Sprintf("%v: %s", err, out)
+		}
+		log.Printf("Update to version %s failed: %s", update.Version, msg)
+		return c.ReportUpdate(addr, update.Version, admin.RolloutStatusFailed, msg)
+	}
+
+	// Server marks this client updated when it checks again with the new
+	// version after restart.
+	log.Printf("Updated to version %s, restarting", update.Version)
+	for _, t := range nc.tunnels {
+		t.Close()
+	}
+	err = admin.RestartSelf()
+	log.Println("Restart error:", err)
+	os.Exit(1)
+	return nil
+}
+
+// runUpdateCommand runs update command in shell, and returns the tail of its
+// output.
+func runUpdateCommand(command, version string) (string, error) {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Env = append(os.Environ(), "NCONNECT_UPDATE_VERSION="+version)
+
+	b, err := cmd.CombinedOutput()
+	b = bytes.TrimSpace(b)
+	if len(b) > maxUpdateOutputReported {
+		b = b[len(b)-maxUpdateOutputReported:]
+	}
+	return strings.ToValidUTF8(string(b), ""), err
+}
@@ -0,0 +1,69 @@
+package nconnect
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+
+	"github.com/nknorg/nconnect/config"
+)
+
+// securityLint returns dangerous config combinations in opts merged with
+// persisted config conf, each with a hint to fix it.
+func securityLint(opts *config.Opts, conf *config.Config) []string {
+	var issues []string
+
+	if opts.Server && len(opts.AdminHTTPAddr) > 0 && !config.IsLoopbackAddr(opts.AdminHTTPAddr) {
+		if !opts.AdminHTTPTLS {
+			issues = append(issues, fmt.Sprintf("admin web GUI listens on non-loopback address %s without TLS, so passwords and tokens are sent in clear text, enable adminHttpTLS or listen on a loopback address", opts.AdminHTTPAddr))
+		}
+		if !opts.DisableAdminHTTPAPI && len(conf.GetAdminAddrs()) == 0 && !conf.HasWebUsers() && !opts.AdminHTTPRequireClientCert {
+			issues = append(issues, fmt.Sprintf("admin http api is enabled on non-loopback address %s with empty adminAddrs and no web users, so anyone who can reach it can manage this server, add web users, set adminHttpRequireClientCert, or set disableAdminHttpApi and manage it from adminAddrs", opts.AdminHTTPAddr))
+		}
+	}
+
+	if opts.Client && opts.Cipher == config.CipherDummy && len(opts.LocalSocksAddr) > 0 && !config.IsLoopbackAddr(opts.LocalSocksAddr) {
+		issues = append(issues, fmt.Sprintf("dummy cipher is used while local socks proxy listens on non-loopback address %s, so proxy traffic is not encrypted on the local network, use another cipher or a loopback address", opts.LocalSocksAddr))
+	}
+
+	// Windows has no permission bits for others
+	if runtime.GOOS != "windows" {
+		for _, path := range conf.FilePaths() {
+			fi, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if fi.Mode().Perm()&0002 != 0 {
+				issues = append(issues, fmt.Sprintf("config file %s is world-writable (%v), so any local user can change it, run chmod o-w %s", path, fi.Mode().Perm(), path))
+			}
+		}
+	}
+
+	return issues
+}
+
+// checkSecurity refuses to start or warns about dangerous config combinations
+// according to config.
+func (nc *nconnect) checkSecurity() error {
+	if nc.opts.SecurityLint == config.SecurityLintOff {
+		return nil
+	}
+
+	issues := securityLint(nc.opts, nc.persistConf)
+	if len(issues) == 0 {
+		return nil
+	}
+
+	if nc.opts.SecurityLint == config.SecurityLintWarn {
+		for _, issue := range issues {
+			log.Println("SECURITY WARNING:", issue)
+		}
+		return nil
+	}
+
+	for _, issue := range issues {
+		log.Println("Insecure config:", issue)
+	}
+	return fmt.Errorf("refuse to start with %d insecure config problem(s), fix them or set --security-lint warn to start anyway", len(issues))
+}
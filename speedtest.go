@@ -0,0 +1,155 @@
+package nconnect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/config"
+)
+
+const (
+	speedTestPings = 3
+)
+
+var (
+	errNoRemoteAdminAddrInConfig = errors.New("remoteAdminAddr is not set in config file, cannot save server order")
+)
+
+// SpeedTestResult is the measured latency and throughput to one remote server
+// of a client.
+type SpeedTestResult struct {
+	Addr          string  `json:"addr"`
+	Error         string  `json:"error,omitempty"`
+	LatencyMs     int64   `json:"latencyMs,omitempty"`     // lowest round trip time of get info rpc
+	ThroughputBps float64 `json:"throughputBps,omitempty"` // download throughput in bytes per second
+}
+
+// SpeedTest measures latency and download throughput through each remote
+// admin address of a client one by one, so servers do not compete for local
+// bandwidth, and returns results ranked by throughput. Unreachable servers
+// are ranked last. If save is true, remoteAdminAddr in config file is
+// reordered by the ranking.
+func SpeedTest(opts *config.Opts, size int, save bool) ([]SpeedTestResult, error) {
+	if !opts.Client || len(opts.RemoteAdminAddr) == 0 {
+		return nil, errNoRemoteAdminAddr
+	}
+	if size <= 0 {
+		size = admin.DefaultSpeedTestSize
+	}
+
+	nc, err := NewNconnect(opts)
+	if err != nil {
+		return nil, err
+	}
+	if save && len(nc.persistConf.RemoteAdminAddr) == 0 {
+		return nil, errNoRemoteAdminAddrInConfig
+	}
+	c, err := nc.getAdminClient()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SpeedTestResult, len(opts.RemoteAdminAddr))
+	for i, addr := range opts.RemoteAdminAddrs() {
+		results[i] = speedTest(c, addr, size)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if (len(results[i].Error) == 0) != (len(results[j].Error) == 0) {
+			return len(results[i].Error) == 0
+		}
+		return results[i].ThroughputBps > results[j].ThroughputBps
+	})
+
+	if save {
+		err = saveServerOrder(nc.persistConf, results)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return results, nil
+}
+
+func speedTest(c *admin.Client, addr string, size int) SpeedTestResult {
+	res := SpeedTestResult{Addr: addr}
+	for i := 0; i < speedTestPings; i++ {
+		start := time.Now()
+		_, err := c.GetInfo(addr)
+		if err != nil {
+			res.Error = err.Error()
+			return res
+		}
+		latency := time.Since(start).Milliseconds()
+		if i == 0 || latency < res.LatencyMs {
+			res.LatencyMs = latency
+		}
+	}
+
+	start := time.Now()
+	n, err := c.SpeedTest(addr, size)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.ThroughputBps = float64(n) / time.Since(start).Seconds()
+	return res
+}
+
+// saveServerOrder reorders remoteAdminAddr in persisted config by results,
+// keeping per server options.
+// Addresses not tested, e.g. only given in command line, keep their relative
+// order after the tested ones.
+func saveServerOrder(persistConf *config.Config, results []SpeedTestResult) error {
+	rank := make(map[string]int, len(results))
+	for i, r := range results {
+		rank[r.Addr] = i
+	}
+	servers := append([]config.RemoteServer(nil), persistConf.RemoteAdminAddr...)
+	sort.SliceStable(servers, func(i, j int) bool {
+		ri, ok := rank[servers[i].Addr]
+		if !ok {
+			ri = len(results)
+		}
+		rj, ok := rank[servers[j].Addr]
+		if !ok {
+			rj = len(results)
+		}
+		return ri < rj
+	})
+	updated, changed, err := persistConf.WithPartial(map[string]interface{}{"remoteAdminAddr": servers})
+	if err != nil {
+		return err
+	}
+	return persistConf.SetFields(updated, changed)
+}
+
+// WriteSpeedTest writes speed test results to w as a ranked table, or as JSON
+// if asJSON is true.
+func WriteSpeedTest(w io.Writer, results []SpeedTestResult, asJSON bool) error {
+	if asJSON {
+		b, err := json.MarshalIndent(results, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "RANK\tSERVER\tLATENCY\tTHROUGHPUT")
+	for i, r := range results {
+		if len(r.Error) > 0 {
+			fmt.Fprintf(tw, "-\t%s\tunreachable\t%s\n", r.Addr, r.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%dms\t%.2f Mbps\n", i+1, r.Addr, r.LatencyMs, r.ThroughputBps*8/1e6)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,129 @@
+package ss
+
+import (
+	"log"
+	"net"
+	"sync"
+)
+
+// SourceStats is the connection counters of one source IP of local proxy.
+type SourceStats struct {
+	Active   int   `json:"active"`
+	Total    int64 `json:"total"`
+	Rejected int64 `json:"rejected"`
+}
+
+var sources struct {
+	sync.Mutex
+	allowed []*net.IPNet
+	stats   map[string]*SourceStats
+}
+
+// SetSocksAllowedIPs changes source CIDRs allowed to use local proxy at
+// runtime. Connections already accepted are kept.
+func SetSocksAllowedIPs(allowed []*net.IPNet) {
+	sources.Lock()
+	defer sources.Unlock()
+	sources.allowed = allowed
+}
+
+// sourceIP returns the IP of a net.Addr, or nil if it has none.
+func sourceIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	}
+	return nil
+}
+
+// isSourceAllowed returns whether a source address may use local proxy.
+// Loopback is always allowed, e.g. for TUN device, and all sources are
+// allowed if allowlist is empty.
+func isSourceAllowed(ip net.IP) bool {
+	if ip == nil || ip.IsLoopback() {
+		return true
+	}
+	sources.Lock()
+	defer sources.Unlock()
+	if len(sources.allowed) == 0 {
+		return true
+	}
+	for _, cidr := range sources.allowed {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func getSourceStats(ip net.IP) *SourceStats {
+	key := ip.String()
+	s, ok := sources.stats[key]
+	if !ok {
+		if sources.stats == nil {
+			sources.stats = make(map[string]*SourceStats)
+		}
+		s = &SourceStats{}
+		sources.stats[key] = s
+	}
+	return s
+}
+
+// acceptSource checks allowlist for a new TCP connection from addr and counts
+// it. The returned function should be called when connection is closed, and
+// is nil if the connection is rejected.
+func acceptSource(addr net.Addr) func() {
+	ip := sourceIP(addr)
+	allowed := isSourceAllowed(ip)
+	if ip == nil {
+		return func() {}
+	}
+
+	sources.Lock()
+	defer sources.Unlock()
+	s := getSourceStats(ip)
+	if !allowed {
+		s.Rejected++
+		if s.Rejected == 1 {
+			log.Printf("Rejected local proxy connection from %s not in allowed IPs", ip)
+		}
+		return nil
+	}
+	s.Active++
+	s.Total++
+	return func() {
+		sources.Lock()
+		s.Active--
+		sources.Unlock()
+	}
+}
+
+// allowPacketSource checks allowlist for a UDP packet from addr.
+func allowPacketSource(addr net.Addr) bool {
+	ip := sourceIP(addr)
+	if isSourceAllowed(ip) {
+		return true
+	}
+	sources.Lock()
+	defer sources.Unlock()
+	s := getSourceStats(ip)
+	s.Rejected++
+	if s.Rejected == 1 {
+		log.Printf("Rejected local proxy packet from %s not in allowed IPs", ip)
+	}
+	return false
+}
+
+// GetSourceStats returns a copy of connection counters of local proxy by
+// source IP.
+func GetSourceStats() map[string]SourceStats {
+	sources.Lock()
+	defer sources.Unlock()
+	stats := make(map[string]SourceStats, len(sources.stats))
+	for k, v := range sources.stats {
+		stats[k] = *v
+	}
+	return stats
+}
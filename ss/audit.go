@@ -0,0 +1,74 @@
+package ss
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/shadowsocks/go-shadowsocks2/shadowaead"
+)
+
+// Kinds of anomalies detected by strict audit.
+const (
+	AnomalyReplay    = "replay"    // repeated salt, a recorded connection or packet is sent again
+	AnomalyTampered  = "tampered"  // chunk or packet fails authentication, modified, reordered or dropped in stream
+	AnomalyTruncated = "truncated" // packet shorter than salt and tag
+)
+
+var audit struct {
+	sync.Mutex
+	anomalies map[string]int64
+}
+
+// classifyAnomaly returns the anomaly kind of an error returned by AEAD
+// stream or packet conn, or empty string if err is not an anomaly. Chunk
+// nonces of a stream are sequential, so replayed, reordered or dropped chunks
+// fail authentication as well as modified ones.
+func classifyAnomaly(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, shadowaead.ErrRepeatedSalt):
+		return AnomalyReplay
+	case errors.Is(err, shadowaead.ErrShortPacket):
+		return AnomalyTruncated
+	case strings.Contains(err.Error(), "message authentication failed"):
+		return AnomalyTampered
+	}
+	return ""
+}
+
+// auditError records err if it indicates relay tampering or replay and
+// strict audit is enabled. It returns whether err is an anomaly.
+func auditError(err error, peer string) bool {
+	if !config.StrictAudit {
+		return false
+	}
+	kind := classifyAnomaly(err)
+	if len(kind) == 0 {
+		return false
+	}
+
+	audit.Lock()
+	if audit.anomalies == nil {
+		audit.anomalies = make(map[string]int64)
+	}
+	audit.anomalies[kind]++
+	audit.Unlock()
+
+	log.Printf("WARNING: strict audit detected %s data from %s: %v. The relay might be tampering with or replaying traffic.", kind, peer, err)
+	return true
+}
+
+// GetAuditAnomalies returns the number of anomalies detected by strict audit
+// by kind.
+func GetAuditAnomalies() map[string]int64 {
+	audit.Lock()
+	defer audit.Unlock()
+	anomalies := make(map[string]int64, len(audit.anomalies))
+	for k, v := range audit.anomalies {
+		anomalies[k] = v
+	}
+	return anomalies
+}
@@ -0,0 +1,158 @@
+package ss
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/shadowsocks/go-shadowsocks2/core"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	benchmarkPayloadSize = 16 * 1024 // max shadowsocks AEAD payload size
+	benchmarkDuration    = 50 * time.Millisecond
+)
+
+// AEADCiphers are the AEAD ciphers that can be benchmarked, in order of
+// preference when they have the same throughput.
+var AEADCiphers = []string{"chacha20-ietf-poly1305", "aes-128-gcm", "aes-256-gcm"}
+
+func newAEAD(name string) (cipher.AEAD, error) {
+	switch name {
+	case "aes-128-gcm", "aes-256-gcm":
+		keySize := 16
+		if name == "aes-256-gcm" {
+			keySize = 32
+		}
+		block, err := aes.NewCipher(make([]byte, keySize))
+		if err != nil {
+			return nil, err
+		}
+		return cipher.NewGCM(block)
+	default:
+		return chacha20poly1305.New(make([]byte, chacha20poly1305.KeySize))
+	}
+}
+
+// BenchmarkCipher returns the encryption throughput of an AEAD cipher on the
+// local CPU in bytes per second.
+func BenchmarkCipher(name string) (float64, error) {
+	aead, err := newAEAD(name)
+	if err != nil {
+		return 0, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	payload := make([]byte, benchmarkPayloadSize)
+	buf := make([]byte, 0, benchmarkPayloadSize+aead.Overhead())
+
+	var n int
+	start := time.Now()
+	for time.Since(start) < benchmarkDuration {
+		aead.Seal(buf[:0], nonce, payload, nil)
+		n += len(payload)
+	}
+
+	return float64(n) / time.Since(start).Seconds(), nil
+}
+
+// FastestCipher benchmarks AEADCiphers and returns the fastest one.
+func FastestCipher() (string, error) {
+	var fastest string
+	var maxSpeed float64
+	for _, name := range AEADCiphers {
+		speed, err := BenchmarkCipher(name)
+		if err != nil {
+			return "", err
+		}
+		if speed > maxSpeed {
+			fastest, maxSpeed = name, speed
+		}
+	}
+	return fastest, nil
+}
+
+// proxyCipher is the cipher of proxy connections, which can be changed at
+// runtime. New TCP connections and UDP packets use the new cipher.
+var proxyCipher struct {
+	sync.RWMutex
+	ciph core.Cipher
+	key  []byte
+}
+
+func setProxyCipher(ciph core.Cipher, key []byte) {
+	proxyCipher.Lock()
+	defer proxyCipher.Unlock()
+	proxyCipher.ciph = ciph
+	proxyCipher.key = key
+}
+
+func getProxyCipher() core.Cipher {
+	proxyCipher.RLock()
+	defer proxyCipher.RUnlock()
+	return proxyCipher.ciph
+}
+
+// SetCipher changes cipher and password of the running proxy. Connections
+// already established keep their cipher.
+func SetCipher(cipher, password string) error {
+	proxyCipher.Lock()
+	defer proxyCipher.Unlock()
+	ciph, err := core.PickCipher(cipher, proxyCipher.key, password)
+	if err != nil {
+		return err
+	}
+	proxyCipher.ciph = ciph
+	log.Println("Proxy cipher changed to", cipher)
+	return nil
+}
+
+// shadowStream wraps a TCP connection with the current cipher.
+func shadowStream(c net.Conn) net.Conn {
+	return getProxyCipher().StreamConn(c)
+}
+
+// shadowPacket wraps a UDP connection with the current cipher, for
+// connections of a single flow.
+func shadowPacket(c net.PacketConn) net.PacketConn {
+	return getProxyCipher().PacketConn(c)
+}
+
+// shadowListener wraps a listening UDP connection so each packet uses the
+// current cipher.
+func shadowListener(c net.PacketConn) net.PacketConn {
+	return &cipherPacketConn{PacketConn: c}
+}
+
+type cipherPacketConn struct {
+	net.PacketConn
+
+	lock   sync.Mutex
+	ciph   core.Cipher
+	shadow net.PacketConn
+}
+
+// current returns c wrapped with the current cipher, which is only wrapped
+// again after cipher changes as wrapping allocates a packet buffer.
+func (c *cipherPacketConn) current() net.PacketConn {
+	ciph := getProxyCipher()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.shadow == nil || c.ciph != ciph {
+		c.ciph = ciph
+		c.shadow = ciph.PacketConn(c.PacketConn)
+	}
+	return c.shadow
+}
+
+func (c *cipherPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return c.current().ReadFrom(b)
+}
+
+func (c *cipherPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	return c.current().WriteTo(b, addr)
+}
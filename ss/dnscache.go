@@ -0,0 +1,234 @@
+package ss
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	dnsQueryTimeout       = 5 * time.Second
+	maxDNSCacheSize       = 4096
+	lowMemoryDNSCacheSize = 256
+	resolvConfPath        = "/etc/resolv.conf"
+)
+
+var (
+	errNoDNSRecord = errors.New("no such host")
+)
+
+// resolver caches resolved target hosts of the server, nil if disabled.
+var resolver *dnsCache
+
+type dnsEntry struct {
+	ips       []net.IP
+	err       error // cached negative answer
+	expiresAt time.Time
+}
+
+// dnsCache resolves hosts lazily on first use and keeps answers until their
+// TTL expires, clamped to [minTTL, maxTTL]. Nonexistent hosts are cached for
+// negativeTTL. When system nameservers cannot be read (e.g. on Windows), the
+// system resolver is used and answers are kept for minTTL.
+type dnsCache struct {
+	minTTL      time.Duration
+	maxTTL      time.Duration
+	negativeTTL time.Duration
+	maxSize     int
+	servers     []string
+
+	lock    sync.Mutex
+	entries map[string]*dnsEntry
+}
+
+func newDNSCache(minTTL, maxTTL, negativeTTL time.Duration, maxSize int) *dnsCache {
+	if maxTTL < minTTL {
+		maxTTL = minTTL
+	}
+	c := &dnsCache{
+		minTTL:      minTTL,
+		maxTTL:      maxTTL,
+		negativeTTL: negativeTTL,
+		maxSize:     maxSize,
+		entries:     make(map[string]*dnsEntry),
+	}
+	if conf, err := dns.ClientConfigFromFile(resolvConfPath); err == nil {
+		for _, s := range conf.Servers {
+			c.servers = append(c.servers, net.JoinHostPort(s, conf.Port))
+		}
+	}
+	return c
+}
+
+// lookup returns IPs of host from cache, or resolves and caches them.
+func (c *dnsCache) lookup(host string) ([]net.IP, error) {
+	now := time.Now()
+	c.lock.Lock()
+	e, ok := c.entries[host]
+	c.lock.Unlock()
+	if ok && now.Before(e.expiresAt) {
+		return e.ips, e.err
+	}
+
+	ips, ttl, err := c.resolve(host)
+	if err != nil && !errors.Is(err, errNoDNSRecord) {
+		return nil, err // do not cache network errors
+	}
+	if err != nil {
+		ttl = c.negativeTTL
+	} else if ttl < c.minTTL {
+		ttl = c.minTTL
+	} else if ttl > c.maxTTL {
+		ttl = c.maxTTL
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if len(c.entries) >= c.maxSize {
+		c.evict(now)
+	}
+	c.entries[host] = &dnsEntry{ips: ips, err: err, expiresAt: now.Add(ttl)}
+	return ips, err
+}
+
+// evict removes expired entries, and an arbitrary one if none is expired.
+func (c *dnsCache) evict(now time.Time) {
+	for k, e := range c.entries {
+		if now.After(e.expiresAt) {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.entries) < c.maxSize {
+		return
+	}
+	for k := range c.entries {
+		delete(c.entries, k)
+		return
+	}
+}
+
+// resolve queries A and AAAA records of host and returns the lowest TTL of
+// answers.
+func (c *dnsCache) resolve(host string) ([]net.IP, time.Duration, error) {
+	if len(c.servers) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), dnsQueryTimeout)
+		defer cancel()
+		addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+				return nil, 0, errNoDNSRecord
+			}
+			return nil, 0, err
+		}
+		ips := make([]net.IP, len(addrs))
+		for i, addr := range addrs {
+			ips[i] = addr.IP
+		}
+		return ips, c.minTTL, nil
+	}
+
+	var ips []net.IP
+	var ttl time.Duration
+	var err error
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		r, e := c.exchange(host, qtype)
+		if e != nil {
+			err = e
+			continue
+		}
+		for _, rr := range r.Answer {
+			var ip net.IP
+			switch v := rr.(type) {
+			case *dns.A:
+				ip = v.A
+			case *dns.AAAA:
+				ip = v.AAAA
+			default:
+				continue
+			}
+			ips = append(ips, ip)
+			rrTTL := time.Duration(rr.Header().Ttl) * time.Second
+			if ttl == 0 || rrTTL < ttl {
+				ttl = rrTTL
+			}
+		}
+	}
+	if len(ips) > 0 {
+		return ips, ttl, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return nil, 0, errNoDNSRecord
+}
+
+// exchange sends a query to nameservers in order until one answers.
+func (c *dnsCache) exchange(host string, qtype uint16) (*dns.Msg, error) {
+	m := new(dns.Msg)
+	m.SetQuestion(dns.Fqdn(host), qtype)
+	client := &dns.Client{Timeout: dnsQueryTimeout}
+	var err error
+	for _, server := range c.servers {
+		var r *dns.Msg
+		r, _, err = client.Exchange(m, server)
+		if err != nil {
+			continue
+		}
+		if r.Rcode != dns.RcodeSuccess && r.Rcode != dns.RcodeNameError {
+			err = errors.New(dns.RcodeToString[r.Rcode])
+			continue
+		}
+		return r, nil
+	}
+	return nil, err
+}
+
+// resolveTarget returns addr with host resolved from cache, or addr itself if
+// host is already an IP or cache is disabled.
+func resolveTarget(addr string) ([]string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if resolver == nil || net.ParseIP(host) != nil {
+		return []string{addr}, nil
+	}
+	ips, err := resolver.lookup(host)
+	if err != nil {
+		return nil, &net.DNSError{Err: err.Error(), Name: host}
+	}
+	addrs := make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip.String(), port)
+	}
+	return addrs, nil
+}
+
+// dialTarget dials resolved addresses of addr in order until one succeeds.
+func dialTarget(network, addr string) (net.Conn, error) {
+	addrs, err := resolveTarget(addr)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range addrs {
+		var c net.Conn
+		c, err = net.Dial(network, a)
+		if err == nil {
+			return c, nil
+		}
+	}
+	return nil, err
+}
+
+// resolveUDPTarget resolves addr to the first cached address.
+func resolveUDPTarget(addr string) (*net.UDPAddr, error) {
+	addrs, err := resolveTarget(addr)
+	if err != nil {
+		return nil, err
+	}
+	return net.ResolveUDPAddr("udp", addrs[0])
+}
@@ -0,0 +1,69 @@
+package ss
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// flow is a proxied TCP connection and the local tunnel port it goes to.
+type flow struct {
+	target string
+	server string
+	conns  []net.Conn
+}
+
+var flows struct {
+	sync.Mutex
+	m map[*flow]struct{}
+}
+
+func addFlow(target, server string, conns ...net.Conn) *flow {
+	f := &flow{target: target, server: server, conns: conns}
+	flows.Lock()
+	if flows.m == nil {
+		flows.m = make(map[*flow]struct{})
+	}
+	flows.m[f] = struct{}{}
+	flows.Unlock()
+	return f
+}
+
+func removeFlow(f *flow) {
+	flows.Lock()
+	delete(flows.m, f)
+	flows.Unlock()
+}
+
+// SetRoutes replaces client routes at runtime. Existing TCP flows whose target
+// now goes to a different local tunnel port are closed after drain, so
+// applications reconnect through the new route. UDP packets are routed one by
+// one and follow new routes immediately. Returns the number of flows to close.
+func SetRoutes(targetToClient map[string]string, cidrToClient []CIDRRoute, defaultClient string, drain time.Duration) int {
+	routes.Lock()
+	routes.TargetToClient = targetToClient
+	routes.CIDRToClient = cidrToClient
+	routes.DefaultClient = defaultClient
+	routes.Unlock()
+
+	var stale []*flow
+	flows.Lock()
+	for f := range flows.m {
+		if getClient(f.target) != f.server {
+			stale = append(stale, f)
+		}
+	}
+	flows.Unlock()
+
+	for _, f := range stale {
+		logf("rerouting %s, closing connection via %s", f.target, f.server)
+		f := f
+		time.AfterFunc(drain, func() {
+			for _, c := range f.conns {
+				c.Close()
+			}
+		})
+	}
+
+	return len(stale)
+}
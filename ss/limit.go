@@ -0,0 +1,32 @@
+package ss
+
+import "log"
+
+const (
+	lowMemoryUDPBufSize = 16 * 1024
+)
+
+// connSlots limits concurrent proxied TCP connections, each of which takes a
+// few goroutines and buffers. Nil means no limit.
+var connSlots chan struct{}
+
+// acquireConn returns whether a new connection can be accepted under the
+// limit. releaseConn should be called when an accepted connection is closed.
+func acquireConn() bool {
+	if connSlots == nil {
+		return true
+	}
+	select {
+	case connSlots <- struct{}{}:
+		return true
+	default:
+		log.Printf("Refused new connection as there are already %d connections", cap(connSlots))
+		return false
+	}
+}
+
+func releaseConn() {
+	if connSlots != nil {
+		<-connSlots
+	}
+}
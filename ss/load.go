@@ -0,0 +1,87 @@
+package ss
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/nknorg/nconnect/events"
+)
+
+// ServerStats are proxied connections and traffic of server mode since start.
+type ServerStats struct {
+	Active        int64 `json:"active"` // proxied TCP connections
+	Total         int64 `json:"total"`
+	Bytes         int64 `json:"bytes"`         // TCP and UDP traffic in both directions
+	BytesSent     int64 `json:"bytesSent"`     // TCP and UDP traffic from clients to targets
+	BytesReceived int64 `json:"bytesReceived"` // TCP and UDP traffic from targets to clients
+	DialErrors    int64 `json:"dialErrors"`    // failed TCP connections to targets
+}
+
+var serverStats ServerStats
+
+// GetServerStats returns a copy of server mode stats.
+func GetServerStats() ServerStats {
+	return ServerStats{
+		Active:        atomic.LoadInt64(&serverStats.Active),
+		Total:         atomic.LoadInt64(&serverStats.Total),
+		Bytes:         atomic.LoadInt64(&serverStats.Bytes),
+		BytesSent:     atomic.LoadInt64(&serverStats.BytesSent),
+		BytesReceived: atomic.LoadInt64(&serverStats.BytesReceived),
+		DialErrors:    atomic.LoadInt64(&serverStats.DialErrors),
+	}
+}
+
+// countedConn counts traffic of a target connection of server mode, and
+// applies global rate limits to it.
+type countedConn struct {
+	net.Conn
+}
+
+// countServerConn counts c as a new target connection of server mode. The
+// returned function should be called when connection is closed.
+func countServerConn(c net.Conn) (net.Conn, func()) {
+	active := atomic.AddInt64(&serverStats.Active, 1)
+	atomic.AddInt64(&serverStats.Total, 1)
+	events.Publish(events.ConnectionOpened{Active: active})
+	return &countedConn{Conn: c}, func() {
+		atomic.AddInt64(&serverStats.Active, -1)
+	}
+}
+
+func (c *countedConn) Read(b []byte) (int, error) {
+	if suspended.Load() {
+		return 0, errSuspended
+	}
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&serverStats.Bytes, int64(n))
+	atomic.AddInt64(&serverStats.BytesReceived, int64(n))
+	waitDownload(n)
+	return n, err
+}
+
+func (c *countedConn) Write(b []byte) (int, error) {
+	if suspended.Load() {
+		return 0, errSuspended
+	}
+	waitUpload(len(b))
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&serverStats.Bytes, int64(n))
+	atomic.AddInt64(&serverStats.BytesSent, int64(n))
+	return n, err
+}
+
+// countServerPacket counts a UDP packet of n bytes of server mode, sent to
+// target or received from it.
+func countServerPacket(n int, sent bool) {
+	atomic.AddInt64(&serverStats.Bytes, int64(n))
+	if sent {
+		atomic.AddInt64(&serverStats.BytesSent, int64(n))
+	} else {
+		atomic.AddInt64(&serverStats.BytesReceived, int64(n))
+	}
+}
+
+// countDialError counts a failed connection to target of server mode.
+func countDialError() {
+	atomic.AddInt64(&serverStats.DialErrors, 1)
+}
@@ -3,7 +3,9 @@ package ss
 import (
 	"fmt"
 	"log"
+	"net"
 	"os"
+	"sync/atomic"
 )
 
 var logger = log.New(os.Stderr, "", log.Lshortfile|log.LstdFlags)
@@ -14,6 +16,42 @@ func logf(f string, v ...interface{}) {
 	}
 }
 
+// DebugLine is a verbose log line about a proxy connection, passed to debug
+// sink to be matched against debug sessions.
+type DebugLine struct {
+	Source string // source address of the connection
+	Tag    string // connection tag
+	Line   string
+}
+
+var debugSink atomic.Pointer[func(*DebugLine)]
+
+// SetDebugSink sets the function verbose lines of proxy connections are passed
+// to whether verbose logs are on or not, or removes it if sink is nil.
+func SetDebugSink(sink func(*DebugLine)) {
+	if sink == nil {
+		debugSink.Store(nil)
+		return
+	}
+	debugSink.Store(&sink)
+}
+
+// connLogf is logf for a line about a connection from source to target, which
+// is also passed to debug sink if set.
+func connLogf(source net.Addr, target string, f string, v ...interface{}) {
+	sink := debugSink.Load()
+	if !config.Verbose && sink == nil {
+		return
+	}
+	line := fmt.Sprintf(f, v...)
+	if config.Verbose {
+		logger.Output(2, line)
+	}
+	if sink != nil {
+		(*sink)(&DebugLine{Source: source.String(), Tag: getTag(target), Line: line})
+	}
+}
+
 type logHelper struct {
 	prefix string
 }
@@ -29,3 +67,8 @@ func (l *logHelper) Write(p []byte) (n int, err error) {
 func newLogHelper(prefix string) *logHelper {
 	return &logHelper{prefix}
 }
+
+// SetVerbose turns verbose proxy logs on or off at runtime.
+func SetVerbose(verbose bool) {
+	config.Verbose = verbose
+}
@@ -1,25 +1,45 @@
-package ss
-
-import (
-	"strings"
-	"sync"
-)
-
-var routes struct {
-	sync.RWMutex
-	TargetToClient map[string]string // map target ip to local tunnel port
-	DefaultClient  string            // the default client for the targets are not in TargetToClient map
-}
-
-func getClient(target string) string {
-	tgtIp := strings.Split(target, ":")
-
-	routes.RLock()
-	defer routes.RUnlock()
-	server, ok := routes.TargetToClient[tgtIp[0]]
-
-	if ok {
-		return server
-	}
-	return routes.DefaultClient
-}
+package ss
+
+import (
+	"net"
+	"strings"
+	"sync"
+)
+
+// CIDRRoute maps targets in CIDR to a local tunnel port.
+type CIDRRoute struct {
+	CIDR   *net.IPNet
+	Client string
+	Tag    string // tag of connections on this route, see TagRule
+}
+
+var routes struct {
+	sync.RWMutex
+	TargetToClient map[string]string // map target ip to local tunnel port
+	CIDRToClient   []CIDRRoute       // checked in order when target ip is not in TargetToClient map
+	DefaultClient  string            // the default client for the targets are not in TargetToClient map
+}
+
+func getClient(target string) string {
+	tgtIp := strings.Split(target, ":")
+
+	routes.RLock()
+	defer routes.RUnlock()
+	server, ok := routes.TargetToClient[tgtIp[0]]
+
+	if ok {
+		return server
+	}
+
+	if host, _, err := net.SplitHostPort(target); err == nil {
+		if ip := net.ParseIP(host); ip != nil {
+			for _, r := range routes.CIDRToClient {
+				if r.CIDR.Contains(ip) {
+					return r.Client
+				}
+			}
+		}
+	}
+
+	return routes.DefaultClient
+}
@@ -0,0 +1,91 @@
+package ss
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Traffic allowed at once after being idle, so short bursts are not
+	// delayed while the average rate stays under limit.
+	rateLimitBurst = 100 * time.Millisecond
+)
+
+// tokenBucket limits the rate of bytes shared by all connections. Tokens are
+// refilled continuously up to burst. Bytes beyond available tokens are taken
+// as debt, so a large write is delayed in one sleep instead of being split.
+type tokenBucket struct {
+	lock   sync.Mutex
+	rate   float64 // bytes per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(mbps float64) *tokenBucket {
+	rate := mbps * 1e6 / 8
+	burst := math.Max(rate*rateLimitBurst.Seconds(), float64(udpBufSize))
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// wait blocks until n bytes are allowed.
+func (b *tokenBucket) wait(n int) {
+	if n <= 0 {
+		return
+	}
+	b.lock.Lock()
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	var delay time.Duration
+	if b.tokens < 0 {
+		delay = time.Duration(-b.tokens / b.rate * float64(time.Second))
+	}
+	b.lock.Unlock()
+	if delay > 0 {
+		time.Sleep(delay)
+	}
+}
+
+// Global rate limits of server mode, nil means no limit.
+var rateLimit struct {
+	upload   atomic.Pointer[tokenBucket] // from clients to targets
+	download atomic.Pointer[tokenBucket] // from targets to clients
+}
+
+// SetRateLimit caps the total rate of server mode traffic from clients to
+// targets (upload) and from targets to clients (download) in Mbps, shared by
+// all clients. 0 is for no limit.
+func SetRateLimit(uploadMbps, downloadMbps float64) {
+	var upload, download *tokenBucket
+	if uploadMbps > 0 {
+		upload = newTokenBucket(uploadMbps)
+	}
+	if downloadMbps > 0 {
+		download = newTokenBucket(downloadMbps)
+	}
+	rateLimit.upload.Store(upload)
+	rateLimit.download.Store(download)
+}
+
+// waitUpload blocks until n bytes from clients to targets are allowed.
+func waitUpload(n int) {
+	if b := rateLimit.upload.Load(); b != nil {
+		b.wait(n)
+	}
+}
+
+// waitDownload blocks until n bytes from targets to clients are allowed.
+func waitDownload(n int) {
+	if b := rateLimit.download.Load(); b != nil {
+		b.wait(n)
+	}
+}
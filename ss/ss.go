@@ -1,8 +1,11 @@
 package ss
 
 import (
+	"context"
 	"encoding/base64"
 	"errors"
+	"io"
+	"net"
 	"net/url"
 	"strings"
 	"time"
@@ -31,17 +34,37 @@ type Config struct {
 	UDPTimeout time.Duration
 	TCPCork    bool
 
+	StrictAudit     bool         // log and count AEAD replay and authentication failures as relay anomalies
+	SocksAllowedIPs []*net.IPNet // source CIDRs allowed to use local proxy, all allowed if empty
+	MaxConns        int          // max concurrent TCP connections, 0 is for no limit
+	MaxUploadMbps   float64      // server total rate from clients to targets, 0 is for no limit
+	MaxDownloadMbps float64      // server total rate from targets to clients, 0 is for no limit
+	LowMemory       bool         // use smaller UDP buffers and DNS cache
+
+	DNSCache            bool          // cache resolved target hosts on server
+	DNSCacheMinTTL      time.Duration // lower bound of cached answer lifetime
+	DNSCacheMaxTTL      time.Duration // upper bound of cached answer lifetime
+	DNSCacheNegativeTTL time.Duration // lifetime of cached nonexistent hosts
+
+	TagRules []TagRule // tag rules of client connections, checked before route tags
+
 	TargetToClient map[string]string // map target ip to local tunnel port
+	CIDRToClient   []CIDRRoute       // map target CIDR to local tunnel port, checked after TargetToClient
 	DefaultClient  string            // the default client for the targets are not in Target2Client map
 }
 
 var config struct {
-	Verbose    bool
-	UDPTimeout time.Duration
-	TCPCork    bool
+	Verbose     bool
+	UDPTimeout  time.Duration
+	TCPCork     bool
+	StrictAudit bool
+
+	ctx context.Context // listeners are closed when it is done
 }
 
-func Start(flags *Config) error {
+// Start starts proxy and blocks until it fails or ctx is done, which closes
+// all of its listeners.
+func Start(ctx context.Context, flags *Config) error {
 	if flags.Client == "" && flags.Server == "" {
 		return errors.New("at least one of client/server mode should be used")
 	}
@@ -49,8 +72,32 @@ func Start(flags *Config) error {
 	config.Verbose = flags.Verbose
 	config.UDPTimeout = flags.UDPTimeout
 	config.TCPCork = flags.TCPCork
+	config.StrictAudit = flags.StrictAudit
+	config.ctx = ctx
+
+	SetSocksAllowedIPs(flags.SocksAllowedIPs)
+
+	if flags.MaxConns > 0 {
+		connSlots = make(chan struct{}, flags.MaxConns)
+	}
+	if flags.LowMemory {
+		udpBufSize = lowMemoryUDPBufSize
+	}
+	if flags.Server != "" {
+		SetRateLimit(flags.MaxUploadMbps, flags.MaxDownloadMbps)
+	}
+	if flags.DNSCache && flags.Server != "" {
+		size := maxDNSCacheSize
+		if flags.LowMemory {
+			size = lowMemoryDNSCacheSize
+		}
+		resolver = newDNSCache(flags.DNSCacheMinTTL, flags.DNSCacheMaxTTL, flags.DNSCacheNegativeTTL, size)
+	}
+
+	SetTagRules(flags.TagRules)
 
 	routes.TargetToClient = flags.TargetToClient
+	routes.CIDRToClient = flags.CIDRToClient
 	routes.DefaultClient = flags.DefaultClient
 
 	var key []byte
@@ -83,6 +130,7 @@ func Start(flags *Config) error {
 		if err != nil {
 			return err
 		}
+		setProxyCipher(ciph, key)
 
 		if flags.Plugin != "" {
 			addr, err = startPlugin(flags.Plugin, flags.PluginOpts, addr, false)
@@ -95,7 +143,7 @@ func Start(flags *Config) error {
 			for _, tun := range strings.Split(flags.UDPTun, ",") {
 				p := strings.Split(tun, "=")
 				go func() {
-					sendErr(udpLocal(p[0], udpAddr, p[1], ciph.PacketConn), errChan)
+					sendErr(udpLocal(p[0], udpAddr, p[1], shadowPacket), errChan)
 				}()
 			}
 		}
@@ -104,7 +152,7 @@ func Start(flags *Config) error {
 			for _, tun := range strings.Split(flags.TCPTun, ",") {
 				p := strings.Split(tun, "=")
 				go func() {
-					sendErr(tcpTun(p[0], addr, p[1], ciph.StreamConn), errChan)
+					sendErr(tcpTun(p[0], addr, p[1], shadowStream), errChan)
 				}()
 			}
 		}
@@ -112,24 +160,24 @@ func Start(flags *Config) error {
 		if flags.Socks != "" {
 			socks.UDPEnabled = flags.UDPSocks
 			go func() {
-				sendErr(socksLocal(flags.Socks, addr, ciph.StreamConn), errChan)
+				sendErr(socksLocal(flags.Socks, addr, shadowStream), errChan)
 			}()
 			if flags.UDPSocks {
 				go func() {
-					sendErr(udpSocksLocal(flags.Socks, udpAddr, ciph.PacketConn), errChan)
+					sendErr(udpSocksLocal(flags.Socks, udpAddr, shadowPacket), errChan)
 				}()
 			}
 		}
 
 		if flags.RedirTCP != "" {
 			go func() {
-				sendErr(redirLocal(flags.RedirTCP, addr, ciph.StreamConn), errChan)
+				sendErr(redirLocal(flags.RedirTCP, addr, shadowStream), errChan)
 			}()
 		}
 
 		if flags.RedirTCP6 != "" {
 			go func() {
-				sendErr(redir6Local(flags.RedirTCP6, addr, ciph.StreamConn), errChan)
+				sendErr(redir6Local(flags.RedirTCP6, addr, shadowStream), errChan)
 			}()
 		}
 	}
@@ -160,22 +208,41 @@ func Start(flags *Config) error {
 		if err != nil {
 			return err
 		}
+		setProxyCipher(ciph, key)
 
 		if flags.UDP {
 			go func() {
-				sendErr(udpRemote(udpAddr, ciph.PacketConn), errChan)
+				sendErr(udpRemote(udpAddr, shadowListener), errChan)
 			}()
 		}
 		if flags.TCP {
 			go func() {
-				sendErr(tcpRemote(addr, ciph.StreamConn), errChan)
+				sendErr(tcpRemote(addr, shadowStream), errChan)
 			}()
 		}
 	}
 
 	defer killPlugin()
 
-	return <-errChan
+	select {
+	case err := <-errChan:
+		return err
+	case <-ctx.Done():
+		return nil
+	}
+}
+
+// closeOnDone closes listener l when proxy is stopped.
+func closeOnDone(l io.Closer) {
+	go func() {
+		<-config.ctx.Done()
+		l.Close()
+	}()
+}
+
+// stopped returns whether err of a listener is caused by stopping proxy.
+func stopped(err error) bool {
+	return errors.Is(err, net.ErrClosed) && config.ctx.Err() != nil
 }
 
 func parseURL(s string) (addr, cipher, password string, err error) {
@@ -0,0 +1,24 @@
+package ss
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var (
+	errSuspended = errors.New("proxy is suspended")
+
+	suspended atomic.Bool
+)
+
+// SetSuspended stops (or resumes) proxying, e.g. when monthly data cap is
+// exceeded. While suspended, new connections and UDP packets are dropped, and
+// traffic of existing connections fails so they are closed.
+func SetSuspended(s bool) {
+	suspended.Store(s)
+}
+
+// IsSuspended returns whether proxying is suspended.
+func IsSuspended() bool {
+	return suspended.Load()
+}
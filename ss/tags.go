@@ -0,0 +1,195 @@
+package ss
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// UntaggedTag is the tag of connections that match no tag rule or route tag.
+const UntaggedTag = "untagged"
+
+// TagRule attaches a tag to connections whose target matches any of CIDRs or
+// domain suffixes, and one of ports if ports is not empty.
+type TagRule struct {
+	Tag     string
+	CIDRs   []*net.IPNet
+	Domains []string // domain suffixes without leading dot, matching the domain and its subdomains
+	Ports   []int
+}
+
+// TagStats is the traffic of connections with one tag since start.
+type TagStats struct {
+	Active        int64 `json:"active"`
+	Total         int64 `json:"total"`
+	BytesSent     int64 `json:"bytesSent"`
+	BytesReceived int64 `json:"bytesReceived"`
+	PacketsSent   int64 `json:"packetsSent"` // UDP packets
+}
+
+// clientBytes is client mode TCP and UDP traffic in both directions.
+var clientBytes int64
+
+var tags struct {
+	sync.RWMutex
+	rules []TagRule
+	stats map[string]*TagStats
+}
+
+// SetTagRules replaces tag rules at runtime. New connections use new rules.
+func SetTagRules(rules []TagRule) {
+	tags.Lock()
+	tags.rules = rules
+	tags.Unlock()
+}
+
+func (r *TagRule) match(host string, port int) bool {
+	if len(r.Ports) > 0 {
+		found := false
+		for _, p := range r.Ports {
+			if p == port {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		for _, cidr := range r.CIDRs {
+			if cidr.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, d := range r.Domains {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// getTag returns the tag of a connection to target. Tag rules are checked in
+// order first, then tags of CIDR routes.
+func getTag(target string) string {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return UntaggedTag
+	}
+	port, _ := strconv.Atoi(portStr)
+
+	tags.RLock()
+	for i := range tags.rules {
+		if tags.rules[i].match(host, port) {
+			tags.RUnlock()
+			return tags.rules[i].Tag
+		}
+	}
+	tags.RUnlock()
+
+	if ip := net.ParseIP(host); ip != nil {
+		routes.RLock()
+		defer routes.RUnlock()
+		for _, r := range routes.CIDRToClient {
+			if len(r.Tag) > 0 && r.CIDR.Contains(ip) {
+				return r.Tag
+			}
+		}
+	}
+
+	return UntaggedTag
+}
+
+// getTagStats returns the stats of tag, which lives until process exits.
+func getTagStats(tag string) *TagStats {
+	tags.Lock()
+	defer tags.Unlock()
+	s, ok := tags.stats[tag]
+	if !ok {
+		if tags.stats == nil {
+			tags.stats = make(map[string]*TagStats)
+		}
+		s = &TagStats{}
+		tags.stats[tag] = s
+	}
+	return s
+}
+
+// GetTagStats returns a copy of traffic stats by connection tag.
+func GetTagStats() map[string]TagStats {
+	tags.RLock()
+	defer tags.RUnlock()
+	stats := make(map[string]TagStats, len(tags.stats))
+	for k, v := range tags.stats {
+		stats[k] = TagStats{
+			Active:        atomic.LoadInt64(&v.Active),
+			Total:         atomic.LoadInt64(&v.Total),
+			BytesSent:     atomic.LoadInt64(&v.BytesSent),
+			BytesReceived: atomic.LoadInt64(&v.BytesReceived),
+			PacketsSent:   atomic.LoadInt64(&v.PacketsSent),
+		}
+	}
+	return stats
+}
+
+// taggedConn counts traffic of a local proxy connection to its tag. Reads
+// are sent to target and writes are received from it.
+type taggedConn struct {
+	net.Conn
+	stats *TagStats
+}
+
+// tagConn counts c as a new connection of the tag of target. The returned
+// function should be called when connection is closed.
+func tagConn(c net.Conn, target string) (net.Conn, func()) {
+	s := getTagStats(getTag(target))
+	atomic.AddInt64(&s.Active, 1)
+	atomic.AddInt64(&s.Total, 1)
+	return &taggedConn{Conn: c, stats: s}, func() {
+		atomic.AddInt64(&s.Active, -1)
+	}
+}
+
+func (c *taggedConn) Read(b []byte) (int, error) {
+	if suspended.Load() {
+		return 0, errSuspended
+	}
+	n, err := c.Conn.Read(b)
+	atomic.AddInt64(&c.stats.BytesSent, int64(n))
+	atomic.AddInt64(&clientBytes, int64(n))
+	return n, err
+}
+
+func (c *taggedConn) Write(b []byte) (int, error) {
+	if suspended.Load() {
+		return 0, errSuspended
+	}
+	n, err := c.Conn.Write(b)
+	atomic.AddInt64(&c.stats.BytesReceived, int64(n))
+	atomic.AddInt64(&clientBytes, int64(n))
+	return n, err
+}
+
+// countTaggedPacket counts a UDP packet of n bytes sent to target.
+func countTaggedPacket(target string, n int) {
+	s := getTagStats(getTag(target))
+	atomic.AddInt64(&s.PacketsSent, 1)
+	atomic.AddInt64(&s.BytesSent, int64(n))
+	atomic.AddInt64(&clientBytes, int64(n))
+}
+
+// countClientPacket counts a UDP packet of n bytes received by client mode.
+func countClientPacket(n int) {
+	atomic.AddInt64(&clientBytes, int64(n))
+}
+
+// GetClientBytes returns client mode traffic in both directions since start.
+func GetClientBytes() int64 {
+	return atomic.LoadInt64(&clientBytes)
+}
@@ -8,13 +8,28 @@ import (
 	"sync"
 	"time"
 
+	"github.com/nknorg/nconnect/monitor"
 	"github.com/shadowsocks/go-shadowsocks2/socks"
 )
 
+// firstPayloadWait is how long to wait for the first payload before sending
+// target address alone, e.g. for protocols where server speaks first.
+const firstPayloadWait = 5 * time.Millisecond
+
+// maxChunkPayloadSize is the max payload size of a shadowsocks AEAD chunk.
+const maxChunkPayloadSize = 0x3FFF
+
+var firstPayloadBufPool = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, maxChunkPayloadSize)
+		return &b
+	},
+}
+
 // Create a SOCKS server listening on addr and proxy to server.
 func socksLocal(addr, server string, shadow func(net.Conn) net.Conn) error {
 	logf("SOCKS proxy %s <-> %s", addr, server)
-	return tcpLocal(addr, server, shadow, func(c net.Conn) (socks.Addr, error) { return socks.Handshake(c) })
+	return tcpLocal(monitor.ListenerSocks, addr, server, shadow, func(c net.Conn) (socks.Addr, error) { return socks.Handshake(c) })
 }
 
 // Create a TCP tunnel from addr to target via server.
@@ -24,25 +39,48 @@ func tcpTun(addr, server, target string, shadow func(net.Conn) net.Conn) error {
 		return fmt.Errorf("invalid target address %q", target)
 	}
 	logf("TCP tunnel %s <-> %s <-> %s", addr, server, target)
-	return tcpLocal(addr, server, shadow, func(net.Conn) (socks.Addr, error) { return tgt, nil })
+	return tcpLocal(monitor.ListenerTCPTun, addr, server, shadow, func(net.Conn) (socks.Addr, error) { return tgt, nil })
 }
 
-// Listen on addr and proxy to server to reach target from getAddr.
-func tcpLocal(addr, server string, shadow func(net.Conn) net.Conn, getAddr func(net.Conn) (socks.Addr, error)) error {
+// Listen on addr and proxy to server to reach target from getAddr. Connections
+// are counted in listener stats of name.
+func tcpLocal(name, addr, server string, shadow func(net.Conn) net.Conn, getAddr func(net.Conn) (socks.Addr, error)) error {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %v", addr, err)
 	}
+	stats := monitor.NewListener(name, l.Addr())
+	l = stats.Count(l)
+	closeOnDone(l)
 
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if stopped(err) {
+				return nil
+			}
 			logf("failed to accept: %s", err)
 			time.Sleep(time.Second)
 			continue
 		}
 
+		done := acceptSource(c.RemoteAddr())
+		if done == nil {
+			stats.AuthFailed()
+			c.Close()
+			continue
+		}
+
+		if !acquireConn() {
+			stats.Dropped()
+			done()
+			c.Close()
+			continue
+		}
+
 		go func() {
+			defer releaseConn()
+			defer done()
 			defer c.Close()
 			tgt, err := getAddr(c)
 			if err != nil {
@@ -63,14 +101,23 @@ func tcpLocal(addr, server string, shadow func(net.Conn) net.Conn, getAddr func(
 					}
 				}
 
+				stats.HandshakeFailed()
 				logf("failed to get target address: %v", err)
 				return
 			}
 
+			if suspended.Load() {
+				connLogf(c.RemoteAddr(), tgt.String(), "refuse %s: %v", tgt, errSuspended)
+				return
+			}
+
+			c, untag := tagConn(c, tgt.String())
+			defer untag()
+
 			server = getClient(tgt.String())
 			rc, err := net.Dial("tcp", server)
 			if err != nil {
-				logf("failed to connect to server %v: %v", server, err)
+				connLogf(c.RemoteAddr(), tgt.String(), "failed to connect to server %v: %v", server, err)
 				return
 			}
 			defer rc.Close()
@@ -80,46 +127,94 @@ func tcpLocal(addr, server string, shadow func(net.Conn) net.Conn, getAddr func(
 			}
 			rc = shadow(rc)
 
-			if _, err = rc.Write(tgt); err != nil {
-				logf("failed to send target address: %v", err)
+			if err = writeTargetAndFirstPayload(rc, c, tgt); err != nil {
+				connLogf(c.RemoteAddr(), tgt.String(), "failed to send target address: %v", err)
 				return
 			}
 
-			logf("proxy %s <-> %s <-> %s", c.RemoteAddr(), server, tgt)
+			f := addFlow(tgt.String(), server, c, rc)
+			defer removeFlow(f)
+
+			connLogf(c.RemoteAddr(), tgt.String(), "proxy %s <-> %s <-> %s", c.RemoteAddr(), server, tgt)
 			err = relay(rc, c)
 			if err != nil {
 				if err, ok := err.(net.Error); ok && err.Timeout() {
 					return // ignore i/o timeout
 				}
-				logf("relay error: %v", err)
+				if auditError(err, server) {
+					return
+				}
+				connLogf(c.RemoteAddr(), tgt.String(), "relay error: %v", err)
 			}
 		}()
 	}
 }
 
+// writeTargetAndFirstPayload writes target address together with the first
+// payload of c if it arrives within firstPayloadWait, so they are encrypted
+// and sent as one chunk instead of two.
+func writeTargetAndFirstPayload(rc, c net.Conn, tgt socks.Addr) error {
+	bp := firstPayloadBufPool.Get().(*[]byte)
+	defer firstPayloadBufPool.Put(bp)
+	b := *bp
+
+	n := copy(b, tgt)
+	c.SetReadDeadline(time.Now().Add(firstPayloadWait))
+	nr, err := c.Read(b[n:])
+	c.SetReadDeadline(time.Time{})
+	if err != nil && err != io.EOF {
+		if err, ok := err.(net.Error); !ok || !err.Timeout() {
+			return err
+		}
+	}
+
+	_, err = rc.Write(b[:n+nr])
+	return err
+}
+
 // Listen on addr for incoming connections.
 func tcpRemote(addr string, shadow func(net.Conn) net.Conn) error {
 	l, err := net.Listen("tcp", addr)
 	if err != nil {
 		return fmt.Errorf("failed to listen on %s: %v", addr, err)
 	}
+	stats := monitor.NewListener(monitor.ListenerProxy, l.Addr())
+	l = stats.Count(l)
+	closeOnDone(l)
 
 	logf("listening TCP on %s", addr)
 	for {
 		c, err := l.Accept()
 		if err != nil {
+			if stopped(err) {
+				return nil
+			}
 			logf("failed to accept: %v", err)
 			time.Sleep(time.Second)
 			continue
 		}
 
+		if !acquireConn() {
+			stats.Dropped()
+			c.Close()
+			continue
+		}
+
 		go func() {
+			defer releaseConn()
 			defer c.Close()
 			sc := shadow(c)
 
 			tgt, err := socks.ReadAddr(sc)
 			if err != nil {
-				logf("failed to get target address: %v", err)
+				if classifyAnomaly(err) == AnomalyTampered {
+					stats.AuthFailed() // wrong cipher or key
+				} else {
+					stats.HandshakeFailed()
+				}
+				if !auditError(err, c.RemoteAddr().String()) {
+					logf("failed to get target address: %v", err)
+				}
 				// drain c to avoid leaking server behavioral features
 				// see https://www.ndss-symposium.org/ndss-paper/detecting-probe-resistant-proxies/
 				_, err = io.Copy(ioutil.Discard, c)
@@ -129,20 +224,31 @@ func tcpRemote(addr string, shadow func(net.Conn) net.Conn) error {
 				return
 			}
 
-			rc, err := net.Dial("tcp", tgt.String())
+			if suspended.Load() {
+				connLogf(c.RemoteAddr(), tgt.String(), "refuse %s: %v", tgt, errSuspended)
+				return
+			}
+
+			rc, err := dialTarget("tcp", tgt.String())
 			if err != nil {
-				logf("failed to connect to target: %v", err)
+				countDialError()
+				connLogf(c.RemoteAddr(), tgt.String(), "failed to connect to target %s: %v", tgt, err)
 				return
 			}
 			defer rc.Close()
+			rc, uncount := countServerConn(rc)
+			defer uncount()
 
-			logf("proxy %s <-> %s", c.RemoteAddr(), tgt)
+			connLogf(c.RemoteAddr(), tgt.String(), "proxy %s <-> %s", c.RemoteAddr(), tgt)
 			err = relay(sc, rc)
 			if err != nil {
 				if err, ok := err.(net.Error); ok && err.Timeout() {
 					return // ignore i/o timeout
 				}
-				logf("relay error: %v", err)
+				if auditError(err, c.RemoteAddr().String()) {
+					return
+				}
+				connLogf(c.RemoteAddr(), tgt.String(), "relay error: %v", err)
 			}
 		}()
 	}
@@ -6,12 +6,13 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/nknorg/nconnect/monitor"
 	"github.com/shadowsocks/go-shadowsocks2/pfutil"
 	"github.com/shadowsocks/go-shadowsocks2/socks"
 )
 
 func redirLocal(addr, server string, shadow func(net.Conn) net.Conn) error {
-	return tcpLocal(addr, server, shadow, natLookup)
+	return tcpLocal(monitor.ListenerRedir, addr, server, shadow, natLookup)
 }
 
 func redir6Local(addr, server string, shadow func(net.Conn) net.Conn) error {
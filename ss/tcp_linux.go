@@ -5,6 +5,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/nknorg/nconnect/monitor"
 	"github.com/shadowsocks/go-shadowsocks2/nfutil"
 	"github.com/shadowsocks/go-shadowsocks2/socks"
 )
@@ -20,13 +21,13 @@ func getOrigDst(c net.Conn, ipv6 bool) (socks.Addr, error) {
 // Listen on addr for netfilter redirected TCP connections
 func redirLocal(addr, server string, shadow func(net.Conn) net.Conn) error {
 	logf("TCP redirect %s <-> %s", addr, server)
-	return tcpLocal(addr, server, shadow, func(c net.Conn) (socks.Addr, error) { return getOrigDst(c, false) })
+	return tcpLocal(monitor.ListenerRedir, addr, server, shadow, func(c net.Conn) (socks.Addr, error) { return getOrigDst(c, false) })
 }
 
 // Listen on addr for netfilter redirected TCP IPv6 connections.
 func redir6Local(addr, server string, shadow func(net.Conn) net.Conn) error {
 	logf("TCP6 redirect %s <-> %s", addr, server)
-	return tcpLocal(addr, server, shadow, func(c net.Conn) (socks.Addr, error) { return getOrigDst(c, true) })
+	return tcpLocal(monitor.ListenerRedir6, addr, server, shadow, func(c net.Conn) (socks.Addr, error) { return getOrigDst(c, true) })
 }
 
 func timedCork(c *net.TCPConn, d time.Duration) error {
@@ -3,10 +3,11 @@ package ss
 import (
 	"fmt"
 	"net"
-	"time"
-
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/nknorg/nconnect/monitor"
 	"github.com/shadowsocks/go-shadowsocks2/socks"
 )
 
@@ -18,7 +19,20 @@ const (
 	socksClient
 )
 
-const udpBufSize = 64 * 1024
+var udpBufSize = 64 * 1024
+
+// udpDisabled makes UDP remote drop packets at runtime without closing its
+// listener.
+var udpDisabled int32
+
+// SetUDPEnabled enables or disables proxying UDP packets on server at runtime.
+func SetUDPEnabled(enabled bool) {
+	var v int32
+	if !enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&udpDisabled, v)
+}
 
 // Listen on laddr for UDP packets, encrypt and send to server to reach target.
 func udpLocal(laddr, server, target string, shadow func(net.PacketConn) net.PacketConn) error {
@@ -38,6 +52,7 @@ func udpLocal(laddr, server, target string, shadow func(net.PacketConn) net.Pack
 		return fmt.Errorf("UDP local listen error: %v", err)
 	}
 	defer c.Close()
+	closeOnDone(c)
 
 	nm := newNATmap(config.UDPTimeout)
 	buf := make([]byte, udpBufSize)
@@ -47,10 +62,17 @@ func udpLocal(laddr, server, target string, shadow func(net.PacketConn) net.Pack
 	for {
 		n, raddr, err := c.ReadFrom(buf[len(tgt):])
 		if err != nil {
+			if stopped(err) {
+				return nil
+			}
 			logf("UDP local read error: %v", err)
 			continue
 		}
 
+		if !allowPacketSource(raddr) || suspended.Load() {
+			continue
+		}
+
 		pc := nm.Get(raddr.String())
 		if pc == nil {
 			pc, err = net.ListenPacket("udp", "")
@@ -68,6 +90,7 @@ func udpLocal(laddr, server, target string, shadow func(net.PacketConn) net.Pack
 			logf("UDP local write error: %v", err)
 			continue
 		}
+		countTaggedPacket(target, n)
 	}
 }
 
@@ -78,16 +101,24 @@ func udpSocksLocal(laddr, server string, shadow func(net.PacketConn) net.PacketC
 		return fmt.Errorf("UDP local listen error: %v", err)
 	}
 	defer c.Close()
+	closeOnDone(c)
 
 	nm := newNATmap(config.UDPTimeout)
 	buf := make([]byte, udpBufSize)
+	srvAddrs := make(map[string]*net.UDPAddr)
 
 	for {
 		n, raddr, err := c.ReadFrom(buf)
 		if err != nil {
+			if stopped(err) {
+				return nil
+			}
 			logf("UDP local read error: %v", err)
 			continue
 		}
+		if suspended.Load() {
+			continue
+		}
 
 		pc := nm.Get(raddr.String())
 		if pc == nil {
@@ -103,9 +134,13 @@ func udpSocksLocal(laddr, server string, shadow func(net.PacketConn) net.PacketC
 
 		dest := socks.Addr(buf[3:])
 		server = getClient(dest.String())
-		srvAddr, err := net.ResolveUDPAddr("udp", server)
-		if err != nil {
-			return fmt.Errorf("UDP server address error: %v", err)
+		srvAddr, ok := srvAddrs[server]
+		if !ok {
+			srvAddr, err = net.ResolveUDPAddr("udp", server)
+			if err != nil {
+				return fmt.Errorf("UDP server address error: %v", err)
+			}
+			srvAddrs[server] = srvAddr
 		}
 
 		_, err = pc.WriteTo(buf[3:n], srvAddr)
@@ -113,6 +148,9 @@ func udpSocksLocal(laddr, server string, shadow func(net.PacketConn) net.PacketC
 			logf("UDP local write error: %v", err)
 			continue
 		}
+		if a := socks.SplitAddr(buf[3:n]); a != nil {
+			countTaggedPacket(a.String(), n-3-len(a))
+		}
 	}
 }
 
@@ -123,6 +161,7 @@ func udpRemote(addr string, shadow func(net.PacketConn) net.PacketConn) error {
 		return fmt.Errorf("UDP remote listen error: %v", err)
 	}
 	defer c.Close()
+	closeOnDone(c)
 	c = shadow(c)
 
 	nm := newNATmap(config.UDPTimeout)
@@ -132,7 +171,16 @@ func udpRemote(addr string, shadow func(net.PacketConn) net.PacketConn) error {
 	for {
 		n, raddr, err := c.ReadFrom(buf)
 		if err != nil {
-			logf("UDP remote read error: %v", err)
+			if stopped(err) {
+				return nil
+			}
+			if raddr == nil || !auditError(err, raddr.String()) {
+				logf("UDP remote read error: %v", err)
+			}
+			continue
+		}
+
+		if atomic.LoadInt32(&udpDisabled) != 0 || suspended.Load() {
 			continue
 		}
 
@@ -142,7 +190,7 @@ func udpRemote(addr string, shadow func(net.PacketConn) net.PacketConn) error {
 			continue
 		}
 
-		tgtUDPAddr, err := net.ResolveUDPAddr("udp", tgtAddr.String())
+		tgtUDPAddr, err := resolveUDPTarget(tgtAddr.String())
 		if err != nil {
 			logf("failed to resolve target UDP address: %v", err)
 			continue
@@ -161,11 +209,13 @@ func udpRemote(addr string, shadow func(net.PacketConn) net.PacketConn) error {
 			nm.Add(raddr, c, pc, remoteServer)
 		}
 
+		waitUpload(len(payload))
 		_, err = pc.WriteTo(payload, tgtUDPAddr) // accept only UDPAddr despite the signature
 		if err != nil {
 			logf("UDP remote write error: %v", err)
 			continue
 		}
+		countServerPacket(len(payload), true)
 	}
 }
 
@@ -212,7 +262,10 @@ func (m *natmap) Add(peer net.Addr, dst, src net.PacketConn, role mode) {
 	m.Set(peer.String(), src)
 
 	go func() {
-		timedCopy(dst, peer, src, m.timeout, role)
+		err := timedCopy(dst, peer, src, m.timeout, role)
+		if role != remoteServer && err != nil {
+			auditError(err, peer.String())
+		}
 		if pc := m.Del(peer.String()); pc != nil {
 			pc.Close()
 		}
@@ -221,26 +274,53 @@ func (m *natmap) Add(peer net.Addr, dst, src net.PacketConn, role mode) {
 
 // copy from src to dst at target with read timeout
 func timedCopy(dst net.PacketConn, target net.Addr, src net.PacketConn, timeout time.Duration, role mode) error {
-	buf := make([]byte, udpBufSize)
+	// Packets are read after headroom so header can be prepended in place
+	// without copying or allocating for each packet.
+	var headroom int
+	switch role {
+	case remoteServer:
+		headroom = socks.MaxAddrLen
+	case socksClient:
+		headroom = 3
+	}
+	buf := make([]byte, headroom+udpBufSize)
+
+	var lastRaddr net.Addr
+	var srcAddr socks.Addr
 
 	for {
-		src.SetReadDeadline(time.Now().Add(timeout))
-		n, raddr, err := src.ReadFrom(buf)
+		setAt := time.Now()
+		src.SetReadDeadline(setAt.Add(timeout))
+		n, raddr, err := src.ReadFrom(buf[headroom:])
 		if err != nil {
+			if monitor.TimedOutLate(err, setAt, timeout) {
+				continue // system was suspended, not idle
+			}
 			return err
 		}
+		if suspended.Load() {
+			continue
+		}
 
 		switch role {
 		case remoteServer: // server -> client: add original packet source
-			srcAddr := socks.ParseAddr(raddr.String())
-			copy(buf[len(srcAddr):], buf[:n])
-			copy(buf, srcAddr)
-			_, err = dst.WriteTo(buf[:len(srcAddr)+n], target)
+			if lastRaddr == nil || !sameUDPAddr(raddr, lastRaddr) {
+				srcAddr = socks.ParseAddr(raddr.String())
+				lastRaddr = raddr
+			}
+			start := headroom - len(srcAddr)
+			copy(buf[start:], srcAddr)
+			waitDownload(n)
+			_, err = dst.WriteTo(buf[start:headroom+n], target)
+			countServerPacket(n, false)
 		case relayClient: // client -> user: strip original packet source
 			srcAddr := socks.SplitAddr(buf[:n])
 			_, err = dst.WriteTo(buf[len(srcAddr):n], target)
+			countClientPacket(n - len(srcAddr))
 		case socksClient: // client -> socks5 program: just set RSV and FRAG = 0
-			_, err = dst.WriteTo(append([]byte{0, 0, 0}, buf[:n]...), target)
+			buf[0], buf[1], buf[2] = 0, 0, 0
+			_, err = dst.WriteTo(buf[:headroom+n], target)
+			countClientPacket(n - len(socks.SplitAddr(buf[headroom:headroom+n])))
 		}
 
 		if err != nil {
@@ -248,3 +328,16 @@ func timedCopy(dst net.PacketConn, target net.Addr, src net.PacketConn, timeout
 		}
 	}
 }
+
+// sameUDPAddr returns whether a and b are the same UDP address.
+func sameUDPAddr(a, b net.Addr) bool {
+	ua, ok := a.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	ub, ok := b.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	return ua.Port == ub.Port && ua.IP.Equal(ub.IP) && ua.Zone == ub.Zone
+}
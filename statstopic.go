@@ -0,0 +1,141 @@
+package nconnect
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/monitor"
+	"github.com/nknorg/nconnect/ss"
+	"github.com/nknorg/nconnect/storage"
+	"github.com/nknorg/nkn-sdk-go"
+	tunnel "github.com/nknorg/nkn-tunnel"
+	"github.com/nknorg/tuna/geo"
+)
+
+const (
+	statsIDKey  = "stats-id"
+	statsIDSize = 8
+)
+
+// StatsTopicJSON is the anonymized aggregate stats a server publishes to
+// stats topic. It has no address of the server, its relay or its clients.
+type StatsTopicJSON struct {
+	ID           string  `json:"id"` // random and kept in state storage, so a dashboard can tell servers apart
+	Version      string  `json:"version"`
+	Uptime       int64   `json:"uptime"` // in seconds
+	Tuna         bool    `json:"tuna"`
+	RelayCountry string  `json:"relayCountry,omitempty"` // country code of tuna relay
+	Sessions     int64   `json:"sessions"`               // active proxied TCP connections
+	UploadBps    float64 `json:"uploadBps"`              // bytes per second from clients to targets since last publish
+	DownloadBps  float64 `json:"downloadBps"`            // bytes per second from targets to clients since last publish
+}
+
+// statsID returns the random ID of this node in stats topic, created on
+// first call.
+func (nc *nconnect) statsID() (string, error) {
+	store, err := nc.persistConf.GetStorage()
+	if err != nil {
+		return "", err
+	}
+	b, err := store.Get(statsIDKey)
+	if err == nil {
+		return string(b), nil
+	}
+	if err != storage.ErrNotFound {
+		return "", err
+	}
+	b = make([]byte, statsIDSize)
+	_, err = rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(b)
+	err = store.Put(statsIDKey, []byte(id))
+	if err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// publishStats publishes stats of server tunnel t to subscribers of stats
+// topic at interval until ctx is done. Stats are sent by a random NKN
+// account, so subscribers cannot tell which server they come from.
+func (nc *nconnect) publishStats(ctx context.Context, t *tunnel.Tunnel) {
+	id, err := nc.statsID()
+	if err != nil {
+		log.Println("Get stats id error:", err)
+		return
+	}
+
+	account, err := nkn.NewAccount(nil)
+	if err != nil {
+		log.Println("Create stats account error:", err)
+		return
+	}
+	m, err := nkn.NewMultiClient(account, "", nc.opts.GetAdminNumSubClients(), false, nc.clientConfig)
+	if err != nil {
+		log.Println("Create stats NKN client error:", err)
+		return
+	}
+	defer m.Close()
+	select {
+	case <-m.OnConnect.C:
+	case <-ctx.Done():
+		return
+	}
+
+	interval := time.Duration(nc.opts.StatsTopicInterval) * time.Second
+	if interval <= 0 {
+		interval = config.DefaultStatsTopicInterval
+	}
+
+	startTime := time.Now()
+	last, lastTime := ss.GetServerStats(), startTime
+	var relayIP, relayCountry string
+	for monitor.Sleep(ctx, interval) {
+		now, stats := time.Now(), ss.GetServerStats()
+		secs := now.Sub(lastTime).Seconds()
+		s := &StatsTopicJSON{
+			ID:          id,
+			Version:     config.Version,
+			Uptime:      int64(now.Sub(startTime).Seconds()),
+			Tuna:        nc.opts.Tuna,
+			Sessions:    stats.Active,
+			UploadBps:   float64(stats.BytesSent-last.BytesSent) / secs,
+			DownloadBps: float64(stats.BytesReceived-last.BytesReceived) / secs,
+		}
+		last, lastTime = stats, now
+
+		if nc.opts.Tuna {
+			if ip := admin.TunaRelayIP(t); ip != relayIP {
+				relayIP, relayCountry = ip, ""
+				if len(ip) > 0 {
+					loc, err := geo.NewIP2CProvider().GetLocation(ip)
+					if err != nil {
+						log.Println("Get tuna relay country error:", err)
+						relayIP = "" // look up again next time
+					} else {
+						relayCountry = loc.CountryCode
+					}
+				}
+			}
+			s.RelayCountry = relayCountry
+		}
+
+		b, err := json.Marshal(s)
+		if err != nil {
+			log.Println("Marshal stats error:", err)
+			continue
+		}
+		err = m.Publish(nc.opts.StatsTopic, b, nil)
+		if err != nil {
+			log.Println("Publish stats error:", err)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package nconnect
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/monitor"
+)
+
+const (
+	// Servers with load score within this margin are considered equally
+	// loaded, and the one with lower latency is preferred.
+	loadScoreMargin = 0.1
+)
+
+var (
+	errNoRemoteAdminAddr = errors.New("servers status needs client mode with remote admin addresses")
+)
+
+// ServerStatus is the status of one remote server of a client.
+type ServerStatus struct {
+	Addr         string        `json:"addr"`
+	Labels       []string      `json:"labels,omitempty"` // labels of the server in client config
+	Reachable    bool          `json:"reachable"`
+	Error        string        `json:"error,omitempty"`
+	LatencyMs    int64         `json:"latencyMs,omitempty"` // round trip time of get info rpc
+	Version      string        `json:"version,omitempty"`
+	NumCPU       int           `json:"numCPU,omitempty"`
+	LoadAverage  []float64     `json:"loadAverage,omitempty"` // 1, 5 and 15 minutes
+	AcceptPaused bool          `json:"acceptPaused,omitempty"`
+	Tags         []string      `json:"tags,omitempty"`
+	Load         *monitor.Load `json:"load,omitempty"` // nil if server does not publish load
+}
+
+// Available returns whether the server can take new connections.
+func (s *ServerStatus) Available() bool {
+	return s.Reachable && !s.AcceptPaused
+}
+
+// better returns whether s is preferred over other: less loaded if load
+// scores differ by more than loadScoreMargin, otherwise lower latency.
+func (s *ServerStatus) better(other *ServerStatus) bool {
+	if s.Load != nil && other.Load != nil && math.Abs(s.Load.Score-other.Load.Score) > loadScoreMargin {
+		return s.Load.Score < other.Load.Score
+	}
+	return s.LatencyMs < other.LatencyMs
+}
+
+// GetServersStatus queries all remote admin addresses of a client
+// concurrently, and returns their status in the order of config.
+func GetServersStatus(opts *config.Opts) ([]ServerStatus, error) {
+	if !opts.Client || len(opts.RemoteAdminAddr) == 0 {
+		return nil, errNoRemoteAdminAddr
+	}
+
+	nc, err := NewNconnect(opts)
+	if err != nil {
+		return nil, err
+	}
+	c, err := nc.getAdminClient()
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]ServerStatus, len(opts.RemoteAdminAddr))
+	var wg sync.WaitGroup
+	for i, remote := range opts.RemoteAdminAddr {
+		wg.Add(1)
+		go func(s *ServerStatus, remote config.RemoteServer) {
+			defer wg.Done()
+			s.Addr = remote.Addr
+			s.Labels = remote.Labels
+			start := time.Now()
+			info, err := c.GetInfo(remote.Addr)
+			if err != nil {
+				s.Error = err.Error()
+				return
+			}
+			s.Reachable = true
+			s.LatencyMs = time.Since(start).Milliseconds()
+			s.Version = info.Version
+			s.AcceptPaused = info.AcceptPaused
+			s.Tags = info.Tags
+			s.Load = info.Load
+			if info.Host != nil {
+				s.NumCPU = info.Host.NumCPU
+				s.LoadAverage = info.Host.LoadAverage
+			}
+		}(&status[i], remote)
+	}
+	wg.Wait()
+
+	return status, nil
+}
+
+// WriteServersStatus writes status of remote servers to w as a table, or as
+// JSON if asJSON is true. In table, the preferred available server is marked
+// with *: the least loaded one, or the one with the lowest latency among
+// similarly loaded ones.
+func WriteServersStatus(w io.Writer, status []ServerStatus, asJSON bool) error {
+	if asJSON {
+		b, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(b))
+		return err
+	}
+
+	best := -1
+	for i := range status {
+		if status[i].Available() && (best < 0 || status[i].better(&status[best])) {
+			best = i
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tSERVER\tSTATUS\tLATENCY\tSCORE\tLOAD\tCPU\tVERSION\tLABELS")
+	for i, s := range status {
+		mark := ""
+		if i == best {
+			mark = "*"
+		}
+		state := "unreachable"
+		if s.Reachable {
+			state = "ok"
+			if s.AcceptPaused {
+				state = "paused"
+			}
+		}
+		latency, score, load, cpu := "-", "-", "-", "-"
+		if s.Reachable {
+			latency = fmt.Sprintf("%dms", s.LatencyMs)
+		}
+		if s.Load != nil {
+			score = fmt.Sprintf("%.2f", s.Load.Score)
+		}
+		if len(s.LoadAverage) > 0 {
+			loads := make([]string, len(s.LoadAverage))
+			for j, l := range s.LoadAverage {
+				loads[j] = fmt.Sprintf("%.2f", l)
+			}
+			load = strings.Join(loads, " ")
+		}
+		if s.NumCPU > 0 {
+			cpu = fmt.Sprint(s.NumCPU)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", mark, s.Addr, state, latency, score, load, cpu, s.Version, strings.Join(s.Labels, ","))
+	}
+	return tw.Flush()
+}
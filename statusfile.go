@@ -0,0 +1,170 @@
+package nconnect
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/nknorg/nconnect/admin"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/monitor"
+	"github.com/nknorg/nconnect/ss"
+	"github.com/nknorg/nkn/v2/util/address"
+)
+
+const (
+	statusStateRunning   = "running"
+	statusStatePaused    = "paused"    // server does not accept new connections
+	statusStateSuspended = "suspended" // proxying is suspended by monthly data cap
+)
+
+// StatusFile is the content of status file, meant to be read by programs that
+// do not speak admin API.
+type StatusFile struct {
+	State      string    `json:"state"`
+	Mode       string    `json:"mode"`
+	Version    string    `json:"version"`
+	StartTime  time.Time `json:"startTime"`
+	UpdateTime time.Time `json:"updateTime"`
+
+	Addresses StatusAddresses `json:"addresses"`
+	Relay     StatusRelay     `json:"relay"`
+	Counters  StatusCounters  `json:"counters"`
+	Load      *monitor.Load   `json:"load,omitempty"` // server mode load
+
+	DataUsage *monitor.DataUsage `json:"dataUsage,omitempty"` // traffic of current data cap period
+
+	Tags map[string]ss.TagStats `json:"tags,omitempty"` // client traffic by connection tag
+}
+
+// StatusAddresses are the addresses of a running node.
+type StatusAddresses struct {
+	Client         string   `json:"client,omitempty"`         // client mode NKN address
+	LocalSocksAddr string   `json:"localSocksAddr,omitempty"` // client mode socks proxy
+	Remote         []string `json:"remote,omitempty"`         // client mode remote tunnel addresses
+	Tunnel         string   `json:"tunnel,omitempty"`         // server mode tunnel address
+	Admin          string   `json:"admin,omitempty"`          // server mode admin address
+}
+
+// StatusRelay is how traffic is relayed.
+type StatusRelay struct {
+	Tuna   bool `json:"tuna"`
+	UDP    bool `json:"udp"`
+	Direct bool `json:"direct"`
+}
+
+// StatusCounters are counters since start.
+type StatusCounters struct {
+	ProxyActive   int   `json:"proxyActive"`
+	ProxyTotal    int64 `json:"proxyTotal"`
+	ProxyRejected int64 `json:"proxyRejected"`
+	AcceptAddrs   int   `json:"acceptAddrs,omitempty"`
+}
+
+func (nc *nconnect) getStatusFile(startTime time.Time) *StatusFile {
+	s := &StatusFile{
+		State:      statusStateRunning,
+		Version:    config.Version,
+		StartTime:  startTime,
+		UpdateTime: time.Now(),
+		Relay: StatusRelay{
+			Tuna: nc.opts.Tuna,
+			UDP:  nc.opts.UDP,
+		},
+	}
+
+	if nc.opts.Client {
+		s.Mode = "client"
+		s.Addresses.Client = address.MakeAddressString(nc.account.PubKey(), nc.opts.Identifier)
+		s.Addresses.LocalSocksAddr = nc.opts.LocalSocksAddr
+		for _, t := range nc.tunnels {
+			s.Addresses.Remote = append(s.Addresses.Remote, t.ToAddr())
+		}
+		s.Relay.Direct = len(nc.forwarders) > 0
+		s.Tags = ss.GetTagStats()
+	} else {
+		s.Mode = "server"
+		if admin.IsAcceptPaused() {
+			s.State = statusStatePaused
+		}
+		if len(nc.tunnels) > 0 {
+			s.Addresses.Tunnel = nc.tunnels[0].FromAddr()
+			if len(nc.opts.AdminIdentifier) > 0 {
+				s.Addresses.Admin = nc.opts.AdminIdentifier + "." + s.Addresses.Tunnel
+			}
+		}
+		s.Relay.Direct = len(nc.opts.DirectListenAddr) > 0
+		s.Counters.AcceptAddrs = len(nc.persistConf.GetAcceptAddrs())
+		if nc.loadMeter != nil {
+			s.Load = nc.loadMeter.Load()
+		}
+	}
+
+	if nc.dataCap != nil {
+		usage := nc.dataCap.Usage()
+		s.DataUsage = &usage
+		if usage.Suspended {
+			s.State = statusStateSuspended
+		}
+	}
+
+	for _, stats := range ss.GetSourceStats() {
+		s.Counters.ProxyActive += stats.Active
+		s.Counters.ProxyTotal += stats.Total
+		s.Counters.ProxyRejected += stats.Rejected
+	}
+
+	return s
+}
+
+// writeStatusFile writes status to path atomically, so readers never see a
+// partial file.
+func writeStatusFile(path string, status *StatusFile) error {
+	b, err := json.MarshalIndent(status, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Chmod(0644)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// startStatusFile keeps writing status file until ctx is done.
+func (nc *nconnect) startStatusFile(ctx context.Context) {
+	interval := time.Duration(nc.opts.StatusInterval) * time.Second
+	if interval <= 0 {
+		interval = config.DefaultStatusInterval
+	}
+	startTime := time.Now()
+	for {
+		err := writeStatusFile(nc.opts.StatusFile, nc.getStatusFile(startTime))
+		if err != nil {
+			log.Println("Write status file error:", err)
+		}
+		if !monitor.Sleep(ctx, interval) {
+			return
+		}
+	}
+}
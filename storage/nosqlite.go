@@ -0,0 +1,12 @@
+//go:build !sqlite
+// +build !sqlite
+
+package storage
+
+import "errors"
+
+// NewSQLiteStorage returns an error as SQLite support is not built in. Build
+// with -tags sqlite (requires cgo) to enable it.
+func NewSQLiteStorage(dir string) (Storage, error) {
+	return nil, errors.New("sqlite storage is not supported by this build, rebuild with -tags sqlite")
+}
@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+)
+
+const (
+	TypeFile   = "file"
+	TypeSQLite = "sqlite"
+)
+
+var (
+	ErrNotFound       = errors.New("key not found")
+	errInvalidKey     = errors.New("key should be a non-empty name without path separator")
+	errUnknownStorage = errors.New("unknown storage type")
+)
+
+// Storage keeps runtime state such as config backups as key value pairs.
+type Storage interface {
+	// Get returns the value of key, or ErrNotFound if it does not exist.
+	Get(key string) ([]byte, error)
+	// Put sets the value of key, replacing the existing one.
+	Put(key string, value []byte) error
+	// Delete removes key. It is not an error if key does not exist.
+	Delete(key string) error
+	// List returns keys with prefix in ascending order.
+	List(prefix string) ([]string, error)
+	Close() error
+}
+
+// New creates a storage of the given type in dir.
+func New(storageType, dir string) (Storage, error) {
+	switch storageType {
+	case "", TypeFile:
+		s, err := NewFileStorage(dir)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	case TypeSQLite:
+		return NewSQLiteStorage(dir)
+	default:
+		return nil, errUnknownStorage
+	}
+}
+
+func verifyKey(key string) error {
+	if len(key) == 0 || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return errInvalidKey
+	}
+	return nil
+}
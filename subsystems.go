@@ -0,0 +1,68 @@
+package nconnect
+
+import (
+	"context"
+	"sync"
+)
+
+// subsystems runs the long running parts of a client or server, such as
+// proxy, tunnels and admin servers, until ctx is done or one of them stops.
+type subsystems struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+	stopped chan error
+}
+
+func newSubsystems(ctx context.Context) *subsystems {
+	ctx, cancel := context.WithCancel(ctx)
+	return &subsystems{
+		ctx:     ctx,
+		cancel:  cancel,
+		stopped: make(chan error, 1),
+	}
+}
+
+// run calls start in a goroutine, which should block until the subsystem
+// stops. If stop is not nil, it is called when ctx is done to make start
+// return. A subsystem that stops before ctx is done stops all others, as
+// nConnect does not work without any of them.
+func (s *subsystems) run(start func() error, stop func() error) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		err := start()
+		if s.ctx.Err() != nil {
+			return
+		}
+		select {
+		case s.stopped <- err:
+		default:
+		}
+		s.cancel()
+	}()
+
+	if stop != nil {
+		go func() {
+			<-s.ctx.Done()
+			stop()
+		}()
+	}
+}
+
+// err returns the error of the subsystem that stopped all others, or nil if
+// they are stopped by ctx or by a subsystem returning nil.
+func (s *subsystems) err() error {
+	select {
+	case err := <-s.stopped:
+		return err
+	default:
+		return nil
+	}
+}
+
+// stopAll stops all subsystems and waits for them to return.
+func (s *subsystems) stopAll() {
+	s.cancel()
+	s.wg.Wait()
+}
@@ -0,0 +1,62 @@
+package nconnect
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/nknorg/nconnect/config"
+)
+
+func errConfigFileExists(path string) error {
+	return fmt.Errorf("config file %s already exists, remove it or use --config-file to choose another path", path)
+}
+
+// InitConfig writes config template of the given name to the config file,
+// and explains the chosen options to w. Existing config file is never
+// overwritten.
+func InitConfig(opts *config.Opts, name string, w io.Writer) error {
+	t, err := config.GetTemplate(name)
+	if err != nil {
+		return err
+	}
+	return writeTemplate(opts, t, w)
+}
+
+// writeTemplate writes config template t to the config file, and explains
+// the chosen options to w. Existing config file is never overwritten.
+func writeTemplate(opts *config.Opts, t *config.Template, w io.Writer) error {
+	format, err := config.GetFormat(opts.ConfigFile, opts.ConfigFormat)
+	if err != nil {
+		return err
+	}
+
+	b, err := t.Marshal(format)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(opts.ConfigFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return errConfigFileExists(opts.ConfigFile)
+		}
+		return err
+	}
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		return err
+	}
+
+	mode := "-c"
+	if t.Server {
+		mode = "-s"
+	}
+	_, err = fmt.Fprintf(w, "Config template %s written to %s (%s):\n%s\n\nStart with: nconnect %s -f %s\n", t.Name, opts.ConfigFile, t.Description, t.Comments(), mode, opts.ConfigFile)
+	return err
+}
@@ -1,6 +1,7 @@
 package tests
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -54,12 +55,12 @@ func startNconnect(configFile string, tuna, udp, tun bool, n *types.Node) error
 	go func() {
 		if opts.Server {
 			nc.SetTunaNode(n)
-			err = nc.StartServer()
+			err = nc.StartServer(context.Background())
 			if err != nil {
 				log.Fatalf("start nconnect server err: %v", err)
 			}
 		} else {
-			err = nc.StartClient()
+			err = nc.StartClient(context.Background())
 			if err != nil {
 				log.Fatalf("start nconnect client err: %v", err)
 			}
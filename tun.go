@@ -0,0 +1,467 @@
+package nconnect
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/eycorsican/go-tun2socks/core"
+	"github.com/eycorsican/go-tun2socks/proxy/socks"
+	"github.com/nknorg/nconnect/arch"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/ss"
+	"github.com/nknorg/nconnect/util"
+)
+
+// tunDevice is a TUN device to open together with the remote servers its
+// traffic goes to.
+type tunDevice struct {
+	config.TunDeviceConfig
+	remotes []string // remote tunnel addresses
+	routes  []*net.IPNet
+	subnet  *net.IPNet
+	dev     io.ReadWriteCloser
+	dnsSet  bool // DNS of DNS domains is set
+
+	dropIPv6 atomic.Bool // drop IPv6 packets from device by block IPv6 policy
+}
+
+// getTunDeviceConfigs returns the TUN devices to open. Top level TUN and VPN
+// config is used as a single device if multiple TUN devices are not
+// configured.
+func (nc *nconnect) getTunDeviceConfigs() []config.TunDeviceConfig {
+	if len(nc.opts.TunDevices) > 0 {
+		return nc.opts.TunDevices
+	}
+	if !nc.opts.Tun && !nc.opts.VPN {
+		return nil
+	}
+	return []config.TunDeviceConfig{{
+		Name:          nc.opts.TunName,
+		Addr:          nc.opts.TunAddr,
+		Gateway:       nc.opts.TunGateway,
+		Mask:          nc.opts.TunMask,
+		DNS:           nc.opts.TunDNS,
+		VPN:           nc.opts.VPN,
+		VPNRoute:      nc.opts.VPNRoute,
+		VPNIPv6Policy: nc.opts.VPNIPv6Policy,
+	}}
+}
+
+// getRemoteTunnelAddrs returns remoteTunnelAddr if not empty, otherwise the
+// tunnel addresses of remote admin addresses.
+func (nc *nconnect) getRemoteTunnelAddrs(remoteAdminAddr, remoteTunnelAddr []string) []string {
+	if len(remoteTunnelAddr) > 0 {
+		return remoteTunnelAddr
+	}
+	addrs := make([]string, 0, len(remoteAdminAddr))
+	for _, addr := range remoteAdminAddr {
+		remoteInfo, err := nc.getRemoteInfo(addr)
+		if err != nil {
+			log.Printf("getRemoteInfo %v err: %v", addr, err)
+			continue
+		}
+		addrs = append(addrs, remoteInfo.Addr)
+	}
+	return addrs
+}
+
+// getVPNRoutes returns VPN routes of a device. If not given, remote server's
+// local IP addresses will be used.
+func (nc *nconnect) getVPNRoutes(d *config.TunDeviceConfig, remoteAdminAddr []string) ([]*net.IPNet, error) {
+	vpnRoutes := d.VPNRoute
+	if len(vpnRoutes) == 0 {
+		for _, addr := range remoteAdminAddr {
+			remoteInfo, err := nc.getRemoteInfo(addr)
+			if err != nil {
+				log.Printf("getRemoteInfo %v err: %v", addr, err)
+				continue
+			}
+			for _, ip := range remoteInfo.LocalIP.Ipv4 {
+				if ip == d.Addr || ip == d.Gateway {
+					log.Printf("Skipping server's local IP %s in routes", ip)
+					continue
+				}
+				vpnRoutes = append(vpnRoutes, fmt.Sprintf("%s/32", ip))
+			}
+		}
+	}
+
+	routes := make([]*net.IPNet, len(vpnRoutes))
+	for i, route := range vpnRoutes {
+		_, cidr, err := net.ParseCIDR(route)
+		if err != nil {
+			return nil, fmt.Errorf("parse CIDR %s error: %v", route, err)
+		}
+		routes[i] = cidr
+	}
+	return routes, nil
+}
+
+// getDNSSearch returns DNS search domains of a device. If not given, search
+// domains pushed by remote servers will be used.
+func (nc *nconnect) getDNSSearch(d *config.TunDeviceConfig, remoteAdminAddr []string) []string {
+	if len(d.DNSSearch) > 0 {
+		return d.DNSSearch
+	}
+	var domains []string
+	seen := make(map[string]bool)
+	for _, addr := range remoteAdminAddr {
+		remoteInfo, err := nc.getRemoteInfo(addr)
+		if err != nil {
+			log.Printf("getRemoteInfo %v err: %v", addr, err)
+			continue
+		}
+		for _, domain := range remoteInfo.DNSSearch {
+			if !seen[domain] {
+				seen[domain] = true
+				domains = append(domains, domain)
+			}
+		}
+	}
+	return domains
+}
+
+// coversDefaultRoute returns whether routes of an IP version take over its
+// default route, either by 0.0.0.0/0 (::/0) or by two /1 routes.
+func coversDefaultRoute(routes []*net.IPNet, ipv6 bool) bool {
+	low, high := net.IPv4zero, net.IPv4(128, 0, 0, 0)
+	if ipv6 {
+		low, high = net.IPv6zero, net.ParseIP("8000::")
+	}
+	var coversLow, coversHigh bool
+	for _, r := range routes {
+		if (r.IP.To4() == nil) != ipv6 {
+			continue
+		}
+		if ones, _ := r.Mask.Size(); ones > 1 {
+			continue
+		}
+		coversLow = coversLow || r.Contains(low)
+		coversHigh = coversHigh || r.Contains(high)
+	}
+	return coversLow && coversHigh
+}
+
+// applyIPv6Policy returns VPN routes of a device with IPv6 routes added by
+// IPv6 policy. When routes take over IPv4 default route but have no IPv6
+// route, IPv6 default route is taken over by two /1 routes, so it does not
+// go around VPN. With block policy, IPv6 packets of the device are dropped.
+func (nc *nconnect) applyIPv6Policy(d *tunDevice, routes []*net.IPNet) []*net.IPNet {
+	policy := d.VPNIPv6Policy
+	if len(policy) == 0 {
+		policy = nc.opts.VPNIPv6Policy
+	}
+
+	takeOver := policy != config.VPNIPv6Bypass && coversDefaultRoute(routes, false)
+	for _, r := range routes {
+		if r.IP.To4() == nil {
+			takeOver = false
+			break
+		}
+	}
+	d.dropIPv6.Store(takeOver && policy == config.VPNIPv6Block)
+	if !takeOver {
+		return routes
+	}
+
+	if policy == config.VPNIPv6Block {
+		log.Printf("VPN routes of %s take over IPv4 default route, blocking IPv6 traffic to avoid leaks", d.Name)
+	} else {
+		log.Printf("VPN routes of %s take over IPv4 default route, routing IPv6 traffic through VPN too", d.Name)
+	}
+	_, low, _ := net.ParseCIDR("::/1")
+	_, high, _ := net.ParseCIDR("8000::/1")
+	return append(routes, low, high)
+}
+
+// tunReader reads packets from a TUN device, skipping IPv6 packets when they
+// are dropped by IPv6 policy.
+type tunReader struct {
+	d *tunDevice
+}
+
+func (r tunReader) Read(b []byte) (int, error) {
+	for {
+		n, err := r.d.dev.Read(b)
+		if err == nil && n > 0 && b[0]>>4 == 6 && r.d.dropIPv6.Load() {
+			continue
+		}
+		return n, err
+	}
+}
+
+// packetDestination returns the destination address of an IP packet.
+func packetDestination(b []byte) net.IP {
+	if len(b) == 0 {
+		return nil
+	}
+	switch b[0] >> 4 {
+	case 4:
+		if len(b) >= 20 {
+			return net.IP(b[16:20])
+		}
+	case 6:
+		if len(b) >= 40 {
+			return net.IP(b[24:40])
+		}
+	}
+	return nil
+}
+
+// startTunDevices opens TUN devices and connects them to the socks proxy
+// through a shared network stack. Packets from the stack are written to the
+// device whose subnet contains the destination. Routes and DNS settings are
+// not set until setTunNetwork is called.
+func (nc *nconnect) startTunDevices(devices []*tunDevice, proxyHost string, proxyPort uint16) error {
+	for _, d := range devices {
+		var err error
+		d.subnet, err = config.TunSubnet(d.Addr, d.Mask)
+		if err != nil {
+			return err
+		}
+
+		d.dev, err = arch.OpenTunDevice(d.Name, d.Addr, d.Gateway, d.Mask, d.DNS, true)
+		if err != nil {
+			return fmt.Errorf("failed to open TUN device %s: %v", d.Name, err)
+		}
+	}
+
+	if len(devices) == 1 {
+		core.RegisterOutputFn(devices[0].dev.Write)
+	} else {
+		core.RegisterOutputFn(func(b []byte) (int, error) {
+			if ip := packetDestination(b); ip != nil {
+				for _, d := range devices {
+					if d.subnet.Contains(ip) {
+						return d.dev.Write(b)
+					}
+				}
+			}
+			return devices[0].dev.Write(b)
+		})
+	}
+
+	core.RegisterTCPConnHandler(socks.NewTCPHandler(proxyHost, proxyPort))
+	core.RegisterUDPConnHandler(socks.NewUDPHandler(proxyHost, proxyPort, 30*time.Second))
+
+	lwipWriter := core.NewLWIPStack()
+
+	for _, d := range devices {
+		go func(d *tunDevice) {
+			_, err := io.CopyBuffer(lwipWriter, tunReader{d}, make([]byte, mtu))
+			if err != nil {
+				log.Fatalf("Failed to write data to network stack: %v", err)
+			}
+		}(d)
+	}
+
+	log.Println("Started tun2socks")
+
+	nc.tunLock.Lock()
+	nc.tunDevices = devices
+	nc.tunLock.Unlock()
+
+	return nil
+}
+
+// setTunNetwork sets DNS of DNS domains and search domains, and adds routes of TUN devices. If a
+// route cannot be added, everything set is reverted. DNS errors are only
+// logged, as traffic to routes still works.
+func (nc *nconnect) setTunNetwork() error {
+	nc.tunLock.Lock()
+	defer nc.tunLock.Unlock()
+	if nc.tunNetworkSet {
+		return nil
+	}
+
+	for _, d := range nc.tunDevices {
+		if len(d.DNSDomains) == 0 && len(d.DNSSearch) == 0 {
+			continue
+		}
+		out, err := arch.SetDNSScopeCmd(d.Name, d.DNS, d.DNSDomains, d.DNSSearch)
+		if len(out) > 0 {
+			os.Stdout.Write(out)
+		}
+		if err != nil {
+			log.Printf("Set DNS of %s error: %v", d.Name, util.ParseExecError(err))
+			continue
+		}
+		d.dnsSet = true
+	}
+
+	for i, d := range nc.tunDevices {
+		for j, dest := range d.routes {
+			err := addRoute(d, dest)
+			if err == nil {
+				continue
+			}
+			for _, dest := range d.routes[:j] {
+				deleteRoute(d, dest)
+			}
+			for _, d := range nc.tunDevices[:i] {
+				for _, dest := range d.routes {
+					deleteRoute(d, dest)
+				}
+			}
+			nc.deleteTunDNS()
+			return err
+		}
+	}
+
+	nc.tunNetworkSet = true
+	return nil
+}
+
+// revertTunNetwork deletes routes and DNS settings of TUN devices set by
+// setTunNetwork.
+func (nc *nconnect) revertTunNetwork() {
+	nc.tunLock.Lock()
+	defer nc.tunLock.Unlock()
+	if !nc.tunNetworkSet {
+		return
+	}
+	nc.revertTunNetworkLocked()
+	nc.tunNetworkSet = false
+}
+
+func (nc *nconnect) revertTunNetworkLocked() {
+	for _, d := range nc.tunDevices {
+		for _, dest := range d.routes {
+			deleteRoute(d, dest)
+		}
+	}
+	nc.deleteTunDNS()
+}
+
+func (nc *nconnect) deleteTunDNS() {
+	for _, d := range nc.tunDevices {
+		if !d.dnsSet {
+			continue
+		}
+		out, err := arch.DeleteDNSScopeCmd(d.Name, d.DNSDomains, d.DNSSearch)
+		if len(out) > 0 {
+			os.Stdout.Write(out)
+		}
+		if err != nil {
+			log.Printf("Delete DNS of %s error: %v", d.Name, util.ParseExecError(err))
+		}
+		d.dnsSet = false
+	}
+}
+
+func addRoute(d *tunDevice, dest *net.IPNet) error {
+	log.Printf("Adding route %s via %s", dest, d.Name)
+	out, err := arch.AddRouteCmd(dest, d.Gateway, d.Name)
+	if len(out) > 0 {
+		os.Stdout.Write(out)
+	}
+	if err != nil {
+		return fmt.Errorf("add route %s error: %s", dest, util.ParseExecError(err))
+	}
+	return nil
+}
+
+func deleteRoute(d *tunDevice, dest *net.IPNet) {
+	log.Printf("Deleting route %s", dest)
+	out, err := arch.DeleteRouteCmd(dest, d.Gateway, d.Name)
+	if len(out) > 0 {
+		os.Stdout.Write(out)
+	}
+	if err != nil {
+		os.Stdout.Write([]byte(util.ParseExecError(err)))
+	}
+}
+
+// cidrRoutes returns ss routes that send traffic to each device's routes to
+// its first remote server.
+func (nc *nconnect) cidrRoutes() []ss.CIDRRoute {
+	var routes []ss.CIDRRoute
+	for _, d := range nc.tunDevices {
+		if len(d.remotes) == 0 {
+			continue
+		}
+		for _, dest := range d.routes {
+			routes = append(routes, ss.CIDRRoute{CIDR: dest, Client: nc.ssAddrByRemote[d.remotes[0]], Tag: d.Tag})
+		}
+	}
+	return routes
+}
+
+// SetVPNRoutes replaces VPN routes of a running TUN device. Existing TCP
+// connections that no longer match the routes to the same remote server are
+// closed after drain, so they reconnect through the new route.
+func (nc *nconnect) SetVPNRoutes(deviceName string, vpnRoutes []string, drain time.Duration) error {
+	routes := make([]*net.IPNet, len(vpnRoutes))
+	for i, route := range vpnRoutes {
+		_, cidr, err := net.ParseCIDR(route)
+		if err != nil {
+			return fmt.Errorf("parse CIDR %s error: %v", route, err)
+		}
+		routes[i] = cidr
+	}
+
+	nc.tunLock.Lock()
+	defer nc.tunLock.Unlock()
+
+	var device *tunDevice
+	for _, d := range nc.tunDevices {
+		if d.Name == deviceName {
+			device = d
+			break
+		}
+	}
+	if device == nil {
+		return fmt.Errorf("TUN device %s not found", deviceName)
+	}
+	routes = nc.applyIPv6Policy(device, routes)
+
+	if !nc.tunNetworkSet { // routes are added when tunnel is verified working
+		device.routes = routes
+		n := ss.SetRoutes(nc.ssConfig.TargetToClient, nc.cidrRoutes(), nc.ssConfig.DefaultClient, drain)
+		if n > 0 {
+			log.Printf("Closing %d connections not matching new routes of %s", n, deviceName)
+		}
+		return nil
+	}
+
+	contains := func(routes []*net.IPNet, dest *net.IPNet) bool {
+		for _, r := range routes {
+			if r.String() == dest.String() {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, dest := range device.routes {
+		if !contains(routes, dest) {
+			deleteRoute(device, dest)
+		}
+	}
+	added := make([]*net.IPNet, 0, len(routes))
+	var err error
+	for _, dest := range routes {
+		if contains(device.routes, dest) {
+			added = append(added, dest)
+			continue
+		}
+		err = addRoute(device, dest)
+		if err != nil {
+			break
+		}
+		added = append(added, dest)
+	}
+	device.routes = added
+
+	n := ss.SetRoutes(nc.ssConfig.TargetToClient, nc.cidrRoutes(), nc.ssConfig.DefaultClient, drain)
+	if n > 0 {
+		log.Printf("Closing %d connections not matching new routes of %s", n, deviceName)
+	}
+
+	return err
+}
@@ -0,0 +1,165 @@
+//go:build windows && routetest
+
+package nconnect
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/nknorg/nconnect/arch"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/ss"
+	"github.com/stretchr/testify/require"
+)
+
+// Route and DNS tests run against the mock adapter in arch package:
+// go test -v -tags routetest -run TestWindows .
+
+const testTunName = "nConnect-test"
+
+func newTestTunDevice(t *testing.T, routes ...string) *tunDevice {
+	d := &tunDevice{
+		TunDeviceConfig: config.TunDeviceConfig{
+			Name:       testTunName,
+			Addr:       "10.0.86.2",
+			Gateway:    "10.0.86.1",
+			Mask:       "255.255.255.0",
+			DNS:        []string{"10.0.86.1", "1.1.1.1"},
+			DNSDomains: []string{"corp.example", "lab.example"},
+			VPN:        true,
+		},
+	}
+	for _, r := range routes {
+		_, cidr, err := net.ParseCIDR(r)
+		require.NoError(t, err)
+		d.routes = append(d.routes, cidr)
+	}
+	return d
+}
+
+func startTestTunDevice(t *testing.T, d *tunDevice) (*nconnect, func(), error) {
+	nc := &nconnect{opts: &config.Opts{}, ssConfig: &ss.Config{}}
+	err := nc.startTunDevices([]*tunDevice{d}, "127.0.0.1", 1080)
+	t.Cleanup(func() {
+		if d.dev != nil {
+			d.dev.Close()
+		}
+	})
+	if err != nil {
+		return nc, nil, err
+	}
+	err = nc.setTunNetwork()
+	return nc, nc.revertTunNetwork, err
+}
+
+func commandCount(prefix string) int {
+	n := 0
+	for _, cmd := range arch.Mock.Commands() {
+		if strings.HasPrefix(strings.Join(cmd, " "), prefix) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestWindowsRouteDNS(t *testing.T) {
+	arch.Mock.Reset()
+	// left over from a run that did not clean up, should be replaced by set
+	arch.Mock.AddRoute(testTunName, "10.2.0.0/16", "10.0.85.1")
+
+	d := newTestTunDevice(t, "10.1.0.0/16", "10.2.0.0/16")
+	nc, cleanup, err := startTestTunDevice(t, d)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]string{
+		"10.1.0.0/16": "10.0.86.1",
+		"10.2.0.0/16": "10.0.86.1",
+	}, arch.Mock.Routes(testTunName))
+	require.Equal(t, 1, commandCount("netsh interface ipv4 set route 10.2.0.0/16"))
+	require.Equal(t, map[string][]string{
+		".corp.example": {"10.0.86.1", "1.1.1.1"},
+		".lab.example":  {"10.0.86.1", "1.1.1.1"},
+	}, arch.Mock.NRPTRules())
+
+	err = nc.SetVPNRoutes(testTunName, []string{"10.1.0.0/16", "10.3.0.0/16"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"10.1.0.0/16": "10.0.86.1",
+		"10.3.0.0/16": "10.0.86.1",
+	}, arch.Mock.Routes(testTunName))
+	require.Equal(t, 1, commandCount("netsh interface ipv4 add route 10.1.0.0/16"))
+
+	err = nc.SetVPNRoutes("nConnect-missing", []string{"10.4.0.0/16"}, 0)
+	require.Error(t, err)
+
+	cleanup()
+	require.Empty(t, arch.Mock.Routes(testTunName))
+	require.Empty(t, arch.Mock.NRPTRules())
+}
+
+func TestWindowsRouteFailure(t *testing.T) {
+	arch.Mock.Reset()
+	routeErr := errors.New("The requested operation requires elevation.")
+	arch.Mock.FailNext("netsh interface ipv4 add route 10.2.0.0/16", routeErr)
+	arch.Mock.FailNext("netsh interface ipv4 set route 10.2.0.0/16", routeErr)
+
+	d := newTestTunDevice(t, "10.1.0.0/16", "10.2.0.0/16", "10.3.0.0/16")
+	_, _, err := startTestTunDevice(t, d)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "10.2.0.0/16")
+
+	// routes and DNS rules added before failure are removed
+	require.Empty(t, arch.Mock.Routes(testTunName))
+	require.Empty(t, arch.Mock.NRPTRules())
+	require.Equal(t, 0, commandCount("netsh interface ipv4 add route 10.3.0.0/16"))
+}
+
+func TestWindowsDNSFailure(t *testing.T) {
+	arch.Mock.Reset()
+	arch.Mock.FailNext("powershell -Command Add-DnsClientNrptRule", errors.New("Access is denied."))
+
+	d := newTestTunDevice(t, "10.1.0.0/16")
+	_, cleanup, err := startTestTunDevice(t, d)
+	require.NoError(t, err, "DNS error should not stop TUN device")
+	require.Len(t, arch.Mock.Routes(testTunName), 1)
+
+	cleanup()
+	require.Empty(t, arch.Mock.Routes(testTunName))
+	require.Equal(t, 0, commandCount("powershell -Command Get-DnsClientNrptRule"))
+}
+
+func TestWindowsIPv6Policy(t *testing.T) {
+	arch.Mock.Reset()
+
+	d := newTestTunDevice(t, "0.0.0.0/1", "128.0.0.0/1")
+	d.VPNIPv6Policy = config.VPNIPv6Bypass
+	require.Len(t, (&nconnect{}).applyIPv6Policy(d, d.routes), 2)
+	require.False(t, d.dropIPv6.Load())
+
+	d.VPNIPv6Policy = config.VPNIPv6Block
+	d.routes = (&nconnect{}).applyIPv6Policy(d, d.routes)
+	require.True(t, d.dropIPv6.Load())
+	nc, cleanup, err := startTestTunDevice(t, d)
+	require.NoError(t, err)
+
+	require.Equal(t, map[string]string{
+		"0.0.0.0/1":   "10.0.86.1",
+		"128.0.0.0/1": "10.0.86.1",
+		"::/1":        "",
+		"8000::/1":    "",
+	}, arch.Mock.Routes(testTunName))
+	require.Equal(t, 1, commandCount("netsh interface ipv6 add route ::/1"))
+
+	// IPv6 default route is released with IPv4 default route
+	err = nc.SetVPNRoutes(testTunName, []string{"10.1.0.0/16"}, 0)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{
+		"10.1.0.0/16": "10.0.86.1",
+	}, arch.Mock.Routes(testTunName))
+	require.False(t, d.dropIPv6.Load())
+
+	cleanup()
+	require.Empty(t, arch.Mock.Routes(testTunName))
+}
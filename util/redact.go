@@ -0,0 +1,93 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"regexp"
+	"sync"
+)
+
+const (
+	redactedText = "[REDACTED]"
+)
+
+var (
+	// DefaultRedactor masks secrets in output of the standard log package,
+	// including logs of dependencies that use it. Output printed directly to
+	// stdout or stderr, e.g. by fmt in dependencies, is not redacted.
+	DefaultRedactor = NewRedactor()
+
+	secretPatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)("?(?:seed|password|passwd|token|secret|auth)"?\s*[:=]\s*"?)([^"\s,}&]+)`),
+	}
+)
+
+// Redactor replaces known secret values and key/value pairs that look like
+// secrets with a placeholder.
+type Redactor struct {
+	lock    sync.RWMutex
+	secrets [][]byte
+}
+
+func NewRedactor() *Redactor {
+	return &Redactor{}
+}
+
+// AddSecrets registers literal secret values to be masked, also as they are
+// escaped in JSON strings. Empty strings are ignored.
+func (r *Redactor) AddSecrets(secrets ...string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, s := range secrets {
+		if len(s) == 0 {
+			continue
+		}
+		r.secrets = append(r.secrets, []byte(s))
+		if b, err := json.Marshal(s); err == nil && string(b[1:len(b)-1]) != s {
+			r.secrets = append(r.secrets, b[1:len(b)-1])
+		}
+	}
+}
+
+// RedactSecrets masks registered secret values only, without guessing secrets
+// by key names, e.g. for JSON whose keys name secrets but are not followed by
+// their values.
+func (r *Redactor) RedactSecrets(b []byte) []byte {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	for _, s := range r.secrets {
+		b = bytes.ReplaceAll(b, s, []byte(redactedText))
+	}
+	return b
+}
+
+func (r *Redactor) Redact(b []byte) []byte {
+	b = r.RedactSecrets(b)
+	for _, re := range secretPatterns {
+		b = re.ReplaceAll(b, []byte("${1}"+redactedText))
+	}
+	return b
+}
+
+func (r *Redactor) RedactString(s string) string {
+	return string(r.Redact([]byte(s)))
+}
+
+type redactWriter struct {
+	w io.Writer
+	r *Redactor
+}
+
+// Writer returns a writer that redacts each write before passing it to w.
+func (r *Redactor) Writer(w io.Writer) io.Writer {
+	return &redactWriter{w: w, r: r}
+}
+
+func (rw *redactWriter) Write(p []byte) (int, error) {
+	_, err := rw.w.Write(rw.r.Redact(p))
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,94 @@
+package util
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedact(t *testing.T) {
+	r := NewRedactor()
+	r.AddSecrets("0123456789abcdef", `pa"ss\word`, "")
+
+	tests := []struct {
+		name string
+		in   string
+		want string
+	}{
+		{
+			name: "registered seed",
+			in:   "Load account with 0123456789abcdef done",
+			want: "Load account with [REDACTED] done",
+		},
+		{
+			name: "registered password escaped in JSON",
+			in:   `{"params":{"value":"pa\"ss\\word"}}`,
+			want: `{"params":{"value":"[REDACTED]"}}`,
+		},
+		{
+			name: "seed key",
+			in:   "seed=fedcba9876543210 identifier=abc",
+			want: "seed=[REDACTED] identifier=abc",
+		},
+		{
+			name: "password key in JSON",
+			in:   `{"password": "hunter22", "port": 1080}`,
+			want: `{"password": "[REDACTED]", "port": 1080}`,
+		},
+		{
+			name: "token key case insensitive",
+			in:   `Call getInfo with Token: abcdef012345`,
+			want: `Call getInfo with Token: [REDACTED]`,
+		},
+		{
+			name: "token in query",
+			in:   "GET /rpc/admin?token=abcdef&x=1",
+			want: "GET /rpc/admin?token=[REDACTED]&x=1",
+		},
+		{
+			name: "secret and auth keys",
+			in:   "secret=s1, auth=user:pass",
+			want: "secret=[REDACTED], auth=[REDACTED]",
+		},
+		{
+			name: "normal line",
+			in:   "Client nConnect.abcdef connected, 3 sessions",
+			want: "Client nConnect.abcdef connected, 3 sessions",
+		},
+		{
+			name: "key name without value",
+			in:   "Change web password of user alice",
+			want: "Change web password of user alice",
+		},
+		{
+			name: "empty secret ignored",
+			in:   "Started",
+			want: "Started",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, r.RedactString(tt.in))
+		})
+	}
+}
+
+func TestRedactSecrets(t *testing.T) {
+	r := NewRedactor()
+	r.AddSecrets("0123456789abcdef")
+	require.Equal(t, `{"seed":"[REDACTED]","password":""}`, string(r.RedactSecrets([]byte(`{"seed":"0123456789abcdef","password":""}`))))
+}
+
+func TestRedactWriter(t *testing.T) {
+	r := NewRedactor()
+	r.AddSecrets("0123456789abcdef")
+	var buf bytes.Buffer
+	logger := log.New(r.Writer(&buf), "", 0)
+	logger.Printf("Set seed to %s", "0123456789abcdef")
+	logger.Printf("password=%s", "hunter22")
+	logger.Printf("Listen on %s", "127.0.0.1:1080")
+	require.Equal(t, "Set seed to [REDACTED]\npassword=[REDACTED]\nListen on 127.0.0.1:1080\n", buf.String())
+}
@@ -0,0 +1,168 @@
+package nconnect
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nconnect/monitor"
+	"golang.org/x/net/proxy"
+)
+
+const (
+	vpnProbeInterval = 5 * time.Second
+	vpnProbeTimeout  = 10 * time.Second
+
+	// Routes and DNS are reverted after this many consecutive failed probes
+	// within grace period.
+	vpnProbeMaxFailures = 3
+
+	// A failed probe is logged this often while waiting for the tunnel.
+	vpnProbeWarnInterval = time.Minute
+)
+
+// startTunNetwork sets routes and DNS of TUN devices, gated by the tunnel
+// working unless VPN probe is disabled or there is nothing to set. They are
+// reverted when subsystems are stopped.
+func (nc *nconnect) startTunNetwork(subs *subsystems, socksAddr string) error {
+	gate := false
+	for _, d := range nc.tunDevices {
+		if len(d.routes) > 0 || len(d.DNSDomains) > 0 || len(d.DNSSearch) > 0 {
+			gate = true
+			break
+		}
+	}
+
+	probeAddr := nc.opts.VPNProbeAddr
+	if len(probeAddr) == 0 {
+		probeAddr = config.DefaultVPNProbeAddr
+	}
+	if !gate || probeAddr == config.VPNProbeNone {
+		err := nc.setTunNetwork()
+		if err != nil {
+			return err
+		}
+		subs.run(func() error {
+			<-subs.ctx.Done()
+			nc.revertTunNetwork()
+			return nil
+		}, nil)
+		return nil
+	}
+
+	grace := time.Duration(nc.opts.VPNGracePeriod) * time.Second
+	if grace <= 0 {
+		grace = config.DefaultVPNGracePeriod
+	}
+	log.Printf("Setting VPN routes and DNS after tunnel is verified working by probing %s", probeAddr)
+	subs.run(func() error {
+		return nc.gateTunNetwork(subs.ctx, socksAddr, probeAddr, grace)
+	}, nil)
+	return nil
+}
+
+// gateTunNetwork sets routes and DNS of TUN devices once the tunnel is
+// verified working by a DNS query to probeAddr through socks proxy socksAddr.
+// If the tunnel stops working within grace period, they are reverted until
+// the tunnel works again, so a box that can only be reached through its
+// original network is not locked out by a tunnel that never comes up. Routes
+// and DNS are reverted when ctx is done. It only returns early if routes
+// cannot be added.
+func (nc *nconnect) gateTunNetwork(ctx context.Context, socksAddr, probeAddr string, grace time.Duration) error {
+	defer nc.revertTunNetwork()
+
+	for {
+		var lastWarn time.Time
+		for {
+			err := probeTunnel(ctx, socksAddr, probeAddr)
+			if err == nil {
+				break
+			}
+			if time.Since(lastWarn) >= vpnProbeWarnInterval {
+				log.Printf("WARNING: Tunnel is not working yet, VPN routes and DNS are not set: %v", err)
+				lastWarn = time.Now()
+			}
+			if !monitor.Sleep(ctx, vpnProbeInterval) {
+				return nil
+			}
+		}
+
+		err := nc.setTunNetwork()
+		if err != nil {
+			return err
+		}
+		log.Println("Tunnel is working, VPN routes and DNS are set")
+
+		failures := 0
+		deadline := time.Now().Add(grace)
+		for failures < vpnProbeMaxFailures && time.Now().Before(deadline) {
+			if !monitor.Sleep(ctx, vpnProbeInterval) {
+				return nil
+			}
+			err = probeTunnel(ctx, socksAddr, probeAddr)
+			if err != nil {
+				failures++
+			} else {
+				failures = 0
+			}
+		}
+		if failures < vpnProbeMaxFailures {
+			<-ctx.Done()
+			return nil
+		}
+
+		log.Printf("WARNING: Tunnel stopped working within %v, reverting VPN routes and DNS: %v", grace, err)
+		nc.revertTunNetwork()
+	}
+}
+
+// probeTunnel sends a DNS query to probeAddr over TCP through socks proxy
+// socksAddr and waits for the reply, which only arrives if the tunnel behind
+// the proxy works.
+func probeTunnel(ctx context.Context, socksAddr, probeAddr string) error {
+	ctx, cancel := context.WithTimeout(ctx, vpnProbeTimeout)
+	defer cancel()
+
+	dialer, err := proxy.SOCKS5("tcp", socksAddr, nil, &net.Dialer{})
+	if err != nil {
+		return err
+	}
+	conn, err := dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", probeAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	c := &dns.Conn{Conn: conn}
+	req := new(dns.Msg)
+	req.SetQuestion(".", dns.TypeNS)
+	err = c.WriteMsg(req)
+	if err != nil {
+		return err
+	}
+	resp, err := c.ReadMsg()
+	if err != nil {
+		return err
+	}
+	if resp.Id != req.Id {
+		return errors.New("DNS reply id mismatch")
+	}
+	return nil
+}
+
+// localSocksAddr returns the address to reach socks proxy listening on
+// proxyAddr from this host.
+func localSocksAddr(proxyAddr *net.TCPAddr) string {
+	ip := proxyAddr.IP
+	if ip == nil || ip.IsUnspecified() {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	return (&net.TCPAddr{IP: ip, Port: proxyAddr.Port}).String()
+}
@@ -3,9 +3,11 @@ import axios from 'axios';
 import * as util from './util';
 
 const rpcAddr = '/rpc/admin';
+const rpcVersion = 2; // admin rpc protocol version, should match admin.RPCVersion
 
 const methods = {
   getAdminToken: { method: 'getAdminToken' },
+  getNKNToken: { method: 'getNKNToken' },
   getAddrs: { method: 'getAddrs' },
   setAddrs: { method: 'setAddrs' },
   addAddrs: { method: 'addAddrs' },
@@ -13,23 +15,65 @@ const methods = {
   getLocalIP: { method: 'getLocalIP' },
   getInfo: { method: 'getInfo' },
   getBalance: { method: 'getBalance' },
+  getTransactions: { method: 'getTransactions' },
   getSeed: { method: 'getSeed' },
   setSeed: { method: 'setSeed' },
   setTunaConfig: { method: 'setTunaConfig' },
-  getLog: { method: 'getLog' }
+  applyConfig: { method: 'applyConfig' },
+  reloadConfig: { method: 'reloadConfig' },
+  restart: { method: 'restart' },
+  getSubsystems: { method: 'getSubsystems' },
+  setSubsystem: { method: 'setSubsystem' },
+  setRollout: { method: 'setRollout' },
+  pauseRollout: { method: 'pauseRollout' },
+  getRollout: { method: 'getRollout' },
+  setClientSettings: { method: 'setClientSettings' },
+  getClientSettings: { method: 'getClientSettings' },
+  runPathProbe: { method: 'runPathProbe' },
+  getSeenClients: { method: 'getSeenClients' },
+  forgetSeenClient: { method: 'forgetSeenClient' },
+  getLog: { method: 'getLog' },
+  webLogin: { method: 'webLogin' },
+  webLogout: { method: 'webLogout' },
+  changeWebPassword: { method: 'changeWebPassword' },
+  getWebUsers: { method: 'getWebUsers' },
+  setWebUser: { method: 'setWebUser' },
+  removeWebUser: { method: 'removeWebUser' },
+  enrollWebTOTP: { method: 'enrollWebTOTP' },
+  confirmWebTOTP: { method: 'confirmWebTOTP' },
+  disableWebTOTP: { method: 'disableWebTOTP' },
+  resetWebTOTP: { method: 'resetWebTOTP' },
+  getRevision: { method: 'getRevision' },
+  grantAssist: { method: 'grantAssist' },
+  revokeAssist: { method: 'revokeAssist' },
+  getAssistAudit: { method: 'getAssistAudit' },
+  getAuditLog: { method: 'getAuditLog' },
+  issueToken: { method: 'issueToken' },
+  listTokens: { method: 'listTokens' },
+  revokeToken: { method: 'revokeToken' }
 }
 
+const sessionTokenKey = 'nConnect-web-token';
+
+// Errors returned by server that the GUI handles, should match admin and
+// config packages.
+export const errPermissionDenied = 'permission denied';
+export const errTOTPRequired = 'two-factor code required';
+
 var rpc = {};
 for (let method in methods) {
   if (methods.hasOwnProperty(method)) {
-    rpc[method] = (addr, params) => {
+    rpc[method] = (addr, params, ifRevision) => {
       params = util.assignDefined({}, methods[method].defaultParams, params)
-      return rpcCall(addr, methods[method].method, params);
+      return rpcCall(addr, methods[method].method, params, ifRevision);
     }
   }
 }
 
-async function rpcCall(addr, method, params = {}) {
+// rpcCall calls method at addr. If ifRevision is given, a mutating method
+// fails with a revision conflict error instead of overwriting changes made
+// since that revision, and the error has the current state as result.
+async function rpcCall(addr, method, params = {}, ifRevision = undefined) {
   let headers;
   try {
     headers = await window.rpcHeaders;
@@ -46,14 +90,26 @@ async function rpcCall(addr, method, params = {}) {
     data: {
       id: 'nConnect-web',
       jsonrpc: '2.0',
+      version: rpcVersion,
       method: method,
       params: params,
+      token: window.sessionStorage.getItem(sessionTokenKey) || undefined,
+      ifRevision,
     },
   });
 
   let data = response.data;
 
+  if (data.deprecated) {
+    console.warn(data.deprecated);
+  }
+
   if (data.error) {
+    if (data.result !== undefined) {
+      let err = new Error(data.error);
+      err.result = data.result;
+      throw err;
+    }
     throw data.error;
   }
 
@@ -68,11 +124,20 @@ export async function getAdminToken() {
   return rpc.getAdminToken(rpcAddr);
 }
 
+// getNKNToken returns the admin NKN address and a token for NKN admin clients
+// with the permission of current web login.
+export async function getNKNToken() {
+  return rpc.getNKNToken(rpcAddr);
+}
+
 export async function getAddrs() {
   return rpc.getAddrs(rpcAddr);
 }
 
-export async function setAddrs(acceptAddrs, adminAddrs) {
+// setAddrs replaces accept and admin addresses. If revision of the addresses
+// they are based on is given, it fails when another admin has changed them
+// since, and the error has the current addresses as result.
+export async function setAddrs(acceptAddrs, adminAddrs, revision) {
   let params = {};
   if (acceptAddrs) {
     params.acceptAddrs = acceptAddrs;
@@ -80,10 +145,10 @@ export async function setAddrs(acceptAddrs, adminAddrs) {
   if (adminAddrs) {
     params.adminAddrs = adminAddrs;
   }
-  return rpc.setAddrs(rpcAddr, params);
+  return rpc.setAddrs(rpcAddr, params, revision);
 }
 
-export async function addAddrs(acceptAddrs, adminAddrs) {
+export async function addAddrs(acceptAddrs, adminAddrs, revision) {
   let params = {};
   if (acceptAddrs) {
     params.acceptAddrs = acceptAddrs;
@@ -91,10 +156,10 @@ export async function addAddrs(acceptAddrs, adminAddrs) {
   if (adminAddrs) {
     params.adminAddrs = adminAddrs;
   }
-  return rpc.addAddrs(rpcAddr, params);
+  return rpc.addAddrs(rpcAddr, params, revision);
 }
 
-export async function removeAddrs(acceptAddrs, adminAddrs) {
+export async function removeAddrs(acceptAddrs, adminAddrs, revision) {
   let params = {};
   if (acceptAddrs) {
     params.acceptAddrs = acceptAddrs;
@@ -102,7 +167,7 @@ export async function removeAddrs(acceptAddrs, adminAddrs) {
   if (adminAddrs) {
     params.adminAddrs = adminAddrs;
   }
-  return rpc.removeAddrs(rpcAddr, params);
+  return rpc.removeAddrs(rpcAddr, params, revision);
 }
 
 export async function getLocalIP() {
@@ -117,6 +182,10 @@ export async function getBalance() {
   return rpc.getBalance(rpcAddr);
 }
 
+export async function getTransactions(limit) {
+  return rpc.getTransactions(rpcAddr, { limit });
+}
+
 export async function getSeed() {
   return rpc.getSeed(rpcAddr);
 }
@@ -129,6 +198,220 @@ export async function setTunaConfig(tunaConfig) {
   return rpc.setTunaConfig(rpcAddr, tunaConfig);
 }
 
-export async function getLog() {
-  return rpc.getLog(rpcAddr);
+export async function applyConfig(partialConfig) {
+  return rpc.applyConfig(rpcAddr, partialConfig);
+}
+
+export async function reloadConfig() {
+  return rpc.reloadConfig(rpcAddr);
+}
+
+export async function restart(token) {
+  return rpc.restart(rpcAddr, { token });
+}
+
+export async function getSubsystems() {
+  return rpc.getSubsystems(rpcAddr);
+}
+
+export async function setSubsystem(name, enabled) {
+  return rpc.setSubsystem(rpcAddr, { name, enabled });
+}
+
+export async function getSeenClients() {
+  return rpc.getSeenClients(rpcAddr);
+}
+
+export async function forgetSeenClient(pubKey) {
+  return rpc.forgetSeenClient(rpcAddr, { pubKey });
+}
+
+// since and until are optional Date to get log lines logged in between,
+// including rotated log files.
+export async function getLog(since, until) {
+  return rpc.getLog(rpcAddr, { since, until });
+}
+
+// followLog calls onLines with new log lines of at least level ('info',
+// 'warning' or 'error') containing contains as they are written, and returns
+// a function to stop following.
+export function followLog(level, contains, onLines) {
+  let params = new URLSearchParams();
+  if (level) {
+    params.set('level', level);
+  }
+  if (contains) {
+    params.set('contains', contains);
+  }
+  let token = window.sessionStorage.getItem(sessionTokenKey);
+  if (token) {
+    params.set('token', token);
+  }
+  let controller = new AbortController();
+  fetch('/log/follow?' + params.toString(), { signal: controller.signal })
+    .then(async (response) => {
+      if (!response.ok) {
+        throw new Error((await response.json()).error);
+      }
+      let reader = response.body.getReader();
+      let decoder = new TextDecoder();
+      for (;;) {
+        let { done, value } = await reader.read();
+        if (done) {
+          return;
+        }
+        onLines(decoder.decode(value, { stream: true }));
+      }
+    })
+    .catch((e) => {
+      if (e.name !== 'AbortError') {
+        console.error('Follow log error:', e);
+      }
+    });
+  return () => controller.abort();
+}
+
+// webLogin logs in a web user. If two-factor authentication is enabled, it
+// fails with "two-factor code required" until code is given.
+export async function webLogin(name, password, code) {
+  let res = await rpc.webLogin(rpcAddr, { name, password, code });
+  window.sessionStorage.setItem(sessionTokenKey, res.token.token);
+  return res;
+}
+
+// hasWebSession returns whether a web user is logged in in this tab.
+export function hasWebSession() {
+  return !!window.sessionStorage.getItem(sessionTokenKey);
+}
+
+export async function webLogout() {
+  try {
+    return await rpc.webLogout(rpcAddr);
+  } finally {
+    window.sessionStorage.removeItem(sessionTokenKey);
+  }
+}
+
+export async function changeWebPassword(name, oldPassword, newPassword) {
+  let res = await rpc.changeWebPassword(rpcAddr, { name, oldPassword, newPassword });
+  window.sessionStorage.removeItem(sessionTokenKey);
+  return res;
+}
+
+export async function getWebUsers() {
+  return rpc.getWebUsers(rpcAddr);
+}
+
+export async function setWebUser(name, role, password) {
+  return rpc.setWebUser(rpcAddr, { name, role, password });
+}
+
+export async function removeWebUser(name) {
+  return rpc.removeWebUser(rpcAddr, { name });
+}
+
+// enrollWebTOTP returns a new TOTP secret and its otpauth URI for the logged
+// in user, which takes effect after confirmWebTOTP with a code from it.
+export async function enrollWebTOTP() {
+  return rpc.enrollWebTOTP(rpcAddr);
+}
+
+// confirmWebTOTP enables two-factor authentication and returns backup codes.
+export async function confirmWebTOTP(code) {
+  return rpc.confirmWebTOTP(rpcAddr, { code });
+}
+
+export async function disableWebTOTP(code) {
+  return rpc.disableWebTOTP(rpcAddr, { code });
+}
+
+export async function resetWebTOTP(name) {
+  return rpc.resetWebTOTP(rpcAddr, { name });
+}
+
+export async function setRollout(rollout) {
+  return rpc.setRollout(rpcAddr, rollout);
+}
+
+export async function pauseRollout(paused) {
+  return rpc.pauseRollout(rpcAddr, { paused });
+}
+
+export async function getRollout() {
+  return rpc.getRollout(rpcAddr);
+}
+
+export async function setClientSettings(settings) {
+  return rpc.setClientSettings(rpcAddr, { settings });
+}
+
+export async function getClientSettings() {
+  return rpc.getClientSettings(rpcAddr);
+}
+
+export async function runPathProbe(target, count, maxHops) {
+  return rpc.runPathProbe(rpcAddr, { target, count, maxHops });
+}
+
+// getRevision returns config and state revision, which changes whenever
+// config or state changes, so callers can poll it before fetching more.
+export async function getRevision() {
+  return rpc.getRevision(rpcAddr);
+}
+
+// grantAssist gives NKN address addr admin rights with role (operator if
+// empty) for duration seconds.
+export async function grantAssist(addr, duration, role) {
+  return rpc.grantAssist(rpcAddr, { addr, duration, role });
+}
+
+export async function revokeAssist(addr) {
+  return rpc.revokeAssist(rpcAddr, { addr });
+}
+
+export async function getAssistAudit(limit) {
+  return rpc.getAssistAudit(rpcAddr, { limit });
+}
+
+// getAuditLog returns a page of admin audit log entries, newest first, and
+// the total number of entries matching method and source if given.
+export async function getAuditLog(offset, limit, method, source) {
+  return rpc.getAuditLog(rpcAddr, { offset, limit, method, source });
+}
+
+// issueToken issues a token with scopes (read, write and acl) that expires
+// after ttl seconds. The token is only returned here.
+export async function issueToken(scopes, ttl, label) {
+  return rpc.issueToken(rpcAddr, { scopes, ttl, label });
+}
+
+export async function listTokens() {
+  return rpc.listTokens(rpcAddr);
+}
+
+export async function revokeToken(id) {
+  return rpc.revokeToken(rpcAddr, { id });
+}
+
+// subscribeEvents calls onEvent(type, event) for each event pushed by the
+// server over websocket, and returns a function to unsubscribe.
+export function subscribeEvents(onEvent) {
+  let protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+  let url = protocol + '//' + window.location.host + '/ws';
+  let token = window.sessionStorage.getItem(sessionTokenKey);
+  if (token) {
+    url += '?token=' + encodeURIComponent(token);
+  }
+  let ws = new WebSocket(url);
+  ws.onmessage = (msg) => {
+    let data;
+    try {
+      data = JSON.parse(msg.data);
+    } catch (e) {
+      console.error('Parse event error:', e);
+      return;
+    }
+    onEvent(data.type, data.event);
+  };
+  return () => ws.close();
 }
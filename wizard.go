@@ -0,0 +1,293 @@
+package nconnect
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/nknorg/nconnect/config"
+	"github.com/nknorg/nkn-sdk-go"
+	"github.com/nknorg/nkn/v2/util/address"
+)
+
+const (
+	wizardModeSocks = "socks"
+	wizardModeTun   = "tun"
+	wizardModeVPN   = "vpn"
+
+	wizardDisable = "none"
+)
+
+var (
+	errWizardCanceled = errors.New("setup wizard canceled")
+
+	pubKeyRegex = regexp.MustCompile("^[0-9a-f]{64}$")
+)
+
+// wizard asks questions on w and reads answers from r line by line.
+type wizard struct {
+	scanner *bufio.Scanner
+	w       io.Writer
+}
+
+// ask returns the answer to question, or def if the answer is empty.
+func (wz *wizard) ask(question, def string) (string, error) {
+	if len(def) > 0 {
+		fmt.Fprintf(wz.w, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(wz.w, "%s: ", question)
+	}
+	if !wz.scanner.Scan() {
+		if err := wz.scanner.Err(); err != nil {
+			return "", err
+		}
+		return "", errWizardCanceled
+	}
+	answer := strings.TrimSpace(wz.scanner.Text())
+	if len(answer) == 0 {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// askChoice asks until the answer is one of choices.
+func (wz *wizard) askChoice(question string, choices []string, def string) (string, error) {
+	for {
+		answer, err := wz.ask(fmt.Sprintf("%s (%s)", question, strings.Join(choices, "/")), def)
+		if err != nil {
+			return "", err
+		}
+		for _, c := range choices {
+			if strings.EqualFold(answer, c) {
+				return c, nil
+			}
+		}
+		fmt.Fprintf(wz.w, "Please answer one of %s\n", strings.Join(choices, ", "))
+	}
+}
+
+// askBool asks a yes or no question.
+func (wz *wizard) askBool(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := wz.ask(fmt.Sprintf("%s [%s]", question, hint), "")
+		if err != nil {
+			return false, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Fprintln(wz.w, "Please answer y or n")
+	}
+}
+
+// askList asks for a comma separated list until each item is accepted by
+// parse, which returns the item to keep.
+func (wz *wizard) askList(question string, required bool, parse func(string) (string, error)) ([]string, error) {
+	for {
+		answer, err := wz.ask(question, "")
+		if err != nil {
+			return nil, err
+		}
+		items := make([]string, 0)
+		valid := true
+		for _, s := range strings.Split(answer, ",") {
+			s = strings.TrimSpace(s)
+			if len(s) == 0 {
+				continue
+			}
+			item, err := parse(s)
+			if err != nil {
+				fmt.Fprintf(wz.w, "Invalid %s: %v\n", s, err)
+				valid = false
+				break
+			}
+			items = append(items, item)
+		}
+		if !valid {
+			continue
+		}
+		if required && len(items) == 0 {
+			fmt.Fprintln(wz.w, "At least one is required")
+			continue
+		}
+		return items, nil
+	}
+}
+
+// parseRemoteAdminAddr accepts a server admin address, which ends with the
+// server public key.
+func parseRemoteAdminAddr(s string) (string, error) {
+	pubKey := s[strings.LastIndex(s, ".")+1:]
+	if !pubKeyRegex.MatchString(strings.ToLower(pubKey)) {
+		return "", errors.New("should end with the 64 hex characters public key of server")
+	}
+	return s, nil
+}
+
+// parseAllowedAddr accepts a public key or regular expression of allowed
+// client addresses. A public key matches any address using it.
+func parseAllowedAddr(s string) (string, error) {
+	if pubKeyRegex.MatchString(strings.ToLower(s)) {
+		return strings.ToLower(s) + "$", nil
+	}
+	_, err := regexp.Compile(s)
+	if err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+// InitWizard asks the user for basic options on w, reading answers from r,
+// and writes a config with a newly generated identity to the config file.
+// Existing config file is never overwritten.
+func InitWizard(opts *config.Opts, r io.Reader, w io.Writer) error {
+	_, err := os.Stat(opts.ConfigFile)
+	if err == nil {
+		return errConfigFileExists(opts.ConfigFile)
+	}
+
+	wz := &wizard{scanner: bufio.NewScanner(r), w: w}
+	fmt.Fprintln(w, "nConnect setup wizard, press Enter to use the value in brackets.")
+
+	def := "client"
+	if opts.Server {
+		def = "server"
+	}
+	mode, err := wz.askChoice("Run as client or server", []string{"client", "server"}, def)
+	if err != nil {
+		return err
+	}
+	server := mode == "server"
+
+	account, err := nkn.NewAccount(nil)
+	if err != nil {
+		return err
+	}
+	t := config.NewTemplate("wizard", mode+" created by setup wizard", server)
+	t.Identifier = config.RandomIdentifier()
+	t.Seed = hex.EncodeToString(account.Seed())
+	addr := address.MakeAddressString(account.PubKey(), t.Identifier)
+
+	var needWebUser bool
+	tunaQuestion := "Relay through tuna for higher throughput (server and client should both enable it)"
+	if server {
+		acceptAddrs, err := wz.askList("Client addresses or public keys allowed to connect, comma separated, empty to add them later", false, parseAllowedAddr)
+		if err != nil {
+			return err
+		}
+		adminAddrs, err := wz.askList("Client addresses or public keys allowed to manage this server, comma separated", false, parseAllowedAddr)
+		if err != nil {
+			return err
+		}
+		tuna, err := wz.askBool(tunaQuestion+", needs NKN token to pay for relay", false)
+		if err != nil {
+			return err
+		}
+		var adminHTTPAddr string
+		for {
+			adminHTTPAddr, err = wz.ask(fmt.Sprintf("Admin web GUI listen address, %s to disable", wizardDisable), "127.0.0.1:8000")
+			if err != nil {
+				return err
+			}
+			if adminHTTPAddr == wizardDisable {
+				break
+			}
+			_, _, err = net.SplitHostPort(adminHTTPAddr)
+			if err == nil {
+				break
+			}
+			fmt.Fprintf(w, "Invalid %s: %v\n", adminHTTPAddr, err)
+		}
+
+		t.Set("acceptAddrs", acceptAddrs, "Client addresses allowed to connect, add more with the web GUI or admin RPC")
+		t.Set("adminAddrs", adminAddrs, "Client addresses allowed to manage this server")
+		if tuna {
+			t.Set("tuna", true, "Relay through tuna service nodes for higher throughput")
+		}
+		if adminHTTPAddr != wizardDisable {
+			t.Set("adminHttpAddr", adminHTTPAddr, "Admin web GUI")
+			if !config.IsLoopbackAddr(adminHTTPAddr) {
+				t.Set("adminHttpTLS", true, "Serve web GUI over HTTPS since it's reachable from other machines")
+				needWebUser = len(adminAddrs) == 0
+			}
+		}
+	} else {
+		remoteAdminAddrs, err := wz.askList("Admin address of your server(s), comma separated, printed by nConnect -s --address", true, parseRemoteAdminAddr)
+		if err != nil {
+			return err
+		}
+		tuna, err := wz.askBool(tunaQuestion, false)
+		if err != nil {
+			return err
+		}
+		trafficMode, err := wz.askChoice("Proxy apps with local socks proxy, a TUN device, or route traffic as VPN (TUN and VPN might require root privilege)", []string{wizardModeSocks, wizardModeTun, wizardModeVPN}, wizardModeSocks)
+		if err != nil {
+			return err
+		}
+		var allTraffic bool
+		if trafficMode == wizardModeVPN {
+			allTraffic, err = wz.askBool("Route all traffic through the server instead of only the server's local network", false)
+			if err != nil {
+				return err
+			}
+		}
+
+		t.Set("remoteAdminAddr", remoteAdminAddrs, "Admin address of your server(s), the first one is the default")
+		if tuna {
+			t.Set("tuna", true, "Relay through tuna service nodes for higher throughput")
+		}
+		switch trafficMode {
+		case wizardModeTun:
+			t.Set("tun", true, "Proxy traffic sent to the TUN device")
+		case wizardModeVPN:
+			t.Set("vpn", true, "Route traffic through the server")
+			if allTraffic {
+				t.Set("vpnRoute", []string{"0.0.0.0/0"}, "Send everything through VPN")
+			}
+		}
+	}
+
+	updated, _, err := opts.Config.WithPartial(t.Values())
+	if err != nil {
+		return err
+	}
+	if server {
+		err = updated.VerifyServer()
+	} else {
+		err = updated.VerifyClient()
+	}
+	if err != nil {
+		return fmt.Errorf("invalid config: %v", err)
+	}
+
+	fmt.Fprintln(w)
+	err = writeTemplate(opts, t, w)
+	if err != nil {
+		return err
+	}
+
+	if server {
+		_, err = fmt.Fprintf(w, "\nServer admin address for clients: %s.%s\n", opts.AdminIdentifier, addr)
+		if err == nil && needWebUser {
+			_, err = fmt.Fprintf(w, "Add a web user with add-web-user before starting, public web GUI without admin addresses or web users is refused\n")
+		}
+	} else {
+		_, err = fmt.Fprintf(w, "\nClient address: %s\nAdd public key %s$ to acceptAddrs of your server, e.g. with its web GUI\n", addr, hex.EncodeToString(account.PubKey()))
+	}
+	return err
+}